@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/client"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nwc"
+	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
+)
+
+// cmdBuy buys a subscription: it reads the pool's pricing event, lets
+// the user pick a plan, shows the invoice (or pays it through the NWC
+// wallet with --nwc) and waits for the token DM.
+func cmdBuy(args []string) error {
+	fs := flag.NewFlagSet("buy", flag.ContinueOnError)
+	planFlag := fs.String("plan", "", "plan to buy (an ID from the pool's pricing); prompts if empty")
+	useNWC := fs.Bool("nwc", false, "pay with the wallet in MEERKAT_NWC_URI instead of showing the invoice")
+	renew := fs.String("renew", "", "renew the subscription of this token ID (\"latest\": the latest valid token); --plan switches plans")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	poolPub, err := nostrutil.ParsePubKey(os.Getenv("MEERKAT_CLIENT_POOL_PUBKEY"))
+	if err != nil {
+		return fmt.Errorf("MEERKAT_CLIENT_POOL_PUBKEY: %w", err)
+	}
+
+	opts := client.BuyOptions{
+		PoolPubKey: poolPub,
+		Plan:       *planFlag,
+		ChoosePlan: promptPlan,
+		OnInvoice:  printInvoice,
+	}
+	if *renew != "" {
+		opts.RenewTokenID = *renew
+		if *renew == "latest" {
+			ts, err := client.LoadTokenStore()
+			if err != nil {
+				return fmt.Errorf("load token store: %w", err)
+			}
+			tok, err := ts.LatestValid(poolPub, time.Now())
+			if err != nil {
+				return fmt.Errorf("nothing to renew: %w", err)
+			}
+			opts.RenewTokenID = tok.Payload.TokenID
+		}
+		opts.ChoosePlan = nil // same plan unless --plan says otherwise
+	}
+	if *useNWC {
+		uri := os.Getenv("MEERKAT_NWC_URI")
+		if uri == "" {
+			return errors.New("--nwc given but MEERKAT_NWC_URI not set")
+		}
+		wallet, err := nwc.NewClientFromURI(uri)
+		if err != nil {
+			return err
+		}
+		opts.Wallet = wallet
+		opts.OnInvoice = func(inv *pool.InvoiceResponse) {
+			fmt.Printf("Paying %d sats for a %s subscription with your wallet...\n", inv.AmountSats, inv.Plan)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("Fetching pool pricing...")
+	tok, err := client.Buy(ctx, opts)
+	if errors.Is(err, client.ErrTokenNotReceived) {
+		return fmt.Errorf("%w; if you paid, run receive-tokens later", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if tok.Payload.PreviousTokenID != "" {
+		fmt.Printf("Renewed %s.\n", tok.Payload.PreviousTokenID)
+	}
+	fmt.Printf("Payment received. Stored token %s (plan=%s, expires %s).\n",
+		tok.Payload.TokenID, tok.Payload.SubscriptionType,
+		time.Unix(tok.Payload.ExpiresAt, 0).Local().Format(time.RFC3339))
+	return nil
+}
+
+func printInvoice(inv *pool.InvoiceResponse) {
+	fmt.Println()
+	if r := inv.Renewal; r != nil {
+		fmt.Printf("Renewing onto %s: %s to %s", r.Plan,
+			r.StartsAt.Local().Format(time.DateOnly), r.ExpiresAt.Local().Format(time.DateOnly))
+		if r.CreditSats > 0 {
+			fmt.Printf(" (%d sats credited for the unused time)", r.CreditSats)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Pay %d sats for a %s subscription:\n\n", inv.AmountSats, inv.Plan)
+	if qr, err := renderQR(strings.ToUpper(inv.Bolt11)); err == nil {
+		fmt.Println(qr)
+	}
+	fmt.Println(inv.Bolt11)
+	fmt.Println()
+	fmt.Printf("Invoice expires at %s. Waiting for payment (Ctrl+C to stop)...\n",
+		time.Unix(inv.ExpiresAt, 0).Local().Format(time.Kitchen))
+}
+
+func planLength(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("(%d days)", int(d.Hours()/24))
+	}
+	return fmt.Sprintf("(%d hours)", int(d.Hours()))
+}
+
+func promptPlan(plans []client.Plan) (client.Plan, error) {
+	fmt.Println("Available plans:")
+	for i, p := range plans {
+		fmt.Printf("  %d) %-12s %7d sats  %-9s tier=%s\n", i+1, p.Name, p.PriceSats, planLength(p.Duration), p.Tier)
+	}
+	fmt.Print("Enter choice [1]: ")
+
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	choice := strings.TrimSpace(line)
+	if choice == "" {
+		return plans[0], nil
+	}
+	if n, err := strconv.Atoi(choice); err == nil && n >= 1 && n <= len(plans) {
+		return plans[n-1], nil
+	}
+	for _, p := range plans {
+		if strings.EqualFold(p.Name, choice) {
+			return p, nil
+		}
+	}
+	return client.Plan{}, fmt.Errorf("unrecognized choice %q", choice)
+}
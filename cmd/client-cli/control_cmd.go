@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/client"
+)
+
+// dialDaemon connects to a running daemon's control socket, or returns
+// nil if none is running.
+func dialDaemon() *client.ControlClient {
+	path, err := client.ControlSocketPath()
+	if err != nil {
+		return nil
+	}
+	cc, err := client.DialControl(path)
+	if err != nil {
+		return nil
+	}
+	return cc
+}
+
+// cmdStatus prints the daemon's connection status. With --follow it
+// keeps printing status changes until Ctrl+C.
+func cmdStatus(args []string) error {
+	follow := len(args) > 0 && (args[0] == "--follow" || args[0] == "-f")
+
+	cc := dialDaemon()
+	if cc == nil {
+		fmt.Println("Daemon not running.")
+		return nil
+	}
+	defer cc.Close()
+
+	ctx := context.Background()
+	if !follow {
+		st, err := cc.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(st)
+		return nil
+	}
+
+	ch, err := cc.Subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	for st := range ch {
+		printStatus(st)
+	}
+	return fmt.Errorf("daemon connection closed")
+}
+
+func printStatus(st client.Status) {
+	line := fmt.Sprintf("%s %s", st.Since.Local().Format(time.RFC3339), st.State)
+	if st.NodeID != "" {
+		line += fmt.Sprintf(" | node=%s", st.NodeID)
+	}
+	if st.Backend != "" {
+		line += fmt.Sprintf(" | backend=%s", st.Backend)
+	}
+	if st.TokenID != "" {
+		line += fmt.Sprintf(" | token=%s (expires %s)", st.TokenID,
+			time.Unix(st.ExpiresAt, 0).Local().Format(time.RFC3339))
+	}
+	if st.LastError != "" {
+		line += fmt.Sprintf(" | error=%s", st.LastError)
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/client"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/tunnel"
+)
+
+// cmdDaemon runs the client service: it keeps a tunnel up (failing over
+// between nodes and renewing the session before its token expires) and
+// serves the control socket front-ends drive it through, until Ctrl+C
+// or SIGTERM.
+func cmdDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	idle := fs.Bool("idle", false, "don't connect on start; wait for a connect request on the control socket")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	poolPub := os.Getenv("MEERKAT_CLIENT_POOL_PUBKEY")
+	if poolPub == "" {
+		return fmt.Errorf("MEERKAT_CLIENT_POOL_PUBKEY not set")
+	}
+
+	defaults := client.ConnectOptions{
+		PoolPubKey:      poolPub,
+		Backend:         os.Getenv("MEERKAT_TUNNEL_BACKEND"),
+		PreferredRegion: os.Getenv("MEERKAT_PREFERRED_REGION"),
+		NodeURL:         os.Getenv("MEERKAT_NODE_URL"),
+	}
+	if !*idle {
+		defaults.Backend = promptBackend()
+		log.Printf("Using backend=%s\n", defaults.Backend)
+	}
+	if defaults.NodeURL != "" {
+		log.Printf("Using node URL from MEERKAT_NODE_URL=%s (failover disabled)\n", defaults.NodeURL)
+	}
+	flushCache := configureFinderFromEnv()
+	defer flushCache()
+
+	sockPath, err := client.ControlSocketPath()
+	if err != nil {
+		return fmt.Errorf("determine control socket path: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	svc := client.NewService(tunnel.NewManagerFromEnv(), defaults)
+
+	// Optional: buy a new subscription through the NWC wallet before the
+	// stored tokens run out.
+	if poolPubHex, err := nostrutil.ParsePubKey(poolPub); err == nil {
+		renewer, err := client.AutoRenewerFromEnv(poolPubHex)
+		if err != nil {
+			return err
+		}
+		if renewer != nil {
+			go renewer.Run(ctx)
+		}
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- client.ServeControl(ctx, sockPath, svc) }()
+
+	log.Println("[daemon] starting; press Ctrl+C to stop")
+	if !*idle {
+		if _, err := svc.Connect(ctx, client.ConnectOptions{}); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		<-serveErr // let it remove the socket
+	case err := <-serveErr:
+		if err != nil {
+			return fmt.Errorf("control socket: %w", err)
+		}
+	}
+
+	downCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := svc.Disconnect(downCtx); err != nil {
+		return err
+	}
+	log.Println("[daemon] stopped")
+	return nil
+}
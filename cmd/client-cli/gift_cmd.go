@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/client"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nwc"
+	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
+)
+
+// cmdGift buys a subscription for another Nostr pubkey, like buy, and
+// waits for the pool's receipt.
+func cmdGift(args []string) error {
+	fs := flag.NewFlagSet("gift", flag.ContinueOnError)
+	to := fs.String("to", "", "recipient's npub or hex pubkey")
+	planFlag := fs.String("plan", "", "plan to buy (an ID from the pool's pricing); prompts if empty")
+	message := fs.String("message", "", "note sent to the recipient with the token")
+	useNWC := fs.Bool("nwc", false, "pay with the wallet in MEERKAT_NWC_URI instead of showing the invoice")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" {
+		return errors.New("usage: gift --to NPUB [--plan P] [--message M] [--nwc]")
+	}
+
+	poolPub, err := nostrutil.ParsePubKey(os.Getenv("MEERKAT_CLIENT_POOL_PUBKEY"))
+	if err != nil {
+		return fmt.Errorf("MEERKAT_CLIENT_POOL_PUBKEY: %w", err)
+	}
+
+	opts := client.GiftOptions{
+		PoolPubKey: poolPub,
+		Recipient:  *to,
+		Message:    *message,
+		Plan:       *planFlag,
+		ChoosePlan: promptPlan,
+		OnInvoice:  printInvoice,
+	}
+	if *useNWC {
+		uri := os.Getenv("MEERKAT_NWC_URI")
+		if uri == "" {
+			return errors.New("--nwc given but MEERKAT_NWC_URI not set")
+		}
+		wallet, err := nwc.NewClientFromURI(uri)
+		if err != nil {
+			return err
+		}
+		opts.Wallet = wallet
+		opts.OnInvoice = func(inv *pool.InvoiceResponse) {
+			fmt.Printf("Paying %d sats for a %s gift with your wallet...\n", inv.AmountSats, inv.Plan)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("Fetching pool pricing...")
+	receipt, err := client.Gift(ctx, opts)
+	if errors.Is(err, client.ErrTokenNotReceived) {
+		return fmt.Errorf("%w; if you paid, the recipient still gets the token", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Gift sent: token %s (plan=%s, expires %s) was DMed to %s.\n",
+		receipt.TokenID, receipt.Plan,
+		time.Unix(receipt.ExpiresAt, 0).Local().Format(time.RFC3339), receipt.Recipient)
+	return nil
+}
+
+// cmdTransfer hands one of our subscriptions over to another pubkey.
+func cmdTransfer(args []string) error {
+	fs := flag.NewFlagSet("transfer", flag.ContinueOnError)
+	to := fs.String("to", "", "new holder's npub or hex pubkey")
+	tokenID := fs.String("token", "latest", "token ID of the subscription to transfer (\"latest\": the latest valid token)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" {
+		return errors.New("usage: transfer --to NPUB [--token ID]")
+	}
+
+	poolPub, err := nostrutil.ParsePubKey(os.Getenv("MEERKAT_CLIENT_POOL_PUBKEY"))
+	if err != nil {
+		return fmt.Errorf("MEERKAT_CLIENT_POOL_PUBKEY: %w", err)
+	}
+	id := *tokenID
+	if id == "latest" {
+		ts, err := client.LoadTokenStore()
+		if err != nil {
+			return fmt.Errorf("load token store: %w", err)
+		}
+		tok, err := ts.LatestValid(poolPub, time.Now())
+		if err != nil {
+			return fmt.Errorf("nothing to transfer: %w", err)
+		}
+		id = tok.Payload.TokenID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resp, err := client.Transfer(ctx, poolPub, id, *to)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Transferred: %s now holds token %s (expires %s); %d of your token(s) were revoked.\n",
+		resp.Recipient, resp.TokenID,
+		time.Unix(resp.ExpiresAt, 0).Local().Format(time.RFC3339), len(resp.Revoked))
+	return nil
+}
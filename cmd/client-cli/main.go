@@ -1,15 +1,12 @@
 package main
 
 import (
-    "bytes"
     "bufio"
     "context"
-    "encoding/json"
+    "flag"
     "fmt"
-    "io"
 	"io/fs"
     "log"
-    "net/http"
     "os"
     "path/filepath"
     "runtime"
@@ -18,7 +15,6 @@ import (
 
     "github.com/MakerMaker19/meerkatvpn/pkg/client"
     "github.com/MakerMaker19/meerkatvpn/pkg/discovery"
-    "github.com/MakerMaker19/meerkatvpn/pkg/vpn"
 )
 
 func init() {
@@ -41,6 +37,30 @@ func main() {
 		if err := cmdReceiveTokens(); err != nil {
 			log.Fatal(err)
 		}
+	case "buy":
+		if err := cmdBuy(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "gift":
+		if err := cmdGift(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "transfer":
+		if err := cmdTransfer(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "redeem-voucher":
+		if err := cmdRedeemVoucher(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "trial":
+		if err := cmdTrial(); err != nil {
+			log.Fatal(err)
+		}
+	case "recover-tokens":
+		if err := cmdRecoverTokens(); err != nil {
+			log.Fatal(err)
+		}
 	case "list-tokens":
 		if err := cmdListTokens(); err != nil {
 			log.Fatal(err)
@@ -50,7 +70,19 @@ func main() {
      		log.Fatal(err)
     	}
 	case "connect":
-		if err := cmdConnect(); err != nil {
+		if err := cmdConnect(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "disconnect":
+		if err := cmdDisconnect(); err != nil {
+			log.Fatal(err)
+		}
+	case "daemon":
+		if err := cmdDaemon(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		if err := cmdStatus(os.Args[2:]); err != nil {
 			log.Fatal(err)
 		}
 	case "watch-nodes":       
@@ -69,9 +101,22 @@ func printUsage() {
     fmt.Println()
     fmt.Println("Usage:")
     fmt.Println("  meerkat-client receive-tokens   # connect to Nostr relays and store subscription tokens")
+    fmt.Println("  meerkat-client buy [--plan P]   # buy a subscription with a Lightning invoice (--nwc: pay via wallet)")
+    fmt.Println("  meerkat-client buy --renew ID   # renew (or upgrade with --plan) a subscription; ID may be \"latest\"")
+    fmt.Println("  meerkat-client gift --to NPUB   # buy a subscription for someone else")
+    fmt.Println("  meerkat-client transfer --to NPUB # hand your subscription over to another key")
+    fmt.Println("  meerkat-client redeem-voucher CODE # get a subscription for a voucher code")
+    fmt.Println("  meerkat-client trial            # get a free trial token, if the pool offers them")
+    fmt.Println("  meerkat-client recover-tokens   # re-fetch the live tokens the pool issued to your key")
     fmt.Println("  meerkat-client list-tokens      # list stored subscription tokens")
     fmt.Println("  meerkat-client list-nodes       # list known Meerkat nodes via discovery")
     fmt.Println("  meerkat-client connect          # use latest valid token to request a session from a node")
+    fmt.Println("  meerkat-client connect --up     # ...and bring the tunnel up, supervised until Ctrl+C")
+    fmt.Println("  meerkat-client disconnect       # tear down the tunnel started by connect --up")
+    fmt.Println("  meerkat-client daemon           # keep a tunnel up with automatic reconnect and node failover")
+    fmt.Println("  meerkat-client daemon --idle    # serve the control socket; connect when a front-end asks")
+    fmt.Println("  meerkat-client status [-f]      # show (or follow) the daemon's connection status")
+    fmt.Println("  meerkat-client watch-nodes      # stream node discovery events")
 }
 
 
@@ -81,17 +126,25 @@ func cmdReceiveTokens() error {
 }
 
 func cmdListTokens() error {
-	ts, err := client.LoadTokenStore()
+	var ctl client.Controller = client.NewService(nil, client.ConnectOptions{})
+	if cc := dialDaemon(); cc != nil {
+		defer cc.Close()
+		ctl = cc
+	}
+
+	tokens, err := ctl.ListTokens(context.Background())
 	if err != nil {
 		return fmt.Errorf("load token store: %w", err)
 	}
-	if len(ts.Tokens) == 0 {
+	if len(tokens) == 0 {
 		fmt.Println("No stored subscription tokens.")
 		return nil
 	}
 
-	fmt.Println("Stored subscription tokens:")
-	for _, t := range ts.Tokens {
+	// Renewed tokens are listed under the subscription they extend.
+	fmt.Println("Stored subscriptions:")
+	for _, sub := range client.CollapseTokens(tokens) {
+		t := sub.Latest()
 		exp := time.Unix(t.Payload.ExpiresAt, 0).Local()
 		fmt.Printf("- %s | plan=%s | expires=%s | issuer=%s\n",
 			t.Payload.TokenID,
@@ -99,6 +152,13 @@ func cmdListTokens() error {
 			exp.Format(time.RFC3339),
 			t.Payload.IssuerPubKey,
 		)
+		if len(sub.Tokens) > 1 {
+			fmt.Printf("    renewed %d time(s) since %s (subscription %s)\n",
+				len(sub.Tokens)-1,
+				time.Unix(sub.Tokens[0].Payload.IssuedAt, 0).Local().Format(time.RFC3339),
+				sub.ID,
+			)
+		}
 	}
 	return nil
 }
@@ -106,7 +166,17 @@ func cmdListTokens() error {
 func cmdListNodes() error {
     ctx := context.Background()
 
-    nodes, err := discovery.ListNodes(ctx)
+    var ctl client.Controller
+    if cc := dialDaemon(); cc != nil {
+        defer cc.Close()
+        ctl = cc
+    } else {
+        flushCache := configureFinderFromEnv()
+        defer flushCache()
+        ctl = client.NewService(nil, client.ConnectOptions{})
+    }
+
+    nodes, err := ctl.ListNodes(ctx)
     if err != nil {
         return fmt.Errorf("list nodes: %w", err)
     }
@@ -116,6 +186,15 @@ func cmdListNodes() error {
         return nil
     }
 
+    if discoveryCache != nil && !discoveryCache.UpdatedAt().IsZero() {
+        state := "fresh"
+        if !discoveryCache.Fresh() {
+            state = "stale"
+        }
+        fmt.Printf("Discovery cache: updated %s ago (%s)\n",
+            time.Since(discoveryCache.UpdatedAt()).Round(time.Second), state)
+    }
+
     fmt.Println("Known Meerkat nodes (via discovery):")
     for _, n := range nodes {
         backends := strings.Join(n.Backends, ",")
@@ -123,8 +202,8 @@ func cmdListNodes() error {
             backends = "(none)"
         }
         fmt.Printf(
-            "- id=%s | api=%s | region=%s | country=%s | city=%s | backends=%s | healthy=%v\n",
-            n.ID, n.APIURL, n.Region, n.Country, n.City, backends, n.Healthy,
+            "- id=%s | api=%s | region=%s | country=%s | city=%s | backends=%s | healthy=%v | source=%s\n",
+            n.ID, n.APIURL, n.Region, n.Country, n.City, backends, n.Healthy, n.Source,
         )
     }
 
@@ -244,7 +323,14 @@ func copyOVPNToOpenVPNConfigDir(srcPath string) {
 // - Calls POST /session/create with {token, client_wg_pubkey, backend}
 // - If backend == "wireguard": builds and writes a WG config (existing behavior)
 // - If backend == "openvpn": expects ovpn_profile in the response and writes meerkat.ovpn
-func cmdConnect() error {
+// - With --up: brings the tunnel up and supervises it (see superviseTunnel)
+func cmdConnect(args []string) error {
+	fs := flag.NewFlagSet("connect", flag.ContinueOnError)
+	up := fs.Bool("up", false, "bring the tunnel up and supervise it until Ctrl+C or disconnect")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
 	ctx := context.Background()
 
 	// Backend selection (OpenVPN vs WireGuard)
@@ -256,116 +342,47 @@ func cmdConnect() error {
 		return fmt.Errorf("MEERKAT_CLIENT_POOL_PUBKEY not set")
 	}
 
-	// Node selection
-	nodeURL := os.Getenv("MEERKAT_NODE_URL")
-	if nodeURL != "" {
-		log.Printf("Using node URL from MEERKAT_NODE_URL=%s\n", nodeURL)
-	} else {
-		preferredRegion := os.Getenv("MEERKAT_PREFERRED_REGION")
-		if preferredRegion == "" {
-			preferredRegion = "auto"
-		}
-
-		node, err := discovery.FindNode(ctx, poolPub, preferredRegion, backend)
-		if err != nil {
-			return fmt.Errorf("no suitable node found via discovery: %w", err)
-		}
-
-		nodeURL = node.APIURL
-
-		log.Printf("Selected node %s (%s) via discovery\n",
-			node.ID, node.Region)
+	opts := client.ConnectOptions{
+		PoolPubKey:      poolPub,
+		Backend:         backend,
+		PreferredRegion: os.Getenv("MEERKAT_PREFERRED_REGION"),
+		NodeURL:         os.Getenv("MEERKAT_NODE_URL"),
 	}
 
-	// - load token store
-	ts, err := client.LoadTokenStore()
-	if err != nil {
-		return fmt.Errorf("load token store: %w", err)
-	}
-
-	// - pick latest valid token
-	tok, err := ts.LatestValid(poolPub, time.Now())
-	if err != nil {
-		return fmt.Errorf("no valid tokens: %w", err)
-	}
-
-	// Generate WG keypair (still required for WG backend; node can ignore for OpenVPN)
-	wgKeys, err := client.GenerateWGKeypair()
-	if err != nil {
-		return fmt.Errorf("generate WG keypair: %w", err)
-	}
-	log.Printf("Generated WireGuard public key: %s\n", wgKeys.Public)
-
-	// Build request including backend
-	reqBody := struct {
-		Token          vpn.SubscriptionToken `json:"token"`
-		ClientWGPubKey string               `json:"client_wg_pubkey"`
-		Backend        string               `json:"backend"` // "wireguard" or "openvpn"
-	}{
-		Token:          *tok,
-		ClientWGPubKey: wgKeys.Public,
-		Backend:        backend,
-	}
-
-	b, err := json.Marshal(reqBody)
-	if err != nil {
-		return fmt.Errorf("marshal request: %w", err)
+	// Node selection
+	if opts.NodeURL != "" {
+		log.Printf("Using node URL from MEERKAT_NODE_URL=%s\n", opts.NodeURL)
+	} else {
+		flushCache := configureFinderFromEnv()
+		defer flushCache()
 	}
 
-	url := nodeURL + "/session/create"
-	log.Printf("Connecting to node at %s with token %s (backend=%s)\n", url, tok.Payload.TokenID, backend)
-
-	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	conn, err := client.PrepareConnection(ctx, opts)
 	if err != nil {
-		return fmt.Errorf("POST /session/create: %w", err)
-	}
-	defer resp.Body.Close()
-
-	var sr struct {
-		Status       string   `json:"status"`
-		Message      string   `json:"message"`
-		ServerPubKey string   `json:"server_pubkey"`
-		Endpoint     string   `json:"endpoint"`
-		ClientIP     string   `json:"client_ip"`
-		AllowedIPs   string   `json:"allowed_ips"`
-		DNS          []string `json:"dns"`
-
-		// New for OpenVPN backend:
-		OVPNProfile string `json:"ovpn_profile,omitempty"`
+		return err
 	}
+	sr := conn.Session
+	path := conn.ConfigPath
 
-	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
-		// try to read raw body for debugging
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("decode response: %w (raw body: %s)", err, string(body))
-	}
-
-	if resp.StatusCode != http.StatusOK || sr.Status != "ok" {
-		return fmt.Errorf("node error: %s (%s)", sr.Status, sr.Message)
-	}
+	fmt.Println("Node accepted session:")
+	fmt.Println("  status :", sr.Status)
+	fmt.Println("  message:", sr.Message)
+	fmt.Println()
 
 	// === Backend-specific handling ===================================
 
 	switch backend {
 	case "openvpn":
-		if sr.OVPNProfile == "" {
-			return fmt.Errorf("node did not provide ovpn_profile for openvpn backend")
-		}
+		fmt.Println("OpenVPN profile written to:")
+		fmt.Println(" ", path)
+		fmt.Println()
 
-		path := "meerkat.ovpn"
-		if err := os.WriteFile(path, []byte(sr.OVPNProfile), 0o600); err != nil {
-			return fmt.Errorf("write %s: %w", path, err)
+		if *up {
+			return superviseTunnel(conn.TunnelState())
 		}
-    	
+
 		copyOVPNToOpenVPNConfigDir(path)
 
-		fmt.Println("Node accepted session:")
-		fmt.Println("  status :", sr.Status)
-		fmt.Println("  message:", sr.Message)
-		fmt.Println()
-		fmt.Println("OpenVPN profile written to:")
-		fmt.Println(" ", path)
-		fmt.Println()
 		if runtime.GOOS == "windows" {
 			fmt.Println("Import this file into the OpenVPN GUI and click Connect.")
 		} else {
@@ -377,43 +394,14 @@ func cmdConnect() error {
 	case "wireguard":
 		fallthrough
 	default:
-		// Original WireGuard behavior
-		if sr.ClientIP == "" {
-			return fmt.Errorf("node did not provide client_ip")
-		}
-		if sr.ServerPubKey == "" {
-			return fmt.Errorf("node did not provide server_pubkey")
-		}
-		if sr.Endpoint == "" {
-			return fmt.Errorf("node did not provide endpoint")
-		}
-
-		cfg := client.BuildWGConfig(client.WGConfigParams{
-			PrivateKey: wgKeys.Private,
-			Address:    sr.ClientIP,
-			DNS:        sr.DNS,
-			ServerPub:  sr.ServerPubKey,
-			Endpoint:   sr.Endpoint,
-			AllowedIPs: sr.AllowedIPs,
-			Keepalive:  25,
-		})
-
-		path, err := client.DefaultWGConfigPath()
-		if err != nil {
-			return fmt.Errorf("determine WG config path: %w", err)
-		}
-
-		if err := client.WriteWGConfig(path, cfg); err != nil {
-			return fmt.Errorf("write WG config: %w", err)
-		}
-
-		fmt.Println("Node accepted session:")
-		fmt.Println("  status :", sr.Status)
-		fmt.Println("  message:", sr.Message)
-		fmt.Println()
 		fmt.Println("WireGuard config written to:")
 		fmt.Println(" ", path)
 		fmt.Println()
+
+		if *up {
+			return superviseTunnel(conn.TunnelState())
+		}
+
 		fmt.Println("You can inspect it and later use it with a WireGuard client.")
 		return nil
 	}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// renderQR renders text as a QR code using Unicode half blocks, two
+// modules per character row, with a quiet zone so phones can scan it
+// off a dark terminal.
+func renderQR(text string) (string, error) {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return "", err
+	}
+
+	const quiet = 2
+	size := code.Size
+	// Dark modules print as spaces and light ones as blocks, so the code
+	// reads as dark-on-light whatever the terminal's colours.
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= size || y >= size {
+			return true
+		}
+		return !code.Black(x, y)
+	}
+
+	var b strings.Builder
+	for y := -quiet; y < size+quiet; y += 2 {
+		for x := -quiet; x < size+quiet; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteRune('█')
+			case top:
+				b.WriteRune('▀')
+			case bottom:
+				b.WriteRune('▄')
+			default:
+				b.WriteRune(' ')
+			}
+		}
+		b.WriteRune('\n')
+	}
+	return b.String(), nil
+}
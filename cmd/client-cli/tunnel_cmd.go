@@ -0,0 +1,78 @@
+// cmd/client-cli/tunnel_cmd.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/tunnel"
+)
+
+// superviseTunnel brings the tunnel up and keeps it up until Ctrl+C,
+// `meerkat-client disconnect`, or the tunnel dies, then tears it down.
+func superviseTunnel(st tunnel.State) error {
+	abs, err := filepath.Abs(st.ConfigPath)
+	if err == nil {
+		st.ConfigPath = abs
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mgr := tunnel.NewManagerFromEnv()
+	sess, err := mgr.Up(ctx, st)
+	if err != nil {
+		return fmt.Errorf("bring tunnel up: %w", err)
+	}
+
+	fmt.Printf("Tunnel up (backend=%s, node=%s). Press Ctrl+C or run `meerkat-client disconnect` to stop.\n",
+		st.Backend, st.NodeURL)
+
+	select {
+	case <-ctx.Done():
+		log.Println("[tunnel] shutting down")
+		if err := sess.Close(context.Background()); err != nil {
+			return fmt.Errorf("tear tunnel down: %w", err)
+		}
+		fmt.Println("Tunnel down.")
+		return nil
+
+	case <-sess.Done():
+		_ = sess.Close(context.Background())
+		return fmt.Errorf("tunnel went down: %w", sess.Err())
+	}
+}
+
+func cmdDisconnect() error {
+	// A running daemon owns its tunnel; ask it to stop rather than
+	// signalling it, so it stays up for the next connect.
+	if cc := dialDaemon(); cc != nil {
+		defer cc.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if _, err := cc.Disconnect(ctx); err != nil {
+			return fmt.Errorf("daemon disconnect: %w", err)
+		}
+		fmt.Println("Disconnected.")
+		return nil
+	}
+
+	mgr := tunnel.NewManagerFromEnv()
+	st, err := mgr.Down(context.Background())
+	if errors.Is(err, tunnel.ErrNoActiveTunnel) {
+		fmt.Println("No active tunnel.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Disconnected (backend=%s, node=%s).\n", st.Backend, st.NodeURL)
+	return nil
+}
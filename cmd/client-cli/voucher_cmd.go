@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/client"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// cmdRedeemVoucher trades a voucher code for a subscription token.
+func cmdRedeemVoucher(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: redeem-voucher CODE")
+	}
+	poolPub, err := nostrutil.ParsePubKey(os.Getenv("MEERKAT_CLIENT_POOL_PUBKEY"))
+	if err != nil {
+		return fmt.Errorf("MEERKAT_CLIENT_POOL_PUBKEY: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tok, err := client.RedeemVoucher(ctx, poolPub, args[0])
+	if err != nil {
+		return err
+	}
+	printReceivedToken("Voucher redeemed", tok)
+	return nil
+}
+
+// cmdTrial asks the pool for a free trial token.
+func cmdTrial() error {
+	poolPub, err := nostrutil.ParsePubKey(os.Getenv("MEERKAT_CLIENT_POOL_PUBKEY"))
+	if err != nil {
+		return fmt.Errorf("MEERKAT_CLIENT_POOL_PUBKEY: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tok, err := client.StartTrial(ctx, poolPub)
+	if err != nil {
+		return err
+	}
+	printReceivedToken("Free trial started", tok)
+	return nil
+}
+
+// cmdRecoverTokens re-fetches the live tokens the pool issued to our key
+// and merges them into the token store.
+func cmdRecoverTokens() error {
+	poolPub, err := nostrutil.ParsePubKey(os.Getenv("MEERKAT_CLIENT_POOL_PUBKEY"))
+	if err != nil {
+		return fmt.Errorf("MEERKAT_CLIENT_POOL_PUBKEY: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	toks, added, err := client.RecoverTokens(ctx, poolPub)
+	if err != nil {
+		return err
+	}
+	if len(toks) == 0 {
+		fmt.Println("The pool has no live tokens for this key.")
+		return nil
+	}
+	for i := range toks {
+		printReceivedToken("Recovered", &toks[i])
+	}
+	fmt.Printf("%d token(s) recovered, %d new.\n", len(toks), added)
+	return nil
+}
+
+func printReceivedToken(what string, tok *vpn.SubscriptionToken) {
+	fmt.Printf("%s: token %s (plan=%s, tier=%s, expires %s) saved to the token store.\n",
+		what, tok.Payload.TokenID, tok.Payload.SubscriptionType, tok.Payload.Tier,
+		time.Unix(tok.Payload.ExpiresAt, 0).Local().Format(time.RFC3339))
+}
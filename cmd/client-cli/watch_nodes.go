@@ -3,11 +3,11 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
 )
@@ -16,7 +16,8 @@ func cmdWatchNodes() error {
 	// Turn on verbose logs from discovery.
 	_ = os.Setenv("MEERKAT_DEBUG_DISCOVERY", "1")
 
-	configureFinderFromEnv()
+	flushCache := configureFinderFromEnv()
+	defer flushCache()
 
 	// ctx will be cancelled when the user hits Ctrl+C
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -24,9 +25,22 @@ func cmdWatchNodes() error {
 
 	log.Println("[watch-nodes] starting; press Ctrl+C to exit")
 
-	// Periodically dump the node list so you can see updates as events arrive.
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	events, err := discovery.Watch(ctx)
+	if err != nil {
+		return fmt.Errorf("watch nodes: %w", err)
+	}
+
+	// Dump the current node list once, then print changes as they happen.
+	nodes, err := discovery.ListNodes(ctx)
+	if err != nil {
+		log.Printf("[watch-nodes] error listing nodes: %v\n", err)
+	} else {
+		log.Printf("[watch-nodes] currently %d nodes:\n", len(nodes))
+		for _, n := range nodes {
+			log.Printf("  - id=%s api=%s region=%s country=%s city=%s backends=%v healthy=%v\n",
+				n.ID, n.APIURL, n.Region, n.Country, n.City, n.Backends, n.Healthy)
+		}
+	}
 
 	for {
 		select {
@@ -34,16 +48,19 @@ func cmdWatchNodes() error {
 			log.Println("[watch-nodes] shutting down")
 			return nil
 
-		case <-ticker.C:
-			nodes, err := discovery.ListNodes(ctx)
-			if err != nil {
-				log.Printf("[watch-nodes] error listing nodes: %v\n", err)
-				continue
+		case ev, ok := <-events:
+			if !ok {
+				log.Println("[watch-nodes] event stream closed")
+				return nil
 			}
-			log.Printf("[watch-nodes] currently %d nodes:\n", len(nodes))
-			for _, n := range nodes {
-				log.Printf("  - id=%s api=%s region=%s country=%s city=%s backends=%v healthy=%v\n",
-					n.ID, n.APIURL, n.Region, n.Country, n.City, n.Backends, n.Healthy)
+			n := ev.Node
+			switch ev.Type {
+			case discovery.NodeHealthChanged:
+				log.Printf("[watch-nodes] %s: id=%s healthy=%v latency=%dms err=%s\n",
+					ev.Type, n.ID, ev.Health.Healthy, ev.Health.LatencyMs, ev.Health.LastError)
+			default:
+				log.Printf("[watch-nodes] %s: id=%s api=%s region=%s country=%s city=%s backends=%v healthy=%v\n",
+					ev.Type, n.ID, n.APIURL, n.Region, n.Country, n.City, n.Backends, n.Healthy)
 			}
 		}
 	}
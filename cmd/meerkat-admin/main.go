@@ -0,0 +1,480 @@
+// Command meerkat-admin operates a running poold through its admin API.
+// Requests are signed (NIP-98) with MEERKAT_ADMIN_NOSTR_PRIVKEY, which
+// must be listed in the pool's MEERKAT_POOL_ADMIN_PUBKEYS.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+	commands := map[string]func(*adminClient, []string) error{
+		"tokens":       cmdTokens,
+		"invoices":     cmdInvoices,
+		"issue":        cmdIssue,
+		"extend":       cmdExtend,
+		"revoke":       cmdRevoke,
+		"resend":       cmdResend,
+		"nodes":        cmdNodes,
+		"approve-node": cmdApproveNode,
+		"remove-node":  cmdRemoveNode,
+		"pricing":      cmdPricing,
+		"set-pricing":  cmdSetPricing,
+		"outbox":       cmdOutbox,
+		"outbox-retry": cmdOutboxRetry,
+		"relays":       cmdRelays,
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		printUsage()
+		os.Exit(1)
+	}
+	c, err := adminClientFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := run(c, os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printUsage() {
+	fmt.Println("MeerkatVPN pool admin")
+	fmt.Println()
+	fmt.Println("Usage:")
+	fmt.Println("  meerkat-admin tokens [--user NPUB] [--active]   # list issued tokens")
+	fmt.Println("  meerkat-admin invoices                          # list pending invoices")
+	fmt.Println("  meerkat-admin issue --to NPUB --plan P [--duration 7d]")
+	fmt.Println("  meerkat-admin extend --token ID --by 30d        # add time to a subscription")
+	fmt.Println("  meerkat-admin revoke --token ID [--subscription]")
+	fmt.Println("  meerkat-admin resend --token ID                 # DM a token to its holder again")
+	fmt.Println("  meerkat-admin nodes                             # list approved nodes")
+	fmt.Println("  meerkat-admin approve-node FILE                 # approve the node in a JSON file (nodes file entry)")
+	fmt.Println("  meerkat-admin remove-node ID")
+	fmt.Println("  meerkat-admin pricing                           # show the plan catalog")
+	fmt.Println("  meerkat-admin set-pricing FILE                  # replace the catalog with {\"plans\": [...]}")
+	fmt.Println("  meerkat-admin outbox [--all]                    # list DMs relays haven't confirmed yet")
+	fmt.Println("  meerkat-admin outbox-retry ID                   # retry an undelivered DM now")
+	fmt.Println("  meerkat-admin relays                            # show the pool's relay connections")
+	fmt.Println()
+	fmt.Println("Environment: MEERKAT_ADMIN_NOSTR_PRIVKEY (operator key), MEERKAT_ADMIN_POOL_URL (pool base URL)")
+}
+
+// adminClient makes NIP-98 signed requests to the pool's admin API.
+type adminClient struct {
+	baseURL string
+	priv    string
+	http    *http.Client
+}
+
+func adminClientFromEnv() (*adminClient, error) {
+	priv := os.Getenv("MEERKAT_ADMIN_NOSTR_PRIVKEY")
+	if priv == "" {
+		return nil, errors.New("MEERKAT_ADMIN_NOSTR_PRIVKEY not set (nsec or hex)")
+	}
+	if _, err := nostrutil.ParsePrivKey(priv); err != nil {
+		return nil, fmt.Errorf("MEERKAT_ADMIN_NOSTR_PRIVKEY: %w", err)
+	}
+	base := os.Getenv("MEERKAT_ADMIN_POOL_URL")
+	if base == "" {
+		base = os.Getenv("MEERKAT_POOL_URL")
+	}
+	if base == "" {
+		return nil, errors.New("MEERKAT_ADMIN_POOL_URL not set (e.g. https://pool.example.com)")
+	}
+	return &adminClient{
+		baseURL: strings.TrimRight(base, "/"),
+		priv:    priv,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// do sends body (if not nil) as JSON and decodes the response into out.
+func (c *adminClient) do(method, path string, body, out any) error {
+	var raw []byte
+	if body != nil {
+		var err error
+		if raw, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	u := c.baseURL + path
+	auth, err := nostrutil.SignHTTPAuth(c.priv, method, u, raw)
+	if err != nil {
+		return fmt.Errorf("sign request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, u, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pool error: %s (%s)", resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+func cmdTokens(c *adminClient, args []string) error {
+	fs := flag.NewFlagSet("tokens", flag.ContinueOnError)
+	user := fs.String("user", "", "only tokens held by this npub or hex pubkey")
+	active := fs.Bool("active", false, "only unexpired, unrevoked tokens")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	q := url.Values{}
+	if *user != "" {
+		q.Set("user", *user)
+	}
+	if *active {
+		q.Set("active", "1")
+	}
+	path := "/admin/tokens"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var entries []pool.LedgerEntry
+	if err := c.do(http.MethodGet, path, nil, &entries); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOKEN\tUSER\tPLAN\tTIER\tEXPIRES\tSOURCE\tSTATUS")
+	now := time.Now().Unix()
+	for _, e := range entries {
+		p := e.Token.Payload
+		status := "active"
+		switch {
+		case e.RevokedAt != 0:
+			status = "revoked"
+		case p.ExpiresAt <= now:
+			status = "expired"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", p.TokenID, short(p.UserPubKey), p.SubscriptionType, p.Tier,
+			time.Unix(p.ExpiresAt, 0).Local().Format(time.RFC3339), e.Source.Kind, status)
+	}
+	return tw.Flush()
+}
+
+func cmdInvoices(c *adminClient, args []string) error {
+	var invoices []pool.PendingInvoice
+	if err := c.do(http.MethodGet, "/admin/invoices", nil, &invoices); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "INVOICE\tUSER\tPLAN\tSATS\tCREATED\tEXPIRES")
+	for _, inv := range invoices {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", inv.InvoiceID, short(inv.Metadata.NostrPubKey), inv.Plan, inv.AmountSats,
+			time.Unix(inv.CreatedAt, 0).Local().Format(time.RFC3339),
+			time.Unix(inv.ExpiresAt, 0).Local().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func cmdIssue(c *adminClient, args []string) error {
+	fs := flag.NewFlagSet("issue", flag.ContinueOnError)
+	to := fs.String("to", "", "recipient's npub or hex pubkey")
+	plan := fs.String("plan", "", "plan ID")
+	duration := fs.String("duration", "", "token lifetime, e.g. 7d (default: the plan's)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" || *plan == "" {
+		return errors.New("usage: issue --to NPUB --plan P [--duration 7d]")
+	}
+	req := pool.AdminIssueRequest{NostrPubKey: *to, Plan: *plan}
+	if *duration != "" {
+		d, err := pool.ParseDuration(*duration)
+		if err != nil {
+			return err
+		}
+		req.Duration = pool.Duration(d)
+	}
+	var tok vpn.SubscriptionToken
+	if err := c.do(http.MethodPost, "/admin/tokens/issue", req, &tok); err != nil {
+		return err
+	}
+	printToken("Issued", tok)
+	return nil
+}
+
+func cmdExtend(c *adminClient, args []string) error {
+	fs := flag.NewFlagSet("extend", flag.ContinueOnError)
+	token := fs.String("token", "", "any token of the subscription")
+	by := fs.String("by", "", "time to add, e.g. 30d")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *token == "" || *by == "" {
+		return errors.New("usage: extend --token ID --by 30d")
+	}
+	d, err := pool.ParseDuration(*by)
+	if err != nil {
+		return err
+	}
+	var tok vpn.SubscriptionToken
+	if err := c.do(http.MethodPost, "/admin/tokens/extend", pool.AdminExtendRequest{TokenID: *token, Duration: pool.Duration(d)}, &tok); err != nil {
+		return err
+	}
+	printToken("Extended with", tok)
+	return nil
+}
+
+func cmdRevoke(c *adminClient, args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ContinueOnError)
+	token := fs.String("token", "", "token ID")
+	sub := fs.Bool("subscription", false, "revoke every token of the token's subscription")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *token == "" {
+		return errors.New("usage: revoke --token ID [--subscription]")
+	}
+	var resp pool.AdminRevokeResponse
+	if err := c.do(http.MethodPost, "/admin/tokens/revoke", pool.AdminRevokeRequest{TokenID: *token, Subscription: *sub}, &resp); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked %d token(s)\n", len(resp.Revoked))
+	for _, id := range resp.Revoked {
+		fmt.Println(" ", id)
+	}
+	return nil
+}
+
+func cmdResend(c *adminClient, args []string) error {
+	fs := flag.NewFlagSet("resend", flag.ContinueOnError)
+	token := fs.String("token", "", "token ID")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *token == "" {
+		return errors.New("usage: resend --token ID")
+	}
+	var entry pool.LedgerEntry
+	if err := c.do(http.MethodPost, "/admin/tokens/resend", pool.AdminTokenRequest{TokenID: *token}, &entry); err != nil {
+		return err
+	}
+	fmt.Printf("Sent token %s to %s again\n", entry.Token.Payload.TokenID, entry.Token.Payload.UserPubKey)
+	return nil
+}
+
+func cmdNodes(c *adminClient, args []string) error {
+	var nodes []discovery.NodeInfo
+	if err := c.do(http.MethodGet, "/admin/nodes", nil, &nodes); err != nil {
+		return err
+	}
+	printNodes(nodes)
+	return nil
+}
+
+func cmdApproveNode(c *adminClient, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: approve-node FILE (a JSON nodes file entry; - reads stdin)")
+	}
+	var b []byte
+	var err error
+	if args[0] == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+	var entry discovery.StaticNodeEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return fmt.Errorf("parse %s: %w", args[0], err)
+	}
+	var nodes []discovery.NodeInfo
+	if err := c.do(http.MethodPost, "/admin/nodes", entry, &nodes); err != nil {
+		return err
+	}
+	printNodes(nodes)
+	return nil
+}
+
+func cmdRemoveNode(c *adminClient, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: remove-node ID")
+	}
+	var nodes []discovery.NodeInfo
+	if err := c.do(http.MethodDelete, "/admin/nodes?id="+url.QueryEscape(args[0]), nil, &nodes); err != nil {
+		return err
+	}
+	printNodes(nodes)
+	return nil
+}
+
+func cmdPricing(c *adminClient, args []string) error {
+	var p pool.AdminPricing
+	if err := c.do(http.MethodGet, "/admin/pricing", nil, &p); err != nil {
+		return err
+	}
+	printPricing(p)
+	return nil
+}
+
+func cmdSetPricing(c *adminClient, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: set-pricing FILE (a catalog file: {\"plans\": [...]})")
+	}
+	b, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var req pool.AdminPricing
+	if err := json.Unmarshal(b, &req); err != nil {
+		return fmt.Errorf("parse %s: %w", args[0], err)
+	}
+	var p pool.AdminPricing
+	if err := c.do(http.MethodPut, "/admin/pricing", req, &p); err != nil {
+		return err
+	}
+	printPricing(p)
+	if !p.Persistent {
+		fmt.Println("Note: the pool's catalog isn't file-backed (MEERKAT_POOL_PLANS_FILE); this change is lost on restart.")
+	}
+	return nil
+}
+
+func cmdOutbox(c *adminClient, args []string) error {
+	fs := flag.NewFlagSet("outbox", flag.ContinueOnError)
+	all := fs.Bool("all", false, "include delivered DMs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path := "/admin/outbox"
+	if *all {
+		path += "?all=1"
+	}
+	var msgs []pool.OutboxMessage
+	if err := c.do(http.MethodGet, path, nil, &msgs); err != nil {
+		return err
+	}
+	printOutbox(msgs)
+	return nil
+}
+
+func cmdOutboxRetry(c *adminClient, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: outbox-retry ID")
+	}
+	var msgs []pool.OutboxMessage
+	if err := c.do(http.MethodPost, "/admin/outbox/retry", pool.AdminOutboxRetryRequest{ID: args[0]}, &msgs); err != nil {
+		return err
+	}
+	fmt.Printf("Retrying %s\n", args[0])
+	printOutbox(msgs)
+	return nil
+}
+
+func printOutbox(msgs []pool.OutboxMessage) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTO\tCREATED\tATTEMPTS\tACKS\tSTATUS")
+	for _, m := range msgs {
+		status := "pending: " + m.LastError
+		if m.Delivered() {
+			status = "delivered " + time.Unix(m.DeliveredAt, 0).Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", m.Event.ID, short(m.To),
+			time.Unix(m.CreatedAt, 0).Local().Format(time.RFC3339), m.Attempts, len(m.Acks), status)
+	}
+	tw.Flush()
+}
+
+func printToken(what string, tok vpn.SubscriptionToken) {
+	p := tok.Payload
+	fmt.Printf("%s token %s for %s (plan=%s, tier=%s, expires %s); DMed to the holder.\n",
+		what, p.TokenID, p.UserPubKey, p.SubscriptionType, p.Tier,
+		time.Unix(p.ExpiresAt, 0).Local().Format(time.RFC3339))
+}
+
+func printNodes(nodes []discovery.NodeInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tAPI\tREGION\tBACKENDS\tENABLED")
+	for _, n := range nodes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%v\n", n.ID, n.APIURL, n.Region, strings.Join(n.Backends, ","), n.Healthy)
+	}
+	tw.Flush()
+}
+
+func printPricing(p pool.AdminPricing) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PLAN\tTIER\tDURATION\tPRICE")
+	for _, pl := range p.Plans {
+		price := fmt.Sprintf("%d sats", pl.PriceSats)
+		if pl.PriceFiat != nil {
+			price = fmt.Sprintf("%.2f %s", pl.PriceFiat.Amount, pl.PriceFiat.Currency)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", pl.ID, pl.Tier, time.Duration(pl.Duration), price)
+	}
+	tw.Flush()
+}
+
+// short abbreviates a hex pubkey for tables.
+func short(pub string) string {
+	if len(pub) <= 16 {
+		return pub
+	}
+	return pub[:8] + "…" + pub[len(pub)-8:]
+}
+
+func cmdRelays(c *adminClient, args []string) error {
+	var health []nostrutil.RelayHealth
+	if err := c.do(http.MethodGet, "/admin/relays", nil, &health); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RELAY	STATUS	CONNECTS	EVENTS	PUBLISHED	LAST ERROR")
+	for _, h := range health {
+		status := "down"
+		if h.Connected {
+			status = "up since " + time.Unix(h.ConnectedAt, 0).Local().Format(time.RFC3339)
+		} else if h.NextAttempt != 0 {
+			status = fmt.Sprintf("down (%d failures, retry %s)", h.Failures, time.Unix(h.NextAttempt, 0).Local().Format(time.TimeOnly))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d/%d\t%s\n", h.URL, status, h.Connects, h.Events,
+			h.Published, h.Published+h.PublishErrors, h.LastError)
+	}
+	tw.Flush()
+	return nil
+}
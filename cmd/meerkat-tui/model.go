@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/client"
+	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// deps is everything the model talks to. main wires in the real client
+// service and discovery package; model tests can swap in fakes and
+// drive Update directly (or through teatest) without relays or tunnels.
+type deps struct {
+	ctl client.Controller
+
+	// watchNodes streams node discovery and health events.
+	watchNodes func(ctx context.Context) (<-chan discovery.NodeEvent, error)
+	// health returns the latest probe result for a node.
+	health func(id string) (discovery.HealthInfo, bool)
+	// listenTokens receives subscription tokens over Nostr until ctx ends.
+	listenTokens func(ctx context.Context) error
+
+	now func() time.Time
+}
+
+type pane int
+
+const (
+	paneTokens pane = iota
+	paneNodes
+	paneCount
+)
+
+type keyMap struct {
+	Next       key.Binding
+	Up         key.Binding
+	Down       key.Binding
+	Connect    key.Binding
+	ConnectAny key.Binding
+	Disconnect key.Binding
+	Receive    key.Binding
+	Backend    key.Binding
+	Quit       key.Binding
+}
+
+var keys = keyMap{
+	Next:       key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "switch pane")),
+	Up:         key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "up")),
+	Down:       key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "down")),
+	Connect:    key.NewBinding(key.WithKeys("enter", "c"), key.WithHelp("enter/c", "connect to selected node")),
+	ConnectAny: key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "connect (auto node)")),
+	Disconnect: key.NewBinding(key.WithKeys("d"), key.WithHelp("d", "disconnect")),
+	Receive:    key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "receive tokens")),
+	Backend:    key.NewBinding(key.WithKeys("b"), key.WithHelp("b", "toggle backend")),
+	Quit:       key.NewBinding(key.WithKeys("q", "ctrl+c"), key.WithHelp("q", "quit")),
+}
+
+// ---- messages ----
+
+type tickMsg time.Time
+type tokensMsg []vpn.SubscriptionToken
+type nodesMsg []discovery.NodeInfo
+type nodeEventMsg discovery.NodeEvent
+type statusMsg client.Status
+type errMsg struct{ err error }
+type listenDoneMsg struct{ err error }
+
+// subscribedMsg carries the streams opened by Init; each is re-armed
+// after every message it delivers.
+type subscribedMsg struct {
+	status <-chan client.Status
+	nodes  <-chan discovery.NodeEvent
+}
+
+type statusClosedMsg struct{}
+type nodesClosedMsg struct{}
+
+// ---- model ----
+
+type model struct {
+	ctx  context.Context
+	deps deps
+
+	tokens  []vpn.SubscriptionToken
+	nodes   []discovery.NodeInfo
+	status  client.Status
+	backend string
+
+	focus      pane
+	tokenIdx   int
+	nodeIdx    int
+	listening  bool
+	stopListen context.CancelFunc
+
+	message string
+	width   int
+}
+
+func newModel(ctx context.Context, d deps, backend string) model {
+	if d.now == nil {
+		d.now = time.Now
+	}
+	if backend == "" {
+		backend = "openvpn"
+	}
+	return model{
+		ctx:     ctx,
+		deps:    d,
+		backend: backend,
+		status:  client.Status{State: client.StateDisconnected},
+		focus:   paneNodes,
+	}
+}
+
+func (m model) Init() tea.Cmd {
+	return tea.Batch(m.loadTokens(), m.loadNodes(), m.subscribe(), tick())
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+func (m model) loadTokens() tea.Cmd {
+	return func() tea.Msg {
+		toks, err := m.deps.ctl.ListTokens(m.ctx)
+		if err != nil {
+			return errMsg{fmt.Errorf("list tokens: %w", err)}
+		}
+		return tokensMsg(toks)
+	}
+}
+
+func (m model) loadNodes() tea.Cmd {
+	return func() tea.Msg {
+		nodes, err := m.deps.ctl.ListNodes(m.ctx)
+		if err != nil {
+			return errMsg{fmt.Errorf("list nodes: %w", err)}
+		}
+		return nodesMsg(nodes)
+	}
+}
+
+func (m model) subscribe() tea.Cmd {
+	return func() tea.Msg {
+		var sub subscribedMsg
+		st, err := m.deps.ctl.Subscribe(m.ctx)
+		if err != nil {
+			return errMsg{fmt.Errorf("subscribe to status: %w", err)}
+		}
+		sub.status = st
+		if m.deps.watchNodes != nil {
+			if ev, err := m.deps.watchNodes(m.ctx); err == nil {
+				sub.nodes = ev
+			}
+		}
+		return sub
+	}
+}
+
+func waitStatus(ch <-chan client.Status) tea.Cmd {
+	return func() tea.Msg {
+		st, ok := <-ch
+		if !ok {
+			return statusClosedMsg{}
+		}
+		return statusWithChan{statusMsg(st), ch}
+	}
+}
+
+func waitNodeEvent(ch <-chan discovery.NodeEvent) tea.Cmd {
+	if ch == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		ev, ok := <-ch
+		if !ok {
+			return nodesClosedMsg{}
+		}
+		return nodeEventWithChan{nodeEventMsg(ev), ch}
+	}
+}
+
+type statusWithChan struct {
+	msg statusMsg
+	ch  <-chan client.Status
+}
+
+type nodeEventWithChan struct {
+	msg nodeEventMsg
+	ch  <-chan discovery.NodeEvent
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		return m, nil
+
+	case tea.KeyMsg:
+		return m.handleKey(msg)
+
+	case tickMsg:
+		// Reload tokens every few seconds: receive-tokens (here or in
+		// another process) writes straight to the token store.
+		var cmds []tea.Cmd
+		if time.Time(msg).Second()%5 == 0 {
+			cmds = append(cmds, m.loadTokens())
+		}
+		return m, tea.Batch(append(cmds, tick())...)
+
+	case tokensMsg:
+		m.tokens = sortTokens(msg)
+		m.tokenIdx = clamp(m.tokenIdx, len(m.tokens))
+		return m, nil
+
+	case nodesMsg:
+		m.nodes = sortNodes(msg)
+		m.nodeIdx = clamp(m.nodeIdx, len(m.nodes))
+		return m, nil
+
+	case subscribedMsg:
+		return m, tea.Batch(waitStatus(msg.status), waitNodeEvent(msg.nodes))
+
+	case statusWithChan:
+		m.status = client.Status(msg.msg)
+		return m, waitStatus(msg.ch)
+
+	case statusMsg:
+		m.status = client.Status(msg)
+		return m, nil
+
+	case statusClosedMsg:
+		m.message = "lost connection to the client daemon"
+		return m, nil
+
+	case nodeEventWithChan:
+		m = m.applyNodeEvent(discovery.NodeEvent(msg.msg))
+		return m, waitNodeEvent(msg.ch)
+
+	case nodeEventMsg:
+		return m.applyNodeEvent(discovery.NodeEvent(msg)), nil
+
+	case nodesClosedMsg:
+		return m, nil
+
+	case listenDoneMsg:
+		m.listening = false
+		m.stopListen = nil
+		if msg.err != nil {
+			m.message = "receive tokens: " + msg.err.Error()
+		} else {
+			m.message = "stopped listening for tokens"
+		}
+		return m, nil
+
+	case errMsg:
+		m.message = msg.err.Error()
+		return m, nil
+	}
+	return m, nil
+}
+
+func (m model) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, keys.Quit):
+		if m.stopListen != nil {
+			m.stopListen()
+		}
+		return m, tea.Quit
+
+	case key.Matches(msg, keys.Next):
+		m.focus = (m.focus + 1) % paneCount
+
+	case key.Matches(msg, keys.Up):
+		if m.focus == paneNodes {
+			m.nodeIdx = clamp(m.nodeIdx-1, len(m.nodes))
+		} else {
+			m.tokenIdx = clamp(m.tokenIdx-1, len(m.tokens))
+		}
+
+	case key.Matches(msg, keys.Down):
+		if m.focus == paneNodes {
+			m.nodeIdx = clamp(m.nodeIdx+1, len(m.nodes))
+		} else {
+			m.tokenIdx = clamp(m.tokenIdx+1, len(m.tokens))
+		}
+
+	case key.Matches(msg, keys.Backend):
+		if m.backend == "openvpn" {
+			m.backend = "wireguard"
+		} else {
+			m.backend = "openvpn"
+		}
+
+	case key.Matches(msg, keys.Connect):
+		opts := client.ConnectOptions{Backend: m.backend}
+		if m.focus == paneNodes && len(m.nodes) > 0 {
+			opts.NodeID = m.nodes[m.nodeIdx].ID
+		}
+		return m, m.connect(opts)
+
+	case key.Matches(msg, keys.ConnectAny):
+		return m, m.connect(client.ConnectOptions{Backend: m.backend})
+
+	case key.Matches(msg, keys.Disconnect):
+		return m, m.disconnect()
+
+	case key.Matches(msg, keys.Receive):
+		if m.listening {
+			m.stopListen()
+			return m, nil
+		}
+		if m.deps.listenTokens == nil {
+			m.message = "receiving tokens is not available"
+			return m, nil
+		}
+		ctx, cancel := context.WithCancel(m.ctx)
+		m.listening = true
+		m.stopListen = cancel
+		m.message = "listening for subscription tokens (r to stop)"
+		listen := m.deps.listenTokens
+		return m, func() tea.Msg { return listenDoneMsg{listen(ctx)} }
+	}
+	return m, nil
+}
+
+func (m model) connect(opts client.ConnectOptions) tea.Cmd {
+	return func() tea.Msg {
+		st, err := m.deps.ctl.Connect(m.ctx, opts)
+		if err != nil {
+			return errMsg{fmt.Errorf("connect: %w", err)}
+		}
+		return statusMsg(st)
+	}
+}
+
+func (m model) disconnect() tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
+		defer cancel()
+		st, err := m.deps.ctl.Disconnect(ctx)
+		if err != nil {
+			return errMsg{fmt.Errorf("disconnect: %w", err)}
+		}
+		return statusMsg(st)
+	}
+}
+
+// applyNodeEvent folds a discovery event into the node list. Health
+// events carry only the node ID; latency is read from deps.health when
+// rendering, so they just trigger a redraw.
+func (m model) applyNodeEvent(ev discovery.NodeEvent) model {
+	switch ev.Type {
+	case discovery.NodeAdded, discovery.NodeUpdated:
+		nodes := make([]discovery.NodeInfo, 0, len(m.nodes)+1)
+		found := false
+		for _, n := range m.nodes {
+			if n.ID == ev.Node.ID {
+				n = ev.Node
+				found = true
+			}
+			nodes = append(nodes, n)
+		}
+		if !found {
+			nodes = append(nodes, ev.Node)
+		}
+		m.nodes = sortNodes(nodes)
+
+	case discovery.NodeRemoved:
+		nodes := make([]discovery.NodeInfo, 0, len(m.nodes))
+		for _, n := range m.nodes {
+			if n.ID != ev.Node.ID {
+				nodes = append(nodes, n)
+			}
+		}
+		m.nodes = nodes
+	}
+	m.nodeIdx = clamp(m.nodeIdx, len(m.nodes))
+	return m
+}
+
+// ---- view ----
+
+var (
+	titleStyle   = lipgloss.NewStyle().Bold(true)
+	paneStyle    = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1)
+	focusStyle   = paneStyle.BorderForeground(lipgloss.Color("12"))
+	selStyle     = lipgloss.NewStyle().Reverse(true)
+	dimStyle     = lipgloss.NewStyle().Faint(true)
+	goodStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+	badStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	warnStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	messageStyle = lipgloss.NewStyle().Italic(true)
+)
+
+func (m model) View() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("MeerkatVPN"))
+	b.WriteString("\n")
+	b.WriteString(m.renderPane("Status", m.statusView(), false))
+	b.WriteString("\n")
+	b.WriteString(m.renderPane("Tokens", m.tokensView(), m.focus == paneTokens))
+	b.WriteString("\n")
+	b.WriteString(m.renderPane("Nodes", m.nodesView(), m.focus == paneNodes))
+	b.WriteString("\n")
+	if m.message != "" {
+		b.WriteString(messageStyle.Render(m.message))
+		b.WriteString("\n")
+	}
+	b.WriteString(dimStyle.Render(helpLine()))
+	b.WriteString("\n")
+	return b.String()
+}
+
+func (m model) renderPane(title, body string, focused bool) string {
+	style := paneStyle
+	if focused {
+		style = focusStyle
+	}
+	if m.width > 4 {
+		style = style.Width(m.width - 2)
+	}
+	return style.Render(titleStyle.Render(title) + "\n" + body)
+}
+
+func (m model) statusView() string {
+	st := m.status
+	state := st.State
+	switch st.State {
+	case client.StateConnected:
+		state = goodStyle.Render(state)
+	case client.StateReconnecting:
+		state = warnStyle.Render(state)
+	case client.StateConnecting:
+		state = warnStyle.Render(state)
+	}
+
+	lines := []string{fmt.Sprintf("%s   backend: %s", state, m.backend)}
+	if st.NodeID != "" {
+		lines = append(lines, fmt.Sprintf("node: %s (%s)", st.NodeID, st.NodeURL))
+	}
+	if st.TokenID != "" {
+		lines = append(lines, fmt.Sprintf("token: %s, expires in %s", st.TokenID, m.countdown(st.ExpiresAt)))
+	}
+	if !st.Since.IsZero() && st.State == client.StateConnected {
+		lines = append(lines, fmt.Sprintf("up for %s", m.deps.now().Sub(st.Since).Round(time.Second)))
+	}
+	if st.LastError != "" {
+		lines = append(lines, badStyle.Render("last error: "+st.LastError))
+	}
+	if m.listening {
+		lines = append(lines, dimStyle.Render("receiving tokens…"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m model) tokensView() string {
+	if len(m.tokens) == 0 {
+		return dimStyle.Render("no stored tokens; press r to receive one")
+	}
+	lines := make([]string, 0, len(m.tokens))
+	for i, t := range m.tokens {
+		left := m.countdown(t.Payload.ExpiresAt)
+		line := fmt.Sprintf("%-36s  %-10s  %s", t.Payload.TokenID, t.Payload.SubscriptionType, left)
+		if t.Payload.ExpiresAt <= m.deps.now().Unix() {
+			line = dimStyle.Render(line)
+		}
+		if m.focus == paneTokens && i == m.tokenIdx {
+			line = selStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m model) nodesView() string {
+	if len(m.nodes) == 0 {
+		return dimStyle.Render("no nodes discovered yet")
+	}
+	lines := make([]string, 0, len(m.nodes))
+	for i, n := range m.nodes {
+		health := dimStyle.Render("unprobed")
+		if m.deps.health != nil {
+			if h, ok := m.deps.health(n.ID); ok {
+				if h.Healthy {
+					health = goodStyle.Render(fmt.Sprintf("up %4dms", h.LatencyMs))
+				} else {
+					health = badStyle.Render("down")
+				}
+			}
+		}
+		line := fmt.Sprintf("%-20s  %-12s  %-20s  %-7s  %s",
+			n.ID, n.Region, strings.Join(n.Backends, ","), n.Source, health)
+		if n.ID == m.status.NodeID && m.status.State == client.StateConnected {
+			line += "  ●"
+		}
+		if m.focus == paneNodes && i == m.nodeIdx {
+			line = selStyle.Render(line)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// countdown renders the time left until the unix timestamp exp.
+func (m model) countdown(exp int64) string {
+	left := time.Unix(exp, 0).Sub(m.deps.now())
+	if left <= 0 {
+		return "expired"
+	}
+	d := int(left.Hours()) / 24
+	h := int(left.Hours()) % 24
+	min := int(left.Minutes()) % 60
+	s := int(left.Seconds()) % 60
+	if d > 0 {
+		return fmt.Sprintf("%dd %02dh %02dm", d, h, min)
+	}
+	return fmt.Sprintf("%02dh %02dm %02ds", h, min, s)
+}
+
+func helpLine() string {
+	bindings := []key.Binding{
+		keys.Next, keys.Up, keys.Down, keys.Connect, keys.ConnectAny,
+		keys.Disconnect, keys.Receive, keys.Backend, keys.Quit,
+	}
+	parts := make([]string, 0, len(bindings))
+	for _, kb := range bindings {
+		h := kb.Help()
+		parts = append(parts, h.Key+" "+h.Desc)
+	}
+	return strings.Join(parts, " • ")
+}
+
+// ---- helpers ----
+
+// sortTokens collapses renewal chains to their latest token and orders
+// the result by expiry, latest first.
+func sortTokens(in []vpn.SubscriptionToken) []vpn.SubscriptionToken {
+	subs := client.CollapseTokens(in)
+	out := make([]vpn.SubscriptionToken, 0, len(subs))
+	for _, sub := range subs {
+		out = append(out, sub.Latest())
+	}
+	return out
+}
+
+func sortNodes(in []discovery.NodeInfo) []discovery.NodeInfo {
+	out := append([]discovery.NodeInfo(nil), in...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func clamp(i, n int) int {
+	if i >= n {
+		i = n - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
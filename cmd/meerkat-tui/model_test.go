@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/client"
+	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// fakeController records the calls the model makes.
+type fakeController struct {
+	mu          sync.Mutex
+	connects    []client.ConnectOptions
+	disconnects int
+	connectErr  error
+
+	tokens []vpn.SubscriptionToken
+	nodes  []discovery.NodeInfo
+	status chan client.Status
+}
+
+func (f *fakeController) Connect(_ context.Context, opts client.ConnectOptions) (client.Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connects = append(f.connects, opts)
+	if f.connectErr != nil {
+		return client.Status{}, f.connectErr
+	}
+	return client.Status{State: client.StateConnected, Backend: opts.Backend, NodeID: opts.NodeID}, nil
+}
+
+func (f *fakeController) Disconnect(context.Context) (client.Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.disconnects++
+	return client.Status{State: client.StateDisconnected}, nil
+}
+
+func (f *fakeController) Status(context.Context) (client.Status, error) {
+	return client.Status{State: client.StateDisconnected}, nil
+}
+
+func (f *fakeController) ListTokens(context.Context) ([]vpn.SubscriptionToken, error) {
+	return f.tokens, nil
+}
+
+func (f *fakeController) ListNodes(context.Context) ([]discovery.NodeInfo, error) {
+	return f.nodes, nil
+}
+
+func (f *fakeController) Subscribe(context.Context) (<-chan client.Status, error) {
+	return f.status, nil
+}
+
+var testNow = time.Unix(1700000000, 0)
+
+func newTestModel(t *testing.T, ctl *fakeController, nodeEvents chan discovery.NodeEvent) model {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	d := deps{
+		ctl: ctl,
+		watchNodes: func(context.Context) (<-chan discovery.NodeEvent, error) {
+			return nodeEvents, nil
+		},
+		health: func(id string) (discovery.HealthInfo, bool) {
+			return discovery.HealthInfo{Healthy: id != "down", LatencyMs: 42}, id != "unprobed"
+		},
+		now: func() time.Time { return testNow },
+	}
+	return newModel(ctx, d, "")
+}
+
+// update feeds msg to m.
+func update(t *testing.T, m model, msg tea.Msg) (model, tea.Cmd) {
+	t.Helper()
+	next, cmd := m.Update(msg)
+	nm, ok := next.(model)
+	if !ok {
+		t.Fatalf("Update returned %T", next)
+	}
+	return nm, cmd
+}
+
+// press sends a key press and runs the command it returns, if any,
+// feeding the result back in.
+func press(t *testing.T, m model, k string) model {
+	t.Helper()
+	var msg tea.KeyMsg
+	switch k {
+	case "enter":
+		msg = tea.KeyMsg{Type: tea.KeyEnter}
+	case "tab":
+		msg = tea.KeyMsg{Type: tea.KeyTab}
+	case "down":
+		msg = tea.KeyMsg{Type: tea.KeyDown}
+	case "up":
+		msg = tea.KeyMsg{Type: tea.KeyUp}
+	default:
+		msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+	}
+	m, cmd := update(t, m, msg)
+	if cmd != nil {
+		m, _ = update(t, m, cmd())
+	}
+	return m
+}
+
+func testNodes() []discovery.NodeInfo {
+	return []discovery.NodeInfo{
+		{ID: "c", Region: "us", Backends: []string{"openvpn"}},
+		{ID: "a", Region: "eu", Backends: []string{"openvpn", "wireguard"}},
+		{ID: "b", Region: "ap", Backends: []string{"wireguard"}},
+	}
+}
+
+func TestNavigateAndConnectToSelectedNode(t *testing.T) {
+	ctl := &fakeController{}
+	m := newTestModel(t, ctl, nil)
+	m, _ = update(t, m, nodesMsg(testNodes()))
+
+	if got := []string{m.nodes[0].ID, m.nodes[1].ID, m.nodes[2].ID}; strings.Join(got, "") != "abc" {
+		t.Fatalf("nodes not sorted: %v", got)
+	}
+
+	m = press(t, m, "up") // clamps at the top
+	m = press(t, m, "j")
+	m = press(t, m, "down")
+	m = press(t, m, "down") // clamps at the bottom
+	if m.nodeIdx != 2 {
+		t.Fatalf("nodeIdx = %d, want 2", m.nodeIdx)
+	}
+	m = press(t, m, "k")
+
+	m = press(t, m, "enter")
+	if len(ctl.connects) != 1 {
+		t.Fatalf("Connect called %d times", len(ctl.connects))
+	}
+	if got := ctl.connects[0]; got.NodeID != "b" || got.Backend != "openvpn" {
+		t.Errorf("Connect(%+v), want node b over openvpn", got)
+	}
+	if m.status.State != client.StateConnected || m.status.NodeID != "b" {
+		t.Errorf("status = %+v", m.status)
+	}
+	if v := m.View(); !strings.Contains(v, "connected") || !strings.Contains(v, "●") {
+		t.Errorf("view doesn't show the connection:\n%s", v)
+	}
+}
+
+func TestConnectAnyWithToggledBackend(t *testing.T) {
+	ctl := &fakeController{}
+	m := newTestModel(t, ctl, nil)
+	m, _ = update(t, m, nodesMsg(testNodes()))
+
+	m = press(t, m, "b")
+	if m.backend != "wireguard" {
+		t.Fatalf("backend = %q after toggle", m.backend)
+	}
+	m = press(t, m, "a")
+	if len(ctl.connects) != 1 || ctl.connects[0].NodeID != "" || ctl.connects[0].Backend != "wireguard" {
+		t.Fatalf("connects = %+v, want one auto-node wireguard connect", ctl.connects)
+	}
+
+	// From the tokens pane, enter doesn't pick a node either.
+	m = press(t, m, "tab")
+	if m.focus != paneTokens {
+		t.Fatalf("focus = %d after tab", m.focus)
+	}
+	press(t, m, "c")
+	if len(ctl.connects) != 2 || ctl.connects[1].NodeID != "" {
+		t.Errorf("connect from tokens pane = %+v", ctl.connects[1])
+	}
+}
+
+func TestConnectErrorAndDisconnect(t *testing.T) {
+	ctl := &fakeController{connectErr: errors.New("no valid tokens")}
+	m := newTestModel(t, ctl, nil)
+
+	m = press(t, m, "a")
+	if !strings.Contains(m.message, "connect: no valid tokens") {
+		t.Errorf("message = %q", m.message)
+	}
+	if m.status.State != client.StateDisconnected {
+		t.Errorf("status = %+v after failed connect", m.status)
+	}
+
+	m.status = client.Status{State: client.StateConnected}
+	m = press(t, m, "d")
+	if ctl.disconnects != 1 || m.status.State != client.StateDisconnected {
+		t.Errorf("disconnects = %d, status = %+v", ctl.disconnects, m.status)
+	}
+}
+
+func TestStatusStream(t *testing.T) {
+	ctl := &fakeController{status: make(chan client.Status, 1)}
+	m := newTestModel(t, ctl, nil)
+
+	sub, ok := m.subscribe()().(subscribedMsg)
+	if !ok {
+		t.Fatal("subscribe didn't return subscribedMsg")
+	}
+	m, _ = update(t, m, sub)
+
+	ctl.status <- client.Status{
+		State:     client.StateReconnecting,
+		NodeID:    "a",
+		TokenID:   "tok-1",
+		ExpiresAt: testNow.Add(26 * time.Hour).Unix(),
+		LastError: "handshake timeout",
+	}
+	m, next := update(t, m, waitStatus(sub.status)())
+	if m.status.State != client.StateReconnecting || next == nil {
+		t.Fatalf("status = %+v, re-armed = %v", m.status, next != nil)
+	}
+	v := m.View()
+	for _, want := range []string{"reconnecting", "tok-1", "1d 02h 00m", "handshake timeout"} {
+		if !strings.Contains(v, want) {
+			t.Errorf("view missing %q:\n%s", want, v)
+		}
+	}
+
+	close(ctl.status)
+	m, _ = update(t, m, next())
+	if m.message != "lost connection to the client daemon" {
+		t.Errorf("message = %q after status stream closed", m.message)
+	}
+}
+
+func TestNodeEvents(t *testing.T) {
+	events := make(chan discovery.NodeEvent, 4)
+	m := newTestModel(t, &fakeController{status: make(chan client.Status)}, events)
+	m, _ = update(t, m, nodesMsg(testNodes()))
+	m.nodeIdx = 2
+
+	sub := m.subscribe()().(subscribedMsg)
+	next := waitNodeEvent(sub.nodes)
+
+	send := func(ev discovery.NodeEvent) {
+		t.Helper()
+		events <- ev
+		m, next = update(t, m, next())
+		if next == nil {
+			t.Fatal("node event stream not re-armed")
+		}
+	}
+
+	send(discovery.NodeEvent{Type: discovery.NodeAdded, Node: discovery.NodeInfo{ID: "d", Region: "sa"}})
+	send(discovery.NodeEvent{Type: discovery.NodeUpdated, Node: discovery.NodeInfo{ID: "a", Region: "eu-west"}})
+	if len(m.nodes) != 4 || m.nodes[0].Region != "eu-west" || m.nodes[3].ID != "d" {
+		t.Fatalf("nodes after add/update = %+v", m.nodes)
+	}
+
+	m.nodeIdx = 3
+	send(discovery.NodeEvent{Type: discovery.NodeRemoved, Node: discovery.NodeInfo{ID: "d"}})
+	if len(m.nodes) != 3 || m.nodeIdx != 2 {
+		t.Errorf("after removing the selected last node: %d nodes, nodeIdx %d", len(m.nodes), m.nodeIdx)
+	}
+
+	send(discovery.NodeEvent{Type: discovery.NodeHealthChanged, Node: discovery.NodeInfo{ID: "a"}})
+	if v := m.View(); !strings.Contains(v, "up   42ms") {
+		t.Errorf("view missing health:\n%s", v)
+	}
+
+	close(events)
+	if _, ok := next().(nodesClosedMsg); !ok {
+		t.Error("closed node stream didn't report nodesClosedMsg")
+	}
+}
+
+func TestReceiveTokensToggle(t *testing.T) {
+	m := newTestModel(t, &fakeController{}, nil)
+	started := make(chan struct{})
+	m.deps.listenTokens = func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	}
+
+	m, cmd := update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	if !m.listening || cmd == nil {
+		t.Fatal("r didn't start listening")
+	}
+	done := make(chan tea.Msg, 1)
+	go func() { done <- cmd() }()
+	<-started
+
+	m, _ = update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	select {
+	case msg := <-done:
+		m, _ = update(t, m, msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("second r didn't stop the listener")
+	}
+	if m.listening || m.message != "stopped listening for tokens" {
+		t.Errorf("listening = %v, message = %q", m.listening, m.message)
+	}
+}
+
+func TestTokensCollapsedAndQuit(t *testing.T) {
+	m := newTestModel(t, &fakeController{}, nil)
+	first := vpn.SubscriptionToken{Payload: vpn.SubscriptionPayload{
+		TokenID: "tok-1", SubscriptionType: "monthly", IssuedAt: 1, ExpiresAt: testNow.Unix() - 10,
+	}}
+	renewed := vpn.SubscriptionToken{Payload: vpn.SubscriptionPayload{
+		TokenID: "tok-2", SubscriptionType: "monthly", IssuedAt: 2, ExpiresAt: testNow.Add(time.Hour).Unix(),
+		PreviousTokenID: "tok-1", SubscriptionID: "tok-1",
+	}}
+	m, _ = update(t, m, tokensMsg{first, renewed})
+	if len(m.tokens) != 1 || m.tokens[0].Payload.TokenID != "tok-2" {
+		t.Fatalf("tokens = %+v, want the renewal chain collapsed to tok-2", m.tokens)
+	}
+	if v := m.tokensView(); !strings.Contains(v, "01h 00m 00s") {
+		t.Errorf("tokens view = %q", v)
+	}
+
+	_, cmd := update(t, m, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	if cmd == nil {
+		t.Fatal("q returned no command")
+	}
+	if _, ok := cmd().(tea.QuitMsg); !ok {
+		t.Error("q didn't quit")
+	}
+}
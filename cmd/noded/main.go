@@ -1,15 +1,21 @@
 package main
 
 import (
+    "context"
     "encoding/json"
+    "fmt"
     "log"
     "net"
     "net/http"
     "os"
+    "strings"
     "time"
 
     "github.com/google/uuid"
 
+    "github.com/MakerMaker19/meerkatvpn/pkg/discovery"
+    "github.com/MakerMaker19/meerkatvpn/pkg/node"
+    "github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
     "github.com/MakerMaker19/meerkatvpn/pkg/vpn"
     "github.com/MakerMaker19/meerkatvpn/pkg/wg"
 )
@@ -22,6 +28,7 @@ type sessionCreateRequest struct {
 
 type sessionCreateResponse struct {
     Status  string `json:"status"`
+    Code    string `json:"code,omitempty"` // node.Code* on errors
     Message string `json:"message,omitempty"`
 
     // WireGuard parameters for the client to build a config.
@@ -36,6 +43,15 @@ type sessionCreateResponse struct {
 }
 
 func main() {
+    // `noded tc <tier> <client-ip>` prints the tc rules a session would get.
+    if len(os.Args) > 1 && os.Args[1] == "tc" {
+        if err := printTCRules(os.Args[2:]); err != nil {
+            fmt.Fprintln(os.Stderr, "noded tc:", err)
+            os.Exit(1)
+        }
+        return
+    }
+
     addr := os.Getenv("MEERKAT_NODE_LISTEN_ADDR")
     if addr == "" {
         addr = ":9090"
@@ -50,6 +66,45 @@ func main() {
         wgMgr = nil
     }
 
+    // Optional tier policy; without one only the limits of the token's plan apply.
+    policy, err := loadPolicyFromEnv()
+    if err != nil {
+        log.Fatalf("failed to load node policy: %v", err)
+    }
+
+    wgIface := "wg0"
+    if wgMgr != nil {
+        wgIface = wgMgr.Interface()
+    }
+    shaper := node.NewShaperFromEnv(wgIface)
+    ovpnShaper := node.NewShaperFromEnv(ovpnIfaceFromEnv())
+
+    // Optional: share session claims with the pool's other nodes so
+    // device limits hold across the network.
+    claims := claimsFromEnv(allowedPool)
+
+    sessions := node.NewSessions(sessionIdleFromEnv())
+    endSession := func(sess node.Session, why string) {
+        log.Printf("session end: session_id=%s token=%s reason=%s\n", sess.ID, sess.TokenID, why)
+        if claims != nil {
+            go claims.Release(context.Background(), sess.ClaimID, sess.SubID)
+        }
+        if sess.WGPubKey != "" && wgMgr != nil {
+            if err := wgMgr.RemovePeer(sess.WGPubKey); err != nil {
+                log.Println("wg remove peer error:", err)
+            }
+        }
+        if sess.ClientIP != "" && sess.Backend == "openvpn" {
+            ovpnShaper.Unlimit(sess.ClientIP)
+        } else if sess.ClientIP != "" {
+            shaper.Unlimit(sess.ClientIP)
+        }
+    }
+    // Optional: refuse (and end sessions of) tokens the pool revoked.
+    revocations := revocationsFromEnv(allowedPool)
+
+    go monitorSessions(sessions, wgMgr, ovpnStatusPathFromEnv(), ovpnShaper, claims, revocations, endSession)
+
     http.HandleFunc("/session/create", func(w http.ResponseWriter, r *http.Request) {
         if r.Method != http.MethodPost {
             http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -59,7 +114,11 @@ func main() {
         var req sessionCreateRequest
         if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
             log.Println("session create decode error:", err)
-            http.Error(w, "bad request", http.StatusBadRequest)
+            writeJSON(w, http.StatusBadRequest, sessionCreateResponse{
+                Status:  "error",
+                Code:    node.CodeBadRequest,
+                Message: "bad request",
+            })
             return
         }
 
@@ -71,6 +130,7 @@ func main() {
                 tok.Payload.IssuerPubKey, allowedPool)
             writeJSON(w, http.StatusForbidden, sessionCreateResponse{
                 Status:  "error",
+                Code:    node.CodeIssuerNotAllowed,
                 Message: "token issuer not allowed",
             })
             return
@@ -81,11 +141,22 @@ func main() {
             log.Println("session create: token invalid:", err)
             writeJSON(w, http.StatusForbidden, sessionCreateResponse{
                 Status:  "error",
+                Code:    node.CodeInvalidToken,
                 Message: "invalid token: " + err.Error(),
             })
             return
         }
 
+        if revocations != nil && revocations.Revoked(tok.Payload.TokenID) {
+            log.Printf("session create: token %s revoked\n", tok.Payload.TokenID)
+            writeJSON(w, http.StatusForbidden, sessionCreateResponse{
+                Status:  "error",
+                Code:    node.CodeTokenRevoked,
+                Message: "token revoked by the pool",
+            })
+            return
+        }
+
         // Decide which backend to use (default: openvpn).
         backend := req.Backend
         if backend == "" {
@@ -98,10 +169,78 @@ func main() {
         // Parse remote IP (strip port).
         remoteIP, _, _ := net.SplitHostPort(r.RemoteAddr)
 
+        session := node.Session{
+            ID:         sessionID,
+            TokenID:    tok.Payload.TokenID,
+            SubID:      tok.Payload.Subscription(),
+            UserPubKey: tok.Payload.UserPubKey,
+            Tier:       tok.Payload.Tier,
+            Backend:    backend,
+            RemoteIP:   remoteIP,
+            DeviceKey:  req.ClientWGPubKey,
+            CreatedAt:  time.Now(),
+            ExpiresAt:  time.Unix(tok.Payload.ExpiresAt, 0),
+        }
+        if claims != nil {
+            session.ClaimID = node.NewClaimID()
+        }
+
+        // Enforce the tier policy, if any, and the token's plan limits,
+        // reserving the session's slot in the same step.
+        var tier node.TierPolicy
+        replaced, perr := sessions.Reserve(session, time.Now(), func(sess *node.Session, u node.Usage) *node.Error {
+            policyReq := node.Request{
+                Tier:           tok.Payload.Tier,
+                Backend:        backend,
+                ActiveSessions: u.Live,
+                UsedBytes:      u.Used,
+                NodeSessions:   u.NodeLive,
+                Plan:           tok.Payload.PlanLimits,
+            }
+            if claims != nil {
+                policyReq.ClaimedElsewhere = claims.Others(session.SubID, time.Now())
+            }
+            var perr *node.Error
+            tier, perr = policy.Check(policyReq)
+            sess.DataCap = tier.DataCapBytes()
+            if policy != nil {
+                sess.Speed = policy.Speed(tier)
+            }
+            return perr
+        })
+        if perr != nil {
+            log.Printf("session create: refused token=%s tier=%s plan=%s backend=%s: %v\n",
+                tok.Payload.TokenID, tok.Payload.Tier, tok.Payload.SubscriptionType, backend, perr)
+            writeJSON(w, policyErrorStatus(perr.Code), sessionCreateResponse{
+                Status:  "error",
+                Code:    perr.Code,
+                Message: perr.Message,
+            })
+            return
+        }
+        for _, old := range replaced {
+            // The same device keeps its WireGuard peer in the new session.
+            if backend != "openvpn" && old.WGPubKey == req.ClientWGPubKey {
+                old.WGPubKey = ""
+            }
+            endSession(old, "replaced")
+        }
+        if claims != nil {
+            go func() {
+                ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+                defer cancel()
+                if err := claims.Claim(ctx, session.ClaimID, session.SubID); err != nil {
+                    log.Println("session claim publish error:", err)
+                }
+            }()
+        }
+
         // Structured logging for audit trail.
-        log.Printf("session create: accepted token=%s user=%s backend=%s session_id=%s remote_ip=%s",
+        log.Printf("session create: accepted token=%s user=%s tier=%s plan=%s backend=%s session_id=%s remote_ip=%s",
             tok.Payload.TokenID,
             tok.Payload.UserPubKey,
+            tok.Payload.Tier,
+            tok.Payload.SubscriptionType,
             backend,
             sessionID,
             remoteIP,
@@ -119,8 +258,10 @@ func main() {
                 log.Printf("session create: failed to read OpenVPN profile from %s: %v\n", ovpnPath, err)
                 writeJSON(w, http.StatusInternalServerError, sessionCreateResponse{
                     Status:  "error",
+                    Code:    node.CodeInternal,
                     Message: "node: could not load OpenVPN profile (check MEERKAT_NODE_OVPN_PROFILE_PATH and file perms)",
                 })
+                sessions.Remove(sessionID)
                 return
             }
 
@@ -135,6 +276,10 @@ func main() {
 
             fullProfile := header + string(profileBytes)
 
+            // The session's speed class is applied once its client
+            // connects and shows up in the server's status; see
+            // monitorSessions.
+
             writeJSON(w, http.StatusOK, sessionCreateResponse{
                 Status:      "ok",
                 Message:     "session accepted (OpenVPN profile)",
@@ -158,6 +303,13 @@ func main() {
             }
         }
 
+        if policy != nil {
+            if err := shaper.Limit(clientIP, policy.Speed(tier)); err != nil {
+                log.Println("tc limit error:", err)
+            }
+        }
+        sessions.SetPeer(sessionID, req.ClientWGPubKey, clientIP)
+
         // Read WG-related env vars or use some simple defaults.
         serverPub := os.Getenv("MEERKAT_NODE_WG_PUBKEY")
         if serverPub == "" {
@@ -192,3 +344,279 @@ func writeJSON(w http.ResponseWriter, code int, v any) {
     w.WriteHeader(code)
     _ = json.NewEncoder(w).Encode(v)
 }
+
+func policyErrorStatus(code string) int {
+    switch code {
+    case node.CodeMaxSessions, node.CodeDeviceLimit:
+        return http.StatusConflict
+    case node.CodeNodeBusy:
+        return http.StatusServiceUnavailable
+    default:
+        return http.StatusForbidden
+    }
+}
+
+// loadPolicyFromEnv loads MEERKAT_NODE_POLICY_FILE, if set. The node's
+// region comes from the file or MEERKAT_NODE_REGION.
+func loadPolicyFromEnv() (*node.Policy, error) {
+    path := os.Getenv("MEERKAT_NODE_POLICY_FILE")
+    if path == "" {
+        log.Println("noded: MEERKAT_NODE_POLICY_FILE not set; tiers are not enforced")
+        return nil, nil
+    }
+    policy, err := node.LoadPolicy(path)
+    if err != nil {
+        return nil, err
+    }
+    if policy.Region == "" {
+        policy.Region = os.Getenv("MEERKAT_NODE_REGION")
+    }
+    log.Printf("noded: enforcing tier policy from %s (%d tiers, region=%q)\n", path, len(policy.Tiers), policy.Region)
+    return policy, nil
+}
+
+// sessionIdleFromEnv returns MEERKAT_NODE_SESSION_IDLE (default 10m):
+// sessions without a WireGuard handshake for this long stop counting
+// towards the tier's session limit.
+func sessionIdleFromEnv() time.Duration {
+    if v := os.Getenv("MEERKAT_NODE_SESSION_IDLE"); v != "" {
+        if d, err := time.ParseDuration(v); err == nil && d > 0 {
+            return d
+        }
+        log.Printf("noded: invalid MEERKAT_NODE_SESSION_IDLE %q; using 10m\n", v)
+    }
+    return 10 * time.Minute
+}
+
+// claimsFromEnv returns session claims over Nostr if MEERKAT_NODE_NOSTR_PRIVKEY
+// and MEERKAT_NODE_RELAYS are set. Claims are scoped to poolPub, so they
+// need MEERKAT_NODE_ALLOWED_POOL_PUBKEY too, and only count from the
+// pool's nodes: those approved in the registry at MEERKAT_NODE_POOL_URL
+// and those in MEERKAT_NODE_CLAIM_PEERS (comma-separated npubs or hex).
+// Without claims, device limits only count this node's sessions.
+func claimsFromEnv(poolPub string) node.Claims {
+    priv := os.Getenv("MEERKAT_NODE_NOSTR_PRIVKEY")
+    relays := os.Getenv("MEERKAT_NODE_RELAYS")
+    if priv == "" || relays == "" {
+        return nil
+    }
+    if poolPub == "" {
+        log.Println("noded: session claims need MEERKAT_NODE_ALLOWED_POOL_PUBKEY; not sharing claims")
+        return nil
+    }
+    poolHex, err := nostrutil.ParsePubKey(poolPub)
+    if err != nil {
+        log.Printf("noded: bad pool pubkey %q: %v; not sharing claims\n", poolPub, err)
+        return nil
+    }
+
+    approved, err := claimPeersFromEnv(poolHex)
+    if err != nil {
+        log.Printf("noded: %v; not sharing claims\n", err)
+        return nil
+    }
+
+    ctx := context.Background()
+    nc, err := nostrutil.NewClient(ctx, priv, strings.Split(relays, ","))
+    if err != nil {
+        log.Printf("noded: session claims disabled: %v\n", err)
+        return nil
+    }
+    claims := node.NewNostrClaims(nc, poolHex, claimTTL, approved)
+    go claims.Run(ctx)
+    log.Printf("noded: sharing session claims as %s on %d relay(s)\n", nc.PubKey, len(nc.Relays.URLs()))
+    return claims
+}
+
+// claimPeersFromEnv returns whether a pubkey belongs to one of the
+// pool's nodes, whose session claims count: a node with an attestation
+// in the registry at MEERKAT_NODE_POOL_URL, or one listed in
+// MEERKAT_NODE_CLAIM_PEERS.
+func claimPeersFromEnv(poolHex string) (func(string) bool, error) {
+    peers := map[string]bool{}
+    for _, p := range strings.Split(os.Getenv("MEERKAT_NODE_CLAIM_PEERS"), ",") {
+        if p = strings.TrimSpace(p); p == "" {
+            continue
+        }
+        pub, err := nostrutil.ParsePubKey(p)
+        if err != nil {
+            return nil, fmt.Errorf("MEERKAT_NODE_CLAIM_PEERS: %q: %w", p, err)
+        }
+        peers[pub] = true
+    }
+
+    var registry *discovery.HTTPFinder
+    if base := os.Getenv("MEERKAT_NODE_POOL_URL"); base != "" {
+        registry = discovery.NewHTTPFinder(base, poolHex)
+    }
+    if registry == nil && len(peers) == 0 {
+        return nil, fmt.Errorf("session claims need MEERKAT_NODE_POOL_URL or MEERKAT_NODE_CLAIM_PEERS to tell the pool's nodes apart")
+    }
+
+    return func(pub string) bool {
+        if peers[pub] {
+            return true
+        }
+        if registry == nil {
+            return false
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+        defer cancel()
+        nodes, err := registry.ListNodes(ctx)
+        if err != nil {
+            log.Printf("noded: node registry: %v\n", err)
+            return false
+        }
+        for _, n := range nodes {
+            if n.PubKey == pub {
+                return true
+            }
+        }
+        return false
+    }, nil
+}
+
+// revocationsFromEnv follows the revocation list of the pool at
+// MEERKAT_NODE_POOL_URL, if set; verifying it needs
+// MEERKAT_NODE_ALLOWED_POOL_PUBKEY.
+func revocationsFromEnv(poolPub string) *node.Revocations {
+    base := os.Getenv("MEERKAT_NODE_POOL_URL")
+    if base == "" {
+        return nil
+    }
+    poolHex, err := nostrutil.ParsePubKey(poolPub)
+    if err != nil {
+        log.Println("noded: revocations need a valid MEERKAT_NODE_ALLOWED_POOL_PUBKEY; not following them")
+        return nil
+    }
+    rev := node.NewRevocations(strings.TrimRight(base, "/")+"/revocations", poolHex)
+    go rev.Run(context.Background(), 5*time.Minute)
+    log.Printf("noded: following revocations from %s\n", base)
+    return rev
+}
+
+// claimTTL is how long a session claim stays valid without a refresh;
+// monitorSessions refreshes live sessions' claims well before that.
+const claimTTL = 5 * time.Minute
+
+// ovpnStatusPathFromEnv returns MEERKAT_NODE_OVPN_STATUS_PATH, the
+// OpenVPN server's status file (its "status" directive), defaulting to
+// that of the openvpn-server@server unit.
+func ovpnStatusPathFromEnv() string {
+    if p := os.Getenv("MEERKAT_NODE_OVPN_STATUS_PATH"); p != "" {
+        return p
+    }
+    return "/run/openvpn-server/status-server.log"
+}
+
+// ovpnIfaceFromEnv returns MEERKAT_NODE_OVPN_IFACE, the OpenVPN server's
+// tun interface, where OpenVPN sessions' speed classes are applied.
+func ovpnIfaceFromEnv() string {
+    if iface := os.Getenv("MEERKAT_NODE_OVPN_IFACE"); iface != "" {
+        return iface
+    }
+    return "tun0"
+}
+
+// monitorSessions feeds WireGuard peer stats and the OpenVPN server's
+// client list to the session tracker, applies OpenVPN sessions' speed
+// classes to their tunnel addresses on ovpnShaper, and ends sessions
+// that went idle, used up their data cap or whose token was revoked.
+// With claims, it also refreshes the claims of the sessions still live.
+func monitorSessions(sessions *node.Sessions, wgMgr *wg.Manager, ovpnStatusPath string, ovpnShaper *node.Shaper, claims node.Claims, revocations *node.Revocations, end func(node.Session, string)) {
+    lastRefresh := time.Now()
+    ovpnStatusOK := true
+    for range time.Tick(30 * time.Second) {
+        var stats map[string]node.PeerStat
+        if wgMgr != nil {
+            peers, err := wgMgr.PeerStats()
+            if err != nil {
+                log.Println("wg peer stats error:", err)
+            }
+            stats = make(map[string]node.PeerStat, len(peers))
+            for pub, p := range peers {
+                stats[pub] = node.PeerStat{LastHandshake: p.LastHandshake, Bytes: p.RxBytes + p.TxBytes}
+            }
+        }
+        ovpnClients, err := node.ReadOpenVPNStatus(ovpnStatusPath)
+        if err != nil && ovpnStatusOK {
+            log.Printf("openvpn status unavailable (%v); OpenVPN sessions go idle %s after creation, unmetered and unshaped\n", err, sessionIdleFromEnv())
+        }
+        ovpnStatusOK = err == nil
+        ended, moved := sessions.Update(stats, ovpnClients, time.Now())
+        // Lift all old limits first: an address may pass to another session.
+        for _, m := range moved {
+            if m.OldIP != "" {
+                ovpnShaper.Unlimit(m.OldIP)
+            }
+        }
+        for _, m := range moved {
+            if m.Session.ClientIP == "" {
+                continue
+            }
+            if err := ovpnShaper.Limit(m.Session.ClientIP, m.Session.Speed); err != nil {
+                log.Println("tc limit error:", err)
+            }
+        }
+        for _, sess := range ended {
+            end(sess, "idle, expired or over data cap")
+        }
+        if revocations != nil {
+            for _, sess := range sessions.List() {
+                if !revocations.Revoked(sess.TokenID) {
+                    continue
+                }
+                if sess, ok := sessions.Remove(sess.ID); ok {
+                    end(sess, "token revoked")
+                }
+            }
+        }
+
+        if claims != nil && time.Since(lastRefresh) >= claimTTL/2 {
+            lastRefresh = time.Now()
+            for _, sess := range sessions.List() {
+                if err := claims.Claim(context.Background(), sess.ClaimID, sess.SubID); err != nil {
+                    log.Println("session claim refresh error:", err)
+                }
+            }
+        }
+    }
+}
+
+// printTCRules implements `noded tc <tier> <client-ip>`: it prints the
+// tc commands a WireGuard session of that tier would get.
+func printTCRules(args []string) error {
+    if len(args) != 2 {
+        return fmt.Errorf("usage: noded tc <tier> <client-ip>")
+    }
+    policy, err := loadPolicyFromEnv()
+    if err != nil {
+        return err
+    }
+    if policy == nil {
+        return fmt.Errorf("MEERKAT_NODE_POLICY_FILE not set")
+    }
+    tier, ok := policy.Tiers[args[0]]
+    if !ok {
+        return fmt.Errorf("tier %q not in policy", args[0])
+    }
+    speed := policy.Speed(tier)
+    if speed.Unlimited() {
+        fmt.Printf("# tier %q has no speed limit\n", args[0])
+        return nil
+    }
+
+    iface := os.Getenv("MEERKAT_NODE_WG_INTERFACE")
+    if iface == "" {
+        iface = "wg0"
+    }
+    shaper := node.NewShaperFromEnv(iface)
+    cmds, err := shaper.LimitCommands(args[1], speed)
+    if err != nil {
+        return err
+    }
+    for _, c := range append(shaper.SetupCommands(), cmds...) {
+        fmt.Println(node.FormatCommand(c))
+    }
+    return nil
+}
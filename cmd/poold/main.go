@@ -2,26 +2,28 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	// "time"
-
-	"github.com/btcsuite/btcd/btcec/v2"
+	"path/filepath"
+	"time"
 
+	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
 	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
 	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
 )
 
 func main() {
-	// ---- 1. Read environment variables ----
-
-	nostrPriv := os.Getenv("MEERKAT_POOL_NOSTR_PRIVKEY")
-	if nostrPriv == "" {
-		log.Fatal("MEERKAT_POOL_NOSTR_PRIVKEY not set (nsec or hex)")
+	if len(os.Args) > 1 && os.Args[1] == "voucher" {
+		if err := cmdVoucher(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
+	// ---- 1. Read environment variables ----
+
 	webhookAddr := os.Getenv("MEERKAT_POOL_LN_WEBHOOK_ADDR")
 	if webhookAddr == "" {
 		webhookAddr = ":8080"
@@ -32,41 +34,174 @@ func main() {
 		log.Println("WARNING: MEERKAT_POOL_LN_WEBHOOK_SECRET not set; webhook will accept any request with any secret")
 	}
 
-	pricing := pool.LoadPricingFromEnv()
+	catalog, err := pool.LoadCatalogFromEnv()
+	if err != nil {
+		log.Fatalf("failed to load plan catalog: %v", err)
+	}
 	relayURLs := pool.RelayURLsFromEnv()
 
-	// ---- 2. Create Nostr client ----
+	// ---- 2. Pool signer ----
 
+	// The pool key signs both Nostr events and tokens. It is either
+	// MEERKAT_POOL_NOSTR_PRIVKEY (nsec or hex) or held by the NIP-46
+	// remote signer at MEERKAT_POOL_BUNKER_URL, so it can live on a
+	// separate machine.
 	ctx := context.Background()
-	nostrClient, err := nostrutil.NewClient(ctx, nostrPriv, relayURLs)
+	signer, err := nostrutil.SignerFromEnv(ctx, "MEERKAT_POOL")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, remote := signer.(*nostrutil.BunkerSigner); remote {
+		log.Printf("poold: signing as %s via remote signer", signer.PubKey())
+	}
+
+	// ---- 3. Create Nostr client ----
+
+	nostrClient, err := nostrutil.NewClientWithSigner(ctx, signer, relayURLs)
 	if err != nil {
 		log.Fatalf("failed to init Nostr client: %v", err)
 	}
 
-	// ---- 3. Build btcec private key from same nostr priv ----
+	// ---- 4. Create pool server ----
+
+	srv := pool.NewServer(nostrClient, catalog, webhookSecret)
+	srv.PublicURL = os.Getenv("MEERKAT_POOL_PUBLIC_URL")
+
+	// The ledger of issued tokens makes sure no payment is paid out twice,
+	// including across restarts.
+	ledgerPath, err := ledgerPathFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if srv.Ledger, err = pool.OpenLedger(ledgerPath); err != nil {
+		log.Fatalf("failed to open ledger: %v", err)
+	}
+
+	// Token DMs go through a persistent outbox and are republished until
+	// enough relays confirm them.
+	if srv.Outbox, err = pool.OutboxFromEnv(nostrClient); err != nil {
+		log.Fatalf("failed to open DM outbox: %v", err)
+	}
+	go srv.Outbox.Run(ctx)
+
+	// ---- 5. HTTP handlers ----
+
+	http.HandleFunc("/ln/webhook", srv.LNWebhookHandler)
+
+	// Renewals are paid like purchases, with renew_token_id set; clients
+	// can price one first.
+	http.HandleFunc("/renew/quote", srv.RenewQuoteHandler)
+
+	// Holders hand subscriptions over with signed requests at POST /transfer;
+	// nodes fetch the tokens that revokes from GET /revocations.
+	http.HandleFunc("/transfer", srv.TransferHandler)
+	http.HandleFunc("/revocations", srv.RevocationsHandler)
 
-	parsed, err := nostrutil.ParsePrivKey(nostrPriv)
+	// Users who lost their tokens get the live ones back from the ledger by
+	// signing a challenge with their key.
+	http.HandleFunc("/recover/challenge", srv.RecoverChallengeHandler)
+	http.HandleFunc("/recover", srv.RecoverHandler)
+
+	// Vouchers created with `poold voucher create` are redeemed at POST
+	// /voucher or by DM; the file is reloaded when it changes.
+	vouchers, err := pool.OpenVouchers(pool.VouchersPathFromEnv())
 	if err != nil {
-		log.Fatalf("failed to parse pool nostr privkey: %v", err)
+		log.Fatalf("failed to load vouchers: %v", err)
 	}
+	srv.EnableVouchers(vouchers)
+	http.HandleFunc("/voucher", srv.VoucherHandler)
 
-	privBytes, err := hex.DecodeString(parsed.PrivHex)
+	// Optional free trials, one per pubkey per cooldown, at POST /trial or
+	// by DM, with POST /trial also limited per address. Trial tokens carry
+	// the "trial" tier.
+	trials, err := pool.TrialPolicyFromEnv()
 	if err != nil {
-		log.Fatalf("failed to decode pool priv hex: %v", err)
+		log.Fatalf("invalid trial settings: %v", err)
+	}
+	if trials != nil {
+		if err := srv.EnableTrials(*trials, pool.TrialsPathFromEnv()); err != nil {
+			log.Fatalf("failed to enable trials: %v", err)
+		}
+		http.HandleFunc("/trial", srv.TrialHandler)
+		log.Printf("poold: free trials of %s enabled at POST /trial (one per pubkey every %s, per address every %s, %d/hour, %d/hour by DM)",
+			trials.Duration, trials.Cooldown, trials.IPCooldown, trials.PerHour, trials.DMPerHour)
 	}
+	srv.ListenForRequestDMs(ctx)
 
-	poolPrivKey, _ := btcec.PrivKeyFromBytes(privBytes)
+	// Optional self-serve purchases: clients request invoices at POST /invoice
+	// and find the endpoint and prices in the kind-30070 pricing event.
+	publishPricing := trials != nil
+	if backend := pool.InvoiceBackendFromEnv(); backend != nil {
+		if err := srv.EnableInvoices(backend, os.Getenv("MEERKAT_POOL_INVOICES_FILE"), 10*time.Second); err != nil {
+			log.Fatalf("failed to enable invoices: %v", err)
+		}
+		http.HandleFunc("/invoice", srv.InvoiceHandler)
+		http.HandleFunc("/gift", srv.GiftHandler)
+		if srv.PublicURL == "" {
+			log.Println("WARNING: MEERKAT_POOL_PUBLIC_URL not set; clients won't find the invoice endpoint in the pricing event")
+		}
+		publishPricing = true
+		log.Println("poold: invoices enabled at POST /invoice and POST /gift")
+	}
 
-	// ---- 4. Create pool server ----
+	// Optional Cashu payments: tokens from the allowed mints are redeemed at
+	// POST /redeem or from encrypted DMs to the pool's pubkey.
+	if mints := pool.CashuMintsFromEnv(); len(mints) > 0 {
+		srv.EnableCashu(mints...)
+		http.HandleFunc("/redeem", srv.RedeemHandler)
+		srv.ListenForCashuDMs(ctx)
+		publishPricing = true
+		log.Printf("poold: accepting Cashu tokens from %d mint(s) at POST /redeem and via DM", len(mints))
+	}
+
+	// Optional zaps: NIP-57 receipts for zaps to the pool's pubkey buy the
+	// plan their amount pays for.
+	zapProvider, err := pool.ZapProviderFromEnv(ctx)
+	if err != nil {
+		log.Fatalf("failed to resolve zap provider: %v", err)
+	}
+	if zapProvider != "" {
+		srv.EnableZaps(ctx, zapProvider)
+		publishPricing = true
+		log.Printf("poold: issuing subscriptions for zaps (receipts signed by %s)", zapProvider)
+	}
 
-	srv := pool.NewServer(nostrClient, poolPrivKey, pricing, webhookSecret)
+	// Advertise the pool's relays (NIP-65) so clients find them.
+	go func() {
+		if err := srv.PublishRelayList(ctx); err != nil {
+			log.Printf("poold: failed to publish relay list: %v", err)
+		}
+	}()
 
-	// Periodically publish pricing as a Nostr event (optional)
-	// srv.StartPricingPublisher(10 * time.Minute)
+	// Periodically publish pricing (and the payment endpoints) as a Nostr event
+	if publishPricing {
+		srv.StartPricingPublisher(10 * time.Minute)
+	}
 
-	// ---- 5. HTTP webhook handler ----
+	// Optional node registry for clients that can't reach Nostr relays.
+	if nodesFile := os.Getenv("MEERKAT_POOL_NODES_FILE"); nodesFile != "" {
+		approved, err := discovery.NewStaticFinderFromFile(nodesFile)
+		if err != nil {
+			log.Fatalf("failed to load approved nodes: %v", err)
+		}
+		approved.WatchFile(ctx, nodesFile)
 
-	http.HandleFunc("/ln/webhook", srv.LNWebhookHandler)
+		srv.Registry = pool.NewRegistry(approved, signer)
+		srv.Registry.File = nodesFile
+		http.HandleFunc("/nodes", srv.Registry.NodesHandler)
+		log.Printf("poold: serving node registry from %s at GET /nodes", nodesFile)
+	}
+
+	// Optional operator API for meerkat-admin, authenticated with NIP-98
+	// for URLs on MEERKAT_POOL_PUBLIC_URL's host or MEERKAT_POOL_ADMIN_HOSTS.
+	if operators := pool.AdminPubKeysFromEnv(); len(operators) > 0 {
+		admin, err := pool.NewAdmin(srv, operators, pool.AdminHostsFromEnv())
+		if err != nil {
+			log.Fatalf("admin API: %v", err)
+		}
+		http.Handle("/admin/", admin)
+		log.Printf("poold: admin API enabled at /admin/ for %d operator key(s)", len(operators))
+	}
 
 	log.Printf("poold: listening on %s for LN webhooks...", webhookAddr)
 	if err := http.ListenAndServe(webhookAddr, nil); err != nil {
@@ -74,6 +209,19 @@ func main() {
 	}
 }
 
+// ledgerPathFromEnv returns MEERKAT_POOL_LEDGER_FILE, defaulting to
+// ~/.meerkatvpn/pool-ledger.json.
+func ledgerPathFromEnv() (string, error) {
+	if p := os.Getenv("MEERKAT_POOL_LEDGER_FILE"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("MEERKAT_POOL_LEDGER_FILE not set and no home directory: %w", err)
+	}
+	return filepath.Join(home, ".meerkatvpn", "pool-ledger.json"), nil
+}
+
 // ---------------------------------------------------------------------
 // Legacy scaffold (kept for reference)
 //
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
+)
+
+// cmdVoucher manages the vouchers file: `poold voucher create` and
+// `poold voucher list`. A running poold picks up new vouchers by itself.
+func cmdVoucher(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: poold voucher create|list [flags]")
+	}
+	vouchers, err := pool.OpenVouchers(pool.VouchersPathFromEnv())
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "create":
+		return voucherCreate(vouchers, args[1:])
+	case "list":
+		return voucherList(vouchers)
+	default:
+		return fmt.Errorf("unknown voucher command %q (create or list)", args[0])
+	}
+}
+
+func voucherCreate(vouchers *pool.Vouchers, args []string) error {
+	fs := flag.NewFlagSet("voucher create", flag.ContinueOnError)
+	plan := fs.String("plan", "", "plan the voucher redeems for (an ID from the catalog)")
+	uses := fs.Int("uses", 1, "how many pubkeys may redeem each code")
+	count := fs.Int("count", 1, "number of codes to create")
+	expires := fs.String("expires", "", "how long the codes stay redeemable, e.g. 30d (default: forever)")
+	code := fs.String("code", "", "use this code instead of a random one (with --count 1)")
+	note := fs.String("note", "", "operator note, e.g. who the codes are for")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *plan == "" || *uses < 1 || *count < 1 {
+		return errors.New("usage: poold voucher create --plan P [--uses N] [--count K] [--expires 30d] [--code C] [--note N]")
+	}
+	if *code != "" && *count != 1 {
+		return errors.New("--code creates a single voucher; drop --count")
+	}
+
+	catalog, err := pool.LoadCatalogFromEnv()
+	if err != nil {
+		return fmt.Errorf("load plan catalog: %w", err)
+	}
+	if _, ok := catalog.Plan(*plan); !ok {
+		return fmt.Errorf("plan %q not in the catalog", *plan)
+	}
+
+	now := time.Now()
+	var expiresAt int64
+	if *expires != "" {
+		d, err := pool.ParseDuration(*expires)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid --expires %q", *expires)
+		}
+		expiresAt = now.Add(d).Unix()
+	}
+
+	created := make([]pool.Voucher, *count)
+	for i := range created {
+		c := *code
+		if c == "" {
+			c = pool.NewVoucherCode()
+		}
+		created[i] = pool.Voucher{
+			Code:      c,
+			Plan:      *plan,
+			MaxUses:   *uses,
+			ExpiresAt: expiresAt,
+			CreatedAt: now.Unix(),
+			Note:      *note,
+		}
+	}
+	if err := vouchers.Add(created...); err != nil {
+		return err
+	}
+	for _, v := range created {
+		fmt.Println(v.Code)
+	}
+	return nil
+}
+
+func voucherList(vouchers *pool.Vouchers) error {
+	ledgerPath, err := ledgerPathFromEnv()
+	if err != nil {
+		return err
+	}
+	ledger, err := pool.OpenLedger(ledgerPath)
+	if err != nil {
+		return fmt.Errorf("open ledger: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODE\tPLAN\tUSED\tEXPIRES\tNOTE")
+	for _, v := range vouchers.List() {
+		expires := "never"
+		if v.ExpiresAt != 0 {
+			expires = time.Unix(v.ExpiresAt, 0).Local().Format(time.RFC3339)
+		}
+		used := ledger.CountSource(pool.SourceVoucher, pool.NormalizeVoucherCode(v.Code)+"/")
+		fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%s\t%s\n", v.Code, v.Plan, used, v.Uses(), expires, v.Note)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,55 @@
+// Package bolt11 reads Lightning (BOLT11) payment requests.
+package bolt11
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AmountMsat returns the amount of a BOLT11 invoice from its
+// human-readable part, in millisatoshis.
+func AmountMsat(invoice string) (int64, error) {
+	inv := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(invoice)), "lightning:")
+	sep := strings.LastIndexByte(inv, '1')
+	if !strings.HasPrefix(inv, "ln") || sep < 0 {
+		return 0, errors.New("bolt11: malformed invoice")
+	}
+	hrp := inv[2:sep]
+	for _, prefix := range []string{"bcrt", "bc", "tbs", "tb", "sb"} {
+		if strings.HasPrefix(hrp, prefix) {
+			hrp = hrp[len(prefix):]
+			break
+		}
+	}
+	if hrp == "" {
+		return 0, errors.New("bolt11: invoice has no amount")
+	}
+
+	// Amounts are in BTC, scaled by an optional multiplier.
+	mult := map[byte]int64{'m': 100_000_000, 'u': 100_000, 'n': 100}
+	digits, unit := hrp, byte(0)
+	if last := hrp[len(hrp)-1]; last < '0' || last > '9' {
+		digits, unit = hrp[:len(hrp)-1], last
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bolt11: invalid amount %q", hrp)
+	}
+	switch unit {
+	case 0:
+		return n * 100_000_000_000, nil
+	case 'p':
+		if n%10 != 0 {
+			return 0, fmt.Errorf("bolt11: sub-millisatoshi amount %q", hrp)
+		}
+		return n / 10, nil
+	default:
+		m, ok := mult[unit]
+		if !ok {
+			return 0, fmt.Errorf("bolt11: invalid multiplier %q", unit)
+		}
+		return n * m, nil
+	}
+}
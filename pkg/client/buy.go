@@ -0,0 +1,402 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/bolt11"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nwc"
+	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// PricingEventKind is the kind of the pool's pricing event.
+const PricingEventKind = 30070
+
+// PoolPricing is the content of the pool's kind-30070 pricing event.
+type PoolPricing struct {
+	Currency         string           `json:"currency"`
+	Offers           []pool.PlanOffer `json:"plans,omitempty"`
+	WeeklyPriceSats  int64            `json:"weekly_price_sats"`
+	MonthlyPriceSats int64            `json:"monthly_price_sats"`
+	YearlyPriceSats  int64            `json:"yearly_price_sats"`
+	PriceLastUpdated int64            `json:"price_last_updated"`
+	InvoiceURL       string           `json:"invoice_url,omitempty"`
+	GiftURL          string           `json:"gift_url,omitempty"`
+	TransferURL      string           `json:"transfer_url,omitempty"`
+	RecoverURL       string           `json:"recover_url,omitempty"`
+	VoucherURL       string           `json:"voucher_url,omitempty"`
+	TrialURL         string           `json:"trial_url,omitempty"`
+	TrialSeconds     int64            `json:"trial_seconds,omitempty"` // 0: no free trials
+}
+
+// Plan is a purchasable subscription plan.
+type Plan struct {
+	Name      string // plan ID, as passed to the pool
+	Title     string // display name, may be empty
+	Tier      string
+	PriceSats int64
+	Duration  time.Duration
+}
+
+// legacyPlanDurations are the plans of pools that predate plan catalogs.
+var legacyPlanDurations = map[string]time.Duration{
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+	"yearly":  365 * 24 * time.Hour,
+}
+
+// Plans lists the plans with a price, cheapest first.
+func (p *PoolPricing) Plans() []Plan {
+	var out []Plan
+	if len(p.Offers) > 0 {
+		for _, o := range p.Offers {
+			if o.PriceSats > 0 {
+				out = append(out, Plan{
+					Name:      o.ID,
+					Title:     o.Name,
+					Tier:      o.Tier,
+					PriceSats: o.PriceSats,
+					Duration:  time.Duration(o.DurationSeconds) * time.Second,
+				})
+			}
+		}
+	} else {
+		for _, pl := range []struct {
+			name  string
+			price int64
+		}{
+			{"weekly", p.WeeklyPriceSats},
+			{"monthly", p.MonthlyPriceSats},
+			{"yearly", p.YearlyPriceSats},
+		} {
+			if pl.price > 0 {
+				out = append(out, Plan{Name: pl.name, Tier: pool.DefaultTier, PriceSats: pl.price, Duration: legacyPlanDurations[pl.name]})
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].PriceSats < out[j].PriceSats })
+	return out
+}
+
+// FetchPricing returns the pool's latest pricing event from relays.
+func FetchPricing(ctx context.Context, relays []string, poolPubHex string) (*PoolPricing, error) {
+	if len(relays) == 0 {
+		return nil, errors.New("no relays configured")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	sp := newSimplePool(ctx)
+	filter := nostr.Filter{
+		Kinds:   []int{PricingEventKind},
+		Authors: []string{poolPubHex},
+		Tags:    nostr.TagMap{"t": []string{"vpn-network-pricing"}},
+	}
+
+	var latest *nostr.Event
+	for ev := range sp.FetchMany(ctx, relays, filter) {
+		if ev.Event == nil || ev.PubKey != poolPubHex {
+			continue
+		}
+		if latest == nil || ev.CreatedAt > latest.CreatedAt {
+			latest = ev.Event
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no pricing event from pool %s on %v", poolPubHex, relays)
+	}
+
+	var p PoolPricing
+	if err := json.Unmarshal([]byte(latest.Content), &p); err != nil {
+		return nil, fmt.Errorf("decode pricing event %s: %w", latest.ID, err)
+	}
+	return &p, nil
+}
+
+// ErrUnknownRenewToken means the pool doesn't know the token a renewal
+// was requested for; buying a new subscription still works.
+var ErrUnknownRenewToken = errors.New("pool doesn't know the token to renew")
+
+// RequestInvoice asks the pool at invoiceURL (POST /invoice) for an
+// invoice for req.Plan; the token will be DMed to req.NostrPubKey (or,
+// for renewals, the subscription's holder) once it is paid.
+func RequestInvoice(ctx context.Context, invoiceURL string, req pool.InvoiceRequest) (*pool.InvoiceResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, invoiceURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("POST %s: %w", invoiceURL, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(raw))
+		if req.RenewTokenID != "" && strings.Contains(msg, "unknown renew_token_id") {
+			return nil, fmt.Errorf("%w %s", ErrUnknownRenewToken, req.RenewTokenID)
+		}
+		return nil, fmt.Errorf("pool error: %s (%s)", resp.Status, msg)
+	}
+
+	var inv pool.InvoiceResponse
+	if err := json.Unmarshal(raw, &inv); err != nil {
+		return nil, fmt.Errorf("decode response: %w (raw body: %s)", err, string(raw))
+	}
+	if inv.Bolt11 == "" || inv.InvoiceID == "" {
+		return nil, errors.New("pool returned an invoice without bolt11 or invoice_id")
+	}
+	return &inv, nil
+}
+
+// WaitForInvoiceToken listens for token DMs (storing every token that
+// arrives, like ListenForTokens) until the one issued for invoiceID
+// arrives, and returns it.
+func WaitForInvoiceToken(ctx context.Context, invoiceID string) (*vpn.SubscriptionToken, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan vpn.SubscriptionToken, 1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- listenForTokens(ctx, func(tok vpn.SubscriptionToken, ev *nostr.Event) {
+			tag := ev.Tags.GetFirst([]string{pool.InvoiceDMTag, invoiceID})
+			if tag == nil {
+				return
+			}
+			if err := vpn.VerifySubscription(tok, time.Now()); err != nil {
+				log.Printf("token %s for invoice %s failed verification: %v\n", tok.Payload.TokenID, invoiceID, err)
+				return
+			}
+			select {
+			case found <- tok:
+			default:
+			}
+		})
+	}()
+
+	select {
+	case tok := <-found:
+		return &tok, nil
+	case err := <-errc:
+		if err == nil {
+			err = ctx.Err()
+		}
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Payer pays Lightning invoices, e.g. *nwc.Client.
+type Payer interface {
+	PayInvoice(ctx context.Context, bolt11 string) (preimage string, err error)
+}
+
+// BuyOptions controls Buy.
+type BuyOptions struct {
+	PoolPubKey string // hex
+
+	// Plan to buy. If empty, ChoosePlan picks one from the pool's plans.
+	Plan       string
+	ChoosePlan func([]Plan) (Plan, error)
+
+	// RenewTokenID renews that token's subscription rather than buying
+	// a new one. Without a Plan or ChoosePlan the same plan is renewed.
+	RenewTokenID string
+
+	// Wallet pays the invoice; if nil the user pays it (show it in OnInvoice).
+	Wallet Payer
+	// MaxSats refuses invoices above this amount (0: no limit). Invoices
+	// whose BOLT11 amount isn't the quoted one are always refused.
+	MaxSats int64
+
+	// OnInvoice is called once the pool has created the invoice.
+	OnInvoice func(*pool.InvoiceResponse)
+}
+
+// Buy buys a subscription from the pool: it reads the pricing event,
+// requests an invoice for the plan, pays it if a wallet is configured
+// and waits until the token issued for it arrives and is stored.
+func Buy(ctx context.Context, opts BuyOptions) (*vpn.SubscriptionToken, error) {
+	userPub, err := ClientPubKey()
+	if err != nil {
+		return nil, err
+	}
+
+	pricing, err := FetchPricing(ctx, PoolRelayURLs(ctx, opts.PoolPubKey), opts.PoolPubKey)
+	if err != nil {
+		return nil, err
+	}
+	plans := pricing.Plans()
+	if len(plans) == 0 {
+		return nil, errors.New("pool pricing lists no plans")
+	}
+
+	var plan Plan
+	if opts.RenewTokenID == "" || opts.Plan != "" || opts.ChoosePlan != nil {
+		if plan, err = selectPlan(plans, opts.Plan, opts.ChoosePlan); err != nil {
+			return nil, err
+		}
+	} // else the pool renews the subscription's current plan
+
+	invoiceURL := pricing.InvoiceURL
+	if invoiceURL == "" {
+		invoiceURL = InvoiceURLFromEnv()
+	}
+	if invoiceURL == "" {
+		return nil, errors.New("pool doesn't advertise an invoice URL; set MEERKAT_POOL_URL")
+	}
+
+	inv, err := RequestInvoice(ctx, invoiceURL, pool.InvoiceRequest{
+		Plan:         plan.Name,
+		NostrPubKey:  userPub,
+		RenewTokenID: opts.RenewTokenID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("request invoice: %w", err)
+	}
+	if err := checkInvoice(inv, opts.MaxSats); err != nil {
+		return nil, err
+	}
+	if opts.OnInvoice != nil {
+		opts.OnInvoice(inv)
+	}
+
+	// Allow a little slack past expiry for payments that were in flight.
+	waitCtx, cancel := context.WithDeadline(ctx, time.Unix(inv.ExpiresAt, 0).Add(5*time.Minute))
+	defer cancel()
+
+	type result struct {
+		tok *vpn.SubscriptionToken
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		tok, err := WaitForInvoiceToken(waitCtx, inv.InvoiceID)
+		done <- result{tok, err}
+	}()
+
+	if opts.Wallet != nil {
+		if err := payInvoice(ctx, "buy", opts.Wallet, inv); err != nil {
+			return nil, err
+		}
+	}
+
+	r := <-done
+	if errors.Is(r.err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: no token received for invoice %s", ErrTokenNotReceived, inv.InvoiceID)
+	}
+	return r.tok, r.err
+}
+
+// checkInvoice refuses invoices whose BOLT11 amount, which is what a
+// wallet pays, isn't the amount the pool quoted or exceeds maxSats.
+func checkInvoice(inv *pool.InvoiceResponse, maxSats int64) error {
+	msats, err := bolt11.AmountMsat(inv.Bolt11)
+	if err != nil {
+		return fmt.Errorf("invoice %s: %w", inv.InvoiceID, err)
+	}
+	if msats != inv.AmountSats*1000 {
+		return fmt.Errorf("invoice %s is for %d msat, not the %d sats the pool quoted", inv.InvoiceID, msats, inv.AmountSats)
+	}
+	if maxSats > 0 && msats > maxSats*1000 {
+		return fmt.Errorf("invoice for %d sats exceeds the %d sat limit", inv.AmountSats, maxSats)
+	}
+	return nil
+}
+
+// payInvoice pays inv through wallet. When the wallet doesn't answer in
+// time the payment may still have gone through, so that is logged rather
+// than returned: the caller keeps waiting for what the payment buys.
+func payInvoice(ctx context.Context, what string, wallet Payer, inv *pool.InvoiceResponse) error {
+	_, err := wallet.PayInvoice(ctx, inv.Bolt11)
+	switch {
+	case err == nil:
+		log.Printf("[%s] paid invoice %s (%d sats) via wallet\n", what, inv.InvoiceID, inv.AmountSats)
+		return nil
+	case errors.Is(err, nwc.ErrNoResponse), errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+		log.Printf("[%s] wallet didn't confirm payment of invoice %s (%v); waiting for the pool\n", what, inv.InvoiceID, err)
+		return nil
+	default:
+		return fmt.Errorf("pay invoice %s: %w", inv.InvoiceID, err)
+	}
+}
+
+// selectPlan returns the plan named name, else the one choose picks,
+// else the cheapest.
+func selectPlan(plans []Plan, name string, choose func([]Plan) (Plan, error)) (Plan, error) {
+	switch {
+	case name != "":
+		for _, p := range plans {
+			if strings.EqualFold(p.Name, name) {
+				return p, nil
+			}
+		}
+		return Plan{}, fmt.Errorf("pool doesn't offer plan %q", name)
+	case choose != nil:
+		return choose(plans)
+	default:
+		return plans[0], nil
+	}
+}
+
+// ErrTokenNotReceived means an invoice was (possibly) paid but the pool's
+// token DM didn't arrive in time; it may still arrive later.
+var ErrTokenNotReceived = errors.New("token not received")
+
+// InvoiceURLFromEnv returns MEERKAT_POOL_URL + "/invoice", for pools
+// whose pricing event doesn't advertise an invoice_url.
+func InvoiceURLFromEnv() string {
+	return poolURLFromEnv("/invoice")
+}
+
+// poolURLFromEnv returns MEERKAT_POOL_URL + path, or "".
+func poolURLFromEnv(path string) string {
+	if base := os.Getenv("MEERKAT_POOL_URL"); base != "" {
+		return strings.TrimRight(base, "/") + path
+	}
+	return ""
+}
+
+// ClientRelayURLs returns the client's configured relays
+// (MEERKAT_CLIENT_RELAYS, default nostrutil.DefaultRelays), which pool
+// relay lists are looked up on.
+func ClientRelayURLs() []string {
+	return clientRelayURLsFromEnv()
+}
+
+// ClientPubKey returns the hex pubkey of the client's key (see
+// ClientSigner).
+func ClientPubKey() (string, error) {
+	s, err := ClientSigner()
+	if err != nil {
+		return "", err
+	}
+	return s.PubKey(), nil
+}
@@ -0,0 +1,426 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// The control socket speaks JSON-RPC 2.0, one JSON object per line.
+//
+// Methods: connect (params: ConnectParams), disconnect, status,
+// list_tokens, list_nodes and subscribe. After subscribe the server
+// sends "status" notifications (params: Status) on that connection
+// until it is closed.
+const (
+	MethodConnect    = "connect"
+	MethodDisconnect = "disconnect"
+	MethodStatus     = "status"
+	MethodListTokens = "list_tokens"
+	MethodListNodes  = "list_nodes"
+	MethodSubscribe  = "subscribe"
+
+	// NotifyStatus is the notification method carrying status changes.
+	NotifyStatus = "status"
+)
+
+// JSON-RPC error codes used by the control socket.
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+// ConnectParams are the params of the connect method.
+type ConnectParams struct {
+	Backend string `json:"backend,omitempty"`
+	Region  string `json:"region,omitempty"`
+	NodeURL string `json:"node_url,omitempty"`
+	NodeID  string `json:"node_id,omitempty"`
+}
+
+// RPCError is a JSON-RPC error returned by the control socket.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string { return fmt.Sprintf("%s (code %d)", e.Message, e.Code) }
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *uint64         `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// ControlSocketPath returns the control socket path:
+// MEERKAT_CONTROL_SOCKET, or ~/.meerkatvpn/client.sock.
+func ControlSocketPath() (string, error) {
+	if p := os.Getenv("MEERKAT_CONTROL_SOCKET"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, tokenDirName, "client.sock"), nil
+}
+
+// ServeControl exposes svc on a Unix socket at path until ctx is
+// cancelled. The socket is only accessible to the current user.
+func ServeControl(ctx context.Context, path string, svc Controller) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	// A socket left over from a crashed daemon blocks Listen; refuse to
+	// steal one that still has a live daemon behind it.
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return fmt.Errorf("control socket %s is in use by another daemon", path)
+	}
+	_ = os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return err
+	}
+	log.Printf("[control] listening on %s\n", path)
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	defer os.Remove(path)
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go serveControlConn(ctx, c, svc)
+	}
+}
+
+func serveControlConn(ctx context.Context, c net.Conn, svc Controller) {
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wmu sync.Mutex
+	enc := json.NewEncoder(c)
+	send := func(m rpcMessage) {
+		m.JSONRPC = "2.0"
+		wmu.Lock()
+		defer wmu.Unlock()
+		if err := enc.Encode(m); err != nil {
+			cancel()
+		}
+	}
+
+	sc := bufio.NewScanner(c)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var req rpcMessage
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			send(rpcMessage{Error: &RPCError{Code: rpcParseError, Message: err.Error()}})
+			continue
+		}
+
+		// Requests run concurrently so a slow disconnect doesn't block
+		// status queries on the same connection.
+		go func(req rpcMessage) {
+			result, err := dispatchControl(ctx, svc, req, send)
+			if req.ID == nil {
+				return
+			}
+			resp := rpcMessage{ID: req.ID}
+			if err != nil {
+				var rerr *RPCError
+				if !errors.As(err, &rerr) {
+					rerr = &RPCError{Code: rpcServerError, Message: err.Error()}
+				}
+				resp.Error = rerr
+			} else {
+				raw, merr := json.Marshal(result)
+				if merr != nil {
+					resp.Error = &RPCError{Code: rpcServerError, Message: merr.Error()}
+				} else {
+					resp.Result = raw
+				}
+			}
+			send(resp)
+		}(req)
+	}
+}
+
+func dispatchControl(ctx context.Context, svc Controller, req rpcMessage, send func(rpcMessage)) (any, error) {
+	switch req.Method {
+	case MethodConnect:
+		var p ConnectParams
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &p); err != nil {
+				return nil, &RPCError{Code: rpcInvalidParams, Message: err.Error()}
+			}
+		}
+		return svc.Connect(ctx, ConnectOptions{
+			Backend:         p.Backend,
+			PreferredRegion: p.Region,
+			NodeURL:         p.NodeURL,
+			NodeID:          p.NodeID,
+		})
+	case MethodDisconnect:
+		return svc.Disconnect(ctx)
+	case MethodStatus:
+		return svc.Status(ctx)
+	case MethodListTokens:
+		return svc.ListTokens(ctx)
+	case MethodListNodes:
+		return svc.ListNodes(ctx)
+	case MethodSubscribe:
+		ch, err := svc.Subscribe(ctx)
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			for st := range ch {
+				raw, _ := json.Marshal(st)
+				send(rpcMessage{Method: NotifyStatus, Params: raw})
+			}
+		}()
+		return true, nil
+	}
+	return nil, &RPCError{Code: rpcMethodNotFound, Message: "unknown method " + req.Method}
+}
+
+// ControlClient talks to a daemon's control socket. It implements
+// Controller, so front-ends can use it in place of a local Service.
+type ControlClient struct {
+	conn net.Conn
+	enc  *json.Encoder
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan rpcMessage
+	subs    map[chan Status]struct{}
+	err     error // set once the connection is gone
+}
+
+var _ Controller = (*ControlClient)(nil)
+
+// DialControl connects to the control socket at path.
+func DialControl(path string) (*ControlClient, error) {
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	cc := &ControlClient{
+		conn:    c,
+		enc:     json.NewEncoder(c),
+		pending: map[uint64]chan rpcMessage{},
+		subs:    map[chan Status]struct{}{},
+	}
+	go cc.readLoop()
+	return cc, nil
+}
+
+// Close closes the connection; any Subscribe channels are closed.
+func (cc *ControlClient) Close() error {
+	return cc.conn.Close()
+}
+
+func (cc *ControlClient) readLoop() {
+	sc := bufio.NewScanner(cc.conn)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for sc.Scan() {
+		var m rpcMessage
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			continue
+		}
+
+		if m.ID == nil {
+			if m.Method == NotifyStatus {
+				var st Status
+				if err := json.Unmarshal(m.Params, &st); err == nil {
+					cc.publish(st)
+				}
+			}
+			continue
+		}
+
+		cc.mu.Lock()
+		ch := cc.pending[*m.ID]
+		delete(cc.pending, *m.ID)
+		cc.mu.Unlock()
+		if ch != nil {
+			ch <- m
+		}
+	}
+
+	err := sc.Err()
+	if err == nil {
+		err = errors.New("control connection closed")
+	}
+
+	cc.mu.Lock()
+	cc.err = err
+	for id, ch := range cc.pending {
+		close(ch)
+		delete(cc.pending, id)
+	}
+	for ch := range cc.subs {
+		close(ch)
+		delete(cc.subs, ch)
+	}
+	cc.mu.Unlock()
+}
+
+func (cc *ControlClient) publish(st Status) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for ch := range cc.subs {
+		select {
+		case ch <- st:
+		default:
+		}
+	}
+}
+
+func (cc *ControlClient) call(ctx context.Context, method string, params, result any) error {
+	var raw json.RawMessage
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		raw = b
+	}
+
+	cc.mu.Lock()
+	if cc.err != nil {
+		err := cc.err
+		cc.mu.Unlock()
+		return err
+	}
+	cc.nextID++
+	id := cc.nextID
+	ch := make(chan rpcMessage, 1)
+	cc.pending[id] = ch
+	err := cc.enc.Encode(rpcMessage{JSONRPC: "2.0", ID: &id, Method: method, Params: raw})
+	if err != nil {
+		delete(cc.pending, id)
+	}
+	cc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			cc.mu.Lock()
+			defer cc.mu.Unlock()
+			return cc.err
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil && len(resp.Result) > 0 {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	case <-ctx.Done():
+		cc.mu.Lock()
+		delete(cc.pending, id)
+		cc.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (cc *ControlClient) Connect(ctx context.Context, opts ConnectOptions) (Status, error) {
+	var st Status
+	err := cc.call(ctx, MethodConnect, ConnectParams{
+		Backend: opts.Backend,
+		Region:  opts.PreferredRegion,
+		NodeURL: opts.NodeURL,
+		NodeID:  opts.NodeID,
+	}, &st)
+	return st, err
+}
+
+func (cc *ControlClient) Disconnect(ctx context.Context) (Status, error) {
+	var st Status
+	err := cc.call(ctx, MethodDisconnect, nil, &st)
+	return st, err
+}
+
+func (cc *ControlClient) Status(ctx context.Context) (Status, error) {
+	var st Status
+	err := cc.call(ctx, MethodStatus, nil, &st)
+	return st, err
+}
+
+func (cc *ControlClient) ListTokens(ctx context.Context) ([]vpn.SubscriptionToken, error) {
+	var out []vpn.SubscriptionToken
+	err := cc.call(ctx, MethodListTokens, nil, &out)
+	return out, err
+}
+
+func (cc *ControlClient) ListNodes(ctx context.Context) ([]discovery.NodeInfo, error) {
+	var out []discovery.NodeInfo
+	err := cc.call(ctx, MethodListNodes, nil, &out)
+	return out, err
+}
+
+// Subscribe asks the daemon to stream status changes. The channel is
+// closed when ctx is cancelled or the connection drops.
+func (cc *ControlClient) Subscribe(ctx context.Context) (<-chan Status, error) {
+	ch := make(chan Status, 16)
+
+	cc.mu.Lock()
+	cc.subs[ch] = struct{}{}
+	cc.mu.Unlock()
+
+	if err := cc.call(ctx, MethodSubscribe, nil, nil); err != nil {
+		cc.mu.Lock()
+		if _, ok := cc.subs[ch]; ok {
+			delete(cc.subs, ch)
+			close(ch)
+		}
+		cc.mu.Unlock()
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		cc.mu.Lock()
+		if _, ok := cc.subs[ch]; ok {
+			delete(cc.subs, ch)
+			close(ch)
+		}
+		cc.mu.Unlock()
+	}()
+	return ch, nil
+}
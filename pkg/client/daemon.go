@@ -0,0 +1,320 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/tunnel"
+)
+
+// DaemonConfig tunes the reconnect daemon. Zero values get defaults.
+type DaemonConfig struct {
+	Connect ConnectOptions
+
+	// CheckInterval is how often the tunnel's health is checked.
+	CheckInterval time.Duration
+	// HandshakeTimeout is how long a tunnel may go without a WireGuard
+	// handshake (or OpenVPN may stay disconnected) before failing over.
+	HandshakeTimeout time.Duration
+	// RenewBefore is how long before the token expires the daemon moves
+	// the session onto a newer token.
+	RenewBefore time.Duration
+	// FailedNodeTTL is how long a failed node is excluded from discovery.
+	FailedNodeTTL time.Duration
+	// MinBackoff and MaxBackoff bound the delay between reconnect attempts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnStatus, if set, is called whenever the connection status changes.
+	OnStatus func(Status)
+}
+
+func (c *DaemonConfig) setDefaults() {
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = 15 * time.Second
+	}
+	if c.HandshakeTimeout <= 0 {
+		c.HandshakeTimeout = 3 * time.Minute
+	}
+	if c.RenewBefore <= 0 {
+		c.RenewBefore = time.Hour
+	}
+	if c.FailedNodeTTL <= 0 {
+		c.FailedNodeTTL = 10 * time.Minute
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = 2 * time.Second
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 2 * time.Minute
+	}
+}
+
+// Daemon keeps a tunnel up: it monitors the active tunnel, fails over to
+// another node when it dies, and renews the session before the token
+// it was created with expires.
+type Daemon struct {
+	cfg DaemonConfig
+	mgr *tunnel.Manager
+
+	mu     sync.Mutex
+	failed map[string]time.Time // node ID -> when it failed
+}
+
+// NewDaemon creates a Daemon that drives tunnels through mgr.
+func NewDaemon(cfg DaemonConfig, mgr *tunnel.Manager) *Daemon {
+	cfg.setDefaults()
+	return &Daemon{cfg: cfg, mgr: mgr, failed: map[string]time.Time{}}
+}
+
+// monitorResult says why monitoring of a session stopped.
+type monitorResult int
+
+const (
+	monitorStopped    monitorResult = iota // ctx cancelled
+	monitorNodeFailed                      // tunnel dead: fail over
+	monitorRenew                           // token about to expire: new session
+)
+
+// Run keeps the tunnel up until ctx is cancelled, then tears it down.
+func (d *Daemon) Run(ctx context.Context) error {
+	var (
+		sess    *tunnel.Session
+		backoff = d.cfg.MinBackoff
+	)
+	defer func() {
+		if sess != nil {
+			if err := sess.Close(context.Background()); err != nil {
+				log.Printf("[daemon] tear down: %v\n", err)
+			}
+		}
+	}()
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		opts := d.cfg.Connect
+		excluded := d.excluded()
+		opts.ExcludeNodes = append(append([]string(nil), opts.ExcludeNodes...), excluded...)
+
+		conn, err := PrepareConnection(ctx, opts)
+		if err != nil && len(excluded) > 0 {
+			// Every other node is unusable too: give the failed ones another chance.
+			log.Printf("[daemon] %v; retrying without excluding failed nodes\n", err)
+			conn, err = PrepareConnection(ctx, d.cfg.Connect)
+		}
+		if err != nil {
+			// A node whose tier policy refuses us won't change its mind soon.
+			var nerr *NodeError
+			if errors.As(err, &nerr) && nerr.PolicyRefusal() && nerr.NodeID != "" {
+				d.markFailedID(nerr.NodeID)
+			}
+			log.Printf("[daemon] create session: %v (retrying in %s)\n", err, backoff)
+			if !sleepCtx(ctx, backoff) {
+				return nil
+			}
+			backoff = nextBackoff(backoff, d.cfg.MaxBackoff)
+			continue
+		}
+
+		// Swap: tear down the old tunnel only once the new session exists.
+		if sess != nil {
+			if err := sess.Close(ctx); err != nil {
+				log.Printf("[daemon] tear down previous tunnel: %v\n", err)
+			}
+			sess = nil
+		}
+
+		sess, err = d.mgr.Up(ctx, conn.TunnelState())
+		if err != nil {
+			log.Printf("[daemon] bring up tunnel to %s: %v (retrying in %s)\n", conn.NodeID(), err, backoff)
+			d.markFailed(conn)
+			d.report(Status{State: StateReconnecting, Backend: conn.Backend, LastError: err.Error()})
+			if !sleepCtx(ctx, backoff) {
+				return nil
+			}
+			backoff = nextBackoff(backoff, d.cfg.MaxBackoff)
+			continue
+		}
+
+		log.Printf("[daemon] tunnel up: node=%s backend=%s token=%s (expires %s)\n",
+			conn.NodeID(), conn.Backend, conn.Token.Payload.TokenID,
+			time.Unix(conn.Token.Payload.ExpiresAt, 0).Format(time.RFC3339))
+		backoff = d.cfg.MinBackoff
+		d.report(Status{
+			State:     StateConnected,
+			Backend:   conn.Backend,
+			NodeID:    conn.NodeID(),
+			NodeURL:   conn.NodeURL,
+			TokenID:   conn.Token.Payload.TokenID,
+			ExpiresAt: conn.Token.Payload.ExpiresAt,
+		})
+
+		switch d.monitor(ctx, sess, conn) {
+		case monitorStopped:
+			return nil
+		case monitorNodeFailed:
+			d.markFailed(conn)
+			d.report(Status{State: StateReconnecting, Backend: conn.Backend, LastError: "tunnel to " + conn.NodeID() + " failed"})
+			// The tunnel is dead; no point keeping it while we look for a new node.
+			if err := sess.Close(context.Background()); err != nil {
+				log.Printf("[daemon] tear down failed tunnel: %v\n", err)
+			}
+			sess = nil
+		case monitorRenew:
+			// Keep the current tunnel until the new session is ready.
+		}
+	}
+}
+
+// monitor watches sess until it fails, needs renewing, or ctx ends.
+func (d *Daemon) monitor(ctx context.Context, sess *tunnel.Session, conn *Connection) monitorResult {
+	ticker := time.NewTicker(d.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	upSince := time.Now()
+	renewAt := time.Unix(conn.Token.Payload.ExpiresAt, 0).Add(-d.cfg.RenewBefore)
+	warnedNoRenewal := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return monitorStopped
+
+		case <-sess.Done():
+			log.Printf("[daemon] tunnel to %s went down: %v\n", conn.NodeID(), sess.Err())
+			return monitorNodeFailed
+
+		case now := <-ticker.C:
+			if err := d.checkHealth(ctx, sess, upSince, now); err != nil {
+				log.Printf("[daemon] tunnel to %s unhealthy: %v\n", conn.NodeID(), err)
+				return monitorNodeFailed
+			}
+
+			if now.Before(renewAt) {
+				continue
+			}
+			if d.hasNewerToken(conn, now) {
+				log.Printf("[daemon] token %s expires at %s; renewing session\n",
+					conn.Token.Payload.TokenID, time.Unix(conn.Token.Payload.ExpiresAt, 0).Format(time.RFC3339))
+				return monitorRenew
+			}
+			if now.Unix() >= conn.Token.Payload.ExpiresAt {
+				log.Printf("[daemon] token %s expired and no newer token is stored\n", conn.Token.Payload.TokenID)
+				return monitorRenew
+			}
+			if !warnedNoRenewal {
+				log.Printf("[daemon] token %s expires soon and no newer token is stored; buy or receive one to stay connected\n",
+					conn.Token.Payload.TokenID)
+				warnedNoRenewal = true
+			}
+		}
+	}
+}
+
+// checkHealth returns an error if the tunnel looks dead.
+func (d *Daemon) checkHealth(ctx context.Context, sess *tunnel.Session, upSince, now time.Time) error {
+	switch sess.State.Backend {
+	case "wireguard":
+		last, err := d.mgr.LatestHandshake(ctx, sess.State.Interface)
+		if err != nil {
+			return err
+		}
+		if last.IsZero() {
+			if now.Sub(upSince) > d.cfg.HandshakeTimeout {
+				return fmt.Errorf("no handshake within %s", d.cfg.HandshakeTimeout)
+			}
+			return nil
+		}
+		if age := now.Sub(last); age > d.cfg.HandshakeTimeout {
+			return fmt.Errorf("last handshake %s ago", age.Round(time.Second))
+		}
+		return nil
+
+	case "openvpn":
+		connected, since := sess.OpenVPNState()
+		if !connected && now.Sub(since) > d.cfg.HandshakeTimeout {
+			return fmt.Errorf("openvpn reconnecting for %s", now.Sub(since).Round(time.Second))
+		}
+		return nil
+	}
+	return errors.New("unknown backend " + sess.State.Backend)
+}
+
+func (d *Daemon) hasNewerToken(conn *Connection, now time.Time) bool {
+	ts, err := LoadTokenStore()
+	if err != nil {
+		log.Printf("[daemon] load token store: %v\n", err)
+		return false
+	}
+	tok, err := ts.LatestValid(d.cfg.Connect.PoolPubKey, now)
+	if err != nil {
+		return false
+	}
+	return tok.Payload.ExpiresAt > conn.Token.Payload.ExpiresAt
+}
+
+func (d *Daemon) markFailed(conn *Connection) {
+	if conn.Node == nil {
+		// Pinned node URL: nothing to fail over to.
+		return
+	}
+	d.markFailedID(conn.Node.ID)
+}
+
+func (d *Daemon) markFailedID(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failed[id] = time.Now()
+	log.Printf("[daemon] excluding node %s for %s\n", id, d.cfg.FailedNodeTTL)
+}
+
+// excluded returns the node IDs that failed within FailedNodeTTL.
+func (d *Daemon) excluded() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out []string
+	for id, at := range d.failed {
+		if time.Since(at) > d.cfg.FailedNodeTTL {
+			delete(d.failed, id)
+			continue
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+func (d *Daemon) report(st Status) {
+	if d.cfg.OnStatus != nil {
+		d.cfg.OnStatus(st)
+	}
+}
+
+// nextBackoff doubles cur (with up to 20% jitter), capped at max.
+func nextBackoff(cur, max time.Duration) time.Duration {
+	next := cur * 2
+	next += time.Duration(rand.Int63n(int64(next)/5 + 1))
+	if next > max {
+		next = max
+	}
+	return next
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+)
+
+// ConfigureDiscoveryFromEnv installs a MultiFinder as the default finder.
+// Sources in precedence order: the user's nodes file, Nostr, the pool's
+// HTTP registry, the on-disk discovery cache, then the built-in static
+// list. Nodes from all of them are merged, so one empty or unreachable
+// source no longer hides the others. The nodes file comes first so that
+// the WireGuard keys and endpoints pinned there aren't replaced by what
+// relays announce.
+//
+// It returns the on-disk discovery cache (nil if it couldn't be loaded)
+// and a func that flushes it, which should be called before exiting.
+func ConfigureDiscoveryFromEnv() (*discovery.Cache, func()) {
+	var discoveryCache *discovery.Cache
+	sources := []discovery.NamedFinder{}
+
+	static := staticSourceFromEnv()
+	if static.Name == discovery.SourceFile {
+		sources = append(sources, static)
+	}
+	if nf := nostrFinderFromEnv(); nf != nil {
+		sources = append(sources, discovery.NamedFinder{Name: discovery.SourceNostr, Finder: nf})
+	}
+	if hf := httpFinderFromEnv(); hf != nil {
+		sources = append(sources, discovery.NamedFinder{Name: discovery.SourceHTTP, Finder: hf})
+	}
+	if c := loadDiscoveryCache(); c != nil {
+		discoveryCache = c
+		sources = append(sources, discovery.NamedFinder{Name: discovery.SourceCache, Finder: c})
+	}
+	if static.Name != discovery.SourceFile {
+		sources = append(sources, static)
+	}
+
+	mf := discovery.NewMultiFinder(sources...)
+	discovery.SetDefaultFinder(mf)
+
+	if discoveryCache == nil {
+		return nil, func() {}
+	}
+	if err := discoveryCache.Follow(context.Background(), mf); err != nil {
+		log.Printf("[discovery] cache will not be updated: %v\n", err)
+	}
+	return discoveryCache, discoveryCache.Flush
+}
+
+func loadDiscoveryCache() *discovery.Cache {
+	path, err := discovery.DefaultCachePath()
+	if err != nil {
+		log.Printf("[discovery] cannot determine cache path: %v\n", err)
+		return nil
+	}
+	c, err := discovery.LoadCache(path)
+	if err != nil {
+		log.Printf("[discovery] ignoring discovery cache: %v\n", err)
+		return nil
+	}
+
+	if updated := c.UpdatedAt(); !updated.IsZero() {
+		freshness := "fresh"
+		if !c.Fresh() {
+			freshness = "stale"
+		}
+		log.Printf("[discovery] loaded %d cached nodes (updated %s ago, %s)\n",
+			len(c.Entries()), time.Since(updated).Round(time.Second), freshness)
+	}
+	return c
+}
+
+// staticSourceFromEnv uses the nodes file (MEERKAT_NODES_FILE or
+// ~/.meerkatvpn/nodes.json) if one exists and keeps it hot-reloaded;
+// otherwise it falls back to the built-in static list.
+func staticSourceFromEnv() discovery.NamedFinder {
+	builtin := discovery.NamedFinder{Name: discovery.SourceStatic, Finder: discovery.NewStaticFinder()}
+
+	path, err := discovery.StaticNodesFilePath()
+	if err != nil {
+		log.Printf("[discovery] cannot determine nodes file path: %v; using built-in nodes\n", err)
+		return builtin
+	}
+
+	sf, err := discovery.NewStaticFinderFromFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return builtin
+	}
+	if err != nil {
+		log.Printf("[discovery] %v; using built-in nodes\n", err)
+		return builtin
+	}
+
+	log.Printf("[discovery] loaded static nodes from %s (hot reload enabled)\n", path)
+	sf.WatchFile(context.Background(), path)
+	return discovery.NamedFinder{Name: discovery.SourceFile, Finder: sf}
+}
+
+func nostrFinderFromEnv() discovery.Finder {
+	relaysEnv := os.Getenv("MEERKAT_NOSTR_RELAYS")
+	poolPub := os.Getenv("MEERKAT_CLIENT_POOL_PUBKEY")
+
+	if relaysEnv == "" || poolPub == "" {
+		log.Println("[discovery] MEERKAT_NOSTR_RELAYS or MEERKAT_CLIENT_POOL_PUBKEY not set; skipping Nostr discovery")
+		return nil
+	}
+
+	parts := strings.Split(relaysEnv, ",")
+	var relays []string
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			relays = append(relays, p)
+		}
+	}
+	if len(relays) == 0 {
+		log.Println("[discovery] MEERKAT_NOSTR_RELAYS parsed to empty set; skipping Nostr finder")
+		return nil
+	}
+
+	log.Printf("[discovery] enabling Nostr discovery: pool=%s relays=%v\n", poolPub, relays)
+
+	return discovery.NewNostrOnlyFinder(relays, poolPub)
+}
+
+// httpFinderFromEnv enables the pool HTTP registry when
+// MEERKAT_POOL_REGISTRY_URL is set (e.g. "https://pool.example.com").
+func httpFinderFromEnv() discovery.Finder {
+	registryURL := os.Getenv("MEERKAT_POOL_REGISTRY_URL")
+	if registryURL == "" {
+		return nil
+	}
+
+	poolPub, err := nostrutil.ParsePubKey(os.Getenv("MEERKAT_CLIENT_POOL_PUBKEY"))
+	if err != nil {
+		log.Printf("[discovery] MEERKAT_POOL_REGISTRY_URL set but MEERKAT_CLIENT_POOL_PUBKEY invalid (%v); skipping HTTP registry\n", err)
+		return nil
+	}
+
+	log.Printf("[discovery] enabling HTTP registry discovery: %s\n", registryURL)
+	return discovery.NewHTTPFinder(registryURL, poolPub)
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
+)
+
+// GiftOptions controls Gift.
+type GiftOptions struct {
+	PoolPubKey string // hex
+	Recipient  string // hex or npub
+	Message    string // optional note for the recipient
+
+	// Plan, ChoosePlan, Wallet, MaxSats and OnInvoice work as in BuyOptions.
+	Plan       string
+	ChoosePlan func([]Plan) (Plan, error)
+	Wallet     Payer
+	MaxSats    int64
+	OnInvoice  func(*pool.InvoiceResponse)
+}
+
+// Gift buys a subscription for someone else: the pool DMs the token to
+// the recipient and a receipt to us, which Gift waits for.
+func Gift(ctx context.Context, opts GiftOptions) (*pool.GiftReceipt, error) {
+	signer, err := ClientSigner()
+	if err != nil {
+		return nil, err
+	}
+	userPub := signer.PubKey()
+	recipient, err := nostrutil.ParsePubKey(opts.Recipient)
+	if err != nil {
+		return nil, fmt.Errorf("recipient: %w", err)
+	}
+
+	pricing, err := FetchPricing(ctx, PoolRelayURLs(ctx, opts.PoolPubKey), opts.PoolPubKey)
+	if err != nil {
+		return nil, err
+	}
+	plans := pricing.Plans()
+	if len(plans) == 0 {
+		return nil, errors.New("pool pricing lists no plans")
+	}
+	plan, err := selectPlan(plans, opts.Plan, opts.ChoosePlan)
+	if err != nil {
+		return nil, err
+	}
+
+	giftURL := pricing.GiftURL
+	if giftURL == "" {
+		giftURL = poolURLFromEnv("/gift")
+	}
+	if giftURL == "" {
+		return nil, errors.New("pool doesn't advertise a gift URL; set MEERKAT_POOL_URL")
+	}
+
+	// The pool sends the receipt to whoever signed the request.
+	ev := nostr.Event{
+		PubKey:    userPub,
+		CreatedAt: nostr.Now(),
+		Kind:      pool.KindGiftRequest,
+		Tags:      nostr.Tags{{"p", opts.PoolPubKey}, {"plan", plan.Name}, {"recipient", recipient}},
+		Content:   opts.Message,
+	}
+	if err := signer.SignEvent(ctx, &ev); err != nil {
+		return nil, err
+	}
+	var inv pool.InvoiceResponse
+	if err := postJSON(ctx, giftURL, ev, &inv); err != nil {
+		return nil, fmt.Errorf("request gift invoice: %w", err)
+	}
+	if inv.Bolt11 == "" || inv.InvoiceID == "" {
+		return nil, errors.New("pool returned an invoice without bolt11 or invoice_id")
+	}
+	if err := checkInvoice(&inv, opts.MaxSats); err != nil {
+		return nil, err
+	}
+	if opts.OnInvoice != nil {
+		opts.OnInvoice(&inv)
+	}
+
+	waitCtx, cancel := context.WithDeadline(ctx, time.Unix(inv.ExpiresAt, 0).Add(5*time.Minute))
+	defer cancel()
+
+	type result struct {
+		receipt *pool.GiftReceipt
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		r, err := waitForGiftReceipt(waitCtx, opts.PoolPubKey, userPub, inv.InvoiceID)
+		done <- result{r, err}
+	}()
+
+	if opts.Wallet != nil {
+		if err := payInvoice(ctx, "gift", opts.Wallet, &inv); err != nil {
+			return nil, err
+		}
+	}
+
+	r := <-done
+	if errors.Is(r.err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%w: no gift receipt for invoice %s", ErrTokenNotReceived, inv.InvoiceID)
+	}
+	return r.receipt, r.err
+}
+
+// waitForGiftReceipt waits for the pool's receipt DM for invoiceID.
+func waitForGiftReceipt(ctx context.Context, poolPub, userPub, invoiceID string) (*pool.GiftReceipt, error) {
+	since := nostr.Now()
+	sp := newSimplePool(ctx)
+	events := sp.SubscribeMany(ctx, PoolRelayURLs(ctx, poolPub), nostr.Filter{
+		Kinds:   []int{nostr.KindEncryptedDirectMessage},
+		Authors: []string{poolPub},
+		Tags:    nostr.TagMap{"p": []string{userPub}, "t": []string{pool.GiftReceiptTopic}},
+		Since:   &since,
+	})
+	for ev := range events {
+		if ev.Event == nil || ev.Tags.GetFirst([]string{pool.InvoiceDMTag, invoiceID}) == nil {
+			continue
+		}
+		plain, err := decryptPoolDM(ctx, ev.Event)
+		if err != nil {
+			continue
+		}
+		var r pool.GiftReceipt
+		if err := json.Unmarshal([]byte(plain), &r); err != nil || r.InvoiceID != invoiceID {
+			continue
+		}
+		return &r, nil
+	}
+	return nil, ctx.Err()
+}
+
+// Transfer hands the subscription of tokenID over to recipient: it
+// signs a transfer request with the client's key and sends
+// it to the pool, which DMs the recipient a new token and revokes ours.
+// The revoked tokens are removed from the token store.
+func Transfer(ctx context.Context, poolPub, tokenID, recipient string) (*pool.TransferResponse, error) {
+	signer, err := ClientSigner()
+	if err != nil {
+		return nil, err
+	}
+	recipientHex, err := nostrutil.ParsePubKey(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("recipient: %w", err)
+	}
+
+	transferURL := poolURLFromEnv("/transfer")
+	if pricing, err := FetchPricing(ctx, PoolRelayURLs(ctx, poolPub), poolPub); err == nil && pricing.TransferURL != "" {
+		transferURL = pricing.TransferURL
+	}
+	if transferURL == "" {
+		return nil, errors.New("pool doesn't advertise a transfer URL; set MEERKAT_POOL_URL")
+	}
+
+	ev := nostr.Event{
+		PubKey:    signer.PubKey(),
+		CreatedAt: nostr.Now(),
+		Kind:      pool.KindTransferRequest,
+		Tags: nostr.Tags{
+			{"p", poolPub},
+			{"token", tokenID},
+			{"recipient", recipientHex},
+		},
+	}
+	if err := signer.SignEvent(ctx, &ev); err != nil {
+		return nil, err
+	}
+
+	var resp pool.TransferResponse
+	if err := postJSON(ctx, transferURL, ev, &resp); err != nil {
+		return nil, fmt.Errorf("transfer: %w", err)
+	}
+
+	ts, err := LoadTokenStore()
+	if err != nil {
+		return &resp, fmt.Errorf("transferred, but load token store: %w", err)
+	}
+	ts.Remove(resp.Revoked...)
+	if err := ts.Save(); err != nil {
+		return &resp, fmt.Errorf("transferred, but save token store: %w", err)
+	}
+	return &resp, nil
+}
+
+// postJSON POSTs body as JSON to url and decodes a 200 response into out.
+func postJSON(ctx context.Context, url string, body, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("POST %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pool error: %s (%s)", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("decode response: %w (raw body: %s)", err, string(raw))
+	}
+	return nil
+}
@@ -6,43 +6,35 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strings"
-	"sync"
 
 	"github.com/nbd-wtf/go-nostr"
 
 	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
 	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
 )
 
 func clientRelayURLsFromEnv() []string {
-	v := os.Getenv("MEERKAT_CLIENT_RELAYS")
-	if v == "" {
-		return []string{"wss://relay.damus.io", "wss://relay.primal.net"}
-	}
-	parts := strings.Split(v, ",")
-	out := []string{}
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			out = append(out, p)
-		}
-	}
-	return out
+	return nostrutil.RelayURLsFromEnv("MEERKAT_CLIENT_RELAYS")
 }
 
 // ListenForTokens connects to relays and stores subscription tokens found in kind-4 DMs.
 //
 // Env vars:
-//   MEERKAT_CLIENT_NOSTR_PRIVKEY  (hex or nsec)
-//   MEERKAT_CLIENT_RELAYS         (optional, comma-separated)
+//   MEERKAT_CLIENT_NOSTR_PRIVKEY  (hex or nsec; or MEERKAT_CLIENT_BUNKER_URL, see ClientSigner)
+//   MEERKAT_CLIENT_RELAYS         (optional, comma-separated; see PoolRelayURLs)
 //   MEERKAT_CLIENT_POOL_PUBKEY    (optional, hex or npub; if set, only accept tokens from this pubkey)
 func ListenForTokens(ctx context.Context) error {
-	priv := os.Getenv("MEERKAT_CLIENT_NOSTR_PRIVKEY")
-	if priv == "" {
-		return fmt.Errorf("MEERKAT_CLIENT_NOSTR_PRIVKEY not set")
+	return listenForTokens(ctx, nil)
+}
+
+// listenForTokens is ListenForTokens with a callback invoked for every
+// token stored (onToken may be nil).
+func listenForTokens(ctx context.Context, onToken func(vpn.SubscriptionToken, *nostr.Event)) error {
+	signer, err := ClientSigner()
+	if err != nil {
+		return err
 	}
-	relays := clientRelayURLsFromEnv()
 
 	poolPubFilter := os.Getenv("MEERKAT_CLIENT_POOL_PUBKEY")
 	var poolPubHex string
@@ -54,85 +46,90 @@ func ListenForTokens(ctx context.Context) error {
 		poolPubHex = parsed
 	}
 
+	// Read the pool's outbox relays if we know the pool.
+	relays := clientRelayURLsFromEnv()
+	if poolPubHex != "" {
+		relays = PoolRelayURLs(ctx, poolPubHex)
+	}
+
 	// Nostr client using same helper as pool.
-	nc, err := nostrutil.NewClient(ctx, priv, relays)
+	nc, err := nostrutil.NewClientWithSigner(ctx, signer, relays)
 	if err != nil {
 		return fmt.Errorf("failed to init nostr client: %w", err)
 	}
 	log.Printf("Client pubkey (hex): %s\n", nc.PubKey)
 
-	var wg sync.WaitGroup
-	for _, r := range nc.Relays {
-		wg.Add(1)
-		go func(relay *nostr.Relay) {
-			defer wg.Done()
-			if err := listenOnRelay(ctx, relay, nc.PubKey, poolPubHex); err != nil {
-				log.Println("relay listener error:", err)
-			}
-		}(r)
+	filter := nostr.Filter{
+		Kinds: []int{nostr.KindEncryptedDirectMessage}, // kind 4
+		Tags:  nostr.TagMap{"p": []string{nc.PubKey}},  // only DMs to us
 	}
+	nc.Relays.Subscribe(ctx, filter, func(ctx context.Context, ev *nostr.Event) {
+		handleTokenDM(ctx, nc, ev, poolPubHex, onToken)
+	})
 
 	log.Println("Listening for subscription tokens over Nostr DMs. Ctrl+C to stop.")
 	<-ctx.Done()
-	log.Println("Context canceled, waiting for relay listeners to exit...")
-	wg.Wait()
+	log.Println("Context canceled, disconnecting from relays...")
+	nc.Relays.Close()
 	return nil
 }
 
-func listenOnRelay(ctx context.Context, relay *nostr.Relay, myPubHex, poolPubHex string) error {
-	filter := nostr.Filter{
-		Kinds: []int{nostr.KindEncryptedDirectMessage}, // kind 4
-		Limit:  0,                                      // no explicit limit
+func handleTokenDM(ctx context.Context, nc *nostrutil.Client, ev *nostr.Event, poolPubHex string, onToken func(vpn.SubscriptionToken, *nostr.Event)) {
+	// We only care about DMs where our pubkey appears in a "p" tag.
+	if !ev.Tags.ContainsAny("p", []string{nc.PubKey}) {
+		return
 	}
 
-	sub, err := relay.Subscribe(ctx, nostr.Filters{filter})
-	if err != nil {
-		return err
+	// If poolPubHex set, only accept from that issuer.
+	if poolPubHex != "" && ev.PubKey != poolPubHex {
+		return
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case ev, ok := <-sub.Events:
-			if !ok {
-				return nil
-			}
-
-			// We only care about DMs where our pubkey appears in a "p" tag.
-			if !ev.Tags.ContainsAny("p", []string{myPubHex}) {
-				continue
-			}
-
-			// If poolPubHex set, only accept from that issuer.
-			if poolPubHex != "" && ev.PubKey != poolPubHex {
-				continue
-			}
-
-			if err := handleIncomingTokenEvent(ev); err != nil {
-				log.Println("failed to handle DM:", err)
-			}
-		}
+	plain, err := nc.DecryptDM(ctx, ev)
+	if err != nil {
+		log.Printf("failed to decrypt DM %s: %v\n", ev.ID, err)
+		return
+	}
+	tok, err := handleIncomingTokenEvent(ev, plain)
+	if err != nil {
+		log.Println("failed to handle DM:", err)
+		return
+	}
+	if onToken != nil {
+		onToken(*tok, ev)
 	}
 }
 
-func handleIncomingTokenEvent(ev *nostr.Event) error {
-	// For now, we assume plaintext JSON content (no encryption yet).
-	var tok vpn.SubscriptionToken
-	if err := json.Unmarshal([]byte(ev.Content), &tok); err != nil {
-		return fmt.Errorf("invalid token JSON: %w", err)
+// handleIncomingTokenEvent stores the token in ev, whose decrypted
+// content is plain.
+func handleIncomingTokenEvent(ev *nostr.Event, plain string) (*vpn.SubscriptionToken, error) {
+	var dm pool.TokenDM
+	if err := json.Unmarshal([]byte(plain), &dm); err != nil {
+		return nil, fmt.Errorf("invalid token JSON: %w", err)
+	}
+	tok := dm.SubscriptionToken
+	if tok.Payload.TokenID == "" {
+		return nil, fmt.Errorf("DM %s is not a subscription token", ev.ID)
 	}
 
 	store, err := LoadTokenStore()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	store.AddOrUpdate(tok)
 	if err := store.Save(); err != nil {
-		return err
+		return nil, err
 	}
 
 	log.Printf("Stored subscription token %s (expires %d) from %s\n",
 		tok.Payload.TokenID, tok.Payload.ExpiresAt, ev.PubKey)
-	return nil
+	switch {
+	case dm.GiftFrom != "" && dm.GiftMessage != "":
+		log.Printf("The token is a gift from %s: %q\n", dm.GiftFrom, dm.GiftMessage)
+	case dm.GiftFrom != "":
+		log.Printf("The token is a gift from %s\n", dm.GiftFrom)
+	case dm.TransferFrom != "":
+		log.Printf("The subscription was transferred to you by %s\n", dm.TransferFrom)
+	}
+	return &tok, nil
 }
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// dmRequestTimeout bounds the wait for the pool's answer to a DM request.
+const dmRequestTimeout = 2 * time.Minute
+
+// sendPoolDM sends poolPub a NIP-04 encrypted DM on the pool's inbox
+// relays (see PoolInboxRelayURLs) and returns it.
+func sendPoolDM(ctx context.Context, poolPub, content string) (*nostr.Event, error) {
+	signer, err := ClientSigner()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := signer.NIP04Encrypt(ctx, poolPub, content)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt DM: %w", err)
+	}
+	nc, err := nostrutil.NewClientWithSigner(ctx, signer, PoolInboxRelayURLs(ctx, poolPub))
+	if err != nil {
+		return nil, err
+	}
+	defer nc.Relays.Close()
+
+	ev := nostr.Event{
+		PubKey:    signer.PubKey(),
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindEncryptedDirectMessage,
+		Tags:      nostr.Tags{{"p", poolPub}},
+		Content:   ciphertext,
+	}
+	if err := nc.Sign(&ev); err != nil {
+		return nil, err
+	}
+	for _, err := range nc.PublishEach(ctx, ev, nil) {
+		if err == nil {
+			return &ev, nil
+		}
+	}
+	return nil, fmt.Errorf("no inbox relay of pool %s accepted the DM", poolPub)
+}
+
+// decryptPoolDM decrypts the content of a DM to the client, e.g. from
+// the pool.
+func decryptPoolDM(ctx context.Context, ev *nostr.Event) (string, error) {
+	signer, err := ClientSigner()
+	if err != nil {
+		return "", err
+	}
+	return signer.NIP04Decrypt(ctx, ev.PubKey, ev.Content)
+}
+
+// requestTokenByDM DMs poolPub a request (e.g. "trial") and waits for
+// the token DM the pool answers it with, tagged tag with the request's
+// ID.
+func requestTokenByDM(ctx context.Context, poolPub, request, tag string) (*vpn.SubscriptionToken, error) {
+	userPub, err := ClientPubKey()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dmRequestTimeout)
+	defer cancel()
+
+	since := nostr.Now()
+	req, err := sendPoolDM(ctx, poolPub, request)
+	if err != nil {
+		return nil, err
+	}
+	sp := newSimplePool(ctx)
+	events := sp.SubscribeMany(ctx, PoolRelayURLs(ctx, poolPub), nostr.Filter{
+		Kinds:   []int{nostr.KindEncryptedDirectMessage},
+		Authors: []string{poolPub},
+		Tags:    nostr.TagMap{"p": []string{userPub}},
+		Since:   &since,
+	})
+	for ev := range events {
+		if ev.Event == nil || ev.Tags.GetFirst([]string{tag, req.ID}) == nil {
+			continue
+		}
+		plain, err := decryptPoolDM(ctx, ev.Event)
+		if err != nil {
+			continue
+		}
+		var tok vpn.SubscriptionToken
+		if err := json.Unmarshal([]byte(plain), &tok); err != nil || tok.Payload.TokenID == "" {
+			continue
+		}
+		return &tok, nil
+	}
+	return nil, fmt.Errorf("%w: no answer from pool %s to DM %s", ErrTokenNotReceived, poolPub, req.ID)
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// RecoverTokens re-fetches the live tokens the pool issued to
+// client's key (see ClientSigner), proving control of the key
+// by signing a challenge from the pool. The tokens are verified and
+// merged into the token store; added counts those that weren't in it.
+func RecoverTokens(ctx context.Context, poolPub string) (tokens []vpn.SubscriptionToken, added int, err error) {
+	signer, err := ClientSigner()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	recoverURL := poolURLFromEnv("/recover")
+	if pricing, err := FetchPricing(ctx, PoolRelayURLs(ctx, poolPub), poolPub); err == nil && pricing.RecoverURL != "" {
+		recoverURL = pricing.RecoverURL
+	}
+	if recoverURL == "" {
+		return nil, 0, errors.New("pool doesn't advertise a recovery URL; set MEERKAT_POOL_URL")
+	}
+
+	ch, err := fetchRecoverChallenge(ctx, strings.TrimRight(recoverURL, "/")+"/challenge")
+	if err != nil {
+		return nil, 0, err
+	}
+	ev := nostr.Event{
+		PubKey:    signer.PubKey(),
+		CreatedAt: nostr.Now(),
+		Kind:      pool.KindRecoverRequest,
+		Tags:      nostr.Tags{{"p", poolPub}, {"challenge", ch.Challenge}},
+	}
+	if err := signer.SignEvent(ctx, &ev); err != nil {
+		return nil, 0, err
+	}
+
+	var resp pool.RecoverResponse
+	if err := postJSON(ctx, recoverURL, ev, &resp); err != nil {
+		return nil, 0, fmt.Errorf("recover tokens: %w", err)
+	}
+
+	ts, err := LoadTokenStore()
+	if err != nil {
+		return nil, 0, fmt.Errorf("load token store: %w", err)
+	}
+	have := map[string]bool{}
+	for _, t := range ts.Tokens {
+		have[t.Payload.TokenID] = true
+	}
+	now := time.Now()
+	for _, tok := range resp.Tokens {
+		if !strings.EqualFold(tok.Payload.IssuerPubKey, poolPub) || tok.Payload.UserPubKey != signer.PubKey() {
+			return nil, 0, fmt.Errorf("pool returned token %s that isn't ours", tok.Payload.TokenID)
+		}
+		if err := vpn.VerifySubscription(tok, now); err != nil {
+			return nil, 0, fmt.Errorf("token %s failed verification: %w", tok.Payload.TokenID, err)
+		}
+		if !have[tok.Payload.TokenID] {
+			added++
+		}
+		ts.AddOrUpdate(tok)
+	}
+	if err := ts.Save(); err != nil {
+		return nil, 0, fmt.Errorf("save token store: %w", err)
+	}
+	return resp.Tokens, added, nil
+}
+
+func fetchRecoverChallenge(ctx context.Context, url string) (*pool.RecoverChallenge, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pool error: %s (%s)", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	var ch pool.RecoverChallenge
+	if err := json.Unmarshal(raw, &ch); err != nil {
+		return nil, fmt.Errorf("decode challenge: %w", err)
+	}
+	if ch.Challenge == "" {
+		return nil, errors.New("pool sent an empty challenge")
+	}
+	return &ch, nil
+}
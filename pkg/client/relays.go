@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"log"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+)
+
+var (
+	poolRelaysMu sync.Mutex
+	poolRelays   = map[string]nostrutil.RelayList{} // pool pubkey -> its relay list, from poolRelayList
+)
+
+// PoolRelayURLs returns the relays to read poolPub's events and DMs
+// from: the outbox (write) relays of the pool's NIP-65 relay list, looked
+// up on ClientRelayURLs, plus MEERKAT_CLIENT_RELAYS if set. Without a
+// relay list it returns ClientRelayURLs. Lookups are cached for the life
+// of the process.
+func PoolRelayURLs(ctx context.Context, poolPub string) []string {
+	rl, ok := poolRelayList(ctx, poolPub)
+	if !ok || len(rl.Write) == 0 {
+		return ClientRelayURLs()
+	}
+	return withClientRelays(rl.Write)
+}
+
+// PoolInboxRelayURLs returns the relays to send events and DMs for
+// poolPub to: the inbox (read) relays of the pool's NIP-65 relay list,
+// plus MEERKAT_CLIENT_RELAYS if set, or ClientRelayURLs without one.
+func PoolInboxRelayURLs(ctx context.Context, poolPub string) []string {
+	rl, ok := poolRelayList(ctx, poolPub)
+	if !ok || len(rl.Read) == 0 {
+		return ClientRelayURLs()
+	}
+	return withClientRelays(rl.Read)
+}
+
+// poolRelayList looks up poolPub's relay list on ClientRelayURLs, once
+// per process.
+func poolRelayList(ctx context.Context, poolPub string) (nostrutil.RelayList, bool) {
+	poolRelaysMu.Lock()
+	cached, ok := poolRelays[poolPub]
+	poolRelaysMu.Unlock()
+	if ok {
+		return cached, true
+	}
+
+	bootstrap := ClientRelayURLs()
+	rl, err := nostrutil.FetchRelayList(ctx, bootstrap, poolPub, clientPoolOptions()...)
+	if err != nil || len(rl.Read)+len(rl.Write) == 0 {
+		if ctx.Err() == nil {
+			log.Printf("no relay list for pool %s; using %v\n", poolPub, bootstrap)
+		}
+		return nostrutil.RelayList{}, false
+	}
+	poolRelaysMu.Lock()
+	poolRelays[poolPub] = *rl
+	poolRelaysMu.Unlock()
+	return *rl, true
+}
+
+// withClientRelays returns urls plus MEERKAT_CLIENT_RELAYS.
+func withClientRelays(urls []string) []string {
+	urls = slices.Clone(urls)
+	for _, url := range nostrutil.SplitRelayURLs(os.Getenv("MEERKAT_CLIENT_RELAYS")) {
+		if url = nostr.NormalizeURL(url); !slices.Contains(urls, url) {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// newSimplePool returns a SimplePool with clientPoolOptions.
+func newSimplePool(ctx context.Context) *nostr.SimplePool {
+	return nostr.NewSimplePool(ctx, clientPoolOptions()...)
+}
+
+// clientPoolOptions answers NIP-42 AUTH challenges with the client's
+// key, if one is configured.
+func clientPoolOptions() []nostr.PoolOption {
+	if !hasClientKey() {
+		return nil
+	}
+	return []nostr.PoolOption{nostrutil.AuthHandler(func(ev *nostr.Event) error {
+		s, err := ClientSigner()
+		if err != nil {
+			return err
+		}
+		return s.SignEvent(context.Background(), ev)
+	})}
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nwc"
+	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// RenewPolicy controls automatic subscription renewal.
+type RenewPolicy struct {
+	// Before is how long before the last valid token expires a new
+	// subscription is bought.
+	Before time.Duration
+	// Plan to buy; "" buys the same plan as the expiring token.
+	Plan string
+	// MaxSats refuses renewals costing more than this (default
+	// DefaultAutoRenewMaxSats).
+	MaxSats int64
+	// CheckInterval is how often the token store is checked.
+	CheckInterval time.Duration
+	// RetryAfter is how long to wait after a failed purchase.
+	RetryAfter time.Duration
+}
+
+// DefaultAutoRenewMaxSats is the most an unattended renewal pays unless
+// RenewPolicy.MaxSats says otherwise.
+const DefaultAutoRenewMaxSats = 100_000
+
+// AutoRenewer renews the subscription through a wallet before the
+// stored tokens run out.
+type AutoRenewer struct {
+	policy     RenewPolicy
+	poolPubHex string
+	wallet     Payer
+
+	buy func(context.Context, BuyOptions) (*vpn.SubscriptionToken, error)
+	now func() time.Time
+
+	nextAttempt time.Time
+}
+
+// NewAutoRenewer creates an AutoRenewer paying through wallet.
+func NewAutoRenewer(poolPubHex string, wallet Payer, policy RenewPolicy) *AutoRenewer {
+	if policy.CheckInterval <= 0 {
+		policy.CheckInterval = 10 * time.Minute
+	}
+	if policy.RetryAfter <= 0 {
+		policy.RetryAfter = 30 * time.Minute
+	}
+	if policy.MaxSats <= 0 {
+		policy.MaxSats = DefaultAutoRenewMaxSats
+	}
+	return &AutoRenewer{
+		policy:     policy,
+		poolPubHex: poolPubHex,
+		wallet:     wallet,
+		buy:        Buy,
+		now:        time.Now,
+	}
+}
+
+// Run checks the token store every CheckInterval until ctx is cancelled.
+func (r *AutoRenewer) Run(ctx context.Context) error {
+	log.Printf("[renew] auto-renew enabled: buying %s before tokens run out\n", r.policy.Before)
+
+	ticker := time.NewTicker(r.policy.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Check(ctx); err != nil {
+			log.Printf("[renew] %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check buys a new subscription if one is due.
+func (r *AutoRenewer) Check(ctx context.Context) error {
+	now := r.now()
+	if now.Before(r.nextAttempt) {
+		return nil
+	}
+
+	ts, err := LoadTokenStore()
+	if err != nil {
+		return fmt.Errorf("load token store: %w", err)
+	}
+	latest, plan, due := r.due(ts, now)
+	if !due {
+		return nil
+	}
+
+	log.Printf("[renew] subscription runs out within %s; renewing it on the %s plan\n", r.policy.Before, plan)
+	opts := BuyOptions{
+		PoolPubKey:   r.poolPubHex,
+		Plan:         plan,
+		Wallet:       r.wallet,
+		MaxSats:      r.policy.MaxSats,
+		RenewTokenID: latest.Payload.TokenID,
+	}
+	tok, err := r.buy(ctx, opts)
+	if errors.Is(err, ErrUnknownRenewToken) {
+		log.Printf("[renew] %v; buying a new subscription instead\n", err)
+		opts.RenewTokenID = ""
+		tok, err = r.buy(ctx, opts)
+	}
+	if errors.Is(err, ErrTokenNotReceived) {
+		// Paid, but the token hasn't arrived: buying again would pay twice.
+		// The token listener will still store it if it shows up.
+		r.nextAttempt = now.Add(r.policy.Before)
+		return fmt.Errorf("%w; not renewing again before %s", err, r.nextAttempt.Format(time.RFC3339))
+	}
+	if err != nil {
+		r.nextAttempt = now.Add(r.policy.RetryAfter)
+		return fmt.Errorf("renew failed (retrying after %s): %w", r.policy.RetryAfter, err)
+	}
+
+	log.Printf("[renew] renewed: token %s (plan=%s) expires %s\n", tok.Payload.TokenID,
+		tok.Payload.SubscriptionType, time.Unix(tok.Payload.ExpiresAt, 0).Format(time.RFC3339))
+	return nil
+}
+
+// due reports whether the last of the pool's tokens expires within
+// policy.Before, and returns that token and the plan to renew it on.
+// Only users who have held a subscription from this pool are renewed.
+func (r *AutoRenewer) due(ts *TokenStore, now time.Time) (latest *vpn.SubscriptionToken, plan string, due bool) {
+	for i := range ts.Tokens {
+		t := &ts.Tokens[i]
+		if t.Payload.IssuerPubKey != r.poolPubHex {
+			continue
+		}
+		if latest == nil || t.Payload.ExpiresAt > latest.Payload.ExpiresAt {
+			latest = t
+		}
+	}
+	if latest == nil {
+		return nil, "", false
+	}
+	if time.Unix(latest.Payload.ExpiresAt, 0).Sub(now) > r.policy.Before {
+		return nil, "", false
+	}
+
+	plan = r.policy.Plan
+	if plan == "" {
+		plan = latest.Payload.SubscriptionType
+	}
+	if plan == pool.TrialPlanID {
+		// Trials aren't for sale; MEERKAT_AUTORENEW_PLAN picks what follows one.
+		return nil, "", false
+	}
+	return latest, plan, true
+}
+
+// AutoRenewerFromEnv returns an AutoRenewer if auto-renew is configured,
+// or nil:
+//
+//	MEERKAT_NWC_URI              nostr+walletconnect:// connection string
+//	MEERKAT_AUTORENEW_HOURS      renew this many hours before tokens run out
+//	MEERKAT_AUTORENEW_PLAN       (optional) plan to buy; default: same plan
+//	MEERKAT_AUTORENEW_MAX_SATS   (optional) refuse renewals above this price;
+//	                             default DefaultAutoRenewMaxSats
+func AutoRenewerFromEnv(poolPubHex string) (*AutoRenewer, error) {
+	hoursEnv := os.Getenv("MEERKAT_AUTORENEW_HOURS")
+	if hoursEnv == "" {
+		return nil, nil
+	}
+	hours, err := strconv.ParseFloat(hoursEnv, 64)
+	if err != nil || hours <= 0 {
+		return nil, fmt.Errorf("invalid MEERKAT_AUTORENEW_HOURS %q", hoursEnv)
+	}
+
+	uri := os.Getenv("MEERKAT_NWC_URI")
+	if uri == "" {
+		return nil, errors.New("MEERKAT_AUTORENEW_HOURS set but MEERKAT_NWC_URI not set")
+	}
+	wallet, err := nwc.NewClientFromURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var maxSats int64
+	if v := os.Getenv("MEERKAT_AUTORENEW_MAX_SATS"); v != "" {
+		if maxSats, err = strconv.ParseInt(v, 10, 64); err != nil || maxSats <= 0 {
+			return nil, fmt.Errorf("invalid MEERKAT_AUTORENEW_MAX_SATS %q", v)
+		}
+	}
+
+	return NewAutoRenewer(poolPubHex, wallet, RenewPolicy{
+		Before:  time.Duration(hours * float64(time.Hour)),
+		Plan:    os.Getenv("MEERKAT_AUTORENEW_PLAN"),
+		MaxSats: maxSats,
+	}), nil
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
+	"github.com/MakerMaker19/meerkatvpn/pkg/tunnel"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// Connection states reported in Status.State.
+const (
+	StateDisconnected = "disconnected"
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+)
+
+// Status is the client's connection status, as shown by front-ends.
+type Status struct {
+	State     string    `json:"state"`
+	Backend   string    `json:"backend,omitempty"`
+	NodeID    string    `json:"node_id,omitempty"`
+	NodeURL   string    `json:"node_url,omitempty"`
+	TokenID   string    `json:"token_id,omitempty"`
+	ExpiresAt int64     `json:"expires_at,omitempty"` // token expiry
+	Since     time.Time `json:"since"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Controller is what front-ends (CLI, TUI, GUI) drive. *Service
+// implements it in-process; *ControlClient implements it against a
+// running daemon's control socket.
+type Controller interface {
+	Connect(ctx context.Context, opts ConnectOptions) (Status, error)
+	Disconnect(ctx context.Context) (Status, error)
+	Status(ctx context.Context) (Status, error)
+	ListTokens(ctx context.Context) ([]vpn.SubscriptionToken, error)
+	ListNodes(ctx context.Context) ([]discovery.NodeInfo, error)
+	// Subscribe streams status changes until ctx is cancelled.
+	Subscribe(ctx context.Context) (<-chan Status, error)
+}
+
+// ErrAlreadyConnected is returned by Connect while a tunnel is active.
+var ErrAlreadyConnected = errors.New("already connected; disconnect first")
+
+// Service owns the client's tunnel. Connect runs a Daemon (with
+// failover and renewal) in the background; Disconnect stops it.
+type Service struct {
+	mgr      *tunnel.Manager
+	defaults ConnectOptions
+
+	mu      sync.Mutex
+	status  Status
+	cancel  context.CancelFunc
+	stopped chan struct{}
+	subs    map[chan Status]struct{}
+}
+
+var _ Controller = (*Service)(nil)
+
+// NewService creates a Service. defaults fills in any ConnectOptions
+// fields a Connect call leaves empty (typically the pool pubkey).
+func NewService(mgr *tunnel.Manager, defaults ConnectOptions) *Service {
+	return &Service{
+		mgr:      mgr,
+		defaults: defaults,
+		status:   Status{State: StateDisconnected, Since: time.Now()},
+		subs:     map[chan Status]struct{}{},
+	}
+}
+
+// Connect starts bringing a tunnel up and returns immediately; progress
+// is reported through Subscribe.
+func (s *Service) Connect(ctx context.Context, opts ConnectOptions) (Status, error) {
+	_ = ctx
+
+	if opts.PoolPubKey == "" {
+		opts.PoolPubKey = s.defaults.PoolPubKey
+	}
+	if opts.Backend == "" {
+		opts.Backend = s.defaults.Backend
+	}
+	if opts.PreferredRegion == "" {
+		opts.PreferredRegion = s.defaults.PreferredRegion
+	}
+	if opts.NodeURL == "" && opts.NodeID == "" {
+		opts.NodeURL = s.defaults.NodeURL
+	}
+	if opts.PoolPubKey == "" {
+		return s.current(), errors.New("pool pubkey not set")
+	}
+
+	s.mu.Lock()
+	if s.cancel != nil {
+		st := s.status
+		s.mu.Unlock()
+		return st, ErrAlreadyConnected
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	s.cancel = cancel
+	s.stopped = stopped
+	s.mu.Unlock()
+
+	s.setStatus(Status{State: StateConnecting, Backend: opts.Backend})
+
+	d := NewDaemon(DaemonConfig{Connect: opts, OnStatus: s.setStatus}, s.mgr)
+	go func() {
+		defer close(stopped)
+		_ = d.Run(runCtx)
+
+		s.mu.Lock()
+		s.cancel = nil
+		s.stopped = nil
+		s.mu.Unlock()
+		s.setStatus(Status{State: StateDisconnected})
+	}()
+
+	return s.current(), nil
+}
+
+// Disconnect stops the tunnel and waits for it to be torn down.
+func (s *Service) Disconnect(ctx context.Context) (Status, error) {
+	s.mu.Lock()
+	cancel, stopped := s.cancel, s.stopped
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return s.current(), nil
+	}
+	cancel()
+
+	select {
+	case <-stopped:
+		return s.current(), nil
+	case <-ctx.Done():
+		return s.current(), ctx.Err()
+	}
+}
+
+func (s *Service) Status(ctx context.Context) (Status, error) {
+	_ = ctx
+	return s.current(), nil
+}
+
+func (s *Service) ListTokens(ctx context.Context) ([]vpn.SubscriptionToken, error) {
+	_ = ctx
+	ts, err := LoadTokenStore()
+	if err != nil {
+		return nil, err
+	}
+	return ts.Tokens, nil
+}
+
+func (s *Service) ListNodes(ctx context.Context) ([]discovery.NodeInfo, error) {
+	return discovery.ListNodes(ctx)
+}
+
+func (s *Service) Subscribe(ctx context.Context) (<-chan Status, error) {
+	ch := make(chan Status, 16)
+
+	s.mu.Lock()
+	s.subs[ch] = struct{}{}
+	ch <- s.status
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.subs, ch)
+		close(ch)
+		s.mu.Unlock()
+	}()
+	return ch, nil
+}
+
+func (s *Service) current() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// setStatus records st and pushes it to subscribers. Slow subscribers
+// miss intermediate states but always see a later one.
+func (s *Service) setStatus(st Status) {
+	if st.Since.IsZero() {
+		st.Since = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status = st
+	for ch := range s.subs {
+		select {
+		case ch <- st:
+		default:
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
+	"github.com/MakerMaker19/meerkatvpn/pkg/node"
+	"github.com/MakerMaker19/meerkatvpn/pkg/tunnel"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// OVPNProfilePath is where PrepareConnection writes OpenVPN profiles.
+const OVPNProfilePath = "meerkat.ovpn"
+
+// ConnectOptions controls node selection for PrepareConnection.
+type ConnectOptions struct {
+	PoolPubKey      string
+	Backend         string   // "openvpn" or "wireguard"
+	PreferredRegion string   // "" or "auto" for best latency
+	NodeURL         string   // if set, skip discovery and use this node
+	NodeID          string   // if set, use this discovered node instead of picking one
+	ExcludeNodes    []string // node IDs discovery must skip (e.g. recently failed)
+}
+
+// SessionResponse is noded's reply to POST /session/create.
+type SessionResponse struct {
+	Status       string   `json:"status"`
+	Code         string   `json:"code,omitempty"` // node.Code* when Status is "error"
+	Message      string   `json:"message"`
+	ServerPubKey string   `json:"server_pubkey"`
+	Endpoint     string   `json:"endpoint"`
+	ClientIP     string   `json:"client_ip"`
+	AllowedIPs   string   `json:"allowed_ips"`
+	DNS          []string `json:"dns"`
+
+	// New for OpenVPN backend:
+	OVPNProfile string `json:"ovpn_profile,omitempty"`
+}
+
+// Connection is a session created on a node, with its tunnel config
+// written to disk and ready to bring up.
+type Connection struct {
+	Node       *discovery.NodeInfo // nil when ConnectOptions.NodeURL was used
+	NodeURL    string
+	Backend    string
+	Token      vpn.SubscriptionToken
+	Session    SessionResponse
+	ConfigPath string
+}
+
+// NodeID returns the selected node's ID, or its URL if it wasn't
+// selected through discovery.
+func (c *Connection) NodeID() string {
+	if c.Node != nil {
+		return c.Node.ID
+	}
+	return c.NodeURL
+}
+
+// TunnelState describes c for tunnel.Manager.Up.
+func (c *Connection) TunnelState() tunnel.State {
+	st := tunnel.State{
+		Backend:    c.Backend,
+		NodeURL:    c.NodeURL,
+		TokenID:    c.Token.Payload.TokenID,
+		ConfigPath: c.ConfigPath,
+	}
+	if c.Node != nil {
+		st.NodeID = c.Node.ID
+	}
+	return st
+}
+
+// PrepareConnection picks a node (unless opts.NodeURL is set), picks the
+// latest valid token, creates a session on the node and writes the
+// resulting OpenVPN profile or WireGuard config.
+func PrepareConnection(ctx context.Context, opts ConnectOptions) (*Connection, error) {
+	if opts.PoolPubKey == "" {
+		return nil, fmt.Errorf("pool pubkey not set")
+	}
+	if opts.Backend == "" {
+		opts.Backend = "openvpn"
+	}
+
+	conn := &Connection{Backend: opts.Backend, NodeURL: opts.NodeURL}
+
+	// Node selection
+	if conn.NodeURL == "" && opts.NodeID != "" {
+		node, err := findNodeByID(ctx, opts.NodeID)
+		if err != nil {
+			return nil, err
+		}
+		conn.Node = node
+		conn.NodeURL = node.APIURL
+
+		log.Printf("Using node %s (%s) (source=%s)\n", node.ID, node.Region, node.Source)
+	} else if conn.NodeURL == "" {
+		region := opts.PreferredRegion
+		if region == "" {
+			region = "auto"
+		}
+
+		node, err := discovery.FindNodeExcluding(ctx, opts.PoolPubKey, region, opts.Backend, opts.ExcludeNodes)
+		if err != nil {
+			return nil, fmt.Errorf("no suitable node found via discovery: %w", err)
+		}
+		conn.Node = node
+		conn.NodeURL = node.APIURL
+
+		log.Printf("Selected node %s (%s) via discovery (source=%s)\n",
+			node.ID, node.Region, node.Source)
+	}
+
+	// - load token store
+	ts, err := LoadTokenStore()
+	if err != nil {
+		return nil, fmt.Errorf("load token store: %w", err)
+	}
+
+	// - pick latest valid token
+	tok, err := ts.LatestValid(opts.PoolPubKey, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("no valid tokens: %w", err)
+	}
+	conn.Token = *tok
+
+	// The device's WG keypair: the WG backend's key, and for either
+	// backend how the node tells this device's sessions apart.
+	wgKeys, err := DeviceWGKeypair()
+	if err != nil {
+		return nil, fmt.Errorf("load WG keypair: %w", err)
+	}
+	log.Printf("Using WireGuard public key: %s\n", wgKeys.Public)
+
+	sr, err := RequestSession(ctx, conn.NodeURL, conn.Token, opts.Backend, wgKeys.Public)
+	if err != nil {
+		var nerr *NodeError
+		if errors.As(err, &nerr) && conn.Node != nil {
+			nerr.NodeID = conn.Node.ID
+		}
+		return nil, err
+	}
+	conn.Session = *sr
+
+	// === Backend-specific handling ===================================
+
+	switch opts.Backend {
+	case "openvpn":
+		if sr.OVPNProfile == "" {
+			return nil, fmt.Errorf("node did not provide ovpn_profile for openvpn backend")
+		}
+		if node := conn.Node; node != nil && node.Endpoints["openvpn"] != "" {
+			profile, err := pinOVPNRemote(sr.OVPNProfile, node.Endpoints["openvpn"])
+			if err != nil {
+				return nil, fmt.Errorf("node %s: %w", node.ID, err)
+			}
+			sr.OVPNProfile = profile
+			conn.Session.OVPNProfile = profile
+		}
+
+		path := OVPNProfilePath
+		if err := os.WriteFile(path, []byte(sr.OVPNProfile), 0o600); err != nil {
+			return nil, fmt.Errorf("write %s: %w", path, err)
+		}
+		conn.ConfigPath = path
+		return conn, nil
+
+	case "wireguard":
+		fallthrough
+	default:
+		if sr.ClientIP == "" {
+			return nil, fmt.Errorf("node did not provide client_ip")
+		}
+		if sr.ServerPubKey == "" {
+			return nil, fmt.Errorf("node did not provide server_pubkey")
+		}
+		if sr.Endpoint == "" {
+			return nil, fmt.Errorf("node did not provide endpoint")
+		}
+
+		// Enforce pins from the nodes file, if any.
+		node := conn.Node
+		if node != nil && node.WGPubKey != "" && node.WGPubKey != sr.ServerPubKey {
+			return nil, fmt.Errorf("node %s returned WireGuard key %s, but nodes file pins %s",
+				node.ID, sr.ServerPubKey, node.WGPubKey)
+		}
+		if node != nil && node.Endpoints["wireguard"] != "" && node.Endpoints["wireguard"] != sr.Endpoint {
+			log.Printf("Node returned endpoint %s; using pinned endpoint %s\n", sr.Endpoint, node.Endpoints["wireguard"])
+			sr.Endpoint = node.Endpoints["wireguard"]
+			conn.Session.Endpoint = sr.Endpoint
+		}
+
+		cfg := BuildWGConfig(WGConfigParams{
+			PrivateKey: wgKeys.Private,
+			Address:    sr.ClientIP,
+			DNS:        sr.DNS,
+			ServerPub:  sr.ServerPubKey,
+			Endpoint:   sr.Endpoint,
+			AllowedIPs: sr.AllowedIPs,
+			Keepalive:  25,
+		})
+
+		path, err := DefaultWGConfigPath()
+		if err != nil {
+			return nil, fmt.Errorf("determine WG config path: %w", err)
+		}
+
+		if err := WriteWGConfig(path, cfg); err != nil {
+			return nil, fmt.Errorf("write WG config: %w", err)
+		}
+		conn.ConfigPath = path
+		return conn, nil
+	}
+}
+
+// pinOVPNRemote points an OpenVPN profile at the pinned endpoint
+// (host:port): the first remote line is rewritten, keeping its protocol,
+// and any others are dropped so the client can't fall back to them.
+func pinOVPNRemote(profile, endpoint string) (string, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("pinned openvpn endpoint %q: %w", endpoint, err)
+	}
+	lines := strings.Split(profile, "\n")
+	out := lines[:0]
+	pinned := false
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "remote" {
+			out = append(out, line)
+			continue
+		}
+		if pinned {
+			continue
+		}
+		remote := "remote " + host + " " + port
+		if len(fields) > 3 {
+			remote += " " + fields[3]
+		}
+		if remote != strings.TrimSpace(line) {
+			log.Printf("Node profile has %q; using pinned endpoint %s\n", strings.TrimSpace(line), endpoint)
+		}
+		out = append(out, remote)
+		pinned = true
+	}
+	if !pinned {
+		return "", errors.New("openvpn profile has no remote line to pin")
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func findNodeByID(ctx context.Context, id string) (*discovery.NodeInfo, error) {
+	nodes, err := discovery.ListNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	for _, n := range nodes {
+		if n.ID == id {
+			return &n, nil
+		}
+	}
+	return nil, fmt.Errorf("node %s not found via discovery", id)
+}
+
+// RequestSession calls POST /session/create on a node.
+func RequestSession(ctx context.Context, nodeURL string, tok vpn.SubscriptionToken, backend, clientWGPub string) (*SessionResponse, error) {
+	// Build request including backend
+	reqBody := struct {
+		Token          vpn.SubscriptionToken `json:"token"`
+		ClientWGPubKey string                `json:"client_wg_pubkey"`
+		Backend        string                `json:"backend"` // "wireguard" or "openvpn"
+	}{
+		Token:          tok,
+		ClientWGPubKey: clientWGPub,
+		Backend:        backend,
+	}
+
+	b, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	url := nodeURL + "/session/create"
+	log.Printf("Connecting to node at %s with token %s (backend=%s)\n", url, tok.Payload.TokenID, backend)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("POST /session/create: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	var sr SessionResponse
+	if err := json.Unmarshal(raw, &sr); err != nil {
+		return nil, fmt.Errorf("decode response: %w (raw body: %s)", err, string(raw))
+	}
+
+	if resp.StatusCode != http.StatusOK || sr.Status != "ok" {
+		return nil, &NodeError{HTTPStatus: resp.StatusCode, Code: sr.Code, Message: sr.Message}
+	}
+	return &sr, nil
+}
+
+// NodeError is a session refused by a node. Code is one of the node.Code*
+// constants (empty for nodes that predate error codes).
+type NodeError struct {
+	NodeID     string // set by PrepareConnection for discovered nodes
+	HTTPStatus int
+	Code       string
+	Message    string
+}
+
+func (e *NodeError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("node error: %s", e.Message)
+	}
+	return fmt.Sprintf("node refused session (%s): %s", e.Code, e.Message)
+}
+
+// PolicyRefusal reports whether the node refused the session because of
+// its tier policy, in which case another node may still accept it.
+func (e *NodeError) PolicyRefusal() bool {
+	switch e.Code {
+	case node.CodeTierNotAllowed, node.CodeBackendNotAllowed, node.CodeRegionNotAllowed, node.CodeDataCapExceeded,
+		node.CodeNodeBusy:
+		return true
+	}
+	return false
+}
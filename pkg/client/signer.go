@@ -0,0 +1,36 @@
+package client
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+)
+
+var (
+	clientSignerMu sync.Mutex
+	clientSigner   nostrutil.Signer
+)
+
+// ClientSigner returns the client's key: MEERKAT_CLIENT_NOSTR_PRIVKEY,
+// or the NIP-46 remote signer at MEERKAT_CLIENT_BUNKER_URL (see
+// nostrutil.SignerFromEnv), which is connected once per process.
+func ClientSigner() (nostrutil.Signer, error) {
+	clientSignerMu.Lock()
+	defer clientSignerMu.Unlock()
+	if clientSigner != nil {
+		return clientSigner, nil
+	}
+	s, err := nostrutil.SignerFromEnv(context.Background(), "MEERKAT_CLIENT")
+	if err != nil {
+		return nil, err
+	}
+	clientSigner = s
+	return s, nil
+}
+
+// hasClientKey reports whether a client key is configured.
+func hasClientKey() bool {
+	return os.Getenv("MEERKAT_CLIENT_NOSTR_PRIVKEY") != "" || os.Getenv("MEERKAT_CLIENT_BUNKER_URL") != ""
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
@@ -68,6 +69,21 @@ func (ts *TokenStore) AddOrUpdate(tok vpn.SubscriptionToken) {
 	ts.Tokens = append(ts.Tokens, tok)
 }
 
+// Remove deletes tokens by TokenID.
+func (ts *TokenStore) Remove(tokenIDs ...string) {
+	drop := map[string]bool{}
+	for _, id := range tokenIDs {
+		drop[id] = true
+	}
+	kept := ts.Tokens[:0]
+	for _, t := range ts.Tokens {
+		if !drop[t.Payload.TokenID] {
+			kept = append(kept, t)
+		}
+	}
+	ts.Tokens = kept
+}
+
 // LatestValid returns the latest non-expired token from a given issuer.
 func (ts *TokenStore) LatestValid(poolPub string, now time.Time) (*vpn.SubscriptionToken, error) {
 	var best *vpn.SubscriptionToken
@@ -88,3 +104,59 @@ func (ts *TokenStore) LatestValid(poolPub string, now time.Time) (*vpn.Subscript
 	}
 	return best, nil
 }
+
+// Subscription is a chain of tokens renewing one another, seen as one
+// subscription.
+type Subscription struct {
+	ID     string                  // TokenID of the chain's first token
+	Tokens []vpn.SubscriptionToken // oldest first
+}
+
+// Latest returns the subscription's token that expires last.
+func (s Subscription) Latest() vpn.SubscriptionToken {
+	latest := s.Tokens[0]
+	for _, t := range s.Tokens[1:] {
+		if t.Payload.ExpiresAt > latest.Payload.ExpiresAt {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// ExpiresAt returns when the subscription runs out.
+func (s Subscription) ExpiresAt() time.Time {
+	return time.Unix(s.Latest().Payload.ExpiresAt, 0)
+}
+
+// CollapseTokens groups tokens into subscriptions by renewal chain,
+// most recently expiring subscription first.
+func CollapseTokens(tokens []vpn.SubscriptionToken) []Subscription {
+	byID := map[string]*Subscription{}
+	var order []string
+	for _, t := range tokens {
+		id := t.Payload.Subscription()
+		sub, ok := byID[id]
+		if !ok {
+			sub = &Subscription{ID: id}
+			byID[id] = sub
+			order = append(order, id)
+		}
+		sub.Tokens = append(sub.Tokens, t)
+	}
+
+	out := make([]Subscription, 0, len(order))
+	for _, id := range order {
+		sub := byID[id]
+		sort.SliceStable(sub.Tokens, func(i, j int) bool {
+			return sub.Tokens[i].Payload.IssuedAt < sub.Tokens[j].Payload.IssuedAt
+		})
+		out = append(out, *sub)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].ExpiresAt().After(out[j].ExpiresAt()) })
+	return out
+}
+
+// Subscriptions returns the stored tokens grouped by renewal chain.
+func (ts *TokenStore) Subscriptions() []Subscription {
+	return CollapseTokens(ts.Tokens)
+}
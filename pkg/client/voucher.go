@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/pool"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// RedeemVoucher redeems a voucher code at the pool for a subscription
+// token, which is verified and added to the token store. Without a
+// voucher URL the request is DMed to the pool instead.
+func RedeemVoucher(ctx context.Context, poolPub, code string) (*vpn.SubscriptionToken, error) {
+	userPub, err := ClientPubKey()
+	if err != nil {
+		return nil, err
+	}
+	req := pool.VoucherRequest{
+		Code:        strings.TrimSpace(code),
+		NostrPubKey: userPub,
+	}
+
+	voucherURL := poolURLFromEnv("/voucher")
+	if pricing, err := FetchPricing(ctx, PoolRelayURLs(ctx, poolPub), poolPub); err == nil && pricing.VoucherURL != "" {
+		voucherURL = pricing.VoucherURL
+	}
+	if voucherURL == "" {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		tok, err := requestTokenByDM(ctx, poolPub, string(b), pool.VoucherDMTag)
+		if err != nil {
+			return nil, fmt.Errorf("redeem voucher by DM: %w", err)
+		}
+		return tok, storeIssuedToken(*tok, poolPub)
+	}
+
+	var tok vpn.SubscriptionToken
+	if err := postJSON(ctx, voucherURL, req, &tok); err != nil {
+		return nil, fmt.Errorf("redeem voucher: %w", err)
+	}
+	if err := storeIssuedToken(tok, poolPub); err != nil {
+		return nil, err
+	}
+	return &tok, nil
+}
+
+// StartTrial asks the pool for a free trial token: it signs a trial
+// request with the client's key, and the token that comes
+// back is verified and added to the token store. If the pool offers
+// trials but no trial URL, the request is DMed to the pool instead.
+func StartTrial(ctx context.Context, poolPub string) (*vpn.SubscriptionToken, error) {
+	signer, err := ClientSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	trialURL, byDM := poolURLFromEnv("/trial"), false
+	if pricing, err := FetchPricing(ctx, PoolRelayURLs(ctx, poolPub), poolPub); err == nil {
+		if pricing.TrialURL != "" {
+			trialURL = pricing.TrialURL
+		}
+		byDM = pricing.TrialSeconds > 0
+	}
+	if trialURL == "" && !byDM {
+		return nil, errors.New("pool doesn't advertise free trials; set MEERKAT_POOL_URL")
+	}
+	if trialURL == "" {
+		tok, err := requestTokenByDM(ctx, poolPub, "trial", pool.TrialDMTag)
+		if err != nil {
+			return nil, fmt.Errorf("request trial by DM: %w", err)
+		}
+		return tok, storeIssuedToken(*tok, poolPub)
+	}
+
+	ev := nostr.Event{
+		PubKey:    signer.PubKey(),
+		CreatedAt: nostr.Now(),
+		Kind:      pool.KindTrialRequest,
+		Tags:      nostr.Tags{{"p", poolPub}},
+	}
+	if err := signer.SignEvent(ctx, &ev); err != nil {
+		return nil, err
+	}
+
+	var tok vpn.SubscriptionToken
+	if err := postJSON(ctx, trialURL, ev, &tok); err != nil {
+		return nil, fmt.Errorf("request trial: %w", err)
+	}
+	if err := storeIssuedToken(tok, poolPub); err != nil {
+		return nil, err
+	}
+	return &tok, nil
+}
+
+// storeIssuedToken checks that tok was issued by poolPub and adds it to
+// the token store.
+func storeIssuedToken(tok vpn.SubscriptionToken, poolPub string) error {
+	if !strings.EqualFold(tok.Payload.IssuerPubKey, poolPub) {
+		return fmt.Errorf("token issued by %s, expected pool %s", tok.Payload.IssuerPubKey, poolPub)
+	}
+	if err := vpn.VerifySubscription(tok, time.Now()); err != nil {
+		return fmt.Errorf("token %s failed verification: %w", tok.Payload.TokenID, err)
+	}
+	ts, err := LoadTokenStore()
+	if err != nil {
+		return fmt.Errorf("load token store: %w", err)
+	}
+	ts.AddOrUpdate(tok)
+	if err := ts.Save(); err != nil {
+		return fmt.Errorf("save token store: %w", err)
+	}
+	return nil
+}
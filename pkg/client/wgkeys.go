@@ -1,6 +1,12 @@
 package client
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
@@ -22,3 +28,39 @@ func GenerateWGKeypair() (WGKeypair, error) {
 		Public:  pub.String(),
 	}, nil
 }
+
+// DeviceWGKeypair returns this device's WireGuard keypair, kept in
+// ~/.meerkatvpn/wg/device.key and created on first use. Nodes tell the
+// devices sharing a subscription apart by its public key, so a device
+// reconnecting replaces its own session instead of taking another slot.
+func DeviceWGKeypair() (WGKeypair, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return WGKeypair{}, err
+	}
+	path := filepath.Join(home, tokenDirName, "wg", "device.key")
+
+	b, err := os.ReadFile(path)
+	if err == nil {
+		priv, err := wgtypes.ParseKey(strings.TrimSpace(string(b)))
+		if err != nil {
+			return WGKeypair{}, fmt.Errorf("parse %s: %w", path, err)
+		}
+		return WGKeypair{Private: priv.String(), Public: priv.PublicKey().String()}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return WGKeypair{}, err
+	}
+
+	keys, err := GenerateWGKeypair()
+	if err != nil {
+		return WGKeypair{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return WGKeypair{}, err
+	}
+	if err := os.WriteFile(path, []byte(keys.Private+"\n"), 0o600); err != nil {
+		return WGKeypair{}, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,265 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SourceCache marks nodes served from the on-disk discovery cache.
+const SourceCache = "cache"
+
+const (
+	defaultCacheFileName = "nodes-cache.json"
+
+	// cacheFreshFor is how old the cache may be before Fresh reports false.
+	cacheFreshFor = time.Hour
+
+	// cacheMaxAge is how long a node stays in the cache without being
+	// seen again by a live source.
+	cacheMaxAge = 7 * 24 * time.Hour
+
+	// cacheSaveDelay batches bursts of events into a single write.
+	cacheSaveDelay = 2 * time.Second
+)
+
+// CachedNode is a node plus what we last knew about its health.
+type CachedNode struct {
+	Node   NodeInfo    `json:"node"`
+	Health *HealthInfo `json:"health,omitempty"`
+	SeenAt int64       `json:"seen_at"`
+}
+
+type cacheFile struct {
+	UpdatedAt int64        `json:"updated_at"`
+	Nodes     []CachedNode `json:"nodes"`
+}
+
+// Cache persists nodes found by live discovery sources (Nostr, HTTP
+// registry) so a fresh process can pick a node before those sources
+// have delivered anything. It implements Finder over the cached nodes.
+type Cache struct {
+	path string
+
+	mu        sync.Mutex
+	updatedAt time.Time
+	nodes     map[string]CachedNode
+	dirty     bool
+	saveTimer *time.Timer
+}
+
+// DefaultCachePath returns ~/.meerkatvpn/nodes-cache.json.
+func DefaultCachePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".meerkatvpn", defaultCacheFileName), nil
+}
+
+// LoadCache reads the cache at path. A missing file yields an empty cache.
+// Cached health results are used to seed latency ranking.
+func LoadCache(path string) (*Cache, error) {
+	c := &Cache{path: path, nodes: map[string]CachedNode{}}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f cacheFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	cutoff := time.Now().Add(-cacheMaxAge).Unix()
+	for _, cn := range f.Nodes {
+		if cn.Node.ID == "" || cn.SeenAt < cutoff {
+			continue
+		}
+		c.nodes[cn.Node.ID] = cn
+		if cn.Health != nil {
+			seedHealth(cn.Node.ID, *cn.Health)
+		}
+	}
+	if f.UpdatedAt > 0 {
+		c.updatedAt = time.Unix(f.UpdatedAt, 0)
+	}
+	return c, nil
+}
+
+// UpdatedAt reports when the cache was last written (zero if never).
+func (c *Cache) UpdatedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.updatedAt
+}
+
+// Fresh reports whether the cache was updated recently enough to trust
+// without waiting for live discovery.
+func (c *Cache) Fresh() bool {
+	t := c.UpdatedAt()
+	return !t.IsZero() && time.Since(t) < cacheFreshFor
+}
+
+// Entries returns the cached nodes with their health and last-seen time.
+func (c *Cache) Entries() []CachedNode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]CachedNode, 0, len(c.nodes))
+	for _, cn := range c.nodes {
+		out = append(out, cn)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Node.ID < out[j].Node.ID })
+	return out
+}
+
+func (c *Cache) FindNode(
+	ctx context.Context,
+	poolPubKey string,
+	preferredRegion string,
+	backend string,
+) (*NodeInfo, error) {
+	_ = poolPubKey
+
+	nodes, err := c.ListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return findNodeFromList(nodes, preferredRegion, backend)
+}
+
+func (c *Cache) ListNodes(ctx context.Context) ([]NodeInfo, error) {
+	_ = ctx
+
+	entries := c.Entries()
+	out := make([]NodeInfo, 0, len(entries))
+	for _, cn := range entries {
+		n := cn.Node
+		n.Source = SourceCache
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// Follow records events from w into the cache until ctx is done. Only
+// nodes that came from live sources are cached; static/file nodes are
+// already on disk and cache-sourced nodes would just echo back.
+func (c *Cache) Follow(ctx context.Context, w Watcher) error {
+	events, err := w.Watch(ctx)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for ev := range events {
+			c.apply(ev)
+		}
+		c.Flush()
+	}()
+	return nil
+}
+
+func (c *Cache) apply(ev NodeEvent) {
+	n := ev.Node
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch ev.Type {
+	case NodeAdded, NodeUpdated:
+		if n.Source != SourceNostr && n.Source != SourceHTTP {
+			return
+		}
+		cn := c.nodes[n.ID]
+		cn.Node = n
+		cn.SeenAt = ev.At.Unix()
+		if h, ok := getHealth(n.ID); ok {
+			cn.Health = &h
+		}
+		c.nodes[n.ID] = cn
+
+	case NodeRemoved:
+		if n.Source == SourceCache {
+			return
+		}
+		if _, ok := c.nodes[n.ID]; !ok {
+			return
+		}
+		delete(c.nodes, n.ID)
+
+	case NodeHealthChanged:
+		cn, ok := c.nodes[n.ID]
+		if !ok {
+			return
+		}
+		h := ev.Health
+		cn.Health = &h
+		c.nodes[n.ID] = cn
+
+	default:
+		return
+	}
+
+	c.dirty = true
+	c.scheduleSaveLocked()
+}
+
+func (c *Cache) scheduleSaveLocked() {
+	if c.saveTimer != nil {
+		return
+	}
+	c.saveTimer = time.AfterFunc(cacheSaveDelay, c.Flush)
+}
+
+// Flush writes pending changes to disk now. Short-lived commands should
+// call it before exiting so events received in the last few seconds are
+// kept.
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	if c.saveTimer != nil {
+		c.saveTimer.Stop()
+		c.saveTimer = nil
+	}
+	if !c.dirty {
+		c.mu.Unlock()
+		return
+	}
+	c.dirty = false
+	c.updatedAt = time.Now()
+	f := cacheFile{UpdatedAt: c.updatedAt.Unix()}
+	for _, cn := range c.nodes {
+		f.Nodes = append(f.Nodes, cn)
+	}
+	c.mu.Unlock()
+
+	if err := c.save(f); err != nil {
+		log.Printf("[discovery/cache] save %s: %v\n", c.path, err)
+	}
+}
+
+// save writes atomically so a crash mid-write can't corrupt the cache.
+func (c *Cache) save(f cacheFile) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
@@ -4,13 +4,19 @@ import "context"
 
 // NodeInfo describes a MeerkatVPN node that a client can connect to.
 type NodeInfo struct {
-	ID       string   // stable node ID (can be hostname, pubkey, etc.)
-	APIURL   string   // base URL for noded, e.g. "http://46.62.204.11:9090"
-	Region   string   // logical region, e.g. "us-east-1", "eu-west-1"
-	Country  string   // "US", "DE", etc.
-	City     string   // optional, e.g. "NYC", "Frankfurt"
-	Backends []string // e.g. []string{"openvpn", "wireguard"}
-	Healthy  bool     // static flag: whether node is enabled at config time
+	ID       string   `json:"id"`                 // stable node ID (can be hostname, pubkey, etc.)
+	APIURL   string   `json:"api_url"`            // base URL for noded, e.g. "http://46.62.204.11:9090"
+	Region   string   `json:"region"`             // logical region, e.g. "us-east-1", "eu-west-1"
+	Country  string   `json:"country,omitempty"`  // "US", "DE", etc.
+	City     string   `json:"city,omitempty"`     // optional, e.g. "NYC", "Frankfurt"
+	Backends []string `json:"backends,omitempty"` // e.g. []string{"openvpn", "wireguard"}
+	Healthy  bool     `json:"healthy"`            // static flag: whether node is enabled at config time
+	PubKey   string   `json:"pubkey,omitempty"`   // node's Nostr pubkey (hex), if known
+	Source   string   `json:"source,omitempty"`   // which discovery source reported this node, e.g. "static", "nostr"
+
+	// Optional pins from a static nodes file.
+	WGPubKey  string            `json:"wg_pubkey,omitempty"` // expected WireGuard server public key
+	Endpoints map[string]string `json:"endpoints,omitempty"` // backend -> tunnel endpoint (host:port)
 }
 
 // Finder is an interface for any node discovery backend
@@ -24,12 +30,20 @@ type Finder interface {
 }
 
 // defaultFinder is what the rest of the code uses.
-// Right now it's backed by a static in-memory list (wrapped in a staticFinder).
+// Right now it's backed by a static in-memory list (wrapped in a StaticFinder).
 var defaultFinder Finder = NewStaticFinder()
 
-// NewStaticFinder returns a Finder implementation that uses staticNodes.
-func NewStaticFinder() Finder {
-	return staticFinder{}
+// NewStaticFinder returns a StaticFinder seeded with staticNodes.
+func NewStaticFinder() *StaticFinder {
+	return NewStaticFinderWithNodes(staticNodes)
+}
+
+// NewStaticFinderWithNodes returns a StaticFinder seeded with nodes.
+func NewStaticFinderWithNodes(nodes []NodeInfo) *StaticFinder {
+	f := &StaticFinder{}
+	f.nodes = make([]NodeInfo, len(nodes))
+	copy(f.nodes, nodes)
+	return f
 }
 
 // SetDefaultFinder lets you swap in a different implementation
@@ -55,3 +69,36 @@ func FindNode(
 func ListNodes(ctx context.Context) ([]NodeInfo, error) {
 	return defaultFinder.ListNodes(ctx)
 }
+
+// FindNodeExcluding is like FindNode but never returns a node whose ID
+// is in exclude (e.g. nodes that just failed). With no exclusions it
+// simply delegates to FindNode.
+func FindNodeExcluding(
+	ctx context.Context,
+	poolPubKey string,
+	preferredRegion string,
+	backend string,
+	exclude []string,
+) (*NodeInfo, error) {
+	if len(exclude) == 0 {
+		return FindNode(ctx, poolPubKey, preferredRegion, backend)
+	}
+
+	nodes, err := defaultFinder.ListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := make(map[string]bool, len(exclude))
+	for _, id := range exclude {
+		skip[id] = true
+	}
+
+	var remaining []NodeInfo
+	for _, n := range nodes {
+		if !skip[n.ID] {
+			remaining = append(remaining, n)
+		}
+	}
+	return findNodeFromList(remaining, preferredRegion, backend)
+}
@@ -0,0 +1,229 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// NodeEventType describes what changed about a node.
+type NodeEventType string
+
+const (
+	NodeAdded         NodeEventType = "added"
+	NodeUpdated       NodeEventType = "updated"
+	NodeRemoved       NodeEventType = "removed"
+	NodeHealthChanged NodeEventType = "health_changed"
+)
+
+// NodeEvent is emitted by a Watcher whenever its view of a node changes.
+type NodeEvent struct {
+	Type   NodeEventType
+	Node   NodeInfo
+	Health HealthInfo // only meaningful for NodeHealthChanged
+	At     time.Time
+}
+
+// Watcher is an optional interface a Finder can implement to push node
+// changes instead of making callers poll ListNodes.
+type Watcher interface {
+	// Watch returns a channel of node events. The channel is closed
+	// when ctx is cancelled.
+	Watch(ctx context.Context) (<-chan NodeEvent, error)
+}
+
+// defaultPollInterval is used by Watch when the default finder does not
+// implement Watcher and we have to diff ListNodes snapshots instead.
+const defaultPollInterval = 5 * time.Second
+
+// Watch streams node events from the default finder. Finders that don't
+// implement Watcher are polled and diffed.
+func Watch(ctx context.Context) (<-chan NodeEvent, error) {
+	if w, ok := defaultFinder.(Watcher); ok {
+		return w.Watch(ctx)
+	}
+	return pollWatch(ctx, defaultFinder, defaultPollInterval), nil
+}
+
+// pollWatch turns any Finder into an event stream by periodically
+// calling ListNodes and emitting the differences.
+func pollWatch(ctx context.Context, f Finder, interval time.Duration) <-chan NodeEvent {
+	out := make(chan NodeEvent, eventBufferSize)
+
+	go func() {
+		defer close(out)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var prev []NodeInfo
+		for {
+			nodes, err := f.ListNodes(ctx)
+			if err != nil {
+				log.Printf("[discovery] poll watch: list nodes: %v\n", err)
+			} else {
+				for _, ev := range diffNodes(prev, nodes) {
+					select {
+					case out <- ev:
+					case <-ctx.Done():
+						return
+					}
+				}
+				prev = nodes
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return out
+}
+
+// diffNodes compares two node snapshots (keyed by ID) and returns the
+// added/updated/removed events needed to go from prev to next.
+func diffNodes(prev, next []NodeInfo) []NodeEvent {
+	now := time.Now()
+
+	old := make(map[string]NodeInfo, len(prev))
+	for _, n := range prev {
+		old[n.ID] = n
+	}
+
+	var out []NodeEvent
+	seen := make(map[string]bool, len(next))
+	for _, n := range next {
+		seen[n.ID] = true
+		o, ok := old[n.ID]
+		switch {
+		case !ok:
+			out = append(out, NodeEvent{Type: NodeAdded, Node: n, At: now})
+		case !nodeEqual(o, n):
+			out = append(out, NodeEvent{Type: NodeUpdated, Node: n, At: now})
+		}
+	}
+	for _, n := range prev {
+		if !seen[n.ID] {
+			out = append(out, NodeEvent{Type: NodeRemoved, Node: n, At: now})
+		}
+	}
+	return out
+}
+
+func nodeEqual(a, b NodeInfo) bool {
+	return a.ID == b.ID &&
+		a.APIURL == b.APIURL &&
+		a.Region == b.Region &&
+		a.Country == b.Country &&
+		a.City == b.City &&
+		a.Healthy == b.Healthy &&
+		a.PubKey == b.PubKey &&
+		a.Source == b.Source &&
+		a.WGPubKey == b.WGPubKey &&
+		maps.Equal(a.Endpoints, b.Endpoints) &&
+		slices.Equal(a.Backends, b.Backends)
+}
+
+// eventBufferSize is the per-subscriber channel buffer. Slow subscribers
+// that fall this far behind start losing events rather than blocking
+// discovery.
+const eventBufferSize = 64
+
+// broadcaster fans NodeEvents out to any number of subscribers.
+type broadcaster struct {
+	mu   sync.Mutex
+	subs map[chan NodeEvent]struct{}
+}
+
+// subscribe registers a new subscriber channel that is closed and
+// removed once ctx is done.
+func (b *broadcaster) subscribe(ctx context.Context) <-chan NodeEvent {
+	ch := make(chan NodeEvent, eventBufferSize)
+
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = map[chan NodeEvent]struct{}{}
+	}
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subs, ch)
+		close(ch)
+		b.mu.Unlock()
+	}()
+
+	return ch
+}
+
+// publish delivers ev to every subscriber without blocking.
+func (b *broadcaster) publish(ev NodeEvent) {
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			if os.Getenv("MEERKAT_DEBUG_DISCOVERY") == "1" {
+				log.Printf("[discovery] dropping %s event for %s: subscriber is full\n", ev.Type, ev.Node.ID)
+			}
+		}
+	}
+}
+
+// watchWithHealth merges a finder's own events with global health
+// changes for the nodes that finder knows about (as reported by lookup).
+func watchWithHealth(ctx context.Context, own *broadcaster, lookup func(id string) (NodeInfo, bool)) <-chan NodeEvent {
+	out := make(chan NodeEvent, eventBufferSize)
+
+	ownCh := own.subscribe(ctx)
+	healthCh := healthEvents.subscribe(ctx)
+
+	go func() {
+		defer close(out)
+		for {
+			var ev NodeEvent
+			var ok bool
+
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok = <-ownCh:
+				if !ok {
+					return
+				}
+			case ev, ok = <-healthCh:
+				if !ok {
+					return
+				}
+				n, known := lookup(ev.Node.ID)
+				if !known {
+					continue
+				}
+				ev.Node = n
+			}
+
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,89 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiffNodes(t *testing.T) {
+	a := NodeInfo{ID: "a", APIURL: "http://a", Backends: []string{"openvpn"}}
+	b := NodeInfo{ID: "b", APIURL: "http://b"}
+	c := NodeInfo{ID: "c", APIURL: "http://c"}
+	aMoved := a
+	aMoved.APIURL = "http://a2"
+	aRepinned := a
+	aRepinned.Endpoints = map[string]string{"wireguard": "1.2.3.4:51820"}
+
+	type change struct {
+		typ NodeEventType
+		id  string
+	}
+	tests := []struct {
+		name       string
+		prev, next []NodeInfo
+		want       []change
+	}{
+		{name: "nothing"},
+		{name: "first snapshot", next: []NodeInfo{a, b}, want: []change{{NodeAdded, "a"}, {NodeAdded, "b"}}},
+		{name: "unchanged", prev: []NodeInfo{a, b}, next: []NodeInfo{b, a}},
+		{name: "add and remove", prev: []NodeInfo{a, b}, next: []NodeInfo{a, c},
+			want: []change{{NodeAdded, "c"}, {NodeRemoved, "b"}}},
+		{name: "update", prev: []NodeInfo{a, b}, next: []NodeInfo{aMoved, b}, want: []change{{NodeUpdated, "a"}}},
+		{name: "pins count as changes", prev: []NodeInfo{a}, next: []NodeInfo{aRepinned}, want: []change{{NodeUpdated, "a"}}},
+		{name: "all gone", prev: []NodeInfo{a, b}, want: []change{{NodeRemoved, "a"}, {NodeRemoved, "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffNodes(tt.prev, tt.next)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events %+v, want %+v", len(got), got, tt.want)
+			}
+			for i, w := range tt.want {
+				if got[i].Type != w.typ || got[i].Node.ID != w.id {
+					t.Errorf("event %d = %s %s, want %s %s", i, got[i].Type, got[i].Node.ID, w.typ, w.id)
+				}
+			}
+		})
+	}
+}
+
+// listFinder serves whatever list it was last given.
+type listFinder struct {
+	emptyFinder
+	mu    sync.Mutex
+	nodes []NodeInfo
+}
+
+func (f *listFinder) set(nodes ...NodeInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodes = nodes
+}
+
+func (f *listFinder) ListNodes(ctx context.Context) ([]NodeInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]NodeInfo(nil), f.nodes...), nil
+}
+
+func TestPollWatch(t *testing.T) {
+	f := &listFinder{}
+	f.set(NodeInfo{ID: "a", APIURL: "http://a"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := pollWatch(ctx, f, 10*time.Millisecond)
+	expectEvent(t, ch, NodeAdded, "a")
+
+	f.set(NodeInfo{ID: "a", APIURL: "http://a2"})
+	expectEvent(t, ch, NodeUpdated, "a")
+
+	f.set(NodeInfo{ID: "b", APIURL: "http://b"})
+	expectEvent(t, ch, NodeAdded, "b")
+	expectEvent(t, ch, NodeRemoved, "a")
+
+	cancel()
+	for range ch {
+	}
+}
@@ -1,6 +1,7 @@
 package discovery
 
 import (
+	"context"
 	"log"
 	"net"
 	"net/url"
@@ -13,15 +14,19 @@ import (
 
 // HealthInfo tracks runtime health/latency for a node.
 type HealthInfo struct {
-	LatencyMs   int
-	Healthy     bool
-	LastChecked time.Time
-	LastError   string
+	LatencyMs   int       `json:"latency_ms"`
+	Healthy     bool      `json:"healthy"`
+	LastChecked time.Time `json:"last_checked"`
+	LastError   string    `json:"last_error,omitempty"`
 }
 
 var (
 	healthMu   sync.RWMutex
 	healthByID = map[string]HealthInfo{}
+
+	// healthEvents carries NodeHealthChanged events whenever a node's
+	// dynamic Healthy flag flips (or is recorded for the first time).
+	healthEvents = &broadcaster{}
 )
 
 // getHealth returns the health info (if any) for a node ID.
@@ -32,15 +37,42 @@ func getHealth(id string) (HealthInfo, bool) {
 	return h, ok
 }
 
-// setHealth updates health info for a node ID.
+// Health returns the latest probe result for a node ID, if it has been
+// probed (or seeded from the discovery cache).
+func Health(id string) (HealthInfo, bool) {
+	return getHealth(id)
+}
+
+// setHealth updates health info for a node ID and publishes a
+// NodeHealthChanged event if the Healthy flag changed.
 func setHealth(id string, h HealthInfo) {
 	healthMu.Lock()
-	defer healthMu.Unlock()
+	prev, had := healthByID[id]
 	healthByID[id] = h
+	healthMu.Unlock()
+
+	if !had || prev.Healthy != h.Healthy {
+		healthEvents.publish(NodeEvent{
+			Type:   NodeHealthChanged,
+			Node:   NodeInfo{ID: id},
+			Health: h,
+			At:     h.LastChecked,
+		})
+	}
+}
+
+// seedHealth records health info loaded from the discovery cache. It
+// never overwrites a live probe result and doesn't publish events.
+func seedHealth(id string, h HealthInfo) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	if _, ok := healthByID[id]; !ok {
+		healthByID[id] = h
+	}
 }
 
 // StartBackgroundHealthProbe launches a goroutine that periodically
-// probes all staticNodes and records health/latency. Safe to call
+// probes every node the default finder knows and records health/latency. Safe to call
 // multiple times; the first call wins.
 var healthProbeOnce sync.Once
 
@@ -62,9 +94,14 @@ func StartBackgroundHealthProbe(interval time.Duration) {
 	})
 }
 
-// probeAllNodesOnce probes each statically-known node once.
+// probeAllNodesOnce probes each node known to the default finder once.
 func probeAllNodesOnce() {
-	for _, n := range staticNodes {
+	nodes, err := ListNodes(context.Background())
+	if err != nil {
+		log.Printf("[discovery] health probe: list nodes: %v\n", err)
+		return
+	}
+	for _, n := range nodes {
 		// Only probe nodes that are "enabled" statically.
 		if !n.Healthy {
 			continue
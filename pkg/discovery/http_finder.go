@@ -0,0 +1,186 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// httpRefreshInterval is how long HTTPFinder serves its cached list
+// before revalidating it with the registry.
+const httpRefreshInterval = time.Minute
+
+// HTTPFinder implements Finder by fetching a pool's node registry
+// (GET <registry>/nodes, served by poold) and verifying each node's
+// attestation against the pool pubkey. Responses are cached and
+// revalidated with ETag / If-None-Match.
+type HTTPFinder struct {
+	registryURL string
+	poolPub     string
+	client      *http.Client
+
+	mu        sync.Mutex
+	nodes     []NodeInfo
+	expires   map[string]int64 // node ID -> attestation expiry
+	etag      string
+	fetchedAt time.Time
+}
+
+// NewHTTPFinder creates a Finder for the registry at registryURL (the
+// poold base URL, e.g. "https://pool.example.com"). poolPubKey is the
+// hex pubkey attestations must be signed by.
+func NewHTTPFinder(registryURL, poolPubKey string) *HTTPFinder {
+	return &HTTPFinder{
+		registryURL: strings.TrimRight(registryURL, "/"),
+		poolPub:     poolPubKey,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (f *HTTPFinder) FindNode(
+	ctx context.Context,
+	poolPubKey string,
+	preferredRegion string,
+	backend string,
+) (*NodeInfo, error) {
+	_ = poolPubKey
+
+	nodes, err := f.ListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return findNodeFromList(nodes, preferredRegion, backend)
+}
+
+// ListNodes returns the verified registry nodes, refreshing the cache
+// if it is stale. If the registry is unreachable but a previous list is
+// cached, the cached list is returned.
+func (f *HTTPFinder) ListNodes(ctx context.Context) ([]NodeInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.fetchedAt.IsZero() || time.Since(f.fetchedAt) >= httpRefreshInterval {
+		if err := f.refreshLocked(ctx); err != nil {
+			if f.fetchedAt.IsZero() {
+				return nil, err
+			}
+			log.Printf("[discovery/http] refresh failed, serving cached list: %v\n", err)
+		}
+	}
+
+	out := make([]NodeInfo, len(f.nodes))
+	copy(out, f.nodes)
+	return out, nil
+}
+
+func (f *HTTPFinder) refreshLocked(ctx context.Context) error {
+	debug := os.Getenv("MEERKAT_DEBUG_DISCOVERY") == "1"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.registryURL+"/nodes", nil)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET /nodes: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		f.fetchedAt = time.Now()
+		// Cached attestations can still expire while the ETag holds.
+		f.nodes = f.dropExpiredLocked(time.Now())
+		if debug {
+			log.Printf("[discovery/http] registry not modified (etag=%s)\n", f.etag)
+		}
+		return nil
+	case http.StatusOK:
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("registry returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var reg struct {
+		PoolPubKey string                `json:"pool_pubkey"`
+		Nodes      []vpn.NodeAttestation `json:"nodes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reg); err != nil {
+		return fmt.Errorf("decode registry: %w", err)
+	}
+	if !strings.EqualFold(reg.PoolPubKey, f.poolPub) {
+		return fmt.Errorf("registry is for pool %s, expected %s", reg.PoolPubKey, f.poolPub)
+	}
+
+	now := time.Now()
+	var nodes []NodeInfo
+	expires := map[string]int64{}
+	var rejected []error
+	for _, att := range reg.Nodes {
+		if err := vpn.VerifyNodeAttestation(att, f.poolPub, now); err != nil {
+			rejected = append(rejected, fmt.Errorf("%s: %w", att.Payload.NodeID, err))
+			continue
+		}
+		nodes = append(nodes, nodeFromAttestation(att))
+		expires[att.Payload.NodeID] = att.Payload.ExpiresAt
+	}
+	if len(rejected) > 0 {
+		log.Printf("[discovery/http] rejected %d attestations: %v\n", len(rejected), errors.Join(rejected...))
+	}
+
+	f.nodes = nodes
+	f.expires = expires
+	f.etag = resp.Header.Get("ETag")
+	f.fetchedAt = now
+
+	if debug {
+		log.Printf("[discovery/http] fetched %d verified nodes from %s\n", len(nodes), f.registryURL)
+	}
+	return nil
+}
+
+func nodeFromAttestation(att vpn.NodeAttestation) NodeInfo {
+	p := att.Payload
+	backends := append([]string(nil), p.Backends...)
+	if len(backends) == 0 {
+		backends = []string{"openvpn"}
+	}
+	return NodeInfo{
+		ID:        p.NodeID,
+		APIURL:    p.APIURL,
+		Region:    p.Region,
+		Country:   p.Country,
+		City:      p.City,
+		Backends:  backends,
+		Healthy:   true,
+		PubKey:    p.NodePubKey,
+		Source:    SourceHTTP,
+		WGPubKey:  p.WGPubKey,
+		Endpoints: p.Endpoints,
+	}
+}
+
+func (f *HTTPFinder) dropExpiredLocked(now time.Time) []NodeInfo {
+	var out []NodeInfo
+	for _, n := range f.nodes {
+		if exp := f.expires[n.ID]; exp != 0 && exp <= now.Unix() {
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+func testSigner(t *testing.T) *nostrutil.LocalSigner {
+	t.Helper()
+	s, err := nostrutil.NewLocalSigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func attest(t *testing.T, signer vpn.Signer, poolPub, id string, expires time.Time) vpn.NodeAttestation {
+	t.Helper()
+	att, err := vpn.SignNodeAttestation(context.Background(), signer, vpn.NodeAttestationPayload{
+		NodeID:     id,
+		APIURL:     "https://" + id + ".example.com",
+		Backends:   []string{"wireguard"},
+		PoolPubKey: poolPub,
+		IssuedAt:   time.Now().Unix(),
+		ExpiresAt:  expires.Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return att
+}
+
+// testRegistry serves GET /nodes like poold, with an ETag.
+func testRegistry(t *testing.T, poolPub string, atts []vpn.NodeAttestation) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/nodes" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		json.NewEncoder(w).Encode(map[string]any{"pool_pubkey": poolPub, "nodes": atts})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPFinderVerifiesAttestations(t *testing.T) {
+	pool, other := testSigner(t), testSigner(t)
+	poolPub := pool.PubKey()
+	now := time.Now()
+
+	forged := attest(t, other, poolPub, "forged", now.Add(time.Hour)) // claims the pool, signed by another key
+	tampered := attest(t, pool, poolPub, "tampered", now.Add(time.Hour))
+	tampered.Payload.APIURL = "https://evil.example.com"
+
+	srv := testRegistry(t, poolPub, []vpn.NodeAttestation{
+		attest(t, pool, poolPub, "good", now.Add(time.Hour)),
+		attest(t, pool, poolPub, "expired", now.Add(-time.Minute)),
+		forged,
+		tampered,
+		attest(t, other, other.PubKey(), "other-pool", now.Add(time.Hour)),
+	})
+
+	f := NewHTTPFinder(srv.URL+"/", poolPub)
+	nodes, err := f.ListNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].ID != "good" || nodes[0].Source != SourceHTTP {
+		t.Fatalf("nodes = %+v, want only the valid attestation", nodes)
+	}
+}
+
+func TestHTTPFinderDropsExpiredOnRevalidate(t *testing.T) {
+	pool := testSigner(t)
+	srv := testRegistry(t, pool.PubKey(), []vpn.NodeAttestation{
+		attest(t, pool, pool.PubKey(), "good", time.Now().Add(time.Hour)),
+	})
+
+	f := NewHTTPFinder(srv.URL, pool.PubKey())
+	if nodes, err := f.ListNodes(context.Background()); err != nil || len(nodes) != 1 {
+		t.Fatalf("ListNodes() = %+v, %v; want one node", nodes, err)
+	}
+
+	// The attestation expires while the registry answers 304.
+	f.mu.Lock()
+	f.expires["good"] = time.Now().Add(-time.Second).Unix()
+	f.fetchedAt = time.Now().Add(-2 * httpRefreshInterval)
+	f.mu.Unlock()
+	if nodes, err := f.ListNodes(context.Background()); err != nil || len(nodes) != 0 {
+		t.Fatalf("ListNodes() = %+v, %v; want the expired node dropped", nodes, err)
+	}
+}
+
+func TestHTTPFinderRejectsOtherPool(t *testing.T) {
+	pool, other := testSigner(t), testSigner(t)
+	srv := testRegistry(t, other.PubKey(), nil)
+
+	if _, err := NewHTTPFinder(srv.URL, pool.PubKey()).ListNodes(context.Background()); err == nil {
+		t.Fatal("ListNodes() accepted another pool's registry")
+	}
+}
@@ -0,0 +1,246 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Source names used when reporting where a NodeInfo came from.
+const (
+	SourceStatic = "static"
+	SourceNostr  = "nostr"
+	SourceHTTP   = "http"
+	SourceFile   = "file"
+)
+
+// NamedFinder pairs a Finder with the source name reported on its nodes.
+type NamedFinder struct {
+	Name   string
+	Finder Finder
+}
+
+// MultiFinder merges the node lists of several finders. Sources are
+// listed in precedence order: when two sources report the same node
+// (matched by ID or PubKey), the earlier source wins. A failing source
+// is logged and skipped so it can't hide nodes the others know about.
+type MultiFinder struct {
+	sources []NamedFinder
+
+	mu       sync.Mutex
+	last     []NodeInfo
+	events   broadcaster
+	watchers int                // active Watch calls
+	stop     context.CancelFunc // stops the source watchers
+}
+
+// NewMultiFinder creates a MultiFinder over sources, highest precedence first.
+func NewMultiFinder(sources ...NamedFinder) *MultiFinder {
+	var out []NamedFinder
+	for _, s := range sources {
+		if s.Finder != nil {
+			out = append(out, s)
+		}
+	}
+	return &MultiFinder{sources: out}
+}
+
+func (m *MultiFinder) FindNode(
+	ctx context.Context,
+	poolPubKey string,
+	preferredRegion string,
+	backend string,
+) (*NodeInfo, error) {
+	_ = poolPubKey
+
+	nodes, err := m.ListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return findNodeFromList(nodes, preferredRegion, backend)
+}
+
+// ListNodes queries every source and returns the merged, deduplicated list.
+// It only fails if every source fails.
+func (m *MultiFinder) ListNodes(ctx context.Context) ([]NodeInfo, error) {
+	debug := os.Getenv("MEERKAT_DEBUG_DISCOVERY") == "1"
+
+	var (
+		merged []NodeInfo
+		errs   []error
+		byKey  = map[string]int{}
+	)
+
+	for _, src := range m.sources {
+		nodes, err := src.Finder.ListNodes(ctx)
+		if err != nil {
+			log.Printf("[discovery/multi] source %s failed: %v\n", src.Name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", src.Name, err))
+			continue
+		}
+
+		for _, n := range nodes {
+			n.Source = src.Name
+
+			if idx, dup := lookupMerged(byKey, n); dup {
+				if debug {
+					log.Printf("[discovery/multi] %s: node %s already provided by %s; skipping\n",
+						src.Name, n.ID, merged[idx].Source)
+				}
+				continue
+			}
+
+			merged = append(merged, n)
+			idx := len(merged) - 1
+			for _, k := range nodeKeys(n) {
+				byKey[k] = idx
+			}
+		}
+	}
+
+	if len(merged) == 0 && len(errs) > 0 && len(errs) == len(m.sources) {
+		return nil, fmt.Errorf("all discovery sources failed: %w", errors.Join(errs...))
+	}
+	return merged, nil
+}
+
+// nodeKeys returns the identities a node can be deduplicated on.
+func nodeKeys(n NodeInfo) []string {
+	var keys []string
+	if n.ID != "" {
+		keys = append(keys, "id:"+strings.ToLower(n.ID))
+	}
+	if n.PubKey != "" {
+		// Nostr nodes use their pubkey as ID, so key pubkeys in the same
+		// namespace to match a static node pinned to that pubkey.
+		keys = append(keys, "id:"+strings.ToLower(n.PubKey))
+	}
+	return keys
+}
+
+func lookupMerged(byKey map[string]int, n NodeInfo) (int, bool) {
+	for _, k := range nodeKeys(n) {
+		if idx, ok := byKey[k]; ok {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// Watch implements Watcher. Any change reported by a source triggers a
+// re-merge, and the differences in the merged view are emitted. Sources
+// that don't implement Watcher are polled.
+func (m *MultiFinder) Watch(ctx context.Context) (<-chan NodeEvent, error) {
+	out := watchWithHealth(ctx, &m.events, m.lookup)
+
+	m.mu.Lock()
+	m.watchers++
+	if m.watchers == 1 {
+		var sctx context.Context
+		sctx, m.stop = context.WithCancel(context.WithoutCancel(ctx))
+		m.startSourceWatchers(sctx)
+	}
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.watchers--; m.watchers == 0 {
+			m.stop()
+			m.stop = nil
+		}
+	}()
+	return out, nil
+}
+
+// startSourceWatchers subscribes to every source until ctx is done,
+// which is when the last Watch call's context is, and re-merges whenever
+// one of them changes.
+func (m *MultiFinder) startSourceWatchers(ctx context.Context) {
+	kick := make(chan struct{}, 1)
+
+	for _, src := range m.sources {
+		var ch <-chan NodeEvent
+		if w, ok := src.Finder.(Watcher); ok {
+			c, err := w.Watch(ctx)
+			if err != nil {
+				log.Printf("[discovery/multi] watch %s: %v; polling instead\n", src.Name, err)
+				c = pollWatch(ctx, src.Finder, defaultPollInterval)
+			}
+			ch = c
+		} else {
+			ch = pollWatch(ctx, src.Finder, defaultPollInterval)
+		}
+
+		go func(ch <-chan NodeEvent) {
+			for ev := range ch {
+				// Health changes are forwarded by watchWithHealth already.
+				if ev.Type == NodeHealthChanged {
+					continue
+				}
+				select {
+				case kick <- struct{}{}:
+				default:
+				}
+			}
+		}(ch)
+	}
+
+	go func() {
+		m.remerge(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-kick:
+				m.remerge(ctx)
+			}
+		}
+	}()
+}
+
+func (m *MultiFinder) remerge(ctx context.Context) {
+	nodes, err := m.ListNodes(ctx)
+	if err != nil {
+		log.Printf("[discovery/multi] re-merge failed: %v\n", err)
+		return
+	}
+
+	m.mu.Lock()
+	prev := m.last
+	m.last = nodes
+	m.mu.Unlock()
+
+	for _, ev := range diffNodes(prev, nodes) {
+		m.events.publish(ev)
+	}
+}
+
+func (m *MultiFinder) lookup(id string) (NodeInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, n := range m.last {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return NodeInfo{}, false
+}
+
+// emptyFinder knows no nodes. It is used as the "fallback" for finders
+// that live inside a MultiFinder, where falling back is the
+// MultiFinder's job.
+type emptyFinder struct{}
+
+func (emptyFinder) FindNode(ctx context.Context, poolPubKey, preferredRegion, backend string) (*NodeInfo, error) {
+	return nil, errors.New("no nodes known")
+}
+
+func (emptyFinder) ListNodes(ctx context.Context) ([]NodeInfo, error) {
+	return nil, nil
+}
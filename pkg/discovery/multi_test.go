@@ -0,0 +1,146 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type failingFinder struct{ emptyFinder }
+
+func (failingFinder) ListNodes(ctx context.Context) ([]NodeInfo, error) {
+	return nil, errors.New("unreachable")
+}
+
+func TestMultiFinderDedupe(t *testing.T) {
+	const nodePub = "aa11"
+	file := NewStaticFinderWithNodes([]NodeInfo{
+		{ID: "vps-1", APIURL: "http://pinned", PubKey: nodePub, WGPubKey: "pinned-key"},
+		{ID: "vps-2", APIURL: "http://two"},
+	})
+	nostr := NewStaticFinderWithNodes([]NodeInfo{
+		{ID: "AA11", APIURL: "http://relay", PubKey: nodePub, WGPubKey: "relay-key"}, // vps-1, by pubkey
+		{ID: "vps-2", APIURL: "http://relay-two"},
+		{ID: "vps-3", APIURL: "http://three"},
+	})
+
+	m := NewMultiFinder(
+		NamedFinder{Name: SourceFile, Finder: file},
+		NamedFinder{Name: SourceNostr, Finder: nostr},
+		NamedFinder{Name: SourceHTTP, Finder: failingFinder{}},
+		NamedFinder{Name: SourceStatic}, // nil finders are dropped
+	)
+	nodes, err := m.ListNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ id, url, source string }{
+		{"vps-1", "http://pinned", SourceFile},
+		{"vps-2", "http://two", SourceFile},
+		{"vps-3", "http://three", SourceNostr},
+	}
+	if len(nodes) != len(want) {
+		t.Fatalf("got %d nodes, want %d: %+v", len(nodes), len(want), nodes)
+	}
+	for i, w := range want {
+		n := nodes[i]
+		if n.ID != w.id || n.APIURL != w.url || n.Source != w.source {
+			t.Errorf("node %d = %s %s from %s, want %s %s from %s", i, n.ID, n.APIURL, n.Source, w.id, w.url, w.source)
+		}
+	}
+	if nodes[0].WGPubKey != "pinned-key" {
+		t.Errorf("vps-1 WGPubKey = %q, want the pinned key", nodes[0].WGPubKey)
+	}
+}
+
+func TestMultiFinderAllSourcesFail(t *testing.T) {
+	m := NewMultiFinder(
+		NamedFinder{Name: SourceNostr, Finder: failingFinder{}},
+		NamedFinder{Name: SourceHTTP, Finder: failingFinder{}},
+	)
+	if _, err := m.ListNodes(context.Background()); err == nil {
+		t.Fatal("ListNodes() succeeded with every source failing")
+	}
+
+	m = NewMultiFinder(
+		NamedFinder{Name: SourceNostr, Finder: failingFinder{}},
+		NamedFinder{Name: SourceStatic, Finder: NewStaticFinderWithNodes(nil)},
+	)
+	if nodes, err := m.ListNodes(context.Background()); err != nil || len(nodes) != 0 {
+		t.Fatalf("ListNodes() = %v, %v; want no nodes and no error", nodes, err)
+	}
+}
+
+func TestMultiFinderWatchRefCount(t *testing.T) {
+	src := NewStaticFinderWithNodes([]NodeInfo{{ID: "vps-1", APIURL: "http://one"}})
+	m := NewMultiFinder(NamedFinder{Name: SourceFile, Finder: src})
+
+	running := func() (int, bool) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.watchers, m.stop != nil
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	ch1, _ := m.Watch(ctx1)
+	ch2, _ := m.Watch(ctx2)
+	if n, ok := running(); n != 2 || !ok {
+		t.Fatalf("watchers = %d, running = %v; want 2, true", n, ok)
+	}
+	expectEvent(t, ch1, NodeAdded, "vps-1")
+
+	// The first watcher leaving doesn't stop the sources.
+	cancel1()
+	waitFor(t, func() bool { n, _ := running(); return n == 1 })
+	if _, ok := running(); !ok {
+		t.Fatal("source watchers stopped with a watcher left")
+	}
+	src.Reload([]NodeInfo{{ID: "vps-1", APIURL: "http://moved"}})
+	for ev := range ch2 {
+		// ch2 may or may not have subscribed before the first merge.
+		if ev.Type == NodeAdded && ev.Node.APIURL == "http://one" {
+			continue
+		}
+		if ev.Type != NodeUpdated || ev.Node.APIURL != "http://moved" {
+			t.Fatalf("got %s event for %+v, want the update", ev.Type, ev.Node)
+		}
+		break
+	}
+
+	// The last one does.
+	cancel2()
+	waitFor(t, func() bool { n, ok := running(); return n == 0 && !ok })
+}
+
+func expectEvent(t *testing.T, ch <-chan NodeEvent, typ NodeEventType, id string) {
+	t.Helper()
+	for {
+		select {
+		case ev := <-ch:
+			if ev.Type == NodeHealthChanged {
+				continue
+			}
+			if ev.Type != typ || ev.Node.ID != id {
+				t.Fatalf("got %s event for %s, want %s for %s", ev.Type, ev.Node.ID, typ, id)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event for %s", typ, id)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
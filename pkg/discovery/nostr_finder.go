@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
@@ -14,6 +15,10 @@ import (
 // NostrNodeAnnouncementKind is the Nostr kind used for node announcements.
 const NostrNodeAnnouncementKind = 38383
 
+// nostrNodeStaleAfter is how long a node may go without re-announcing
+// before the finder drops it and emits a NodeRemoved event.
+const nostrNodeStaleAfter = 2 * time.Hour
+
 // NostrNodeAnnouncement models the JSON content of a node-announcement event.
 type NostrNodeAnnouncement struct {
 	APIURL   string   `json:"api_url"`
@@ -33,8 +38,11 @@ type nostrFinder struct {
 
 	fallback Finder
 
-	mu    sync.RWMutex
-	nodes []NodeInfo
+	mu       sync.RWMutex
+	nodes    []NodeInfo
+	lastSeen map[string]time.Time
+
+	events broadcaster
 
 	startOnce sync.Once
 }
@@ -49,9 +57,16 @@ func NewNostrFinder(relays []string, poolPubKey string, fallback Finder) Finder
 		relays:   relays,
 		poolPub:  poolPubKey,
 		fallback: fallback,
+		lastSeen: map[string]time.Time{},
 	}
 }
 
+// NewNostrOnlyFinder creates a Nostr-based Finder with no fallback, for
+// use as one source of a MultiFinder.
+func NewNostrOnlyFinder(relays []string, poolPubKey string) Finder {
+	return NewNostrFinder(relays, poolPubKey, emptyFinder{})
+}
+
 func (f *nostrFinder) ensureStarted() {
 	f.startOnce.Do(func() {
 		go f.run()
@@ -93,6 +108,8 @@ func (f *nostrFinder) run() {
 		},
 	}
 
+	go f.pruneStaleLoop(ctx)
+
 	ch := pool.SubMany(ctx, f.relays, filters)
 	log.Printf("[discovery/nostr] started subscription (kind=%d, pool=%s, relays=%v)\n",
 		NostrNodeAnnouncementKind, f.poolPub, f.relays)
@@ -156,13 +173,15 @@ func (f *nostrFinder) updateFromEvent(ev *nostr.Event) {
 	}
 
 	node := NodeInfo{
-		ID:      ev.PubKey,
-		APIURL:  ann.APIURL,
-		Region:  strings.TrimSpace(ann.Region),
-		Country: strings.TrimSpace(ann.Country),
-		City:    strings.TrimSpace(ann.City),
+		ID:       ev.PubKey,
+		APIURL:   ann.APIURL,
+		Region:   strings.TrimSpace(ann.Region),
+		Country:  strings.TrimSpace(ann.Country),
+		City:     strings.TrimSpace(ann.City),
 		Backends: append([]string(nil), ann.Backends...),
 		Healthy:  true,
+		PubKey:   ev.PubKey,
+		Source:   SourceNostr,
 	}
 
 	// Tags can override JSON content.
@@ -189,23 +208,83 @@ func (f *nostrFinder) updateFromEvent(ev *nostr.Event) {
 			node.ID, node.APIURL, node.Region, node.Country, node.City, node.Backends)
 	}
 
-	// Merge into our node list.
+	// The node was seen when the announcement arrived, but no later than
+	// it was made: relays replay old announcements, and a node's clock
+	// running ahead mustn't keep it listed.
+	seenAt := time.Now()
+	if created := ev.CreatedAt.Time(); created.Before(seenAt) {
+		seenAt = created
+	}
+	f.mergeNode(node, seenAt)
+}
+
+// mergeNode inserts or replaces node in the in-memory list and emits
+// the matching NodeAdded/NodeUpdated event.
+func (f *nostrFinder) mergeNode(node NodeInfo, seenAt time.Time) {
+	debug := os.Getenv("MEERKAT_DEBUG_DISCOVERY") == "1"
+
 	f.mu.Lock()
-	defer f.mu.Unlock()
+	f.lastSeen[node.ID] = seenAt
 
 	for i := range f.nodes {
 		if f.nodes[i].ID == node.ID {
+			changed := !nodeEqual(f.nodes[i], node)
 			f.nodes[i] = node
+			f.mu.Unlock()
+
 			if debug {
 				log.Printf("[discovery/nostr] updated node %s\n", node.ID)
 			}
+			if changed {
+				f.events.publish(NodeEvent{Type: NodeUpdated, Node: node})
+			}
 			return
 		}
 	}
 
 	f.nodes = append(f.nodes, node)
+	count := len(f.nodes)
+	f.mu.Unlock()
+
 	if debug {
-		log.Printf("[discovery/nostr] now tracking %d nostr nodes\n", len(f.nodes))
+		log.Printf("[discovery/nostr] now tracking %d nostr nodes\n", count)
+	}
+	f.events.publish(NodeEvent{Type: NodeAdded, Node: node})
+}
+
+// pruneStaleLoop periodically drops nodes that haven't re-announced
+// within nostrNodeStaleAfter.
+func (f *nostrFinder) pruneStaleLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			f.pruneStale(now)
+		}
+	}
+}
+
+func (f *nostrFinder) pruneStale(now time.Time) {
+	f.mu.Lock()
+	var kept, removed []NodeInfo
+	for _, n := range f.nodes {
+		if now.Sub(f.lastSeen[n.ID]) > nostrNodeStaleAfter {
+			removed = append(removed, n)
+			delete(f.lastSeen, n.ID)
+			continue
+		}
+		kept = append(kept, n)
+	}
+	f.nodes = kept
+	f.mu.Unlock()
+
+	for _, n := range removed {
+		log.Printf("[discovery/nostr] node %s went stale; removing\n", n.ID)
+		f.events.publish(NodeEvent{Type: NodeRemoved, Node: n})
 	}
 }
 
@@ -269,3 +348,21 @@ func (f *nostrFinder) ListNodes(ctx context.Context) ([]NodeInfo, error) {
 	}
 	return nodes, nil
 }
+
+// Watch implements Watcher. Events cover Nostr-discovered nodes only;
+// the fallback finder is not watched.
+func (f *nostrFinder) Watch(ctx context.Context) (<-chan NodeEvent, error) {
+	f.ensureStarted()
+	return watchWithHealth(ctx, &f.events, f.lookup), nil
+}
+
+func (f *nostrFinder) lookup(id string) (NodeInfo, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, n := range f.nodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return NodeInfo{}, false
+}
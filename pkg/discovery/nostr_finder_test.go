@@ -0,0 +1,51 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const testPoolPub = "pool"
+
+func announcement(t *testing.T, createdAt time.Time) *nostr.Event {
+	t.Helper()
+	ev := &nostr.Event{
+		CreatedAt: nostr.Timestamp(createdAt.Unix()),
+		Kind:      NostrNodeAnnouncementKind,
+		Tags:      nostr.Tags{{"pool", testPoolPub}},
+		Content:   `{"api_url": "https://node.example.com"}`,
+	}
+	if err := ev.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestNostrFinderLastSeen(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		pruneAt   time.Time
+		wantKept  bool
+	}{
+		{name: "fresh", createdAt: now, pruneAt: now.Add(nostrNodeStaleAfter - time.Minute), wantKept: true},
+		{name: "replayed", createdAt: now.Add(-nostrNodeStaleAfter - time.Minute), pruneAt: now.Add(time.Second)},
+		{name: "clock ahead", createdAt: now.Add(2 * nostrNodeStaleAfter), pruneAt: now.Add(nostrNodeStaleAfter + time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewNostrOnlyFinder(nil, testPoolPub).(*nostrFinder)
+			f.updateFromEvent(announcement(t, tt.createdAt))
+			if len(f.nodes) != 1 {
+				t.Fatalf("tracking %d nodes, want 1", len(f.nodes))
+			}
+			f.pruneStale(tt.pruneAt)
+			if kept := len(f.nodes) == 1; kept != tt.wantKept {
+				t.Fatalf("node kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
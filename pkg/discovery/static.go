@@ -6,10 +6,11 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 )
 
-// staticNodes is a simple, in-memory registry.
-// You can later replace this with Nostr-based discovery or a pool API.
+// staticNodes is the built-in default registry, used when no nodes file
+// (see StaticNodesFilePath) is present.
 var staticNodes = []NodeInfo{
 	{
 		ID:       "vps-us-east-1",
@@ -31,10 +32,17 @@ var staticNodes = []NodeInfo{
 	},
 }
 
-// staticFinder implements Finder using staticNodes + runtime health data.
-type staticFinder struct{}
+// StaticFinder implements Finder (and Watcher) using an in-memory node
+// list plus runtime health data. The list starts out as staticNodes and
+// can be swapped at runtime with Reload.
+type StaticFinder struct {
+	mu    sync.RWMutex
+	nodes []NodeInfo
 
-func (staticFinder) FindNode(
+	events broadcaster
+}
+
+func (f *StaticFinder) FindNode(
 	ctx context.Context,
 	poolPubKey string,
 	preferredRegion string,
@@ -42,19 +50,52 @@ func (staticFinder) FindNode(
 ) (*NodeInfo, error) {
 	_ = ctx
 	_ = poolPubKey
-	return findNodeStatic(preferredRegion, backend)
+	return findNodeFromList(f.snapshot(), preferredRegion, backend)
 }
 
-func (staticFinder) ListNodes(ctx context.Context) ([]NodeInfo, error) {
+func (f *StaticFinder) ListNodes(ctx context.Context) ([]NodeInfo, error) {
 	_ = ctx
-	out := make([]NodeInfo, len(staticNodes))
-	copy(out, staticNodes)
-	return out, nil
+	return f.snapshot(), nil
+}
+
+// Reload replaces the node list and emits added/updated/removed events
+// to any watchers.
+func (f *StaticFinder) Reload(nodes []NodeInfo) {
+	next := make([]NodeInfo, len(nodes))
+	copy(next, nodes)
+
+	f.mu.Lock()
+	prev := f.nodes
+	f.nodes = next
+	f.mu.Unlock()
+
+	for _, ev := range diffNodes(prev, next) {
+		f.events.publish(ev)
+	}
 }
 
-// internal helper with the selection logic
-func findNodeStatic(preferredRegion, backend string) (*NodeInfo, error) {
-	return findNodeFromList(staticNodes, preferredRegion, backend)
+// Watch implements Watcher.
+func (f *StaticFinder) Watch(ctx context.Context) (<-chan NodeEvent, error) {
+	return watchWithHealth(ctx, &f.events, f.lookup), nil
+}
+
+func (f *StaticFinder) snapshot() []NodeInfo {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make([]NodeInfo, len(f.nodes))
+	copy(out, f.nodes)
+	return out
+}
+
+func (f *StaticFinder) lookup(id string) (NodeInfo, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, n := range f.nodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return NodeInfo{}, false
 }
 
 // findNodeFromList selects a node from an arbitrary list using the same rules
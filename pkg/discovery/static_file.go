@@ -0,0 +1,329 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v3"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+)
+
+// defaultNodesFileName lives in ~/.meerkatvpn unless MEERKAT_NODES_FILE
+// points somewhere else.
+const defaultNodesFileName = "nodes.json"
+
+// staticFileReloadInterval is how often WatchFile checks the file's mtime.
+const staticFileReloadInterval = 5 * time.Second
+
+// StaticNodesFile is the on-disk format of the static node list, JSON
+// or, for files ending in .yaml or .yml, the same structure in YAML:
+//
+//	{
+//	  "nodes": [
+//	    {
+//	      "id": "vps-us-east-1",
+//	      "api_url": "http://46.62.204.11:9090",
+//	      "region": "us-east-1",
+//	      "country": "US",
+//	      "city": "nyc",
+//	      "backends": ["openvpn", "wireguard"],
+//	      "pubkey": "npub1...",
+//	      "wg_pubkey": "base64 WireGuard server key",
+//	      "endpoints": {"wireguard": "46.62.204.11:51820"}
+//	    }
+//	  ]
+//	}
+type StaticNodesFile struct {
+	Nodes []StaticNodeEntry `json:"nodes" yaml:"nodes"`
+}
+
+// StaticNodeEntry is one node in a StaticNodesFile.
+type StaticNodeEntry struct {
+	ID        string            `json:"id" yaml:"id"`
+	APIURL    string            `json:"api_url" yaml:"api_url"`
+	Region    string            `json:"region" yaml:"region"`
+	Country   string            `json:"country,omitempty" yaml:"country,omitempty"`
+	City      string            `json:"city,omitempty" yaml:"city,omitempty"`
+	Backends  []string          `json:"backends,omitempty" yaml:"backends,omitempty"`
+	Enabled   *bool             `json:"enabled,omitempty" yaml:"enabled,omitempty"`     // default true
+	PubKey    string            `json:"pubkey,omitempty" yaml:"pubkey,omitempty"`       // pinned node Nostr pubkey (hex or npub)
+	WGPubKey  string            `json:"wg_pubkey,omitempty" yaml:"wg_pubkey,omitempty"` // pinned WireGuard server key
+	Endpoints map[string]string `json:"endpoints,omitempty" yaml:"endpoints,omitempty"` // backend -> host:port
+}
+
+// StaticNodesFilePath returns MEERKAT_NODES_FILE, or
+// ~/.meerkatvpn/nodes.json if it is unset.
+func StaticNodesFilePath() (string, error) {
+	if p := os.Getenv("MEERKAT_NODES_FILE"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".meerkatvpn", defaultNodesFileName), nil
+}
+
+// LoadStaticNodesFile reads and validates a node list file.
+func LoadStaticNodesFile(path string) ([]NodeInfo, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := decodeStaticNodesFile(path, b)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := validateStaticEntries(f.Nodes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return nodes, nil
+}
+
+// UpdateStaticNodesFile applies update to the entries of the node list
+// file at path (missing: empty) and writes the result back, in the same
+// format, if it is valid. It returns the new node list.
+func UpdateStaticNodesFile(path string, update func([]StaticNodeEntry) ([]StaticNodeEntry, error)) ([]NodeInfo, error) {
+	var f StaticNodesFile
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if f, err = decodeStaticNodesFile(path, b); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := update(f.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := validateStaticEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if isYAMLPath(path) {
+		out, err = yaml.Marshal(StaticNodesFile{Nodes: entries})
+	} else {
+		out, err = json.MarshalIndent(StaticNodesFile{Nodes: entries}, "", "  ")
+	}
+	if err != nil {
+		return nil, err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+func isYAMLPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// decodeStaticNodesFile parses a node list file, rejecting unknown
+// fields. An empty YAML file is an empty list.
+func decodeStaticNodesFile(path string, b []byte) (StaticNodesFile, error) {
+	var f StaticNodesFile
+	if isYAMLPath(path) {
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+			return f, fmt.Errorf("parse %s: %w", path, err)
+		}
+		return f, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return f, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// validateStaticEntries converts entries to NodeInfos, reporting every
+// invalid or duplicate entry.
+func validateStaticEntries(entries []StaticNodeEntry) ([]NodeInfo, error) {
+	nodes := make([]NodeInfo, 0, len(entries))
+	seen := map[string]bool{}
+	var errs []error
+
+	for i, e := range entries {
+		n, err := e.toNodeInfo()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %d (%q): %w", i, e.ID, err))
+			continue
+		}
+		if seen[n.ID] {
+			errs = append(errs, fmt.Errorf("node %d: duplicate id %q", i, n.ID))
+			continue
+		}
+		seen[n.ID] = true
+		nodes = append(nodes, n)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nodes, nil
+}
+
+func (e StaticNodeEntry) toNodeInfo() (NodeInfo, error) {
+	n := NodeInfo{
+		ID:       strings.TrimSpace(e.ID),
+		APIURL:   strings.TrimRight(strings.TrimSpace(e.APIURL), "/"),
+		Region:   strings.TrimSpace(e.Region),
+		Country:  strings.TrimSpace(e.Country),
+		City:     strings.TrimSpace(e.City),
+		Healthy:  e.Enabled == nil || *e.Enabled,
+		WGPubKey: strings.TrimSpace(e.WGPubKey),
+	}
+
+	if n.ID == "" {
+		return n, errors.New("id is required")
+	}
+
+	u, err := url.Parse(n.APIURL)
+	if err != nil {
+		return n, fmt.Errorf("api_url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return n, fmt.Errorf("api_url %q must be an absolute http(s) URL", e.APIURL)
+	}
+
+	for _, b := range e.Backends {
+		b = strings.ToLower(strings.TrimSpace(b))
+		if b != "openvpn" && b != "wireguard" {
+			return n, fmt.Errorf("unknown backend %q", b)
+		}
+		n.Backends = append(n.Backends, b)
+	}
+	if len(n.Backends) == 0 {
+		n.Backends = []string{"openvpn"}
+	}
+
+	if e.PubKey != "" {
+		pub, err := nostrutil.ParsePubKey(e.PubKey)
+		if err != nil {
+			return n, fmt.Errorf("pubkey: %w", err)
+		}
+		if len(pub) != 64 {
+			return n, fmt.Errorf("pubkey: expected 32-byte key, got %d hex chars", len(pub))
+		}
+		n.PubKey = strings.ToLower(pub)
+	}
+
+	if n.WGPubKey != "" {
+		if _, err := wgtypes.ParseKey(n.WGPubKey); err != nil {
+			return n, fmt.Errorf("wg_pubkey: %w", err)
+		}
+	}
+
+	if len(e.Endpoints) > 0 {
+		n.Endpoints = map[string]string{}
+		for backend, ep := range e.Endpoints {
+			backend = strings.ToLower(strings.TrimSpace(backend))
+			if !supportsBackend(n, backend) {
+				return n, fmt.Errorf("endpoint for backend %q which the node does not list", backend)
+			}
+			if _, _, err := net.SplitHostPort(ep); err != nil {
+				return n, fmt.Errorf("endpoint %q for %s: %w", ep, backend, err)
+			}
+			n.Endpoints[backend] = ep
+		}
+	}
+
+	return n, nil
+}
+
+func supportsBackend(n NodeInfo, backend string) bool {
+	for _, b := range n.Backends {
+		if strings.EqualFold(b, backend) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewStaticFinderFromFile loads path into a new StaticFinder.
+func NewStaticFinderFromFile(path string) (*StaticFinder, error) {
+	nodes, err := LoadStaticNodesFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticFinderWithNodes(nodes), nil
+}
+
+// WatchFile reloads the finder from path whenever the file's mtime
+// changes or the process receives SIGHUP, until ctx is cancelled. An
+// invalid file is logged and the previous node list is kept.
+func (f *StaticFinder) WatchFile(ctx context.Context, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var lastMod time.Time
+	if st, err := os.Stat(path); err == nil {
+		lastMod = st.ModTime()
+	}
+
+	go func() {
+		defer signal.Stop(hup)
+
+		ticker := time.NewTicker(staticFileReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-hup:
+				log.Printf("[discovery/static] SIGHUP: reloading %s\n", path)
+				f.reloadFile(path)
+
+			case <-ticker.C:
+				st, err := os.Stat(path)
+				if err != nil || st.ModTime().Equal(lastMod) {
+					continue
+				}
+				lastMod = st.ModTime()
+				log.Printf("[discovery/static] %s changed; reloading\n", path)
+				f.reloadFile(path)
+			}
+		}
+	}()
+}
+
+func (f *StaticFinder) reloadFile(path string) {
+	nodes, err := LoadStaticNodesFile(path)
+	if err != nil {
+		log.Printf("[discovery/static] reload failed, keeping previous nodes: %v\n", err)
+		return
+	}
+	f.Reload(nodes)
+	log.Printf("[discovery/static] loaded %d nodes from %s\n", len(nodes), path)
+}
@@ -0,0 +1,101 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testWGKey is a valid WireGuard public key.
+const testWGKey = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+
+func writeNodesFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadStaticNodesFileRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodes   string
+		wantErr string
+	}{
+		{name: "missing id", nodes: `{"api_url": "http://a"}`, wantErr: "id is required"},
+		{name: "relative url", nodes: `{"id": "a", "api_url": "a.example.com"}`, wantErr: "absolute http(s) URL"},
+		{name: "unknown backend", nodes: `{"id": "a", "api_url": "http://a", "backends": ["ipsec"]}`, wantErr: "unknown backend"},
+		{name: "bad pubkey", nodes: `{"id": "a", "api_url": "http://a", "pubkey": "npub1nope"}`, wantErr: "pubkey"},
+		{name: "bad wg key", nodes: `{"id": "a", "api_url": "http://a", "wg_pubkey": "nope"}`, wantErr: "wg_pubkey"},
+		{name: "endpoint for unlisted backend", nodes: `{"id": "a", "api_url": "http://a",
+			"endpoints": {"wireguard": "1.2.3.4:51820"}}`, wantErr: "does not list"},
+		{name: "endpoint without port", nodes: `{"id": "a", "api_url": "http://a", "backends": ["wireguard"],
+			"endpoints": {"wireguard": "1.2.3.4"}}`, wantErr: "endpoint"},
+		{name: "duplicate id", nodes: `{"id": "a", "api_url": "http://a"}, {"id": "a", "api_url": "http://b"}`,
+			wantErr: "duplicate id"},
+		{name: "unknown field", nodes: `{"id": "a", "api_url": "http://a", "wireguard_key": "x"}`, wantErr: "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "nodes.json")
+			writeNodesFile(t, path, `{"nodes": [`+tt.nodes+`]}`)
+			_, err := LoadStaticNodesFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("LoadStaticNodesFile() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadStaticNodesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.yaml")
+	writeNodesFile(t, path, `nodes:
+  - id: vps-1
+    api_url: "https://vps-1.example.com/"
+    backends: [WireGuard]
+    wg_pubkey: "`+testWGKey+`"
+    endpoints: {wireguard: "203.0.113.1:51820"}
+  - id: vps-2
+    api_url: "http://vps-2.example.com"
+    enabled: false
+`)
+	nodes, err := LoadStaticNodesFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("got %d nodes, want 2", len(nodes))
+	}
+	if n := nodes[0]; n.APIURL != "https://vps-1.example.com" || n.Backends[0] != "wireguard" ||
+		n.WGPubKey != testWGKey || n.Endpoints["wireguard"] != "203.0.113.1:51820" || !n.Healthy {
+		t.Errorf("vps-1 = %+v", n)
+	}
+	if n := nodes[1]; n.Healthy || len(n.Backends) != 1 || n.Backends[0] != "openvpn" {
+		t.Errorf("vps-2 = %+v, want disabled with the default backend", n)
+	}
+}
+
+func TestStaticFinderReloadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.json")
+	writeNodesFile(t, path, `{"nodes": [{"id": "a", "api_url": "http://a"}]}`)
+	f, err := NewStaticFinderFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+	ch, _ := f.Watch(ctx)
+
+	writeNodesFile(t, path, `{"nodes": [{"id": "a", "api_url": "http://a2"}, {"id": "b", "api_url": "http://b"}]}`)
+	f.reloadFile(path)
+	expectEvent(t, ch, NodeUpdated, "a")
+	expectEvent(t, ch, NodeAdded, "b")
+
+	// An invalid file keeps the previous list.
+	writeNodesFile(t, path, `{"nodes": [{"id": "a"}]}`)
+	f.reloadFile(path)
+	nodes, _ := f.ListNodes(ctx)
+	if len(nodes) != 2 || nodes[0].APIURL != "http://a2" {
+		t.Fatalf("nodes after invalid reload = %+v, want the previous list", nodes)
+	}
+}
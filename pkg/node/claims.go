@@ -0,0 +1,189 @@
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+)
+
+// SessionClaimKind is the addressable Nostr kind nodes publish session
+// claims with. The d tag is a random per-session claim ID, "k" carries
+// the claim key and "expiration" (NIP-40) bounds the claim's life.
+const SessionClaimKind = 38384
+
+// claimEpoch is how often claim keys rotate, so claims can't be linked
+// to one subscription over long periods by outside observers.
+const claimEpoch = time.Hour
+
+// ClaimKey returns the claim key of subscription subID at time t: a
+// hash of the subscription ID and the current epoch. Claims are keyed on
+// the subscription so all tokens of a renewal chain share one device
+// limit; only parties that know the subscription ID can tell which
+// subscription a claim is for.
+func ClaimKey(subID string, t time.Time) string {
+	epoch := t.Unix() / int64(claimEpoch/time.Second)
+	h := sha256.Sum256([]byte("meerkat-session-claim:" + subID + ":" + strconv.FormatInt(epoch, 10)))
+	return hex.EncodeToString(h[:])
+}
+
+// NewClaimID returns a random claim ID for a new session.
+func NewClaimID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Claims shares session claims between nodes so a subscription's
+// sessions on other nodes count against its device limit.
+type Claims interface {
+	// Claim publishes or refreshes the claim of a live session of
+	// subscription subID.
+	Claim(ctx context.Context, claimID, subID string) error
+	// Release withdraws a claim when its session ends.
+	Release(ctx context.Context, claimID, subID string)
+	// Others returns the number of live claims for subscription subID
+	// published by other nodes.
+	Others(subID string, now time.Time) int
+}
+
+type claimEntry struct {
+	key       string
+	createdAt nostr.Timestamp
+	expiresAt time.Time
+}
+
+// NostrClaims exchanges session claims over Nostr relays, scoped to one
+// pool with a "pool" tag. Anyone can publish a claim, so only claims
+// signed by approved nodes count.
+type NostrClaims struct {
+	nc       *nostrutil.Client
+	poolPub  string
+	ttl      time.Duration
+	approved func(pubHex string) bool
+
+	mu    sync.Mutex
+	cache map[string]claimEntry // author + "/" + claim ID -> newest claim
+}
+
+// NewNostrClaims returns NostrClaims publishing with nc. Claims expire
+// ttl after their last refresh unless refreshed again. Claims of other
+// nodes are ignored unless approved reports their key as one of the
+// pool's nodes.
+func NewNostrClaims(nc *nostrutil.Client, poolPub string, ttl time.Duration, approved func(pubHex string) bool) *NostrClaims {
+	return &NostrClaims{nc: nc, poolPub: poolPub, ttl: ttl, approved: approved, cache: map[string]claimEntry{}}
+}
+
+// Run follows the pool's claims on every relay until ctx is cancelled.
+func (c *NostrClaims) Run(ctx context.Context) {
+	since := nostr.Timestamp(time.Now().Add(-c.ttl).Unix())
+	c.nc.Relays.Subscribe(ctx, nostr.Filter{
+		Kinds: []int{SessionClaimKind},
+		Tags:  nostr.TagMap{"pool": []string{c.poolPub}},
+		Since: &since,
+	}, func(_ context.Context, ev *nostr.Event) { c.observe(ev) })
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.ttl):
+			c.prune(time.Now())
+		}
+	}
+}
+
+func (c *NostrClaims) observe(ev *nostr.Event) {
+	if ev.Kind != SessionClaimKind || ev.PubKey == c.nc.PubKey {
+		return
+	}
+	d, key := tagValue(ev.Tags, "d"), tagValue(ev.Tags, "k")
+	exp, err := strconv.ParseInt(tagValue(ev.Tags, "expiration"), 10, 64)
+	if d == "" || key == "" || err != nil || tagValue(ev.Tags, "pool") != c.poolPub {
+		return
+	}
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return
+	}
+	if !c.approved(ev.PubKey) {
+		return
+	}
+
+	id := ev.PubKey + "/" + d
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev, ok := c.cache[id]; ok && prev.createdAt > ev.CreatedAt {
+		return
+	}
+	c.cache[id] = claimEntry{key: key, createdAt: ev.CreatedAt, expiresAt: time.Unix(exp, 0)}
+}
+
+func (c *NostrClaims) prune(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, e := range c.cache {
+		if !now.Before(e.expiresAt) {
+			delete(c.cache, id)
+		}
+	}
+}
+
+func (c *NostrClaims) Others(subID string, now time.Time) int {
+	// A claim refreshed before the key rotated still carries the
+	// previous epoch's key.
+	cur, prev := ClaimKey(subID, now), ClaimKey(subID, now.Add(-claimEpoch))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, e := range c.cache {
+		if (e.key == cur || e.key == prev) && now.Before(e.expiresAt) {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *NostrClaims) Claim(ctx context.Context, claimID, subID string) error {
+	return c.publish(ctx, claimID, subID, time.Now().Add(c.ttl))
+}
+
+func (c *NostrClaims) Release(ctx context.Context, claimID, subID string) {
+	// Replace the claim with one that has already expired.
+	if err := c.publish(ctx, claimID, subID, time.Now()); err != nil {
+		log.Printf("[claims] release %s: %v\n", claimID, err)
+	}
+}
+
+func (c *NostrClaims) publish(ctx context.Context, claimID, subID string, expires time.Time) error {
+	ev := nostr.Event{
+		PubKey:    c.nc.PubKey,
+		CreatedAt: nostr.Now(),
+		Kind:      SessionClaimKind,
+		Tags: nostr.Tags{
+			{"d", claimID},
+			{"k", ClaimKey(subID, time.Now())},
+			{"pool", c.poolPub},
+			{"expiration", strconv.FormatInt(expires.Unix(), 10)},
+		},
+	}
+	if err := c.nc.Sign(&ev); err != nil {
+		return err
+	}
+	return c.nc.Publish(ctx, ev)
+}
+
+func tagValue(tags nostr.Tags, name string) string {
+	if tag := tags.GetFirst([]string{name}); tag != nil && len(*tag) > 1 {
+		return (*tag)[1]
+	}
+	return ""
+}
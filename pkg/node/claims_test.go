@@ -0,0 +1,136 @@
+package node
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+)
+
+type testNode struct{ priv, pub string }
+
+func newTestNode(t *testing.T) testNode {
+	t.Helper()
+	priv := nostr.GeneratePrivateKey()
+	pub, err := nostr.GetPublicKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testNode{priv, pub}
+}
+
+// claim returns n's signed claim claimID for subID, expiring at expires.
+func (n testNode) claim(t *testing.T, poolPub, claimID, subID string, at, expires time.Time) *nostr.Event {
+	t.Helper()
+	ev := &nostr.Event{
+		CreatedAt: nostr.Timestamp(at.Unix()),
+		Kind:      SessionClaimKind,
+		Tags: nostr.Tags{
+			{"d", claimID},
+			{"k", ClaimKey(subID, at)},
+			{"pool", poolPub},
+			{"expiration", strconv.FormatInt(expires.Unix(), 10)},
+		},
+	}
+	if err := ev.Sign(n.priv); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+// testClaims returns claims of the node self for pool, counting claims
+// of peers only.
+func testClaims(self testNode, pool string, peers ...testNode) *NostrClaims {
+	approved := map[string]bool{}
+	for _, p := range peers {
+		approved[p.pub] = true
+	}
+	nc := &nostrutil.Client{PubKey: self.pub}
+	return NewNostrClaims(nc, pool, 5*time.Minute, func(pub string) bool { return approved[pub] })
+}
+
+func TestNostrClaimsObserve(t *testing.T) {
+	self, peer, stranger := newTestNode(t), newTestNode(t), newTestNode(t)
+	pool := newTestNode(t).pub
+	now := time.Now()
+	exp := now.Add(5 * time.Minute)
+
+	tampered := peer.claim(t, pool, "c-tampered", "sub", now, exp)
+	tampered.Tags[1][1] = ClaimKey("other", now)
+	noExpiry := peer.claim(t, pool, "c-noexp", "sub", now, exp)
+	noExpiry.Tags = noExpiry.Tags[:3]
+	_ = noExpiry.Sign(peer.priv)
+
+	for name, tc := range map[string]struct {
+		ev   *nostr.Event
+		want int
+	}{
+		"approved peer":      {peer.claim(t, pool, "c1", "sub", now, exp), 1},
+		"unapproved key":     {stranger.claim(t, pool, "c1", "sub", now, exp), 0},
+		"own claim":          {self.claim(t, pool, "c1", "sub", now, exp), 0},
+		"other pool":         {peer.claim(t, newTestNode(t).pub, "c1", "sub", now, exp), 0},
+		"bad signature":      {tampered, 0},
+		"no expiration":      {noExpiry, 0},
+		"other subscription": {peer.claim(t, pool, "c1", "other", now, exp), 0},
+	} {
+		c := testClaims(self, pool, peer, self)
+		c.observe(tc.ev)
+		if got := c.Others("sub", now); got != tc.want {
+			t.Errorf("%s: Others = %d, want %d", name, got, tc.want)
+		}
+	}
+}
+
+func TestNostrClaimsCount(t *testing.T) {
+	self, a, b := newTestNode(t), newTestNode(t), newTestNode(t)
+	pool := newTestNode(t).pub
+	c := testClaims(self, pool, a, b)
+	now := time.Now()
+	exp := now.Add(2 * claimEpoch)
+
+	c.observe(a.claim(t, pool, "a1", "sub", now, exp))
+	c.observe(a.claim(t, pool, "a2", "sub", now, exp))
+	c.observe(b.claim(t, pool, "b1", "sub", now, exp))
+	c.observe(b.claim(t, pool, "b2", "other", now, exp))
+	if got := c.Others("sub", now); got != 3 {
+		t.Errorf("Others = %d, want 3", got)
+	}
+
+	// A claim made before the key rotated still counts in the next epoch.
+	if got := c.Others("sub", now.Add(claimEpoch)); got != 3 {
+		t.Errorf("Others after key rotation = %d, want 3", got)
+	}
+
+	// A release replaces the claim with an expired one; older events
+	// for the same claim don't bring it back.
+	c.observe(a.claim(t, pool, "a1", "sub", now.Add(time.Second), now))
+	c.observe(a.claim(t, pool, "a1", "sub", now.Add(-time.Second), exp))
+	if got := c.Others("sub", now); got != 2 {
+		t.Errorf("Others after release = %d, want 2", got)
+	}
+}
+
+func TestNostrClaimsExpiry(t *testing.T) {
+	self, peer := newTestNode(t), newTestNode(t)
+	pool := newTestNode(t).pub
+	c := testClaims(self, pool, peer)
+	now := time.Now()
+
+	c.observe(peer.claim(t, pool, "short", "sub", now, now.Add(time.Minute)))
+	c.observe(peer.claim(t, pool, "long", "sub", now, now.Add(10*time.Minute)))
+	if got := c.Others("sub", now.Add(2*time.Minute)); got != 1 {
+		t.Errorf("Others after the first claim expired = %d, want 1", got)
+	}
+
+	c.prune(now.Add(2 * time.Minute))
+	if n := len(c.cache); n != 1 {
+		t.Errorf("%d claims cached after prune, want 1", n)
+	}
+	c.prune(now.Add(10 * time.Minute))
+	if n := len(c.cache); n != 0 {
+		t.Errorf("%d claims cached after all expired, want 0", n)
+	}
+}
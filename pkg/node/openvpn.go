@@ -0,0 +1,151 @@
+package node
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenVPNClient is a client connected to the OpenVPN server, from its
+// status file.
+type OpenVPNClient struct {
+	RealIP    string    // the client's public address
+	VirtualIP string    // its tunnel address; "" if not (yet) assigned
+	Bytes     int64     // received + sent since it connected
+	Since     time.Time // when it connected; zero if unknown
+	Conn      string    // identifies the connection: real address and connect time
+}
+
+// ReadOpenVPNStatus reads the OpenVPN server's status file (any
+// status-version) and returns its connected clients, newest first.
+func ReadOpenVPNStatus(path string) ([]OpenVPNClient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseOpenVPNStatus(f)
+}
+
+// v2ClientColumns are the CLIENT_LIST columns of OpenVPN 2.4+, for
+// status files without a HEADER line.
+var v2ClientColumns = []string{"Common Name", "Real Address", "Virtual Address", "Virtual IPv6 Address",
+	"Bytes Received", "Bytes Sent", "Connected Since", "Connected Since (time_t)"}
+
+func parseOpenVPNStatus(r io.Reader) ([]OpenVPNClient, error) {
+	clients := []OpenVPNClient{}
+	var v1Cols, v2Cols map[string]int
+	v1Virtual := map[string]string{} // real address -> virtual address
+	section := ""
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		sep := ","
+		if strings.Contains(line, "\t") {
+			sep = "\t"
+		}
+		fields := strings.Split(line, sep)
+		switch {
+		// Versions 2 and 3: HEADER,CLIENT_LIST,<columns> names the
+		// columns of the CLIENT_LIST,<values> rows.
+		case fields[0] == "HEADER" && len(fields) > 1 && fields[1] == "CLIENT_LIST":
+			v2Cols = columns(fields[2:])
+		case fields[0] == "CLIENT_LIST":
+			if v2Cols == nil {
+				v2Cols = columns(v2ClientColumns)
+			}
+			clients = append(clients, clientFrom(fields[1:], v2Cols))
+
+		// Version 1: a client list and a routing table, each with a
+		// header line.
+		case strings.HasPrefix(line, "Common Name"+sep+"Real Address"):
+			v1Cols, section = columns(fields), "clients"
+		case strings.HasPrefix(line, "Virtual Address"+sep):
+			section = "routes"
+		case line == "ROUTING TABLE" || line == "GLOBAL STATS" || line == "END":
+			section = ""
+		case section == "clients":
+			clients = append(clients, clientFrom(fields, v1Cols))
+		case section == "routes" && len(fields) > 2:
+			// Clients may also have iroute subnets; keep the address.
+			if ip := net.ParseIP(fields[0]); ip != nil && v1Virtual[fields[2]] == "" {
+				v1Virtual[fields[2]] = fields[0]
+			}
+		}
+	}
+	for i := range clients {
+		if clients[i].VirtualIP == "" {
+			clients[i].VirtualIP = v1Virtual[realAddress(clients[i].Conn)]
+		}
+	}
+	sort.SliceStable(clients, func(i, j int) bool { return clients[i].Since.After(clients[j].Since) })
+	return clients, sc.Err()
+}
+
+func columns(names []string) map[string]int {
+	cols := make(map[string]int, len(names))
+	for i, name := range names {
+		cols[name] = i
+	}
+	return cols
+}
+
+func clientFrom(fields []string, cols map[string]int) OpenVPNClient {
+	get := func(name string) string {
+		if i, ok := cols[name]; ok && i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+	rx, _ := strconv.ParseInt(get("Bytes Received"), 10, 64)
+	tx, _ := strconv.ParseInt(get("Bytes Sent"), 10, 64)
+	since := get("Connected Since (time_t)")
+	if since == "" {
+		since = get("Connected Since")
+	}
+	addr := get("Real Address")
+	return OpenVPNClient{
+		RealIP:    realAddressIP(addr),
+		VirtualIP: get("Virtual Address"),
+		Bytes:     rx + tx,
+		Since:     parseStatusTime(since),
+		Conn:      addr + " " + since,
+	}
+}
+
+// parseStatusTime parses a status file time: a time_t, or a local date
+// as OpenVPN prints it (ctime before 2.6, ISO after).
+func parseStatusTime(s string) time.Time {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0)
+	}
+	for _, layout := range []string{time.ANSIC, "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// realAddress returns the real address part of a Conn.
+func realAddress(conn string) string {
+	addr, _, _ := strings.Cut(conn, " ")
+	return addr
+}
+
+// realAddressIP returns the IP of a status file's real address, which
+// may carry a protocol prefix ("udp4:") and a port.
+func realAddressIP(addr string) string {
+	if proto, rest, ok := strings.Cut(addr, ":"); ok && (strings.HasPrefix(proto, "udp") || strings.HasPrefix(proto, "tcp")) {
+		addr = rest
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
@@ -0,0 +1,109 @@
+package node
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOpenVPNStatus(t *testing.T) {
+	since := time.Date(2015, 6, 18, 4, 23, 3, 0, time.Local)
+	later := since.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		status string
+		want   []OpenVPNClient
+	}{
+		{
+			name: "version 1",
+			status: `OpenVPN CLIENT LIST
+Updated,Thu Jun 18 08:12:15 2015
+Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since
+alice,203.0.113.5:1194,100,200,Thu Jun 18 04:23:03 2015
+bob,198.51.100.7:5000,1,2,Thu Jun 18 05:23:03 2015
+ROUTING TABLE
+Virtual Address,Common Name,Real Address,Last Ref
+192.168.5.0/24,alice,203.0.113.5:1194,Thu Jun 18 08:12:09 2015
+10.8.0.6,alice,203.0.113.5:1194,Thu Jun 18 08:12:09 2015
+10.8.0.10,bob,198.51.100.7:5000,Thu Jun 18 08:12:09 2015
+GLOBAL STATS
+Max bcast/mcast queue length,0
+END
+`,
+			want: []OpenVPNClient{
+				{RealIP: "198.51.100.7", VirtualIP: "10.8.0.10", Bytes: 3, Since: later,
+					Conn: "198.51.100.7:5000 Thu Jun 18 05:23:03 2015"},
+				{RealIP: "203.0.113.5", VirtualIP: "10.8.0.6", Bytes: 300, Since: since,
+					Conn: "203.0.113.5:1194 Thu Jun 18 04:23:03 2015"},
+			},
+		},
+		{
+			name: "version 2",
+			status: `TITLE,OpenVPN 2.5.1 x86_64-pc-linux-gnu
+TIME,Thu Jun 18 08:12:15 2015,1434615135
+HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Virtual IPv6 Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t),Username,Client ID,Peer ID,Data Channel Cipher
+CLIENT_LIST,alice,udp4:203.0.113.5:1194,10.8.0.6,,100,200,Thu Jun 18 04:23:03 2015,` + unix(since) + `,UNDEF,0,0,AES-256-GCM
+HEADER,ROUTING_TABLE,Virtual Address,Common Name,Real Address,Last Ref,Last Ref (time_t)
+ROUTING_TABLE,10.8.0.6,alice,udp4:203.0.113.5:1194,Thu Jun 18 08:12:09 2015,1434615129
+GLOBAL_STATS,Max bcast/mcast queue length,0
+END
+`,
+			want: []OpenVPNClient{
+				{RealIP: "203.0.113.5", VirtualIP: "10.8.0.6", Bytes: 300, Since: since,
+					Conn: "udp4:203.0.113.5:1194 " + unix(since)},
+			},
+		},
+		{
+			name: "version 2 before 2.4, no IPv6 column",
+			status: `HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t),Username
+CLIENT_LIST,alice,203.0.113.5:1194,10.8.0.6,100,200,Thu Jun 18 04:23:03 2015,` + unix(since) + `,UNDEF
+`,
+			want: []OpenVPNClient{
+				{RealIP: "203.0.113.5", VirtualIP: "10.8.0.6", Bytes: 300, Since: since,
+					Conn: "203.0.113.5:1194 " + unix(since)},
+			},
+		},
+		{
+			name: "version 3",
+			status: "HEADER\tCLIENT_LIST\tCommon Name\tReal Address\tVirtual Address\tVirtual IPv6 Address\tBytes Received\tBytes Sent\tConnected Since\tConnected Since (time_t)\n" +
+				"CLIENT_LIST\talice\t[2001:db8::1]:1194\t10.8.0.6\t\t100\t200\t2015-06-18 04:23:03\t" + unix(since) + "\n",
+			want: []OpenVPNClient{
+				{RealIP: "2001:db8::1", VirtualIP: "10.8.0.6", Bytes: 300, Since: since,
+					Conn: "[2001:db8::1]:1194 " + unix(since)},
+			},
+		},
+		{
+			name:   "no clients",
+			status: "HEADER,CLIENT_LIST,Common Name,Real Address\nEND\n",
+			want:   []OpenVPNClient{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOpenVPNStatus(strings.NewReader(tt.status))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStatusTime(t *testing.T) {
+	want := time.Date(2015, 6, 18, 4, 23, 3, 0, time.Local)
+	for _, s := range []string{unix(want), "Thu Jun 18 04:23:03 2015", "2015-06-18 04:23:03"} {
+		if got := parseStatusTime(s); !got.Equal(want) {
+			t.Errorf("parseStatusTime(%q) = %v, want %v", s, got, want)
+		}
+	}
+	if got := parseStatusTime("soon"); !got.IsZero() {
+		t.Errorf("parseStatusTime(%q) = %v, want zero", "soon", got)
+	}
+}
+
+func unix(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) }
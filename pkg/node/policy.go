@@ -0,0 +1,200 @@
+// Package node holds noded's session policy: which subscription tiers
+// may use this node, with what limits, and how those limits are applied.
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// DefaultTier is assumed for tokens that carry no tier.
+const DefaultTier = "full"
+
+// Error codes returned by POST /session/create in the "code" field.
+const (
+	CodeBadRequest        = "bad_request"
+	CodeIssuerNotAllowed  = "issuer_not_allowed"
+	CodeInvalidToken      = "invalid_token"
+	CodeTokenRevoked      = "token_revoked"
+	CodeTierNotAllowed    = "tier_not_allowed"
+	CodeBackendNotAllowed = "backend_not_allowed"
+	CodeRegionNotAllowed  = "region_not_allowed"
+	CodeMaxSessions       = "max_sessions"
+	CodeDeviceLimit       = "device_limit"
+	CodeDataCapExceeded   = "data_cap_exceeded"
+	CodeNodeBusy          = "node_busy"
+	CodeInternal          = "internal_error"
+)
+
+// Error is a policy violation, reported to the client as a code and a
+// human-readable message.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Code + ": " + e.Message }
+
+// SpeedClass is a bandwidth limit; zero means unlimited.
+type SpeedClass struct {
+	DownMbit int `json:"down_mbit,omitempty"` // node -> client
+	UpMbit   int `json:"up_mbit,omitempty"`   // client -> node
+}
+
+// Unlimited reports whether the class limits nothing.
+func (c SpeedClass) Unlimited() bool { return c.DownMbit <= 0 && c.UpMbit <= 0 }
+
+// TierPolicy is what a subscription tier may do on this node. Empty
+// lists and zero limits mean no restriction.
+type TierPolicy struct {
+	Backends    []string `json:"backends,omitempty"`
+	Regions     []string `json:"regions,omitempty"`      // node regions the tier may use
+	MaxSessions int      `json:"max_sessions,omitempty"` // on this node
+	DeviceLimit int      `json:"device_limit,omitempty"` // across all of the pool's nodes
+	SpeedClass  string   `json:"speed_class,omitempty"`
+	DataCapGB   float64  `json:"data_cap_gb,omitempty"`
+
+	// MaxLoad is the share of the node's capacity in use (0-1) above
+	// which the tier gets no new sessions, so low-priority tiers such as
+	// free trials leave room for paying ones.
+	MaxLoad float64 `json:"max_load,omitempty"`
+}
+
+// DataCapBytes returns the data cap in bytes (0: unlimited).
+func (t TierPolicy) DataCapBytes() int64 {
+	return int64(t.DataCapGB * 1e9)
+}
+
+// Policy is a node's policy file. Tokens whose tier isn't listed in
+// Tiers are refused.
+type Policy struct {
+	Region       string                `json:"region,omitempty"`
+	Capacity     int                   `json:"capacity,omitempty"` // live sessions the node is sized for; MaxLoad is relative to it
+	SpeedClasses map[string]SpeedClass `json:"speed_classes,omitempty"`
+	Tiers        map[string]TierPolicy `json:"tiers"`
+}
+
+// LoadPolicy reads and validates a policy file.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(p.Tiers) == 0 {
+		return nil, fmt.Errorf("%s: policy allows no tiers", path)
+	}
+	for name, t := range p.Tiers {
+		if t.MaxLoad > 0 && p.Capacity <= 0 {
+			return nil, fmt.Errorf("%s: tier %q sets max_load but the policy has no capacity", path, name)
+		}
+		if t.SpeedClass == "" {
+			continue
+		}
+		if _, ok := p.SpeedClasses[t.SpeedClass]; !ok {
+			return nil, fmt.Errorf("%s: tier %q uses undefined speed class %q", path, name, t.SpeedClass)
+		}
+	}
+	return &p, nil
+}
+
+// Speed returns the speed class of a tier policy.
+func (p *Policy) Speed(t TierPolicy) SpeedClass {
+	return p.SpeedClasses[t.SpeedClass]
+}
+
+// Request describes a session being created, with the token's current
+// usage of this node.
+type Request struct {
+	Tier             string
+	Backend          string
+	ActiveSessions   int   // the subscription's other live sessions on this node
+	ClaimedElsewhere int   // live sessions other nodes have claimed for the subscription
+	UsedBytes        int64 // traffic the token has used so far
+	NodeSessions     int   // live sessions on this node, of all tokens
+
+	Plan vpn.PlanLimits // limits carried by the token
+}
+
+// Check returns the tier's policy if the session is allowed, or the
+// policy violation. The limits of the token's plan apply on top of the
+// tier's, and the returned policy has the stricter data cap. A nil
+// policy applies only the plan's limits.
+func (p *Policy) Check(r Request) (TierPolicy, *Error) {
+	if p == nil {
+		return checkPlan(TierPolicy{}, r, "")
+	}
+	tier := r.Tier
+	if tier == "" {
+		tier = DefaultTier
+	}
+	t, ok := p.Tiers[tier]
+	if !ok {
+		return t, &Error{CodeTierNotAllowed, fmt.Sprintf("this node doesn't serve the %q tier", tier)}
+	}
+	if len(t.Backends) > 0 && !containsFold(t.Backends, r.Backend) {
+		return t, &Error{CodeBackendNotAllowed, fmt.Sprintf("the %q tier can't use %s on this node (allowed: %s)",
+			tier, r.Backend, strings.Join(t.Backends, ", "))}
+	}
+	if len(t.Regions) > 0 && !containsFold(t.Regions, p.Region) {
+		return t, &Error{CodeRegionNotAllowed, fmt.Sprintf("the %q tier isn't available in region %q (allowed: %s)",
+			tier, p.Region, strings.Join(t.Regions, ", "))}
+	}
+	if t.MaxSessions > 0 && r.ActiveSessions >= t.MaxSessions {
+		return t, &Error{CodeMaxSessions, fmt.Sprintf("the %q tier allows %d concurrent session(s); disconnect another device first",
+			tier, t.MaxSessions)}
+	}
+	if t.DeviceLimit > 0 && r.ActiveSessions+r.ClaimedElsewhere >= t.DeviceLimit {
+		return t, &Error{CodeDeviceLimit, fmt.Sprintf("the %q tier allows %d device(s) at a time across the network; disconnect another device first",
+			tier, t.DeviceLimit)}
+	}
+	if t.MaxLoad > 0 && p.Capacity > 0 && float64(r.NodeSessions) >= t.MaxLoad*float64(p.Capacity) {
+		return t, &Error{CodeNodeBusy, fmt.Sprintf("this node is too busy for new %q sessions; try another node", tier)}
+	}
+	if limit := t.DataCapBytes(); limit > 0 && r.UsedBytes >= limit {
+		return t, &Error{CodeDataCapExceeded, fmt.Sprintf("the %q tier's %.1f GB data cap is used up", tier, t.DataCapGB)}
+	}
+	return checkPlan(t, r, p.Region)
+}
+
+// checkPlan applies the token's plan limits on a node in region.
+func checkPlan(t TierPolicy, r Request, region string) (TierPolicy, *Error) {
+	pl := r.Plan
+	if len(pl.Backends) > 0 && !containsFold(pl.Backends, r.Backend) {
+		return t, &Error{CodeBackendNotAllowed, fmt.Sprintf("your plan can't use %s (allowed: %s)",
+			r.Backend, strings.Join(pl.Backends, ", "))}
+	}
+	if len(pl.Regions) > 0 && !containsFold(pl.Regions, region) {
+		return t, &Error{CodeRegionNotAllowed, fmt.Sprintf("your plan isn't available in region %q (allowed: %s)",
+			region, strings.Join(pl.Regions, ", "))}
+	}
+	if pl.DeviceLimit > 0 && r.ActiveSessions+r.ClaimedElsewhere >= pl.DeviceLimit {
+		return t, &Error{CodeDeviceLimit, fmt.Sprintf("your plan allows %d device(s) at a time across the network; disconnect another device first",
+			pl.DeviceLimit)}
+	}
+	if pl.BandwidthCapGB > 0 {
+		if r.UsedBytes >= pl.BandwidthCapGB*1e9 {
+			return t, &Error{CodeDataCapExceeded, fmt.Sprintf("your plan's %d GB data cap is used up", pl.BandwidthCapGB)}
+		}
+		if t.DataCapGB <= 0 || float64(pl.BandwidthCapGB) < t.DataCapGB {
+			t.DataCapGB = float64(pl.BandwidthCapGB)
+		}
+	}
+	return t, nil
+}
+
+func containsFold(list []string, v string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+func testPolicy() *Policy {
+	return &Policy{
+		Region:       "eu",
+		Capacity:     10,
+		SpeedClasses: map[string]SpeedClass{"slow": {DownMbit: 5, UpMbit: 1}},
+		Tiers: map[string]TierPolicy{
+			"full": {},
+			"trial": {
+				Backends:    []string{"wireguard"},
+				Regions:     []string{"EU"},
+				MaxSessions: 1,
+				DeviceLimit: 2,
+				SpeedClass:  "slow",
+				DataCapGB:   1,
+				MaxLoad:     0.5,
+			},
+			"us-only": {Regions: []string{"us"}},
+		},
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *Policy
+		req      Request
+		wantCode string
+		wantCap  int64
+	}{
+		{name: "no tier means full", policy: testPolicy(), req: Request{Backend: "openvpn"}},
+		{name: "unknown tier", policy: testPolicy(), req: Request{Tier: "gold"}, wantCode: CodeTierNotAllowed},
+		{name: "backend", policy: testPolicy(), req: Request{Tier: "trial", Backend: "openvpn"}, wantCode: CodeBackendNotAllowed},
+		{name: "backend case-insensitive", policy: testPolicy(), req: Request{Tier: "trial", Backend: "WireGuard"}, wantCap: 1e9},
+		{name: "region", policy: testPolicy(), req: Request{Tier: "us-only"}, wantCode: CodeRegionNotAllowed},
+		{name: "max sessions", policy: testPolicy(),
+			req: Request{Tier: "trial", Backend: "wireguard", ActiveSessions: 1}, wantCode: CodeMaxSessions},
+		{name: "device limit across nodes", policy: testPolicy(),
+			req: Request{Tier: "trial", Backend: "wireguard", ClaimedElsewhere: 2}, wantCode: CodeDeviceLimit},
+		{name: "node busy", policy: testPolicy(),
+			req: Request{Tier: "trial", Backend: "wireguard", NodeSessions: 5}, wantCode: CodeNodeBusy},
+		{name: "below max load", policy: testPolicy(),
+			req: Request{Tier: "trial", Backend: "wireguard", NodeSessions: 4}, wantCap: 1e9},
+		{name: "tier data cap used up", policy: testPolicy(),
+			req: Request{Tier: "trial", Backend: "wireguard", UsedBytes: 1e9}, wantCode: CodeDataCapExceeded},
+		{name: "plan applies on top of the tier", policy: testPolicy(),
+			req: Request{Backend: "openvpn", Plan: vpn.PlanLimits{Backends: []string{"wireguard"}}}, wantCode: CodeBackendNotAllowed},
+		{name: "stricter plan cap", policy: testPolicy(),
+			req: Request{Tier: "trial", Backend: "wireguard", Plan: vpn.PlanLimits{BandwidthCapGB: 1}}, wantCap: 1e9},
+		{name: "nil policy applies the plan", policy: nil,
+			req: Request{ActiveSessions: 3, Plan: vpn.PlanLimits{DeviceLimit: 3}}, wantCode: CodeDeviceLimit},
+		{name: "nil policy without plan", policy: nil, req: Request{ActiveSessions: 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Check(tt.req)
+			if code := errCode(err); code != tt.wantCode {
+				t.Fatalf("Check() error = %v, want code %q", err, tt.wantCode)
+			}
+			if err == nil && got.DataCapBytes() != tt.wantCap {
+				t.Fatalf("Check() data cap = %d, want %d", got.DataCapBytes(), tt.wantCap)
+			}
+		})
+	}
+}
+
+func TestCheckPlan(t *testing.T) {
+	tests := []struct {
+		name     string
+		tier     TierPolicy
+		req      Request
+		region   string
+		wantCode string
+		wantCap  float64
+	}{
+		{name: "no limits"},
+		{name: "backend", req: Request{Backend: "openvpn", Plan: vpn.PlanLimits{Backends: []string{"wireguard"}}},
+			wantCode: CodeBackendNotAllowed},
+		{name: "region", region: "us", req: Request{Plan: vpn.PlanLimits{Regions: []string{"eu"}}},
+			wantCode: CodeRegionNotAllowed},
+		{name: "region matches", region: "EU", req: Request{Plan: vpn.PlanLimits{Regions: []string{"eu"}}}},
+		{name: "device limit", req: Request{ActiveSessions: 1, ClaimedElsewhere: 1, Plan: vpn.PlanLimits{DeviceLimit: 2}},
+			wantCode: CodeDeviceLimit},
+		{name: "under device limit", req: Request{ActiveSessions: 1, Plan: vpn.PlanLimits{DeviceLimit: 2}}},
+		{name: "cap used up", req: Request{UsedBytes: 2e9, Plan: vpn.PlanLimits{BandwidthCapGB: 2}},
+			wantCode: CodeDataCapExceeded},
+		{name: "plan cap without tier cap", req: Request{Plan: vpn.PlanLimits{BandwidthCapGB: 2}}, wantCap: 2},
+		{name: "plan cap below tier cap", tier: TierPolicy{DataCapGB: 5}, req: Request{Plan: vpn.PlanLimits{BandwidthCapGB: 2}},
+			wantCap: 2},
+		{name: "tier cap below plan cap", tier: TierPolicy{DataCapGB: 1}, req: Request{Plan: vpn.PlanLimits{BandwidthCapGB: 2}},
+			wantCap: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkPlan(tt.tier, tt.req, tt.region)
+			if code := errCode(err); code != tt.wantCode {
+				t.Fatalf("checkPlan() error = %v, want code %q", err, tt.wantCode)
+			}
+			if err == nil && got.DataCapGB != tt.wantCap {
+				t.Fatalf("checkPlan() data cap = %v GB, want %v GB", got.DataCapGB, tt.wantCap)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{name: "valid", json: `{"capacity": 10, "speed_classes": {"slow": {"down_mbit": 5}},
+			"tiers": {"full": {}, "trial": {"speed_class": "slow", "max_load": 0.5}}}`},
+		{name: "bad json", json: `{"tiers": `, wantErr: "parse"},
+		{name: "no tiers", json: `{"tiers": {}}`, wantErr: "allows no tiers"},
+		{name: "max load without capacity", json: `{"tiers": {"trial": {"max_load": 0.5}}}`, wantErr: "no capacity"},
+		{name: "undefined speed class", json: `{"tiers": {"trial": {"speed_class": "slow"}}}`, wantErr: "undefined speed class"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			p, err := LoadPolicy(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := p.Speed(p.Tiers["trial"]); got.DownMbit != 5 {
+					t.Fatalf("trial speed = %+v, want the slow class", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("LoadPolicy() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadPolicy(filepath.Join(t.TempDir(), "missing.json")); !os.IsNotExist(err) {
+		t.Fatalf("LoadPolicy(missing) error = %v, want not exist", err)
+	}
+}
+
+func errCode(err *Error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Code
+}
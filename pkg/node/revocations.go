@@ -0,0 +1,94 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// Revocations follows the pool's signed revocation list (GET
+// /revocations on the pool).
+type Revocations struct {
+	url     string
+	poolPub string
+	http    *http.Client
+
+	mu       sync.Mutex
+	revoked  map[string]bool // vpn.RevocationHash of token IDs
+	issuedAt int64
+}
+
+// NewRevocations returns Revocations fetching url, signed by poolPub.
+func NewRevocations(url, poolPub string) *Revocations {
+	return &Revocations{
+		url:     url,
+		poolPub: poolPub,
+		http:    &http.Client{Timeout: 15 * time.Second},
+		revoked: map[string]bool{},
+	}
+}
+
+// Revoked reports whether tokenID was on the last list fetched.
+func (r *Revocations) Revoked(tokenID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.revoked[vpn.RevocationHash(tokenID)]
+}
+
+// Run refreshes the list every interval until ctx is cancelled. A list
+// that fails to fetch or verify keeps the previous one in place.
+func (r *Revocations) Run(ctx context.Context, interval time.Duration) {
+	for {
+		if err := r.Refresh(ctx); err != nil {
+			log.Printf("[revocations] %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Refresh fetches and verifies the list.
+func (r *Revocations) Refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", r.url, resp.Status)
+	}
+
+	var list vpn.RevocationList
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(&list); err != nil {
+		return fmt.Errorf("decode %s: %w", r.url, err)
+	}
+	if err := vpn.VerifyRevocationList(list, r.poolPub); err != nil {
+		return err
+	}
+
+	revoked := make(map[string]bool, len(list.Payload.Revoked))
+	for _, h := range list.Payload.Revoked {
+		revoked[h] = true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if list.Payload.IssuedAt < r.issuedAt {
+		return fmt.Errorf("%s served a list older than the last one", r.url)
+	}
+	r.revoked, r.issuedAt = revoked, list.Payload.IssuedAt
+	return nil
+}
@@ -0,0 +1,270 @@
+package node
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Session is a session created through POST /session/create.
+type Session struct {
+	ID         string
+	TokenID    string
+	SubID      string // the token's subscription; see vpn.SubscriptionPayload.Subscription
+	UserPubKey string
+	Tier       string
+	Backend    string
+	RemoteIP   string
+	DeviceKey  string // the client's WireGuard public key, sent for either backend
+	WGPubKey   string // WireGuard sessions only
+	ClientIP   string // tunnel address; for OpenVPN, from the server's status
+	ClaimID    string // d tag of the session's claim; see Claims
+	CreatedAt  time.Time
+	ExpiresAt  time.Time  // when the token expires
+	DataCap    int64      // bytes; 0: unlimited
+	Speed      SpeedClass // bandwidth limit for ClientIP
+
+	lastActive time.Time
+	ovpnConn   string // OpenVPN connection; see OpenVPNClient.Conn
+}
+
+// PeerStat is a WireGuard peer's handshake and traffic counters.
+type PeerStat struct {
+	LastHandshake time.Time
+	Bytes         int64 // rx + tx since the peer was added
+}
+
+// AddressChange is an OpenVPN session whose tunnel address changed
+// from OldIP: its client connected, reconnected or went away.
+type AddressChange struct {
+	Session Session
+	OldIP   string
+}
+
+// Sessions tracks live sessions and per-token traffic. Sessions without
+// a WireGuard handshake in idle time (or, for OpenVPN, without a client
+// connection in the server's status) are no longer counted as live; a
+// new session has idle time to connect. Usage is kept in memory and
+// starts over when noded restarts.
+type Sessions struct {
+	idle time.Duration
+
+	mu       sync.Mutex
+	byID     map[string]*Session
+	used     map[string]int64 // token ID -> bytes
+	counters map[string]int64 // WG pubkey -> last seen byte counter
+
+	ovpnCounters map[string]int64 // OpenVPN connection -> last seen byte counter
+}
+
+// NewSessions returns a tracker that expires sessions idle for idle.
+func NewSessions(idle time.Duration) *Sessions {
+	return &Sessions{
+		idle:     idle,
+		byID:     map[string]*Session{},
+		used:     map[string]int64{},
+		counters: map[string]int64{},
+
+		ovpnCounters: map[string]int64{},
+	}
+}
+
+func sameDevice(sess *Session, deviceKey string) bool {
+	return deviceKey != "" && sess.DeviceKey == deviceKey
+}
+
+// List returns the tracked sessions.
+func (s *Sessions) List() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Session, 0, len(s.byID))
+	for _, sess := range s.byID {
+		out = append(out, *sess)
+	}
+	return out
+}
+
+// Remove stops tracking a session and returns it.
+func (s *Sessions) Remove(id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.byID[id]
+	if !ok {
+		return Session{}, false
+	}
+	s.removeLocked(id)
+	return *sess, true
+}
+
+// Usage is what a new session's limits are checked against: the
+// node's live sessions, excluding those the new session replaces.
+type Usage struct {
+	Live     int   // the subscription's other live sessions on this node
+	NodeLive int   // live sessions on this node, of all subscriptions
+	Used     int64 // bytes the token has used on this node
+}
+
+// Reserve records a new session if check allows it given the node's
+// current usage, and returns the sessions it replaces (same
+// subscription, same device), which the caller should tear down. check
+// may fill in the session's limits (its data cap). The check and the
+// reservation happen under one lock, so concurrent requests can't both
+// take the last slot.
+func (s *Sessions) Reserve(sess Session, now time.Time, check func(*Session, Usage) *Error) ([]Session, *Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := Usage{Used: s.used[sess.TokenID]}
+	for _, old := range s.byID {
+		if !s.liveLocked(old, now) || old.SubID == sess.SubID && sameDevice(old, sess.DeviceKey) {
+			continue
+		}
+		u.NodeLive++
+		if old.SubID == sess.SubID {
+			u.Live++
+		}
+	}
+	if err := check(&sess, u); err != nil {
+		return nil, err
+	}
+
+	// A device reconnecting over WireGuard keeps its peer (its device
+	// key), and the peer's byte counter.
+	counter, hasCounter := s.counters[sess.DeviceKey]
+	var replaced []Session
+	for id, old := range s.byID {
+		if old.SubID == sess.SubID && sameDevice(old, sess.DeviceKey) {
+			replaced = append(replaced, *old)
+			s.removeLocked(id)
+		}
+	}
+	if hasCounter && sess.Backend != "openvpn" {
+		s.counters[sess.DeviceKey] = counter
+	}
+	sess.lastActive = sess.CreatedAt
+	s.byID[sess.ID] = &sess
+	return replaced, nil
+}
+
+// SetPeer records the WireGuard peer and tunnel address of a reserved
+// session.
+func (s *Sessions) SetPeer(id, wgPubKey, clientIP string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.byID[id]; ok {
+		sess.WGPubKey, sess.ClientIP = wgPubKey, clientIP
+	}
+}
+
+// Update folds WireGuard peer stats and the OpenVPN server's connected
+// clients (see ReadOpenVPNStatus; nil if unknown) into session liveness,
+// tunnel addresses and token usage. It removes and returns sessions that
+// are no longer live or have used up their data cap, and returns the
+// OpenVPN sessions whose tunnel address changed.
+func (s *Sessions) Update(stats map[string]PeerStat, ovpn []OpenVPNClient, now time.Time) (ended []Session, moved []AddressChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ovpn != nil {
+		moved = s.updateOpenVPNLocked(ovpn, now)
+	}
+
+	for _, sess := range s.byID {
+		st, ok := stats[sess.WGPubKey]
+		if sess.WGPubKey == "" || !ok {
+			continue
+		}
+		if st.LastHandshake.After(sess.lastActive) {
+			sess.lastActive = st.LastHandshake
+		}
+		if delta := st.Bytes - s.counters[sess.WGPubKey]; delta > 0 {
+			s.used[sess.TokenID] += delta
+		}
+		s.counters[sess.WGPubKey] = st.Bytes
+	}
+
+	for id, sess := range s.byID {
+		overCap := sess.DataCap > 0 && s.used[sess.TokenID] >= sess.DataCap
+		if overCap || !s.liveLocked(sess, now) {
+			ended = append(ended, *sess)
+			s.removeLocked(id)
+		}
+	}
+	return ended, moved
+}
+
+// updateOpenVPNLocked pairs OpenVPN sessions with the server's client
+// connections. A connection stays with the session it was paired with;
+// a new one goes to the newest unpaired session from its address.
+func (s *Sessions) updateOpenVPNLocked(clients []OpenVPNClient, now time.Time) (moved []AddressChange) {
+	byConn := make(map[string]OpenVPNClient, len(clients))
+	for _, c := range clients {
+		byConn[c.Conn] = c
+	}
+	for conn := range s.ovpnCounters {
+		if _, ok := byConn[conn]; !ok {
+			delete(s.ovpnCounters, conn)
+		}
+	}
+
+	var unpaired []*Session
+	paired := map[string]bool{}
+	for _, sess := range s.byID {
+		if sess.Backend != "openvpn" {
+			continue
+		}
+		if _, ok := byConn[sess.ovpnConn]; ok && !paired[sess.ovpnConn] {
+			paired[sess.ovpnConn] = true
+			continue
+		}
+		sess.ovpnConn = ""
+		unpaired = append(unpaired, sess)
+	}
+	sort.Slice(unpaired, func(i, j int) bool { return unpaired[i].CreatedAt.After(unpaired[j].CreatedAt) })
+	for _, c := range clients { // newest first
+		if paired[c.Conn] {
+			continue
+		}
+		for _, sess := range unpaired {
+			if sess.ovpnConn == "" && sess.RemoteIP == c.RealIP {
+				sess.ovpnConn = c.Conn
+				paired[c.Conn] = true
+				break
+			}
+		}
+	}
+
+	for _, sess := range s.byID {
+		if sess.Backend != "openvpn" {
+			continue
+		}
+		clientIP := ""
+		if c, ok := byConn[sess.ovpnConn]; ok {
+			sess.lastActive = now
+			if delta := c.Bytes - s.ovpnCounters[c.Conn]; delta > 0 {
+				s.used[sess.TokenID] += delta
+			}
+			s.ovpnCounters[c.Conn] = c.Bytes
+			if c.VirtualIP != "" {
+				clientIP = c.VirtualIP + "/32"
+			}
+		}
+		if clientIP != sess.ClientIP {
+			old := sess.ClientIP
+			sess.ClientIP = clientIP
+			moved = append(moved, AddressChange{Session: *sess, OldIP: old})
+		}
+	}
+	return moved
+}
+
+func (s *Sessions) liveLocked(sess *Session, now time.Time) bool {
+	return now.Before(sess.ExpiresAt) && now.Sub(sess.lastActive) < s.idle
+}
+
+func (s *Sessions) removeLocked(id string) {
+	if sess, ok := s.byID[id]; ok {
+		delete(s.counters, sess.WGPubKey)
+		delete(s.byID, id)
+	}
+}
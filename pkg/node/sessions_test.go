@@ -0,0 +1,153 @@
+package node
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionsReserveConcurrent(t *testing.T) {
+	const limit = 2
+	s := NewSessions(time.Minute)
+	now := time.Now()
+	check := func(_ *Session, u Usage) *Error {
+		if u.Live >= limit {
+			return &Error{Code: CodeMaxSessions}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sess := Session{
+				ID:        strconv.Itoa(i),
+				SubID:     "sub",
+				DeviceKey: "dev" + strconv.Itoa(i),
+				CreatedAt: now,
+				ExpiresAt: now.Add(time.Hour),
+			}
+			if _, err := s.Reserve(sess, now, check); err == nil {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if accepted != limit {
+		t.Fatalf("accepted %d sessions, want %d", accepted, limit)
+	}
+	if n := len(s.List()); n != limit {
+		t.Fatalf("tracking %d sessions, want %d", n, limit)
+	}
+}
+
+func TestSessionsReserveReplacesDevice(t *testing.T) {
+	s := NewSessions(time.Minute)
+	now := time.Now()
+	var got Usage
+	check := func(sess *Session, u Usage) *Error {
+		got = u
+		sess.DataCap = 100
+		return nil
+	}
+
+	s.Reserve(Session{ID: "a", SubID: "sub", DeviceKey: "dev", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, now, check)
+	s.Reserve(Session{ID: "b", SubID: "other", DeviceKey: "dev2", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, now, check)
+	replaced, err := s.Reserve(Session{ID: "c", SubID: "sub", DeviceKey: "dev", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, now, check)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replaced) != 1 || replaced[0].ID != "a" {
+		t.Fatalf("replaced = %+v, want session a", replaced)
+	}
+	if got.Live != 0 || got.NodeLive != 1 {
+		t.Fatalf("usage = %+v, want the replaced session left out", got)
+	}
+	for _, sess := range s.List() {
+		if sess.DataCap != 100 {
+			t.Fatalf("session %s DataCap = %d, want the cap set by check", sess.ID, sess.DataCap)
+		}
+	}
+}
+
+func TestSessionsUpdateOpenVPN(t *testing.T) {
+	s := NewSessions(time.Minute)
+	now := time.Now()
+	allow := func(sess *Session, _ Usage) *Error {
+		sess.DataCap = 1000
+		return nil
+	}
+	for _, id := range []string{"old", "new"} {
+		sess := Session{ID: id, TokenID: "tok", SubID: "sub", Backend: "openvpn", RemoteIP: "203.0.113.5",
+			DeviceKey: "dev-" + id, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if _, err := s.Reserve(sess, now, allow); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Second)
+	}
+	conn := OpenVPNClient{RealIP: "203.0.113.5", VirtualIP: "10.8.0.6", Bytes: 400, Conn: "a"}
+
+	// The connection goes to the newest session.
+	ended, moved := s.Update(nil, []OpenVPNClient{conn}, now)
+	if len(ended) != 0 {
+		t.Fatalf("ended = %+v, want none", ended)
+	}
+	if len(moved) != 1 || moved[0].Session.ID != "new" || moved[0].Session.ClientIP != "10.8.0.6/32" || moved[0].OldIP != "" {
+		t.Fatalf("moved = %+v, want session new at 10.8.0.6/32", moved)
+	}
+
+	// Only the growth of its byte counter counts; status unknown changes nothing.
+	conn.Bytes = 900
+	if ended, moved := s.Update(nil, []OpenVPNClient{conn}, now); len(ended)+len(moved) != 0 {
+		t.Fatalf("Update() = %+v, %+v, want no changes", ended, moved)
+	}
+	if ended, moved := s.Update(nil, nil, now); len(ended)+len(moved) != 0 {
+		t.Fatalf("Update(nil status) = %+v, %+v, want no changes", ended, moved)
+	}
+
+	// Over the data cap the session ends; the unpaired one idles out.
+	conn.Bytes = 1000
+	ended, moved = s.Update(nil, []OpenVPNClient{conn}, now.Add(2*time.Minute))
+	if len(moved) != 0 {
+		t.Fatalf("moved = %+v, want none", moved)
+	}
+	if len(ended) != 2 {
+		t.Fatalf("ended = %+v, want both sessions", ended)
+	}
+	for _, sess := range ended {
+		if sess.ID == "new" && sess.ClientIP != "10.8.0.6/32" {
+			t.Fatalf("ended session new has ClientIP %q, want its tunnel address to unlimit", sess.ClientIP)
+		}
+	}
+}
+
+func TestSessionsUpdateOpenVPNReconnect(t *testing.T) {
+	s := NewSessions(time.Minute)
+	now := time.Now()
+	sess := Session{ID: "s", TokenID: "tok", SubID: "sub", Backend: "openvpn", RemoteIP: "203.0.113.5",
+		CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	s.Reserve(sess, now, func(*Session, Usage) *Error { return nil })
+
+	s.Update(nil, []OpenVPNClient{{RealIP: "203.0.113.5", VirtualIP: "10.8.0.6", Bytes: 500, Conn: "a"}}, now)
+	_, moved := s.Update(nil, []OpenVPNClient{{RealIP: "203.0.113.5", VirtualIP: "10.8.0.10", Bytes: 100, Conn: "b"}}, now)
+	if len(moved) != 1 || moved[0].OldIP != "10.8.0.6/32" || moved[0].Session.ClientIP != "10.8.0.10/32" {
+		t.Fatalf("moved = %+v, want 10.8.0.6/32 -> 10.8.0.10/32", moved)
+	}
+	_, moved = s.Update(nil, []OpenVPNClient{}, now)
+	if len(moved) != 1 || moved[0].OldIP != "10.8.0.10/32" || moved[0].Session.ClientIP != "" {
+		t.Fatalf("moved = %+v, want the address released", moved)
+	}
+	s.mu.Lock()
+	used := s.used["tok"]
+	s.mu.Unlock()
+	if used != 600 {
+		t.Fatalf("used = %d, want both connections' bytes", used)
+	}
+}
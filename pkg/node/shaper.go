@@ -0,0 +1,173 @@
+package node
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Shaper limits per-client bandwidth on the WireGuard interface with tc:
+// an HTB class per client for download (egress on the interface) and an
+// ingress policer for upload. In dry-run mode it only logs the commands.
+type Shaper struct {
+	iface string
+	apply bool
+
+	mu    sync.Mutex
+	setup bool
+}
+
+// NewShaperFromEnv returns a Shaper for iface. It runs tc only if
+// MEERKAT_NODE_TC_APPLY=1 (on Linux); otherwise it is a dry run that
+// logs the rules it would apply.
+func NewShaperFromEnv(iface string) *Shaper {
+	apply := os.Getenv("MEERKAT_NODE_TC_APPLY") == "1"
+	if apply && runtime.GOOS != "linux" {
+		log.Printf("[tc] apply requested, but OS is %s -> dry-run\n", runtime.GOOS)
+		apply = false
+	}
+	mode := "dry-run"
+	if apply {
+		mode = "apply (tc CLI)"
+	}
+	log.Printf("[tc] shaper initialized: iface=%s mode=%s\n", iface, mode)
+	return &Shaper{iface: iface, apply: apply}
+}
+
+// SetupCommands returns the commands creating the root qdiscs that
+// per-client rules attach to.
+func (s *Shaper) SetupCommands() [][]string {
+	return [][]string{
+		{"qdisc", "replace", "dev", s.iface, "root", "handle", "1:", "htb"},
+		{"qdisc", "replace", "dev", s.iface, "handle", "ffff:", "ingress"},
+	}
+}
+
+// LimitCommands returns the tc commands limiting clientIP (e.g.
+// "10.8.0.2/32") to speed.
+func (s *Shaper) LimitCommands(clientIP string, speed SpeedClass) ([][]string, error) {
+	ip, minor, prio, err := classFor(clientIP)
+	if err != nil {
+		return nil, err
+	}
+	var cmds [][]string
+	if speed.DownMbit > 0 {
+		rate := strconv.Itoa(speed.DownMbit) + "mbit"
+		cmds = append(cmds,
+			[]string{"class", "replace", "dev", s.iface, "parent", "1:", "classid", "1:" + minor,
+				"htb", "rate", rate, "ceil", rate},
+			[]string{"filter", "replace", "dev", s.iface, "parent", "1:", "protocol", "ip", "prio", prio,
+				"u32", "match", "ip", "dst", ip + "/32", "flowid", "1:" + minor},
+		)
+	}
+	if speed.UpMbit > 0 {
+		rate := strconv.Itoa(speed.UpMbit) + "mbit"
+		cmds = append(cmds,
+			[]string{"filter", "replace", "dev", s.iface, "parent", "ffff:", "protocol", "ip", "prio", prio,
+				"u32", "match", "ip", "src", ip + "/32",
+				"police", "rate", rate, "burst", burstFor(speed.UpMbit), "drop", "flowid", ":1"},
+		)
+	}
+	return cmds, nil
+}
+
+// RemoveCommands returns the tc commands removing clientIP's limits.
+func (s *Shaper) RemoveCommands(clientIP string) ([][]string, error) {
+	_, minor, prio, err := classFor(clientIP)
+	if err != nil {
+		return nil, err
+	}
+	return [][]string{
+		{"filter", "del", "dev", s.iface, "parent", "1:", "prio", prio},
+		{"class", "del", "dev", s.iface, "classid", "1:" + minor},
+		{"filter", "del", "dev", s.iface, "parent", "ffff:", "prio", prio},
+	}, nil
+}
+
+// Limit applies speed to clientIP.
+func (s *Shaper) Limit(clientIP string, speed SpeedClass) error {
+	if speed.Unlimited() {
+		return nil
+	}
+	cmds, err := s.LimitCommands(clientIP, speed)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.setup {
+		if err := s.run(s.SetupCommands(), false); err != nil {
+			return err
+		}
+		s.setup = true
+	}
+	return s.run(cmds, false)
+}
+
+// Unlimit removes clientIP's limits, if any.
+func (s *Shaper) Unlimit(clientIP string) {
+	cmds, err := s.RemoveCommands(clientIP)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.setup {
+		_ = s.run(cmds, true)
+	}
+}
+
+func (s *Shaper) run(cmds [][]string, ignoreErrors bool) error {
+	for _, args := range cmds {
+		if !s.apply {
+			log.Printf("[tc] dry-run: %s\n", FormatCommand(args))
+			continue
+		}
+		out, err := exec.Command("tc", args...).CombinedOutput()
+		if err != nil && !ignoreErrors {
+			return fmt.Errorf("tc %s: %w (%s)", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// FormatCommand renders tc arguments as a shell command line.
+func FormatCommand(args []string) string {
+	return "tc " + strings.Join(args, " ")
+}
+
+// classFor returns the bare IPv4 address of clientIP, and the tc class
+// minor (hex) and filter priority (decimal) derived from its last two
+// octets; each client gets its own filter priority so its rules can be
+// deleted on their own.
+func classFor(clientIP string) (ip, minor, prio string, err error) {
+	host := clientIP
+	if i := strings.IndexByte(host, '/'); i >= 0 {
+		host = host[:i]
+	}
+	ip4 := net.ParseIP(host).To4()
+	if ip4 == nil {
+		return "", "", "", fmt.Errorf("tc: %q is not an IPv4 address", clientIP)
+	}
+	n := int(ip4[2])<<8 | int(ip4[3])
+	if n == 0 || n == 0xffff {
+		return "", "", "", fmt.Errorf("tc: no class for %s", host)
+	}
+	return ip4.String(), strconv.FormatInt(int64(n), 16), strconv.Itoa(n), nil
+}
+
+// burstFor sizes the policer burst at roughly 10ms of traffic.
+func burstFor(mbit int) string {
+	kb := mbit * 1000 / 8 / 100
+	if kb < 16 {
+		kb = 16
+	}
+	return strconv.Itoa(kb) + "k"
+}
@@ -2,63 +2,111 @@ package nostrutil
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"log"
+	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 )
 
-// Client wraps a basic nostr relay set + keys.
+// signTimeout bounds signatures made without a caller's context.
+const signTimeout = time.Minute
+
+// Client wraps a managed relay pool + signer.
 type Client struct {
-	PrivKey string
-	PubKey  string
-	Relays  []*nostr.Relay
+	Signer Signer
+	PubKey string
+	Relays *RelayPool
 }
 
-// NewClient connects to the given relay URLs and parses the privkey
-// (which may be hex or nsec).
+// NewClient parses the privkey (which may be hex or nsec) and connects
+// to the given relay URLs. Relays that fail to connect, or drop later,
+// keep being retried in the background until ctx is done.
 func NewClient(ctx context.Context, priv string, relayURLs []string) (*Client, error) {
-	parsed, err := ParsePrivKey(priv)
+	signer, err := NewLocalSigner(priv)
 	if err != nil {
 		return nil, err
 	}
+	return NewClientWithSigner(ctx, signer, relayURLs)
+}
 
+// NewClientWithSigner is NewClient for a key held by signer, e.g. a
+// remote signer.
+func NewClientWithSigner(ctx context.Context, signer Signer, relayURLs []string) (*Client, error) {
 	c := &Client{
-		PrivKey: parsed.PrivHex,
-		PubKey:  parsed.PubHex,
-		Relays:  []*nostr.Relay{},
+		Signer: signer,
+		PubKey: signer.PubKey(),
 	}
+	c.Relays = NewRelayPool(ctx, relayURLs, c.Sign)
 
-	for _, raw := range relayURLs {
-		url := strings.TrimSpace(raw)
-		if url == "" {
-			continue
-		}
-		relay, err := nostr.RelayConnect(ctx, url)
-		if err != nil {
-			fmt.Println("failed to connect to relay", url, ":", err)
-			continue
-		}
-		c.Relays = append(c.Relays, relay)
+	if len(c.Relays.URLs()) == 0 {
+		c.Relays.Close()
+		return nil, fmt.Errorf("no relays configured")
 	}
-
-	if len(c.Relays) == 0 {
-		return nil, fmt.Errorf("no relays connected")
+	if c.Relays.WaitFirstAttempt(ctx) == 0 {
+		fmt.Println("no relays connected yet; retrying in the background")
 	}
 
 	return c, nil
 }
 
-// SendDM sends a simple (currently plaintext) kind-4 DM to the target pubkey.
-//
-// NOTE: For now this does NOT do NIP-04/44 encryption. It just signs and
-// publishes the event so we can focus on plumbing. We can harden this later.
+// Sign signs ev as the client.
+func (c *Client) Sign(ev *nostr.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+	defer cancel()
+	return c.Signer.SignEvent(ctx, ev)
+}
+
+// DecryptDM decrypts the content of a NIP-04 DM to the client.
+func (c *Client) DecryptDM(ctx context.Context, ev *nostr.Event) (string, error) {
+	return c.Signer.NIP04Decrypt(ctx, ev.PubKey, ev.Content)
+}
+
+// AuthHandler lets a nostr.SimplePool answer NIP-42 AUTH challenges as
+// the client.
+func (c *Client) AuthHandler() nostr.WithAuthHandler {
+	return AuthHandler(c.Sign)
+}
+
+// AuthHandler lets a nostr.SimplePool answer NIP-42 AUTH challenges,
+// signing the auth events with sign.
+func AuthHandler(sign func(*nostr.Event) error) nostr.WithAuthHandler {
+	return func(ctx context.Context, ev nostr.RelayEvent) error {
+		return sign(ev.Event)
+	}
+}
+
+// SendDM sends a NIP-04 encrypted kind-4 DM to the target pubkey.
+// It fails if no relay confirmed the DM with OK.
 func (c *Client) SendDM(ctx context.Context, toPub string, content string, extraTags nostr.Tags) error {
-	pubHex, err := ParsePubKey(toPub)
+	ev, err := c.SignDM(toPub, content, extraTags)
 	if err != nil {
 		return err
 	}
 
+	var errs []error
+	for url, err := range c.PublishEach(ctx, ev, nil) {
+		if err == nil {
+			return nil
+		}
+		log.Printf("failed to publish DM to %s: %v\n", url, err)
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
+	}
+	if len(errs) == 0 {
+		return errors.New("no relays to send the DM to")
+	}
+	return fmt.Errorf("no relay accepted the DM: %w", errors.Join(errs...))
+}
+
+// SignDM builds, encrypts and signs the kind-4 DM SendDM publishes, so
+// it can be published (and republished) later. Only the tags are public.
+func (c *Client) SignDM(toPub string, content string, extraTags nostr.Tags) (nostr.Event, error) {
+	pubHex, err := ParsePubKey(toPub)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
 	tags := nostr.Tags{
 		{"p", pubHex},
 	}
@@ -66,38 +114,41 @@ func (c *Client) SendDM(ctx context.Context, toPub string, content string, extra
 		tags = append(tags, extraTags...)
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+	defer cancel()
+	ciphertext, err := c.Signer.NIP04Encrypt(ctx, pubHex, content)
+	if err != nil {
+		return nostr.Event{}, fmt.Errorf("encrypt DM: %w", err)
+	}
+
 	ev := nostr.Event{
 		PubKey:    c.PubKey,
 		CreatedAt: nostr.Now(),
 		Kind:      nostr.KindEncryptedDirectMessage, // kind 4
 		Tags:      tags,
-		Content:   content, // plaintext for now
+		Content:   ciphertext,
 	}
 
-	if err := ev.Sign(c.PrivKey); err != nil {
-		return err
-	}
-
-	for _, r := range c.Relays {
-		if err := r.Publish(ctx, ev); err != nil {
-			fmt.Println("failed to publish DM:", err)
-		}
+	if err := c.Sign(&ev); err != nil {
+		return nostr.Event{}, err
 	}
+	return ev, nil
+}
 
-	return nil
+// PublishEach publishes ev to every relay not in skip; see
+// RelayPool.PublishEach.
+func (c *Client) PublishEach(ctx context.Context, ev nostr.Event, skip map[string]bool) map[string]error {
+	return c.Relays.PublishEach(ctx, ev, skip)
 }
 
-// Publish broadcasts a generic event to all connected relays.
+// Publish broadcasts a generic event to all relays.
 func (c *Client) Publish(ctx context.Context, ev nostr.Event) error {
 	var lastErr error
-	for _, r := range c.Relays {
-		if err := r.Publish(ctx, ev); err != nil {
+	for _, err := range c.Relays.PublishEach(ctx, ev, nil) {
+		if err != nil {
 			fmt.Println("failed to publish event:", err)
 			lastErr = err
 		}
 	}
 	return lastErr
 }
-
-
-
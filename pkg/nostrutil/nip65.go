@@ -0,0 +1,169 @@
+package nostrutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// DefaultRelays are used when no relays are configured, and are where
+// relay lists are published and looked up.
+var DefaultRelays = []string{"wss://relay.damus.io", "wss://relay.primal.net"}
+
+// RelayURLsFromEnv returns the comma-separated relays in the env var
+// name, or DefaultRelays if it is empty.
+func RelayURLsFromEnv(name string) []string {
+	out := SplitRelayURLs(os.Getenv(name))
+	if len(out) == 0 {
+		return slices.Clone(DefaultRelays)
+	}
+	return out
+}
+
+// SplitRelayURLs splits a comma-separated relay list.
+func SplitRelayURLs(v string) []string {
+	out := []string{}
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// RelayList is a NIP-65 relay list: Read relays are the inbox others
+// send events for the author to, Write relays the outbox the author
+// publishes to.
+type RelayList struct {
+	Read  []string `json:"read"`
+	Write []string `json:"write"`
+}
+
+// ParseRelayList reads the "r" tags of a kind-10002 event. An r tag
+// without a marker lists the relay for both reading and writing.
+func ParseRelayList(ev *nostr.Event) RelayList {
+	var rl RelayList
+	for _, t := range ev.Tags {
+		if len(t) < 2 || t[0] != "r" {
+			continue
+		}
+		url := nostr.NormalizeURL(t[1])
+		if url == "" {
+			continue
+		}
+		marker := ""
+		if len(t) > 2 {
+			marker = t[2]
+		}
+		if marker != "write" && !slices.Contains(rl.Read, url) {
+			rl.Read = append(rl.Read, url)
+		}
+		if marker != "read" && !slices.Contains(rl.Write, url) {
+			rl.Write = append(rl.Write, url)
+		}
+	}
+	return rl
+}
+
+// Tags returns rl as the r tags of a kind-10002 event.
+func (rl RelayList) Tags() nostr.Tags {
+	tags := nostr.Tags{}
+	for _, url := range rl.Read {
+		if slices.Contains(rl.Write, url) {
+			tags = append(tags, nostr.Tag{"r", url})
+		} else {
+			tags = append(tags, nostr.Tag{"r", url, "read"})
+		}
+	}
+	for _, url := range rl.Write {
+		if !slices.Contains(rl.Read, url) {
+			tags = append(tags, nostr.Tag{"r", url, "write"})
+		}
+	}
+	return tags
+}
+
+// FetchRelayList looks up pubHex's newest NIP-65 relay list on relays;
+// opts configure the SimplePool used (e.g. AuthHandler).
+func FetchRelayList(ctx context.Context, relays []string, pubHex string, opts ...nostr.PoolOption) (*RelayList, error) {
+	if len(relays) == 0 {
+		return nil, errors.New("no relays to look up the relay list on")
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var latest *nostr.Event
+	sp := nostr.NewSimplePool(ctx, opts...)
+	for ev := range sp.FetchMany(ctx, relays, nostr.Filter{
+		Kinds:   []int{nostr.KindRelayListMetadata},
+		Authors: []string{pubHex},
+	}) {
+		if ev.Event == nil || ev.PubKey != pubHex {
+			continue
+		}
+		if latest == nil || ev.CreatedAt > latest.CreatedAt {
+			latest = ev.Event
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no relay list for %s on %v", pubHex, relays)
+	}
+	rl := ParseRelayList(latest)
+	return &rl, nil
+}
+
+// PublishRelayList publishes rl as the client's NIP-65 relay list, to
+// its own relays and to extra (typically DefaultRelays, where others
+// look it up). It fails only if no relay accepted it.
+func (c *Client) PublishRelayList(ctx context.Context, rl RelayList, extra []string) error {
+	ev := nostr.Event{
+		PubKey:    c.PubKey,
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindRelayListMetadata,
+		Tags:      rl.Tags(),
+	}
+	if err := c.Sign(&ev); err != nil {
+		return err
+	}
+
+	var errs []error
+	accepted := 0
+	for url, err := range c.Relays.PublishEach(ctx, ev, nil) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+			continue
+		}
+		accepted++
+	}
+
+	own := map[string]bool{}
+	for _, url := range c.Relays.URLs() {
+		own[url] = true
+	}
+	var others []string
+	for _, url := range extra {
+		if url = nostr.NormalizeURL(url); !own[url] {
+			others = append(others, url)
+		}
+	}
+	if len(others) > 0 {
+		sp := nostr.NewSimplePool(ctx, c.AuthHandler())
+		for res := range sp.PublishMany(ctx, others, ev) {
+			if res.Error != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", res.RelayURL, res.Error))
+				continue
+			}
+			accepted++
+		}
+	}
+	if accepted == 0 {
+		return fmt.Errorf("no relay accepted the relay list: %w", errors.Join(errs...))
+	}
+	return nil
+}
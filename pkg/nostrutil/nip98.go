@@ -0,0 +1,113 @@
+package nostrutil
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// HTTPAuthWindow is how far a NIP-98 auth event's created_at may be from
+// the verifier's clock.
+const HTTPAuthWindow = time.Minute
+
+// SignHTTPAuth returns an Authorization header value ("Nostr <base64
+// event>") authorizing a request per NIP-98. body may be nil; if not,
+// its hash is bound to the event.
+func SignHTTPAuth(priv, method, rawURL string, body []byte) (string, error) {
+	parsed, err := ParsePrivKey(priv)
+	if err != nil {
+		return "", err
+	}
+	ev := nostr.Event{
+		PubKey:    parsed.PubHex,
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindHTTPAuth,
+		Tags: nostr.Tags{
+			{"u", rawURL},
+			{"method", strings.ToUpper(method)},
+		},
+	}
+	if len(body) > 0 {
+		h := sha256.Sum256(body)
+		ev.Tags = append(ev.Tags, nostr.Tag{"payload", hex.EncodeToString(h[:])})
+	}
+	if err := ev.Sign(parsed.PrivHex); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return "", err
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// HTTPAuthRequest is what a NIP-98 auth event must match.
+type HTTPAuthRequest struct {
+	Method     string
+	RequestURI string   // path and query, as in http.Request.RequestURI
+	Hosts      []string // host names the u tag may name
+	Body       []byte
+}
+
+// VerifyHTTPAuth checks a NIP-98 Authorization header against req and
+// returns the signed event; its PubKey is the authenticated key.
+func VerifyHTTPAuth(header string, req HTTPAuthRequest, now time.Time) (*nostr.Event, error) {
+	enc, ok := strings.CutPrefix(header, "Nostr ")
+	if !ok {
+		return nil, errors.New("missing Nostr authorization")
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(enc))
+	if err != nil {
+		return nil, fmt.Errorf("authorization: %w", err)
+	}
+	var ev nostr.Event
+	if err := json.Unmarshal(b, &ev); err != nil {
+		return nil, fmt.Errorf("authorization: %w", err)
+	}
+
+	if ev.Kind != nostr.KindHTTPAuth {
+		return nil, fmt.Errorf("auth event has kind %d", ev.Kind)
+	}
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return nil, errors.New("auth event has a bad signature")
+	}
+	if d := now.Sub(ev.CreatedAt.Time()); d > HTTPAuthWindow || d < -HTTPAuthWindow {
+		return nil, errors.New("auth event created_at too far from now")
+	}
+	if m := ev.Tags.GetFirst([]string{"method", ""}); m == nil || !strings.EqualFold((*m)[1], req.Method) {
+		return nil, errors.New("auth event is for another method")
+	}
+	u := ev.Tags.GetFirst([]string{"u", ""})
+	if u == nil {
+		return nil, errors.New("auth event has no u tag")
+	}
+	signed, err := url.Parse((*u)[1])
+	if err != nil || signed.RequestURI() != req.RequestURI || !containsHost(req.Hosts, signed.Host) {
+		return nil, errors.New("auth event is for another URL")
+	}
+	if len(req.Body) > 0 {
+		h := sha256.Sum256(req.Body)
+		p := ev.Tags.GetFirst([]string{"payload", ""})
+		if p == nil || !strings.EqualFold((*p)[1], hex.EncodeToString(h[:])) {
+			return nil, errors.New("auth event payload hash doesn't match the body")
+		}
+	}
+	return &ev, nil
+}
+
+func containsHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,457 @@
+package nostrutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	relayConnectTimeout = 15 * time.Second
+	relayMinBackoff     = time.Second
+	relayMaxBackoff     = 5 * time.Minute
+	// resumeMargin is how far before a subscription was last known to be
+	// live it resumes after a reconnect. It covers dead connections
+	// go-nostr hasn't noticed yet (it pings every 29s) and clock skew.
+	resumeMargin = 2 * time.Minute
+	// seenTTL is how long a subscription remembers the events it handled,
+	// since the same event arrives from every relay and again after a
+	// resubscription.
+	seenTTL = 48 * time.Hour
+)
+
+// ErrRelayDown is the result of publishing to a relay that isn't
+// connected right now.
+var ErrRelayDown = errors.New("relay not connected")
+
+// RelayHealth is a snapshot of a relay's connection and traffic.
+type RelayHealth struct {
+	URL            string `json:"url"`
+	Connected      bool   `json:"connected"`
+	ConnectedAt    int64  `json:"connected_at,omitempty"`
+	Authenticated  bool   `json:"authenticated,omitempty"` // NIP-42, on this connection
+	Connects       int    `json:"connects"`
+	Disconnects    int    `json:"disconnects"`
+	Failures       int    `json:"failures"` // failed attempts since the last connect
+	NextAttempt    int64  `json:"next_attempt,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+	LastErrorAt    int64  `json:"last_error_at,omitempty"`
+	Events         int    `json:"events"`
+	Published      int    `json:"published"`
+	PublishErrors  int    `json:"publish_errors"`
+	LastEventAt    int64  `json:"last_event_at,omitempty"`
+	LastPublishErr string `json:"last_publish_error,omitempty"`
+}
+
+// RelayPool keeps a connection to each of a set of relays until its
+// context is done, reconnecting with exponential backoff whenever one
+// fails or drops. Relays that answer "auth-required" get a NIP-42 AUTH
+// and the publish or subscription is retried.
+type RelayPool struct {
+	relays []*managedRelay
+	cancel context.CancelFunc
+}
+
+type managedRelay struct {
+	url   string
+	auth  func(*nostr.Event) error
+	tried chan struct{} // closed after the first connection attempt
+
+	authMu sync.Mutex // one AUTH at a time; concurrent ones share an event ID
+
+	mu     sync.Mutex
+	conn   *nostr.Relay  // nil while disconnected
+	up     chan struct{} // closed once conn is set
+	authed *nostr.Relay  // the connection we authenticated on
+	health RelayHealth
+}
+
+// NewRelayPool starts connecting to urls in the background. auth signs
+// NIP-42 auth events; if nil, relays that require AUTH can't be used.
+func NewRelayPool(ctx context.Context, urls []string, auth func(*nostr.Event) error) *RelayPool {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &RelayPool{cancel: cancel}
+	seen := map[string]bool{}
+	for _, raw := range urls {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		url := nostr.NormalizeURL(raw)
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		r := &managedRelay{
+			url:    url,
+			auth:   auth,
+			tried:  make(chan struct{}),
+			up:     make(chan struct{}),
+			health: RelayHealth{URL: url},
+		}
+		p.relays = append(p.relays, r)
+		go r.maintain(ctx)
+	}
+	return p
+}
+
+// Close disconnects from every relay and stops reconnecting.
+func (p *RelayPool) Close() { p.cancel() }
+
+// URLs returns the pool's relays, connected or not.
+func (p *RelayPool) URLs() []string {
+	out := make([]string, len(p.relays))
+	for i, r := range p.relays {
+		out[i] = r.url
+	}
+	return out
+}
+
+// Connected returns the relays that are connected right now.
+func (p *RelayPool) Connected() []*nostr.Relay {
+	var out []*nostr.Relay
+	for _, r := range p.relays {
+		if conn, _ := r.current(); conn != nil {
+			out = append(out, conn)
+		}
+	}
+	return out
+}
+
+// Health returns a snapshot of every relay's health.
+func (p *RelayPool) Health() []RelayHealth {
+	out := make([]RelayHealth, len(p.relays))
+	for i, r := range p.relays {
+		r.mu.Lock()
+		out[i] = r.health
+		r.mu.Unlock()
+	}
+	return out
+}
+
+// WaitFirstAttempt waits until every relay has been tried once (or ctx
+// is done) and returns how many are connected.
+func (p *RelayPool) WaitFirstAttempt(ctx context.Context) int {
+	for _, r := range p.relays {
+		select {
+		case <-r.tried:
+		case <-ctx.Done():
+		}
+	}
+	return len(p.Connected())
+}
+
+// PublishEach publishes ev to every relay not in skip, concurrently, and
+// returns each relay's result by URL: nil means the relay confirmed the
+// event with OK, ErrRelayDown that it isn't connected.
+func (p *RelayPool) PublishEach(ctx context.Context, ev nostr.Event, skip map[string]bool) map[string]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := map[string]error{}
+	for _, r := range p.relays {
+		if skip[r.url] {
+			continue
+		}
+		wg.Add(1)
+		go func(r *managedRelay) {
+			defer wg.Done()
+			err := ErrRelayDown
+			if conn, _ := r.current(); conn != nil {
+				err = conn.Publish(ctx, ev)
+				if isAuthRequired(err) && r.authenticate(ctx, conn) == nil {
+					err = conn.Publish(ctx, ev)
+				}
+			}
+			r.published(err)
+			mu.Lock()
+			results[r.url] = err
+			mu.Unlock()
+		}(r)
+	}
+	wg.Wait()
+	return results
+}
+
+// Subscribe follows filter on every relay until ctx is done and calls
+// handle once per event, from whichever relay delivers it first. When a
+// relay reconnects or closes the subscription, it resubscribes from just
+// before the subscription was last known to be live, so events published
+// in between aren't missed.
+func (p *RelayPool) Subscribe(ctx context.Context, filter nostr.Filter, handle func(context.Context, *nostr.Event)) {
+	seen := &seenEvents{ids: map[string]time.Time{}}
+	for _, r := range p.relays {
+		go r.follow(ctx, filter, seen, handle)
+	}
+}
+
+func (r *managedRelay) maintain(ctx context.Context) {
+	first := true
+	tried := func() {
+		if first {
+			close(r.tried)
+			first = false
+		}
+	}
+	failures := 0
+	for ctx.Err() == nil {
+		connCtx, cancel := context.WithTimeout(ctx, relayConnectTimeout)
+		conn, err := nostr.RelayConnect(connCtx, r.url)
+		cancel()
+		if err != nil {
+			failures++
+			wait := relayBackoff(failures)
+			r.failed(err, failures, time.Now().Add(wait))
+			tried()
+			log.Printf("relay %s: connect failed (attempt %d, retrying in %s): %v\n", r.url, failures, wait.Round(time.Second), err)
+			sleepCtx(ctx, wait)
+			continue
+		}
+		failures = 0
+		r.connected(conn)
+		tried()
+
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			r.disconnected(ctx.Err())
+			return
+		case <-conn.Context().Done():
+		}
+		cause := context.Cause(conn.Context())
+		r.disconnected(cause)
+		log.Printf("relay %s: disconnected: %v; reconnecting\n", r.url, cause)
+		// Spread reconnects out a little so clients don't all hit a
+		// restarted relay at once.
+		sleepCtx(ctx, relayBackoff(1))
+	}
+	tried()
+}
+
+// relayBackoff is the wait after the given number of consecutive
+// failures, with up to 25% jitter.
+func relayBackoff(failures int) time.Duration {
+	d := min(relayMinBackoff<<min(failures-1, 20), relayMaxBackoff)
+	return d + rand.N(d/4+1)
+}
+
+func (r *managedRelay) current() (*nostr.Relay, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn, r.up
+}
+
+func (r *managedRelay) connected(conn *nostr.Relay) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conn = conn
+	close(r.up)
+	r.health.Connected = true
+	r.health.ConnectedAt = time.Now().Unix()
+	r.health.Connects++
+	r.health.Failures = 0
+	r.health.NextAttempt = 0
+}
+
+func (r *managedRelay) disconnected(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conn = nil
+	r.up = make(chan struct{})
+	r.authed = nil
+	r.health.Connected = false
+	r.health.Authenticated = false
+	r.health.Disconnects++
+	r.setErrorLocked(err)
+}
+
+func (r *managedRelay) failed(err error, failures int, next time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.health.Failures = failures
+	r.health.NextAttempt = next.Unix()
+	r.setErrorLocked(err)
+}
+
+func (r *managedRelay) setErrorLocked(err error) {
+	if err != nil {
+		r.health.LastError = err.Error()
+		r.health.LastErrorAt = time.Now().Unix()
+	}
+}
+
+// authenticate answers conn's NIP-42 challenge, once per connection.
+func (r *managedRelay) authenticate(ctx context.Context, conn *nostr.Relay) error {
+	if r.auth == nil {
+		return errors.New("relay requires AUTH and there is no key to authenticate with")
+	}
+	r.authMu.Lock()
+	defer r.authMu.Unlock()
+	r.mu.Lock()
+	done := r.authed == conn
+	r.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	authCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	err := conn.Auth(authCtx, r.auth)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		err = fmt.Errorf("auth: %w", err)
+		r.setErrorLocked(err)
+		log.Printf("relay %s: %v\n", r.url, err)
+		return err
+	}
+	if r.conn == conn {
+		r.authed = conn
+		r.health.Authenticated = true
+	}
+	return nil
+}
+
+// isAuthRequired reports whether err is a relay's NIP-42 "auth-required"
+// rejection (from OK or CLOSED).
+func isAuthRequired(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "auth-required:")
+}
+
+func (r *managedRelay) published(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.health.PublishErrors++
+		r.health.LastPublishErr = err.Error()
+		return
+	}
+	r.health.Published++
+}
+
+func (r *managedRelay) received() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.health.Events++
+	r.health.LastEventAt = time.Now().Unix()
+}
+
+// follow keeps filter subscribed on r, across reconnects, until ctx is
+// done.
+func (r *managedRelay) follow(ctx context.Context, filter nostr.Filter, seen *seenEvents, handle func(context.Context, *nostr.Event)) {
+	since := filter.Since
+	var authedOn *nostr.Relay // the connection this subscription retried after AUTH on
+	for ctx.Err() == nil {
+		conn, up := r.current()
+		if conn == nil {
+			select {
+			case <-ctx.Done():
+			case <-up:
+			}
+			continue
+		}
+
+		f := filter
+		f.Since = since
+		live, err := r.followOn(ctx, conn, f, seen, handle)
+		if !live.IsZero() {
+			ts := nostr.Timestamp(live.Add(-resumeMargin).Unix())
+			if since == nil || ts > *since {
+				since = &ts
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("relay %s: subscription ended: %v; resubscribing\n", r.url, err)
+		if isAuthRequired(err) && authedOn != conn && r.authenticate(ctx, conn) == nil {
+			authedOn = conn
+			continue
+		}
+		if conn.IsConnected() {
+			// The relay closed the subscription but kept the connection;
+			// don't hammer it.
+			sleepCtx(ctx, 30*time.Second)
+		}
+	}
+}
+
+// followOn runs one subscription on conn and returns when it was last
+// known to be live: caught up (EOSE seen) and still connected. It is zero
+// if the subscription never caught up.
+func (r *managedRelay) followOn(ctx context.Context, conn *nostr.Relay, f nostr.Filter, seen *seenEvents, handle func(context.Context, *nostr.Event)) (time.Time, error) {
+	var live time.Time
+	sub, err := conn.Subscribe(ctx, nostr.Filters{f})
+	if err != nil {
+		return live, err
+	}
+	defer sub.Unsub()
+
+	tick := time.NewTicker(30 * time.Second)
+	defer tick.Stop()
+	eose := sub.EndOfStoredEvents
+	for {
+		select {
+		case <-ctx.Done():
+			return live, nil
+		case <-eose:
+			eose = nil
+			live = time.Now()
+		case <-tick.C:
+			if !live.IsZero() && conn.IsConnected() {
+				live = time.Now()
+			}
+		case ev, ok := <-sub.Events:
+			if !ok {
+				select {
+				case reason := <-sub.ClosedReason:
+					return live, fmt.Errorf("closed by relay: %s", reason)
+				default:
+					return live, errors.New("subscription closed")
+				}
+			}
+			r.received()
+			if seen.add(ev.ID) {
+				handle(ctx, ev)
+			}
+		}
+	}
+}
+
+// seenEvents remembers recently handled event IDs.
+type seenEvents struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+func (s *seenEvents) add(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, t := range s.ids {
+		if now.Sub(t) > seenTTL {
+			delete(s.ids, k)
+		}
+	}
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	s.ids[id] = now
+	return true
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
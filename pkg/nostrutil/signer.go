@@ -0,0 +1,192 @@
+package nostrutil
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip46"
+)
+
+// Signer holds a Nostr identity: it signs events and encrypts and
+// decrypts NIP-04 DMs as PubKey, without necessarily exposing the
+// private key.
+type Signer interface {
+	PubKey() string
+	SignEvent(ctx context.Context, ev *nostr.Event) error
+	NIP04Encrypt(ctx context.Context, recipientPub, plaintext string) (string, error)
+	NIP04Decrypt(ctx context.Context, senderPub, ciphertext string) (string, error)
+}
+
+// LocalSigner is a Signer for a private key held in memory.
+type LocalSigner struct {
+	privHex string
+	pubHex  string
+	key     *btcec.PrivateKey
+}
+
+// NewLocalSigner parses priv (hex or nsec).
+func NewLocalSigner(priv string) (*LocalSigner, error) {
+	parsed, err := ParsePrivKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(parsed.PrivHex)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := btcec.PrivKeyFromBytes(b)
+	return &LocalSigner{privHex: parsed.PrivHex, pubHex: parsed.PubHex, key: key}, nil
+}
+
+func (s *LocalSigner) PubKey() string { return s.pubHex }
+
+func (s *LocalSigner) SignEvent(_ context.Context, ev *nostr.Event) error {
+	return ev.Sign(s.privHex)
+}
+
+func (s *LocalSigner) NIP04Encrypt(_ context.Context, recipientPub, plaintext string) (string, error) {
+	shared, err := nip04.ComputeSharedSecret(recipientPub, s.privHex)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Encrypt(plaintext, shared)
+}
+
+func (s *LocalSigner) NIP04Decrypt(_ context.Context, senderPub, ciphertext string) (string, error) {
+	shared, err := nip04.ComputeSharedSecret(senderPub, s.privHex)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Decrypt(ciphertext, shared)
+}
+
+// SignHash returns the Schnorr signature of a 32-byte hash. Remote
+// signers only sign events, so this is specific to local keys.
+func (s *LocalSigner) SignHash(hash []byte) ([]byte, error) {
+	sig, err := schnorr.Sign(s.key, hash)
+	if err != nil {
+		return nil, err
+	}
+	return sig.Serialize(), nil
+}
+
+// bunkerTimeout bounds each request to a remote signer.
+const bunkerTimeout = 30 * time.Second
+
+// BunkerSigner is a Signer whose key lives in a NIP-46 remote signer
+// ("bunker"); every signature is a round trip over the bunker's relays.
+type BunkerSigner struct {
+	bunker *nip46.BunkerClient
+	pubHex string
+}
+
+// ConnectBunker connects to the remote signer at bunkerURL
+// (bunker://<pubkey>?relay=...&secret=...), identifying this app with
+// clientKey (hex or nsec), which the bunker must authorize. ctx bounds
+// the connection: the bunker's responses are received until it is done.
+func ConnectBunker(ctx context.Context, bunkerURL, clientKey string) (*BunkerSigner, error) {
+	u, err := url.Parse(bunkerURL)
+	if err != nil || u.Scheme != "bunker" {
+		return nil, fmt.Errorf("invalid bunker URL %q: want bunker://<pubkey>?relay=...", bunkerURL)
+	}
+	if !nostr.IsValidPublicKey(u.Host) {
+		return nil, fmt.Errorf("invalid bunker pubkey %q", u.Host)
+	}
+	relays := u.Query()["relay"]
+	if len(relays) == 0 {
+		return nil, fmt.Errorf("bunker URL has no relay")
+	}
+	client, err := ParsePrivKey(clientKey)
+	if err != nil {
+		return nil, fmt.Errorf("bunker client key: %w", err)
+	}
+
+	bunker := nip46.NewBunker(ctx, client.PrivHex, u.Host, relays, nil, func(authURL string) {
+		log.Printf("remote signer asks to authorize this app at %s\n", authURL)
+	})
+
+	rctx, cancel := context.WithTimeout(ctx, bunkerTimeout)
+	defer cancel()
+	if _, err := bunker.RPC(rctx, "connect", []string{u.Host, u.Query().Get("secret")}); err != nil {
+		return nil, fmt.Errorf("connect to bunker: %w", err)
+	}
+	pub, err := bunker.GetPublicKey(rctx)
+	if err != nil {
+		return nil, fmt.Errorf("bunker get_public_key: %w", err)
+	}
+	if !nostr.IsValidPublicKey(pub) {
+		return nil, fmt.Errorf("bunker returned invalid pubkey %q", pub)
+	}
+	return &BunkerSigner{bunker: bunker, pubHex: pub}, nil
+}
+
+func (s *BunkerSigner) PubKey() string { return s.pubHex }
+
+// SignEvent has the bunker sign ev, which must be ev.PubKey's: the
+// bunker only signs as its own key.
+func (s *BunkerSigner) SignEvent(ctx context.Context, ev *nostr.Event) error {
+	if ev.PubKey != "" && ev.PubKey != s.pubHex {
+		return fmt.Errorf("bunker signs as %s, not %s", s.pubHex, ev.PubKey)
+	}
+	ctx, cancel := context.WithTimeout(ctx, bunkerTimeout)
+	defer cancel()
+	if err := s.bunker.SignEvent(ctx, ev); err != nil {
+		return fmt.Errorf("bunker sign_event: %w", err)
+	}
+	if ev.PubKey != s.pubHex {
+		return fmt.Errorf("bunker signed as %s, not %s", ev.PubKey, s.pubHex)
+	}
+	return nil
+}
+
+func (s *BunkerSigner) NIP04Encrypt(ctx context.Context, recipientPub, plaintext string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, bunkerTimeout)
+	defer cancel()
+	return s.bunker.NIP04Encrypt(ctx, recipientPub, plaintext)
+}
+
+func (s *BunkerSigner) NIP04Decrypt(ctx context.Context, senderPub, ciphertext string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, bunkerTimeout)
+	defer cancel()
+	return s.bunker.NIP04Decrypt(ctx, senderPub, ciphertext)
+}
+
+// SignerFromEnv returns the signer configured by the env vars starting
+// with prefix (e.g. "MEERKAT_POOL"): the bunker at <prefix>_BUNKER_URL
+// if set, connected with <prefix>_BUNKER_CLIENT_KEY (a fresh key, which
+// the bunker has to authorize on every start, if unset); otherwise the
+// local key <prefix>_NOSTR_PRIVKEY. ctx bounds a bunker connection.
+func SignerFromEnv(ctx context.Context, prefix string) (Signer, error) {
+	if bunkerURL := os.Getenv(prefix + "_BUNKER_URL"); bunkerURL != "" {
+		clientKey := os.Getenv(prefix + "_BUNKER_CLIENT_KEY")
+		if clientKey == "" {
+			clientKey = nostr.GeneratePrivateKey()
+			pub, _ := nostr.GetPublicKey(clientKey)
+			log.Printf("%s_BUNKER_CLIENT_KEY not set; connecting to the bunker as new app key %s\n", prefix, pub)
+		}
+		s, err := ConnectBunker(ctx, bunkerURL, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s_BUNKER_URL: %w", prefix, err)
+		}
+		return s, nil
+	}
+
+	priv := os.Getenv(prefix + "_NOSTR_PRIVKEY")
+	if priv == "" {
+		return nil, fmt.Errorf("%s_NOSTR_PRIVKEY or %s_BUNKER_URL must be set", prefix, prefix)
+	}
+	s, err := NewLocalSigner(priv)
+	if err != nil {
+		return nil, fmt.Errorf("%s_NOSTR_PRIVKEY: %w", prefix, err)
+	}
+	return s, nil
+}
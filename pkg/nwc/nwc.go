@@ -0,0 +1,261 @@
+// Package nwc is a minimal Nostr Wallet Connect (NIP-47) client: it
+// parses nostr+walletconnect:// connection strings and pays invoices
+// through the wallet service with pay_invoice requests.
+package nwc
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// Event kinds defined by NIP-47.
+const (
+	KindRequest  = 23194
+	KindResponse = 23195
+)
+
+// URIScheme is the scheme of NWC connection strings.
+const URIScheme = "nostr+walletconnect"
+
+// Connection is a parsed NWC connection string.
+type Connection struct {
+	WalletPubKey string   // wallet service pubkey (hex)
+	Relays       []string // relays the wallet service listens on
+	Secret       string   // client secret key (hex) used to sign and encrypt requests
+	LUD16        string   // optional lightning address of the wallet
+}
+
+// ParseURI parses a connection string of the form
+//
+//	nostr+walletconnect://<wallet-pubkey>?relay=wss://...&secret=<hex>[&lud16=...]
+func ParseURI(uri string) (*Connection, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("nwc: parse connection string: %w", err)
+	}
+	if u.Scheme != URIScheme && u.Scheme != "nostrwalletconnect" {
+		return nil, fmt.Errorf("nwc: unexpected scheme %q", u.Scheme)
+	}
+
+	walletPub := u.Host
+	if walletPub == "" {
+		walletPub = strings.TrimPrefix(u.Opaque, "//")
+	}
+	if !isHexKey(walletPub) {
+		return nil, errors.New("nwc: connection string has no valid wallet pubkey")
+	}
+
+	q := u.Query()
+	c := &Connection{
+		WalletPubKey: strings.ToLower(walletPub),
+		Relays:       q["relay"],
+		Secret:       strings.ToLower(q.Get("secret")),
+		LUD16:        q.Get("lud16"),
+	}
+	if len(c.Relays) == 0 {
+		return nil, errors.New("nwc: connection string has no relay")
+	}
+	if !isHexKey(c.Secret) {
+		return nil, errors.New("nwc: connection string has no valid secret")
+	}
+	return c, nil
+}
+
+// Error is an error returned by the wallet service.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("nwc: wallet error %s: %s", e.Code, e.Message) }
+
+// ErrNoResponse means a request reached a relay but no usable response
+// came back: the wallet may or may not have acted on it.
+var ErrNoResponse = errors.New("nwc: no response from wallet")
+
+type request struct {
+	Method string `json:"method"`
+	Params any    `json:"params"`
+}
+
+type response struct {
+	ResultType string          `json:"result_type"`
+	Error      *Error          `json:"error,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+}
+
+// Client sends NIP-47 requests to a wallet service.
+type Client struct {
+	conn      Connection
+	clientPub string
+	shared    []byte
+
+	// Timeout bounds how long a request waits for the wallet's response.
+	Timeout time.Duration
+}
+
+// NewClient creates a Client for a parsed connection.
+func NewClient(conn *Connection) (*Client, error) {
+	pub, err := nostr.GetPublicKey(conn.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("nwc: derive client pubkey: %w", err)
+	}
+	shared, err := nip04.ComputeSharedSecret(conn.WalletPubKey, conn.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("nwc: compute shared secret: %w", err)
+	}
+	return &Client{conn: *conn, clientPub: pub, shared: shared, Timeout: 60 * time.Second}, nil
+}
+
+// NewClientFromURI parses uri and creates a Client.
+func NewClientFromURI(uri string) (*Client, error) {
+	conn, err := ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn)
+}
+
+// PayInvoice pays a BOLT11 invoice and returns the payment preimage.
+func (c *Client) PayInvoice(ctx context.Context, bolt11 string) (string, error) {
+	var result struct {
+		Preimage string `json:"preimage"`
+	}
+	params := map[string]any{"invoice": bolt11}
+	if err := c.call(ctx, "pay_invoice", params, &result); err != nil {
+		return "", err
+	}
+	return result.Preimage, nil
+}
+
+// call sends a request and waits for the wallet's response on the first
+// relay that accepts the request. Once a relay has taken the request it
+// isn't sent again elsewhere, since the wallet may already be paying it.
+func (c *Client) call(ctx context.Context, method string, params, out any) error {
+	plain, err := json.Marshal(request{Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	content, err := nip04.Encrypt(string(plain), c.shared)
+	if err != nil {
+		return fmt.Errorf("nwc: encrypt request: %w", err)
+	}
+
+	ev := nostr.Event{
+		PubKey:    c.clientPub,
+		CreatedAt: nostr.Now(),
+		Kind:      KindRequest,
+		Tags:      nostr.Tags{{"p", c.conn.WalletPubKey}},
+		Content:   content,
+	}
+	if err := ev.Sign(c.conn.Secret); err != nil {
+		return fmt.Errorf("nwc: sign request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var lastErr error
+	for _, relayURL := range c.conn.Relays {
+		resp, sent, err := c.callOnRelay(ctx, relayURL, ev)
+		if err != nil {
+			if sent {
+				return err
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if resp.ResultType != "" && resp.ResultType != method {
+			return fmt.Errorf("nwc: unexpected result_type %q for %s", resp.ResultType, method)
+		}
+		if out != nil && len(resp.Result) > 0 {
+			if err := json.Unmarshal(resp.Result, out); err != nil {
+				return fmt.Errorf("nwc: decode %s result: %w", method, err)
+			}
+		}
+		return nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("nwc: no relays")
+	}
+	return lastErr
+}
+
+// callOnRelay sends req through relayURL and waits for the response.
+// sent reports whether the relay took the request; after that, errors
+// wrap ErrNoResponse.
+func (c *Client) callOnRelay(ctx context.Context, relayURL string, req nostr.Event) (resp *response, sent bool, err error) {
+	relay, err := nostr.RelayConnect(ctx, relayURL)
+	if err != nil {
+		return nil, false, fmt.Errorf("nwc: connect %s: %w", relayURL, err)
+	}
+	defer relay.Close()
+
+	// Subscribe before publishing so a fast wallet's response isn't missed.
+	sub, err := relay.Subscribe(ctx, nostr.Filters{{
+		Kinds:   []int{KindResponse},
+		Authors: []string{c.conn.WalletPubKey},
+		Tags:    nostr.TagMap{"e": []string{req.ID}, "p": []string{c.clientPub}},
+	}})
+	if err != nil {
+		return nil, false, fmt.Errorf("nwc: subscribe on %s: %w", relayURL, err)
+	}
+	defer sub.Unsub()
+
+	if err := relay.Publish(ctx, req); err != nil {
+		if ctx.Err() != nil {
+			// Without an OK the relay may still have taken the request.
+			return nil, true, fmt.Errorf("%w: publish request to %s: %w", ErrNoResponse, relayURL, err)
+		}
+		return nil, false, fmt.Errorf("nwc: publish request to %s: %w", relayURL, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, true, fmt.Errorf("%w on %s: %w", ErrNoResponse, relayURL, ctx.Err())
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return nil, true, fmt.Errorf("%w: subscription on %s closed", ErrNoResponse, relayURL)
+			}
+			if ev.PubKey != c.conn.WalletPubKey {
+				continue
+			}
+			if ok, err := ev.CheckSignature(); err != nil || !ok {
+				continue
+			}
+			plain, err := nip04.Decrypt(ev.Content, c.shared)
+			if err != nil {
+				return nil, true, fmt.Errorf("%w: decrypt response: %w", ErrNoResponse, err)
+			}
+			var r response
+			if err := json.Unmarshal([]byte(plain), &r); err != nil {
+				return nil, true, fmt.Errorf("%w: decode response: %w", ErrNoResponse, err)
+			}
+			return &r, true, nil
+		}
+	}
+}
+
+func isHexKey(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,319 @@
+package nwc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// fakeRelay is an in-process relay. Requests published to it are handed
+// to wallet, whose response (if any) goes to the matching subscriptions.
+type fakeRelay struct {
+	t      *testing.T
+	srv    *httptest.Server
+	reject string // if set, requests are refused with this reason
+	wallet *fakeWallet
+
+	mu       sync.Mutex
+	conns    []*relayConn
+	requests []nostr.Event
+}
+
+type relayConn struct {
+	ws   *websocket.Conn
+	mu   sync.Mutex
+	subs map[string]nostr.Filters
+}
+
+func newFakeRelay(t *testing.T, wallet *fakeWallet) *fakeRelay {
+	r := &fakeRelay{t: t, wallet: wallet}
+	r.srv = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.srv.Close)
+	return r
+}
+
+func (r *fakeRelay) URL() string { return "ws" + strings.TrimPrefix(r.srv.URL, "http") }
+
+func (r *fakeRelay) Requests() []nostr.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]nostr.Event(nil), r.requests...)
+}
+
+func (r *fakeRelay) serve(w http.ResponseWriter, req *http.Request) {
+	ws, err := websocket.Accept(w, req, nil)
+	if err != nil {
+		return
+	}
+	defer ws.CloseNow()
+	c := &relayConn{ws: ws, subs: map[string]nostr.Filters{}}
+	r.mu.Lock()
+	r.conns = append(r.conns, c)
+	r.mu.Unlock()
+
+	ctx := req.Context()
+	for {
+		_, msg, err := ws.Read(ctx)
+		if err != nil {
+			return
+		}
+		switch env := nostr.ParseMessage(string(msg)).(type) {
+		case *nostr.ReqEnvelope:
+			c.mu.Lock()
+			c.subs[env.SubscriptionID] = env.Filters
+			c.mu.Unlock()
+			eose := nostr.EOSEEnvelope(env.SubscriptionID)
+			c.send(ctx, &eose)
+		case *nostr.CloseEnvelope:
+			c.mu.Lock()
+			delete(c.subs, string(*env))
+			c.mu.Unlock()
+		case *nostr.EventEnvelope:
+			if r.reject != "" {
+				c.send(ctx, &nostr.OKEnvelope{EventID: env.Event.ID, OK: false, Reason: r.reject})
+				continue
+			}
+			r.mu.Lock()
+			r.requests = append(r.requests, env.Event)
+			r.mu.Unlock()
+			c.send(ctx, &nostr.OKEnvelope{EventID: env.Event.ID, OK: true})
+			if resp := r.wallet.handle(r.t, env.Event); resp != nil {
+				r.broadcast(ctx, resp)
+			}
+		}
+	}
+}
+
+func (r *fakeRelay) broadcast(ctx context.Context, ev *nostr.Event) {
+	r.mu.Lock()
+	conns := append([]*relayConn(nil), r.conns...)
+	r.mu.Unlock()
+	for _, c := range conns {
+		c.mu.Lock()
+		var matched []string
+		for id, filters := range c.subs {
+			if filters.Match(ev) {
+				matched = append(matched, id)
+			}
+		}
+		c.mu.Unlock()
+		for _, id := range matched {
+			c.send(ctx, &nostr.EventEnvelope{SubscriptionID: &id, Event: *ev})
+		}
+	}
+}
+
+func (c *relayConn) send(ctx context.Context, env nostr.Envelope) {
+	b, _ := env.MarshalJSON()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.ws.Write(ctx, websocket.MessageText, b)
+}
+
+// fakeWallet is a wallet service answering NIP-47 requests with pay.
+// A nil pay never answers.
+type fakeWallet struct {
+	secret string
+	pub    string
+	pay    func(invoice string) (preimage string, err *Error)
+
+	mu       sync.Mutex
+	invoices []string
+}
+
+func newFakeWallet(pay func(string) (string, *Error)) *fakeWallet {
+	secret := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(secret)
+	return &fakeWallet{secret: secret, pub: pub, pay: pay}
+}
+
+func (w *fakeWallet) Invoices() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.invoices...)
+}
+
+func (w *fakeWallet) handle(t *testing.T, req nostr.Event) *nostr.Event {
+	if req.Kind != KindRequest || w.pay == nil {
+		return nil
+	}
+	shared, err := nip04.ComputeSharedSecret(req.PubKey, w.secret)
+	if err != nil {
+		t.Errorf("wallet: shared secret: %v", err)
+		return nil
+	}
+	plain, err := nip04.Decrypt(req.Content, shared)
+	if err != nil {
+		t.Errorf("wallet: decrypt request: %v", err)
+		return nil
+	}
+	var r struct {
+		Method string `json:"method"`
+		Params struct {
+			Invoice string `json:"invoice"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal([]byte(plain), &r); err != nil || r.Method != "pay_invoice" {
+		t.Errorf("wallet: unexpected request %s (%v)", plain, err)
+		return nil
+	}
+	w.mu.Lock()
+	w.invoices = append(w.invoices, r.Params.Invoice)
+	w.mu.Unlock()
+
+	resp := response{ResultType: r.Method}
+	preimage, walletErr := w.pay(r.Params.Invoice)
+	if walletErr != nil {
+		resp.Error = walletErr
+	} else {
+		resp.Result, _ = json.Marshal(map[string]string{"preimage": preimage})
+	}
+	b, _ := json.Marshal(resp)
+	content, err := nip04.Encrypt(string(b), shared)
+	if err != nil {
+		t.Errorf("wallet: encrypt response: %v", err)
+		return nil
+	}
+	ev := &nostr.Event{
+		CreatedAt: nostr.Now(),
+		Kind:      KindResponse,
+		Tags:      nostr.Tags{{"e", req.ID}, {"p", req.PubKey}},
+		Content:   content,
+	}
+	if err := ev.Sign(w.secret); err != nil {
+		t.Errorf("wallet: sign response: %v", err)
+		return nil
+	}
+	return ev
+}
+
+func testClient(t *testing.T, w *fakeWallet, relays ...*fakeRelay) *Client {
+	t.Helper()
+	conn := &Connection{WalletPubKey: w.pub, Secret: nostr.GeneratePrivateKey()}
+	for _, r := range relays {
+		conn.Relays = append(conn.Relays, r.URL())
+	}
+	c, err := NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Timeout = 5 * time.Second
+	return c
+}
+
+func paid(preimage string) func(string) (string, *Error) {
+	return func(string) (string, *Error) { return preimage, nil }
+}
+
+func TestPayInvoice(t *testing.T) {
+	w := newFakeWallet(paid("beef"))
+	r := newFakeRelay(t, w)
+	c := testClient(t, w, r)
+
+	preimage, err := c.PayInvoice(context.Background(), "lnbc10u1test")
+	if err != nil {
+		t.Fatalf("PayInvoice: %v", err)
+	}
+	if preimage != "beef" {
+		t.Errorf("preimage = %q, want beef", preimage)
+	}
+	if got := w.Invoices(); len(got) != 1 || got[0] != "lnbc10u1test" {
+		t.Errorf("wallet paid %q", got)
+	}
+}
+
+func TestPayInvoiceWalletError(t *testing.T) {
+	w := newFakeWallet(func(string) (string, *Error) {
+		return "", &Error{Code: "INSUFFICIENT_BALANCE", Message: "not enough sats"}
+	})
+	c := testClient(t, w, newFakeRelay(t, w))
+
+	_, err := c.PayInvoice(context.Background(), "lnbc10u1test")
+	var werr *Error
+	if !errors.As(err, &werr) || werr.Code != "INSUFFICIENT_BALANCE" {
+		t.Fatalf("PayInvoice: %v, want INSUFFICIENT_BALANCE", err)
+	}
+}
+
+func TestPayInvoiceTriesNextRelayWhenRejected(t *testing.T) {
+	w := newFakeWallet(paid("beef"))
+	refusing := newFakeRelay(t, w)
+	refusing.reject = "blocked: kind not allowed"
+	ok := newFakeRelay(t, w)
+	c := testClient(t, w, refusing, ok)
+
+	if _, err := c.PayInvoice(context.Background(), "lnbc10u1test"); err != nil {
+		t.Fatalf("PayInvoice: %v", err)
+	}
+	if n := len(ok.Requests()); n != 1 {
+		t.Errorf("second relay got %d requests, want 1", n)
+	}
+	if n := len(w.Invoices()); n != 1 {
+		t.Errorf("wallet paid %d times, want 1", n)
+	}
+}
+
+func TestPayInvoiceTriesNextRelayWhenUnreachable(t *testing.T) {
+	w := newFakeWallet(paid("beef"))
+	down := newFakeRelay(t, w)
+	down.srv.Close()
+	c := testClient(t, w, down, newFakeRelay(t, w))
+
+	if _, err := c.PayInvoice(context.Background(), "lnbc10u1test"); err != nil {
+		t.Fatalf("PayInvoice: %v", err)
+	}
+}
+
+func TestPayInvoiceNotResentAfterRelayTookIt(t *testing.T) {
+	silent := newFakeWallet(nil)
+	first := newFakeRelay(t, silent)
+	second := newFakeRelay(t, newFakeWallet(paid("beef")))
+	c := testClient(t, silent, first, second)
+	c.Timeout = 300 * time.Millisecond
+
+	_, err := c.PayInvoice(context.Background(), "lnbc10u1test")
+	if !errors.Is(err, ErrNoResponse) {
+		t.Fatalf("PayInvoice: %v, want ErrNoResponse", err)
+	}
+	if n := len(first.Requests()); n != 1 {
+		t.Errorf("first relay got %d requests, want 1", n)
+	}
+	if n := len(second.Requests()); n != 0 {
+		t.Errorf("request was resent to the second relay %d times", n)
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	pub := strings.Repeat("ab", 32)
+	secret := strings.Repeat("cd", 32)
+
+	c, err := ParseURI(URIScheme + "://" + pub + "?relay=wss://a.example&relay=wss://b.example&secret=" + secret + "&lud16=me@example.com")
+	if err != nil {
+		t.Fatalf("ParseURI: %v", err)
+	}
+	if c.WalletPubKey != pub || c.Secret != secret || c.LUD16 != "me@example.com" ||
+		len(c.Relays) != 2 || c.Relays[1] != "wss://b.example" {
+		t.Errorf("ParseURI = %+v", c)
+	}
+
+	for _, bad := range []string{
+		"https://" + pub + "?relay=wss://a.example&secret=" + secret,
+		URIScheme + "://nothex?relay=wss://a.example&secret=" + secret,
+		URIScheme + "://" + pub + "?secret=" + secret,
+		URIScheme + "://" + pub + "?relay=wss://a.example&secret=short",
+	} {
+		if _, err := ParseURI(bad); err == nil {
+			t.Errorf("ParseURI(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// AdminIssueRequest is the body of POST /admin/tokens/issue.
+type AdminIssueRequest struct {
+	NostrPubKey string   `json:"nostr_pubkey"`       // hex or npub
+	Plan        string   `json:"plan"`               // catalog plan, or "trial"
+	Duration    Duration `json:"duration,omitempty"` // default: the plan's
+}
+
+// AdminExtendRequest is the body of POST /admin/tokens/extend: the
+// subscription gets a successor token running Duration past its end.
+type AdminExtendRequest struct {
+	TokenID  string   `json:"token_id"`
+	Duration Duration `json:"duration"`
+}
+
+// AdminRevokeRequest is the body of POST /admin/tokens/revoke.
+type AdminRevokeRequest struct {
+	TokenID      string `json:"token_id"`
+	Subscription bool   `json:"subscription,omitempty"` // revoke every token of its subscription
+}
+
+// AdminRevokeResponse lists the tokens a revoke request revoked.
+type AdminRevokeResponse struct {
+	Revoked []string `json:"revoked"`
+}
+
+// AdminTokenRequest is the body of POST /admin/tokens/resend.
+type AdminTokenRequest struct {
+	TokenID string `json:"token_id"`
+}
+
+// AdminPricing is the body of GET and PUT /admin/pricing.
+type AdminPricing struct {
+	Plans      []PlanSpec `json:"plans"`
+	Persistent bool       `json:"persistent"` // false: edits are lost on restart
+}
+
+// AdminOutboxRetryRequest is the body of POST /admin/outbox/retry.
+type AdminOutboxRetryRequest struct {
+	ID string `json:"id"` // event ID of the DM
+}
+
+// Admin serves the operator API under /admin/. Every request carries a
+// NIP-98 Authorization header signed by one of the operator keys.
+//
+//	GET    /admin/tokens[?user=PUBKEY&active=1]  ledger entries
+//	POST   /admin/tokens/issue                    AdminIssueRequest
+//	POST   /admin/tokens/extend                   AdminExtendRequest
+//	POST   /admin/tokens/revoke                   AdminRevokeRequest
+//	POST   /admin/tokens/resend                   AdminTokenRequest
+//	GET    /admin/invoices                        pending invoices
+//	GET    /admin/nodes                           approved nodes
+//	POST   /admin/nodes                           approve a discovery.StaticNodeEntry
+//	DELETE /admin/nodes?id=ID                     remove a node
+//	GET    /admin/pricing                         AdminPricing
+//	PUT    /admin/pricing                         replace the plans
+//	GET    /admin/outbox[?all=1]                  undelivered (or all) DMs
+//	POST   /admin/outbox/retry                    AdminOutboxRetryRequest
+//	GET    /admin/relays                          relay health
+type Admin struct {
+	s         *Server
+	operators map[string]bool
+	hosts     []string // hosts the auth event's URL may name
+
+	mu   sync.Mutex
+	seen map[string]time.Time // auth event IDs, against replays
+	mux  *http.ServeMux
+}
+
+// NewAdmin returns the admin API of s, open to the operator pubkeys
+// (hex or npub). Auth events must be signed for a URL on the host of
+// s.PublicURL or one of hosts (host[:port]), never whatever Host header
+// a request carries.
+func NewAdmin(s *Server, operators, hosts []string) (*Admin, error) {
+	if len(operators) == 0 {
+		return nil, errors.New("no operator pubkeys")
+	}
+	if u, err := url.Parse(s.PublicURL); err == nil && u.Host != "" {
+		hosts = append([]string{u.Host}, hosts...)
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no admin hosts: set the pool's public URL or admin hosts")
+	}
+	a := &Admin{s: s, operators: map[string]bool{}, hosts: hosts, seen: map[string]time.Time{}, mux: http.NewServeMux()}
+	for _, op := range operators {
+		pub, err := nostrutil.ParsePubKey(op)
+		if err != nil {
+			return nil, fmt.Errorf("operator %q: %w", op, err)
+		}
+		a.operators[pub] = true
+	}
+
+	a.mux.HandleFunc("/admin/tokens", a.tokens)
+	a.mux.HandleFunc("/admin/tokens/issue", a.issue)
+	a.mux.HandleFunc("/admin/tokens/extend", a.extend)
+	a.mux.HandleFunc("/admin/tokens/revoke", a.revoke)
+	a.mux.HandleFunc("/admin/tokens/resend", a.resend)
+	a.mux.HandleFunc("/admin/invoices", a.invoiceList)
+	a.mux.HandleFunc("/admin/nodes", a.nodes)
+	a.mux.HandleFunc("/admin/pricing", a.pricing)
+	a.mux.HandleFunc("/admin/outbox", a.outbox)
+	a.mux.HandleFunc("/admin/outbox/retry", a.outboxRetry)
+	a.mux.HandleFunc("/admin/relays", a.relays)
+	return a, nil
+}
+
+// AdminPubKeysFromEnv returns the operator keys in
+// MEERKAT_POOL_ADMIN_PUBKEYS (comma-separated npubs or hex), or nil.
+func AdminPubKeysFromEnv() []string {
+	return splitEnvList("MEERKAT_POOL_ADMIN_PUBKEYS")
+}
+
+// AdminHostsFromEnv returns MEERKAT_POOL_ADMIN_HOSTS (comma-separated
+// host[:port]): the hosts admin requests may be addressed to besides the
+// pool's public URL, e.g. "127.0.0.1:8080" for meerkat-admin on the
+// pool's machine.
+func AdminHostsFromEnv() []string {
+	return splitEnvList("MEERKAT_POOL_ADMIN_HOSTS")
+}
+
+func splitEnvList(name string) []string {
+	var out []string
+	for _, p := range strings.Split(os.Getenv(name), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ServeHTTP authenticates the request and dispatches it.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	op, err := a.authenticate(r, body)
+	if err != nil {
+		log.Printf("[admin] %s %s refused: %v\n", r.Method, r.URL.Path, err)
+		w.Header().Set("WWW-Authenticate", "Nostr")
+		http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	log.Printf("[admin] %s %s by %s\n", r.Method, r.URL.RequestURI(), op.PubKey)
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	a.mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authEventKey{}, op.ID)))
+}
+
+type authEventKey struct{}
+
+// adminSource is the ledger source of a token issued by an admin
+// request: the request's auth event, which is never accepted twice.
+func adminSource(r *http.Request) Source {
+	id, _ := r.Context().Value(authEventKey{}).(string)
+	return Source{Kind: SourceAdmin, ID: id}
+}
+
+func (a *Admin) authenticate(r *http.Request, body []byte) (*nostr.Event, error) {
+	now := time.Now()
+	ev, err := nostrutil.VerifyHTTPAuth(r.Header.Get("Authorization"), nostrutil.HTTPAuthRequest{
+		Method:     r.Method,
+		RequestURI: r.URL.RequestURI(),
+		Hosts:      a.hosts,
+		Body:       body,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+	if !a.operators[ev.PubKey] {
+		return nil, fmt.Errorf("%s is not an operator", ev.PubKey)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, t := range a.seen {
+		if now.Sub(t) > 2*nostrutil.HTTPAuthWindow {
+			delete(a.seen, id)
+		}
+	}
+	if _, ok := a.seen[ev.ID]; ok {
+		return nil, errors.New("auth event already used")
+	}
+	a.seen[ev.ID] = now
+	return ev, nil
+}
+
+func (a *Admin) tokens(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	user := r.URL.Query().Get("user")
+	if user != "" {
+		pub, err := nostrutil.ParsePubKey(user)
+		if err != nil {
+			http.Error(w, "bad user: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		user = pub
+	}
+	active := r.URL.Query().Get("active") == "1"
+
+	now := time.Now().Unix()
+	out := []LedgerEntry{}
+	for _, e := range a.s.Ledger.Entries() {
+		if user != "" && e.Token.Payload.UserPubKey != user {
+			continue
+		}
+		if active && (e.RevokedAt != 0 || e.Token.Payload.ExpiresAt <= now) {
+			continue
+		}
+		out = append(out, e)
+	}
+	writeAdminJSON(w, out)
+}
+
+func (a *Admin) issue(w http.ResponseWriter, r *http.Request) {
+	var req AdminIssueRequest
+	if !allowMethod(w, r, http.MethodPost) || !decodeAdmin(w, r, &req) {
+		return
+	}
+	userPub, err := nostrutil.ParsePubKey(req.NostrPubKey)
+	if err != nil {
+		http.Error(w, "bad nostr_pubkey: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	plan, ok := a.s.plan(req.Plan)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown plan %q", req.Plan), http.StatusBadRequest)
+		return
+	}
+	d := time.Duration(plan.Duration)
+	if req.Duration > 0 {
+		d = time.Duration(req.Duration)
+	}
+
+	now := time.Now()
+	src := adminSource(r)
+	if _, ok := a.s.Ledger.claim(src); !ok {
+		http.Error(w, ErrAlreadyIssued.Error(), http.StatusConflict)
+		return
+	}
+	tok, err := a.s.deliver(src, vpn.SubscriptionPayload{
+		TokenID:          "sub_" + uuid.New().String(),
+		UserPubKey:       userPub,
+		SubscriptionType: plan.ID,
+		Tier:             plan.Tier,
+		IssuedAt:         now.Unix(),
+		ExpiresAt:        now.Add(d).Unix(),
+		Nonce:            uuid.New().String(),
+		IssuerPubKey:     a.s.PoolPubHex,
+		PlanLimits:       plan.Limits(),
+	}, TokenOrigin{}, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminJSON(w, tok)
+}
+
+func (a *Admin) extend(w http.ResponseWriter, r *http.Request) {
+	var req AdminExtendRequest
+	if !allowMethod(w, r, http.MethodPost) || !decodeAdmin(w, r, &req) {
+		return
+	}
+	if req.Duration <= 0 {
+		http.Error(w, "missing duration", http.StatusBadRequest)
+		return
+	}
+
+	a.s.renewMu.Lock()
+	defer a.s.renewMu.Unlock()
+	head, ok := a.s.Ledger.Head(req.TokenID)
+	if !ok {
+		http.Error(w, ErrUnknownToken.Error(), http.StatusNotFound)
+		return
+	}
+	if head.RevokedAt != 0 {
+		http.Error(w, "subscription is revoked", http.StatusConflict)
+		return
+	}
+	prev := head.Token.Payload
+	now := time.Now()
+	start := time.Unix(prev.ExpiresAt, 0)
+	if start.Before(now) {
+		start = now
+	}
+
+	src := adminSource(r)
+	if _, ok := a.s.Ledger.claim(src); !ok {
+		http.Error(w, ErrAlreadyIssued.Error(), http.StatusConflict)
+		return
+	}
+	tok, err := a.s.deliver(src, vpn.SubscriptionPayload{
+		TokenID:          "sub_" + uuid.New().String(),
+		UserPubKey:       prev.UserPubKey,
+		SubscriptionType: prev.SubscriptionType,
+		Tier:             prev.Tier,
+		IssuedAt:         now.Unix(),
+		ExpiresAt:        start.Add(time.Duration(req.Duration)).Unix(),
+		Nonce:            uuid.New().String(),
+		IssuerPubKey:     a.s.PoolPubHex,
+		PreviousTokenID:  prev.TokenID,
+		SubscriptionID:   prev.Subscription(),
+		PlanLimits:       prev.PlanLimits,
+	}, TokenOrigin{}, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminJSON(w, tok)
+}
+
+func (a *Admin) revoke(w http.ResponseWriter, r *http.Request) {
+	var req AdminRevokeRequest
+	if !allowMethod(w, r, http.MethodPost) || !decodeAdmin(w, r, &req) {
+		return
+	}
+	entry, ok := a.s.Ledger.Token(req.TokenID)
+	if !ok {
+		http.Error(w, ErrUnknownToken.Error(), http.StatusNotFound)
+		return
+	}
+	revoke := []string{}
+	if req.Subscription {
+		for _, e := range a.s.Ledger.Chain(req.TokenID) {
+			if e.RevokedAt == 0 {
+				revoke = append(revoke, e.Token.Payload.TokenID)
+			}
+		}
+	} else if entry.RevokedAt == 0 {
+		revoke = append(revoke, req.TokenID)
+	}
+	a.s.Ledger.Revoke(revoke, time.Now())
+	log.Printf("[admin] revoked %d token(s): %s\n", len(revoke), strings.Join(revoke, ", "))
+	writeAdminJSON(w, AdminRevokeResponse{Revoked: revoke})
+}
+
+func (a *Admin) resend(w http.ResponseWriter, r *http.Request) {
+	var req AdminTokenRequest
+	if !allowMethod(w, r, http.MethodPost) || !decodeAdmin(w, r, &req) {
+		return
+	}
+	entry, ok := a.s.Ledger.Token(req.TokenID)
+	if !ok {
+		http.Error(w, ErrUnknownToken.Error(), http.StatusNotFound)
+		return
+	}
+	var tags nostr.Tags
+	if entry.Source.Kind == SourceInvoice && entry.Source.ID != "" {
+		tags = nostr.Tags{{InvoiceDMTag, entry.Source.ID}}
+	}
+	if err := a.s.sendSubscriptionDM(entry.Token.Payload.UserPubKey, TokenDM{SubscriptionToken: entry.Token}, tags); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeAdminJSON(w, entry)
+}
+
+func (a *Admin) invoiceList(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	out := []PendingInvoice{}
+	if a.s.invoices != nil {
+		out = append(out, a.s.invoices.list()...)
+	}
+	writeAdminJSON(w, out)
+}
+
+func (a *Admin) nodes(w http.ResponseWriter, r *http.Request) {
+	reg := a.s.Registry
+	if reg == nil {
+		http.Error(w, "node registry not enabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminJSON(w, reg.Nodes())
+	case http.MethodPost:
+		var entry discovery.StaticNodeEntry
+		if !decodeAdmin(w, r, &entry) {
+			return
+		}
+		if err := reg.Approve(entry); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[admin] approved node %s (%s)\n", entry.ID, entry.APIURL)
+		writeAdminJSON(w, reg.Nodes())
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if err := reg.Remove(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("[admin] removed node %s\n", id)
+		writeAdminJSON(w, reg.Nodes())
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *Admin) pricing(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req AdminPricing
+		if !decodeAdmin(w, r, &req) {
+			return
+		}
+		if err := a.s.Catalog.SetPlans(req.Plans); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[admin] catalog replaced (%d plans)\n", len(req.Plans))
+		go a.s.publishPricing()
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeAdminJSON(w, AdminPricing{Plans: a.s.Catalog.Plans(), Persistent: a.s.Catalog.Persistent()})
+}
+
+func (a *Admin) outbox(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if a.s.Outbox == nil {
+		http.Error(w, "outbox not enabled", http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("all") == "1" {
+		writeAdminJSON(w, a.s.Outbox.All())
+		return
+	}
+	writeAdminJSON(w, a.s.Outbox.Undelivered())
+}
+
+func (a *Admin) outboxRetry(w http.ResponseWriter, r *http.Request) {
+	var req AdminOutboxRetryRequest
+	if !allowMethod(w, r, http.MethodPost) || !decodeAdmin(w, r, &req) {
+		return
+	}
+	if a.s.Outbox == nil || !a.s.Outbox.Retry(req.ID) {
+		http.Error(w, "no undelivered DM with that ID", http.StatusNotFound)
+		return
+	}
+	writeAdminJSON(w, a.s.Outbox.Undelivered())
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func decodeAdmin(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (a *Admin) relays(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeAdminJSON(w, a.s.Nostr.Relays.Health())
+}
@@ -0,0 +1,432 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// CashuProof is a Cashu ecash proof (NUT-00).
+type CashuProof struct {
+	Amount int64  `json:"amount"`
+	ID     string `json:"id"`     // keyset ID
+	Secret string `json:"secret"` // the secret the mint signed
+	C      string `json:"C"`      // unblinded signature (hex point)
+}
+
+// CashuToken is a decoded, serialized Cashu token.
+type CashuToken struct {
+	Mint   string
+	Unit   string
+	Memo   string
+	Proofs []CashuProof
+}
+
+// Amount returns the sum of the token's proofs.
+func (t *CashuToken) Amount() int64 {
+	var sum int64
+	for _, p := range t.Proofs {
+		sum += p.Amount
+	}
+	return sum
+}
+
+// ID identifies the token by a hash of its proofs' secrets.
+func (t *CashuToken) ID() string {
+	secrets := make([]string, len(t.Proofs))
+	for i, p := range t.Proofs {
+		secrets[i] = p.Secret
+	}
+	sort.Strings(secrets)
+	h := sha256.Sum256([]byte(strings.Join(secrets, "\n")))
+	return hex.EncodeToString(h[:16])
+}
+
+// ParseCashuToken decodes a V3 ("cashuA...") Cashu token. All proofs
+// must come from a single mint.
+func ParseCashuToken(s string) (*CashuToken, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "cashu:")
+	if strings.HasPrefix(s, "cashuB") {
+		return nil, errors.New("cashu: V4 (cashuB) tokens are not supported yet; send a V3 (cashuA) token")
+	}
+	if !strings.HasPrefix(s, "cashuA") {
+		return nil, errors.New("cashu: not a cashuA token")
+	}
+	enc := strings.TrimRight(s[len("cashuA"):], "=")
+
+	raw, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		// Some wallets use the standard alphabet.
+		if raw, err = base64.RawStdEncoding.DecodeString(enc); err != nil {
+			return nil, fmt.Errorf("cashu: decode token: %w", err)
+		}
+	}
+
+	var v3 struct {
+		Token []struct {
+			Mint   string       `json:"mint"`
+			Proofs []CashuProof `json:"proofs"`
+		} `json:"token"`
+		Unit string `json:"unit"`
+		Memo string `json:"memo"`
+	}
+	if err := json.Unmarshal(raw, &v3); err != nil {
+		return nil, fmt.Errorf("cashu: decode token JSON: %w", err)
+	}
+
+	t := &CashuToken{Unit: v3.Unit, Memo: v3.Memo}
+	if t.Unit == "" {
+		t.Unit = "sat"
+	}
+	for _, entry := range v3.Token {
+		if len(entry.Proofs) == 0 {
+			continue
+		}
+		if t.Mint != "" && normalizeMintURL(entry.Mint) != t.Mint {
+			return nil, errors.New("cashu: token spans several mints")
+		}
+		t.Mint = normalizeMintURL(entry.Mint)
+		t.Proofs = append(t.Proofs, entry.Proofs...)
+	}
+	if len(t.Proofs) == 0 {
+		return nil, errors.New("cashu: token has no proofs")
+	}
+	for _, p := range t.Proofs {
+		if p.Amount <= 0 || p.Secret == "" || p.C == "" || p.ID == "" {
+			return nil, errors.New("cashu: malformed proof")
+		}
+	}
+	return t, nil
+}
+
+func normalizeMintURL(u string) string {
+	return strings.TrimRight(strings.TrimSpace(u), "/")
+}
+
+// Mint redeems ecash: it claims the proofs for the pool (so they can't
+// be spent again) and returns the amount the pool received.
+type Mint interface {
+	URL() string
+	Redeem(ctx context.Context, proofs []CashuProof) (received int64, err error)
+}
+
+// CashuMint redeems proofs at a Cashu mint by swapping them (NUT-03)
+// for fresh proofs owned by the pool, which are appended to a wallet
+// file so the pool can melt or spend them later.
+type CashuMint struct {
+	url        string
+	walletPath string
+	http       *http.Client
+}
+
+// cashuWalletMu serializes wallet file writes; mints may share a file.
+var cashuWalletMu sync.Mutex
+
+// NewCashuMint returns a CashuMint for mintURL. Redeemed proofs are
+// stored in walletPath.
+func NewCashuMint(mintURL, walletPath string) *CashuMint {
+	return &CashuMint{
+		url:        normalizeMintURL(mintURL),
+		walletPath: walletPath,
+		http:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (m *CashuMint) URL() string { return m.url }
+
+type cashuKeyset struct {
+	ID          string `json:"id"`
+	Unit        string `json:"unit"`
+	Active      bool   `json:"active"`
+	InputFeePPK int64  `json:"input_fee_ppk"`
+}
+
+type cashuBlindedMessage struct {
+	Amount int64  `json:"amount"`
+	ID     string `json:"id"`
+	B      string `json:"B_"`
+}
+
+type cashuBlindSignature struct {
+	Amount int64  `json:"amount"`
+	ID     string `json:"id"`
+	C      string `json:"C_"`
+}
+
+func (m *CashuMint) Redeem(ctx context.Context, proofs []CashuProof) (int64, error) {
+	var keysets struct {
+		Keysets []cashuKeyset `json:"keysets"`
+	}
+	if err := m.do(ctx, http.MethodGet, "/v1/keysets", nil, &keysets); err != nil {
+		return 0, err
+	}
+
+	fees := map[string]int64{}
+	var active *cashuKeyset
+	for i, ks := range keysets.Keysets {
+		fees[ks.ID] = ks.InputFeePPK
+		if ks.Active && ks.Unit == "sat" && active == nil {
+			active = &keysets.Keysets[i]
+		}
+	}
+	if active == nil {
+		return 0, errors.New("cashu: mint has no active sat keyset")
+	}
+
+	// NUT-02: fee is the sum of the inputs' per-proof fees, rounded up.
+	var total, feePPK int64
+	for _, p := range proofs {
+		total += p.Amount
+		feePPK += fees[p.ID]
+	}
+	received := total - (feePPK+999)/1000
+	if received <= 0 {
+		return 0, errors.New("cashu: token doesn't cover the mint's fees")
+	}
+
+	var keys struct {
+		Keysets []struct {
+			ID   string            `json:"id"`
+			Keys map[string]string `json:"keys"`
+		} `json:"keysets"`
+	}
+	if err := m.do(ctx, http.MethodGet, "/v1/keys/"+active.ID, nil, &keys); err != nil {
+		return 0, err
+	}
+	if len(keys.Keysets) == 0 {
+		return 0, fmt.Errorf("cashu: mint returned no keys for keyset %s", active.ID)
+	}
+	mintKeys := keys.Keysets[0].Keys
+
+	// Build blinded outputs for the received amount, split into powers of two.
+	type pending struct {
+		secret string
+		r      *btcec.PrivateKey
+	}
+	var (
+		outputs []cashuBlindedMessage
+		blinds  []pending
+	)
+	for _, amt := range splitAmount(received) {
+		secret, err := randomHex(32)
+		if err != nil {
+			return 0, err
+		}
+		B, r, err := blindMessage([]byte(secret))
+		if err != nil {
+			return 0, err
+		}
+		outputs = append(outputs, cashuBlindedMessage{Amount: amt, ID: active.ID, B: hex.EncodeToString(B.SerializeCompressed())})
+		blinds = append(blinds, pending{secret: secret, r: r})
+	}
+
+	var swap struct {
+		Signatures []cashuBlindSignature `json:"signatures"`
+	}
+	req := map[string]any{"inputs": proofs, "outputs": outputs}
+	if err := m.do(ctx, http.MethodPost, "/v1/swap", req, &swap); err != nil {
+		return 0, err
+	}
+	if len(swap.Signatures) != len(outputs) {
+		return 0, fmt.Errorf("cashu: mint returned %d signatures for %d outputs", len(swap.Signatures), len(outputs))
+	}
+
+	// The inputs are spent now: from here on, never fail without saving
+	// what we got.
+	var fresh []CashuProof
+	for i, sig := range swap.Signatures {
+		C, err := unblindSignature(sig, mintKeys, blinds[i].r)
+		if err != nil {
+			log.Printf("cashu: unblind signature %d from %s: %v\n", i, m.url, err)
+			continue
+		}
+		fresh = append(fresh, CashuProof{Amount: sig.Amount, ID: sig.ID, Secret: blinds[i].secret, C: C})
+	}
+	if err := m.saveProofs(fresh); err != nil {
+		log.Printf("cashu: could not save redeemed proofs (%v); proofs: %+v\n", err, fresh)
+	}
+	return received, nil
+}
+
+// saveProofs appends proofs to the wallet file.
+func (m *CashuMint) saveProofs(proofs []CashuProof) error {
+	if m.walletPath == "" || len(proofs) == 0 {
+		return nil
+	}
+	cashuWalletMu.Lock()
+	defer cashuWalletMu.Unlock()
+
+	wallet := map[string][]CashuProof{}
+	if b, err := os.ReadFile(m.walletPath); err == nil {
+		if err := json.Unmarshal(b, &wallet); err != nil {
+			return fmt.Errorf("parse %s: %w", m.walletPath, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	wallet[m.url] = append(wallet[m.url], proofs...)
+
+	b, err := json.MarshalIndent(wallet, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.walletPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.walletPath)
+}
+
+func (m *CashuMint) do(ctx context.Context, method, path string, body, out any) error {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, m.url+path, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := m.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("cashu: %s: %w", m.url, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("cashu: read response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		var merr struct {
+			Detail string `json:"detail"`
+			Code   int    `json:"code"`
+		}
+		if json.Unmarshal(raw, &merr) == nil && merr.Detail != "" {
+			return fmt.Errorf("cashu: mint error %d: %s", merr.Code, merr.Detail)
+		}
+		return fmt.Errorf("cashu: %s %s: %s", method, path, resp.Status)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("cashu: decode response: %w", err)
+	}
+	return nil
+}
+
+// ---- NUT-00 blind Diffie-Hellman key exchange ----
+
+var hashToCurveDomain = []byte("Secp256k1_HashToCurve_Cashu_")
+
+// hashToCurve maps a secret to a curve point Y.
+func hashToCurve(msg []byte) (*btcec.PublicKey, error) {
+	h := sha256.Sum256(append(append([]byte{}, hashToCurveDomain...), msg...))
+	var counter [4]byte
+	for i := uint32(0); i < 1<<16; i++ {
+		binary.LittleEndian.PutUint32(counter[:], i)
+		candidate := sha256.Sum256(append(h[:], counter[:]...))
+		if pk, err := btcec.ParsePubKey(append([]byte{0x02}, candidate[:]...)); err == nil {
+			return pk, nil
+		}
+	}
+	return nil, errors.New("cashu: no valid point found")
+}
+
+// blindMessage returns B_ = Y + rG for a fresh blinding factor r.
+func blindMessage(secret []byte) (*btcec.PublicKey, *btcec.PrivateKey, error) {
+	Y, err := hashToCurve(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := btcec.NewPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var y, rG, b btcec.JacobianPoint
+	Y.AsJacobian(&y)
+	btcec.ScalarBaseMultNonConst(&r.Key, &rG)
+	btcec.AddNonConst(&y, &rG, &b)
+	b.ToAffine()
+	return btcec.NewPublicKey(&b.X, &b.Y), r, nil
+}
+
+// unblindSignature returns C = C_ - rK, with K the mint's key for the amount.
+func unblindSignature(sig cashuBlindSignature, keys map[string]string, r *btcec.PrivateKey) (string, error) {
+	kHex, ok := keys[strconv.FormatInt(sig.Amount, 10)]
+	if !ok {
+		return "", fmt.Errorf("no mint key for amount %d", sig.Amount)
+	}
+	kBytes, err := hex.DecodeString(kHex)
+	if err != nil {
+		return "", err
+	}
+	K, err := btcec.ParsePubKey(kBytes)
+	if err != nil {
+		return "", err
+	}
+	cBytes, err := hex.DecodeString(sig.C)
+	if err != nil {
+		return "", err
+	}
+	Cb, err := btcec.ParsePubKey(cBytes)
+	if err != nil {
+		return "", err
+	}
+
+	var k, rK, cb, c btcec.JacobianPoint
+	K.AsJacobian(&k)
+	btcec.ScalarMultNonConst(&r.Key, &k, &rK)
+	rK.ToAffine()
+	rK.Y.Negate(1).Normalize()
+	Cb.AsJacobian(&cb)
+	btcec.AddNonConst(&cb, &rK, &c)
+	c.ToAffine()
+	return hex.EncodeToString(btcec.NewPublicKey(&c.X, &c.Y).SerializeCompressed()), nil
+}
+
+// splitAmount splits n into powers of two, largest first.
+func splitAmount(n int64) []int64 {
+	var out []int64
+	for bit := int64(1); n > 0; bit <<= 1 {
+		if n&bit != 0 {
+			out = append(out, bit)
+			n &^= bit
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] > out[j] })
+	return out
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
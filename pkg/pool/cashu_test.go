@@ -0,0 +1,53 @@
+package pool
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestParseCashuToken(t *testing.T) {
+	raw := cashuToken(t, testMintURL+"/", 8, 2)
+	tok, err := ParseCashuToken("cashu:" + raw + "\n")
+	if err != nil {
+		t.Fatalf("ParseCashuToken: %v", err)
+	}
+	if tok.Mint != testMintURL || tok.Unit != "sat" || len(tok.Proofs) != 2 || tok.Amount() != 10 {
+		t.Errorf("ParseCashuToken = mint %q unit %q, %d proofs, %d sats", tok.Mint, tok.Unit, len(tok.Proofs), tok.Amount())
+	}
+
+	// The ID doesn't depend on the order of the proofs.
+	swapped := *tok
+	swapped.Proofs = []CashuProof{tok.Proofs[1], tok.Proofs[0]}
+	if swapped.ID() != tok.ID() {
+		t.Error("token ID depends on proof order")
+	}
+	if other, _ := ParseCashuToken(cashuToken(t, testMintURL, 8, 2)); other == nil || other.ID() == tok.ID() {
+		t.Error("different proofs have the same token ID")
+	}
+
+	// Some wallets use the standard base64 alphabet.
+	b, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(raw, "cashuA"))
+	if _, err := ParseCashuToken("cashuA" + base64.StdEncoding.EncodeToString(b)); err != nil {
+		t.Errorf("standard base64: %v", err)
+	}
+}
+
+func TestParseCashuTokenRejects(t *testing.T) {
+	v3 := func(s string) string { return "cashuA" + base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for name, s := range map[string]string{
+		"V4":          "cashuBo2FteBtodHRwczovL21pbnQuZXhhbXBsZQ",
+		"not cashu":   "lnbc10u1test",
+		"bad base64":  "cashuA!!!",
+		"bad JSON":    v3(`{"token":`),
+		"no proofs":   v3(`{"token":[{"mint":"https://m","proofs":[]}]}`),
+		"zero amount": v3(`{"token":[{"mint":"https://m","proofs":[{"amount":0,"id":"k","secret":"s","C":"c"}]}]}`),
+		"no secret":   v3(`{"token":[{"mint":"https://m","proofs":[{"amount":1,"id":"k","C":"c"}]}]}`),
+		"two mints": v3(`{"token":[{"mint":"https://a","proofs":[{"amount":1,"id":"k","secret":"s","C":"c"}]},` +
+			`{"mint":"https://b","proofs":[{"amount":1,"id":"k","secret":"t","C":"c"}]}]}`),
+	} {
+		if _, err := ParseCashuToken(s); err == nil {
+			t.Errorf("%s: ParseCashuToken succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// DefaultTier is the tier of plans that don't name one.
+const DefaultTier = "full"
+
+// fiatSlippage is how far below a fiat plan's current sat price an
+// amount may fall and still pay for it, when the plan is inferred from
+// an amount (zaps, Cashu): the payer converted at an older rate.
+const fiatSlippage = 0.02
+
+// Duration is a time.Duration that reads from JSON as "720h", "30d" or
+// a number of seconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var secs int64
+	if err := json.Unmarshal(b, &secs); err == nil {
+		*d = Duration(time.Duration(secs) * time.Second)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string or seconds: %w", err)
+	}
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ParseDuration is time.ParseDuration that also accepts whole days ("30d").
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// FiatPrice is a price in a fiat currency.
+type FiatPrice struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"` // ISO 4217, e.g. "USD"
+}
+
+// PlanSpec is a plan in the catalog: what a subscription costs and what
+// it entitles its holder to. Zero limits mean unlimited; empty region
+// and backend lists allow all. The limits are carried in the plan's
+// tokens and enforced by nodes.
+type PlanSpec struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name,omitempty"`
+	Duration       Duration   `json:"duration"`
+	Tier           string     `json:"tier,omitempty"`
+	DeviceLimit    int        `json:"device_limit,omitempty"`
+	BandwidthCapGB int64      `json:"bandwidth_cap_gb,omitempty"`
+	Regions        []string   `json:"regions,omitempty"`
+	Backends       []string   `json:"backends,omitempty"`
+	PriceSats      int64      `json:"price_sats,omitempty"`
+	PriceFiat      *FiatPrice `json:"price_fiat,omitempty"`
+}
+
+// Limits returns the plan's limits, which its tokens carry.
+func (p PlanSpec) Limits() vpn.PlanLimits {
+	return vpn.PlanLimits{
+		DeviceLimit:    p.DeviceLimit,
+		BandwidthCapGB: p.BandwidthCapGB,
+		Regions:        p.Regions,
+		Backends:       p.Backends,
+	}
+}
+
+// RateSource converts fiat prices: it returns the price of one bitcoin
+// in currency.
+type RateSource interface {
+	BTCPrice(ctx context.Context, currency string) (float64, error)
+}
+
+// Catalog is the set of plans the pool sells. It drives the pricing
+// event, invoice amounts and the tokens minted for each plan.
+type Catalog struct {
+	rates RateSource
+	path  string // file SetPlans saves to, if loaded from one
+
+	mu    sync.RWMutex
+	plans []PlanSpec
+}
+
+// NewCatalog validates plans and returns a catalog. rates converts fiat
+// prices and may be nil if every plan is priced in sats.
+func NewCatalog(plans []PlanSpec, rates RateSource) (*Catalog, error) {
+	if err := checkPlans(plans, rates); err != nil {
+		return nil, err
+	}
+	return &Catalog{plans: plans, rates: rates}, nil
+}
+
+// checkPlans validates plans and fills in defaults.
+func checkPlans(plans []PlanSpec, rates RateSource) error {
+	if len(plans) == 0 {
+		return errors.New("catalog has no plans")
+	}
+	seen := map[string]bool{}
+	for i := range plans {
+		p := &plans[i]
+		switch {
+		case p.ID == "":
+			return fmt.Errorf("plan %d has no id", i)
+		case seen[p.ID]:
+			return fmt.Errorf("duplicate plan id %q", p.ID)
+		case p.ID == TrialPlanID:
+			return fmt.Errorf("plan id %q is reserved for free trials", p.ID)
+		case p.Duration <= 0:
+			return fmt.Errorf("plan %q has no duration", p.ID)
+		case p.PriceSats < 0 || (p.PriceSats > 0) == (p.PriceFiat != nil):
+			return fmt.Errorf("plan %q needs exactly one of price_sats and price_fiat", p.ID)
+		case p.PriceFiat != nil && (p.PriceFiat.Amount <= 0 || p.PriceFiat.Currency == ""):
+			return fmt.Errorf("plan %q has an invalid price_fiat", p.ID)
+		case p.PriceFiat != nil && rates == nil:
+			return fmt.Errorf("plan %q is priced in %s but no rate source is configured", p.ID, p.PriceFiat.Currency)
+		}
+		seen[p.ID] = true
+		if p.Tier == "" {
+			p.Tier = DefaultTier
+		}
+		if p.PriceFiat != nil {
+			p.PriceFiat.Currency = strings.ToUpper(p.PriceFiat.Currency)
+		}
+	}
+	return nil
+}
+
+// LoadCatalog reads a catalog file of the form {"plans": [PlanSpec...]}.
+func LoadCatalog(path string, rates RateSource) (*Catalog, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Plans []PlanSpec `json:"plans"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	c, err := NewCatalog(cfg.Plans, rates)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c.path = path
+	return c, nil
+}
+
+// SetPlans validates and replaces the catalog's plans, saving them to
+// the catalog file if it was loaded from one. Tokens already issued
+// keep the terms they were issued with.
+func (c *Catalog) SetPlans(plans []PlanSpec) error {
+	plans = append([]PlanSpec(nil), plans...)
+	if err := checkPlans(plans, c.rates); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path != "" {
+		b, err := json.MarshalIndent(map[string][]PlanSpec{"plans": plans}, "", "  ")
+		if err != nil {
+			return err
+		}
+		tmp := c.path + ".tmp"
+		if err := os.WriteFile(tmp, b, 0o600); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, c.path); err != nil {
+			return err
+		}
+	}
+	c.plans = plans
+	return nil
+}
+
+// Persistent reports whether SetPlans survives a restart.
+func (c *Catalog) Persistent() bool {
+	return c.path != ""
+}
+
+// Plans returns the catalog's plans in config order.
+func (c *Catalog) Plans() []PlanSpec {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]PlanSpec(nil), c.plans...)
+}
+
+// Plan looks up a plan by ID.
+func (c *Catalog) Plan(id string) (PlanSpec, bool) {
+	for _, p := range c.Plans() {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return PlanSpec{}, false
+}
+
+// PriceSats returns the current price of plan id in sats, converting
+// fiat prices at the rate source's current rate.
+func (c *Catalog) PriceSats(ctx context.Context, id string) (int64, error) {
+	p, ok := c.Plan(id)
+	if !ok {
+		return 0, fmt.Errorf("unknown plan %q", id)
+	}
+	return c.planPrice(ctx, p)
+}
+
+func (c *Catalog) planPrice(ctx context.Context, p PlanSpec) (int64, error) {
+	if p.PriceFiat == nil {
+		return p.PriceSats, nil
+	}
+	btc, err := c.rates.BTCPrice(ctx, p.PriceFiat.Currency)
+	if err != nil {
+		return 0, fmt.Errorf("price plan %q: %w", p.ID, err)
+	}
+	if btc <= 0 {
+		return 0, fmt.Errorf("price plan %q: invalid BTC/%s rate %v", p.ID, p.PriceFiat.Currency, btc)
+	}
+	return int64(math.Ceil(p.PriceFiat.Amount / btc * 1e8)), nil
+}
+
+// PlanForAmount returns the longest plan amountSats pays for.
+func (c *Catalog) PlanForAmount(ctx context.Context, amountSats int64) (PlanSpec, bool) {
+	var best PlanSpec
+	found := false
+	for _, p := range c.Plans() {
+		price, err := c.planPrice(ctx, p)
+		if err != nil || price <= 0 {
+			continue
+		}
+		if p.PriceFiat != nil {
+			price = int64(float64(price) * (1 - fiatSlippage))
+		}
+		if amountSats < price {
+			continue
+		}
+		if !found || p.Duration > best.Duration {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+// PlanOffer is a plan as published in the pricing event.
+type PlanOffer struct {
+	PlanSpec
+	DurationSeconds int64 `json:"duration_seconds"`
+}
+
+// Offers returns the plans with their current sat prices, for the
+// pricing event. Plans that can't be priced right now are left out.
+func (c *Catalog) Offers(ctx context.Context) []PlanOffer {
+	var out []PlanOffer
+	for _, p := range c.Plans() {
+		price, err := c.planPrice(ctx, p)
+		if err != nil {
+			continue
+		}
+		p.PriceSats = price
+		out = append(out, PlanOffer{PlanSpec: p, DurationSeconds: int64(time.Duration(p.Duration) / time.Second)})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].PriceSats < out[j].PriceSats })
+	return out
+}
+
+// LoadCatalogFromEnv loads the catalog from MEERKAT_POOL_PLANS_FILE. If
+// it isn't set, the catalog is the weekly/monthly/yearly plans priced by
+// MEERKAT_POOL_WEEKLY_SATS, MEERKAT_POOL_MONTHLY_SATS and
+// MEERKAT_POOL_YEARLY_SATS. Fiat prices are converted with
+// RateSourceFromEnv.
+func LoadCatalogFromEnv() (*Catalog, error) {
+	rates := RateSourceFromEnv()
+	if path := os.Getenv("MEERKAT_POOL_PLANS_FILE"); path != "" {
+		return LoadCatalog(path, rates)
+	}
+
+	price := func(env string, def int64) int64 {
+		if v, _ := strconv.ParseInt(os.Getenv(env), 10, 64); v > 0 {
+			return v
+		}
+		return def
+	}
+	return NewCatalog([]PlanSpec{
+		{ID: "weekly", Duration: Duration(7 * 24 * time.Hour), PriceSats: price("MEERKAT_POOL_WEEKLY_SATS", 1500)},
+		{ID: "monthly", Duration: Duration(30 * 24 * time.Hour), PriceSats: price("MEERKAT_POOL_MONTHLY_SATS", 5000)},
+		{ID: "yearly", Duration: Duration(365 * 24 * time.Hour), PriceSats: price("MEERKAT_POOL_YEARLY_SATS", 45000)},
+	}, rates)
+}
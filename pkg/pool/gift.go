@@ -0,0 +1,117 @@
+package pool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// KindGiftRequest is the kind of the event a buyer signs to buy a
+// subscription for someone else; the buyer, who gets a GiftReceipt DM,
+// is its signer. It is POSTed to /gift, not published. Tags: "p" (the
+// pool), "plan" and "recipient" (hex or npub; receives the token DM).
+// The content is an optional note to the recipient, sent with the token.
+const KindGiftRequest = 21071
+
+// ErrBadGift is returned for invalid gift requests.
+var ErrBadGift = errors.New("invalid gift request")
+
+// GiftReceipt is the DM content telling a buyer their gift was issued.
+type GiftReceipt struct {
+	InvoiceID string `json:"invoice_id"`
+	TokenID   string `json:"token_id"`
+	Recipient string `json:"recipient"`
+	Plan      string `json:"plan"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// GiftReceiptTopic is the t tag of a buyer's receipt DM, which is also
+// tagged with the invoice ID. A gifted token's DM names the buyer (and
+// their note) in its content; see TokenOrigin.
+const GiftReceiptTopic = "vpn-gift-receipt"
+
+// maxGiftMessage bounds the note sent along with a gift.
+const maxGiftMessage = 280
+
+type giftMeta struct {
+	From    string
+	Message string
+}
+
+// GiftHandler serves POST /gift: the body is a signed KindGiftRequest
+// event. It is paid like POST /invoice and answered with an
+// InvoiceResponse.
+func (s *Server) GiftHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Invoices == nil {
+		http.Error(w, "invoices not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	var ev nostr.Event
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<10)).Decode(&ev); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req, gift, err := s.giftRequest(&ev, time.Now())
+	if err != nil {
+		log.Printf("gift %s: %v\n", ev.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.serveInvoice(w, r, req, gift)
+}
+
+func (s *Server) giftRequest(ev *nostr.Event, now time.Time) (InvoiceRequest, giftMeta, error) {
+	if ev.Kind != KindGiftRequest {
+		return InvoiceRequest{}, giftMeta{}, fmt.Errorf("%w: kind %d", ErrBadGift, ev.Kind)
+	}
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return InvoiceRequest{}, giftMeta{}, fmt.Errorf("%w: bad signature", ErrBadGift)
+	}
+	if tagValue(ev.Tags, "p") != s.PoolPubHex {
+		return InvoiceRequest{}, giftMeta{}, fmt.Errorf("%w: not addressed to this pool", ErrBadGift)
+	}
+	if d := now.Sub(ev.CreatedAt.Time()); d > transferMaxAge || d < -transferMaxAge {
+		return InvoiceRequest{}, giftMeta{}, fmt.Errorf("%w: created_at too far from now", ErrBadGift)
+	}
+	recipient, err := nostrutil.ParsePubKey(tagValue(ev.Tags, "recipient"))
+	if err != nil {
+		return InvoiceRequest{}, giftMeta{}, fmt.Errorf("%w: bad recipient", ErrBadGift)
+	}
+	if len(ev.Content) > maxGiftMessage {
+		return InvoiceRequest{}, giftMeta{}, fmt.Errorf("%w: message too long", ErrBadGift)
+	}
+	req := InvoiceRequest{Plan: tagValue(ev.Tags, "plan"), NostrPubKey: recipient}
+	return req, giftMeta{From: ev.PubKey, Message: ev.Content}, nil
+}
+
+// sendGiftReceipt tells the buyer of a gift that it was issued.
+func (s *Server) sendGiftReceipt(buyer, invoiceID string, tok vpn.SubscriptionToken) {
+	data, err := json.Marshal(GiftReceipt{
+		InvoiceID: invoiceID,
+		TokenID:   tok.Payload.TokenID,
+		Recipient: tok.Payload.UserPubKey,
+		Plan:      tok.Payload.SubscriptionType,
+		ExpiresAt: tok.Payload.ExpiresAt,
+	})
+	if err != nil {
+		return
+	}
+	tags := nostr.Tags{{"t", GiftReceiptTopic}, {InvoiceDMTag, invoiceID}}
+	if err := s.sendDM(buyer, string(data), tags); err != nil {
+		log.Println("failed to send gift receipt DM:", err)
+	}
+}
@@ -0,0 +1,112 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// signedRequest returns an event of kind signed by priv at createdAt.
+func signedRequest(t *testing.T, priv string, kind int, createdAt time.Time, content string, tags ...nostr.Tag) *nostr.Event {
+	t.Helper()
+	ev := &nostr.Event{
+		CreatedAt: nostr.Timestamp(createdAt.Unix()),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   content,
+	}
+	if err := ev.Sign(priv); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func TestGiftRequest(t *testing.T) {
+	s, _ := newTestServer(t, "")
+	buyer := nostr.GeneratePrivateKey()
+	buyerPub, _ := nostr.GetPublicKey(buyer)
+	recipient := testUser(t)
+	now := time.Now()
+	pool := nostr.Tag{"p", s.PoolPubHex}
+	plan := nostr.Tag{"plan", "weekly"}
+	to := nostr.Tag{"recipient", recipient}
+
+	tampered := signedRequest(t, buyer, KindGiftRequest, now, "", pool, plan, to)
+	tampered.Tags = nostr.Tags{pool, plan, {"recipient", testUser(t)}}
+
+	tests := []struct {
+		name string
+		ev   *nostr.Event
+	}{
+		{"wrong kind", signedRequest(t, buyer, KindTrialRequest, now, "", pool, plan, to)},
+		{"bad signature", tampered},
+		{"other pool", signedRequest(t, buyer, KindGiftRequest, now, "", nostr.Tag{"p", testUser(t)}, plan, to)},
+		{"stale", signedRequest(t, buyer, KindGiftRequest, now.Add(-time.Hour), "", pool, plan, to)},
+		{"no recipient", signedRequest(t, buyer, KindGiftRequest, now, "", pool, plan)},
+		{"long message", signedRequest(t, buyer, KindGiftRequest, now, strings.Repeat("x", maxGiftMessage+1), pool, plan, to)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := s.giftRequest(tt.ev, now); !errors.Is(err, ErrBadGift) {
+				t.Fatalf("giftRequest() error = %v, want ErrBadGift", err)
+			}
+		})
+	}
+
+	req, gift, err := s.giftRequest(signedRequest(t, buyer, KindGiftRequest, now, "enjoy", pool, plan, to), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Plan != "weekly" || req.NostrPubKey != recipient || gift.From != buyerPub || gift.Message != "enjoy" {
+		t.Fatalf("giftRequest() = %+v, %+v; want the signer as buyer", req, gift)
+	}
+}
+
+func TestGiftTokenDMKeepsOriginPrivate(t *testing.T) {
+	s, _ := newTestServer(t, "")
+	var err error
+	if s.Outbox, err = OpenOutbox("", s.Nostr, 1); err != nil {
+		t.Fatal(err)
+	}
+	buyer, recipient := testUser(t), testUser(t)
+	meta := InvoiceMetadata{Plan: "weekly", NostrPubKey: recipient, GiftFrom: buyer, GiftMessage: "enjoy"}
+	tok, err := s.issueForInvoice(meta, Source{Kind: SourceInvoice, ID: "inv1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, m := range s.Outbox.All() {
+		if m.To != recipient {
+			continue
+		}
+		found = true
+		for _, tag := range m.Event.Tags {
+			for _, v := range tag {
+				if v == buyer || v == "enjoy" {
+					t.Fatalf("token DM tag %v reveals the gift", tag)
+				}
+			}
+		}
+		// NIP-04's shared secret lets the pool decrypt it too.
+		plain, err := s.Nostr.Signer.NIP04Decrypt(context.Background(), recipient, m.Event.Content)
+		if err != nil {
+			t.Fatalf("token DM not encrypted to the recipient: %v", err)
+		}
+		var dm TokenDM
+		if err := json.Unmarshal([]byte(plain), &dm); err != nil {
+			t.Fatal(err)
+		}
+		if dm.Payload.TokenID != tok.Payload.TokenID || dm.GiftFrom != buyer || dm.GiftMessage != "enjoy" {
+			t.Fatalf("token DM content = %+v, want the token with its origin", dm)
+		}
+	}
+	if !found {
+		t.Fatal("no token DM to the recipient")
+	}
+}
@@ -0,0 +1,427 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// invoiceExpiry is how long invoices created through POST /invoice stay payable.
+const invoiceExpiry = 30 * time.Minute
+
+// Invoice is a Lightning invoice created by an InvoiceBackend.
+type Invoice struct {
+	ID        string    // backend's ID, e.g. the payment hash
+	Bolt11    string    // BOLT11 payment request
+	ExpiresAt time.Time // when the invoice stops being payable
+}
+
+// InvoiceBackend creates Lightning invoices and reports when they settle.
+type InvoiceBackend interface {
+	CreateInvoice(ctx context.Context, amountSats int64, memo string, expiry time.Duration) (*Invoice, error)
+	InvoiceSettled(ctx context.Context, id string) (bool, error)
+}
+
+// InvoiceRequest is the JSON body of POST /invoice.
+type InvoiceRequest struct {
+	Plan        string `json:"plan"`         // plan ID from the catalog
+	NostrPubKey string `json:"nostr_pubkey"` // hex or npub; receives the token DM
+
+	// RenewTokenID renews that token's subscription (see Renewal)
+	// instead of starting a new one; the token goes to its holder and
+	// NostrPubKey may be empty.
+	RenewTokenID string `json:"renew_token_id,omitempty"`
+}
+
+// InvoiceResponse is returned by POST /invoice.
+type InvoiceResponse struct {
+	InvoiceID  string `json:"invoice_id"`
+	Bolt11     string `json:"bolt11"`
+	AmountSats int64  `json:"amount_sats"`
+	Plan       string `json:"plan"`
+	ExpiresAt  int64  `json:"expires_at"`
+
+	Renewal *Renewal `json:"renewal,omitempty"` // set for renewals
+}
+
+// PendingInvoice is an invoice the pool is waiting to see paid.
+type PendingInvoice struct {
+	InvoiceResponse
+	Metadata  InvoiceMetadata `json:"metadata"`
+	CreatedAt int64           `json:"created_at"`
+}
+
+// InvoiceDMTag is the DM tag carrying the invoice ID a subscription token
+// was issued for, so a buying client can match the token to its invoice.
+const InvoiceDMTag = "invoice"
+
+// invoiceStore holds pending invoices, optionally persisted to a JSON
+// file so a restart doesn't lose invoices that are paid later.
+type invoiceStore struct {
+	path string
+
+	mu      sync.Mutex
+	pending map[string]PendingInvoice
+}
+
+func newInvoiceStore(path string) (*invoiceStore, error) {
+	st := &invoiceStore{path: path, pending: map[string]PendingInvoice{}}
+	if path == "" {
+		return st, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []PendingInvoice
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, p := range list {
+		st.pending[p.InvoiceID] = p
+	}
+	return st, nil
+}
+
+func (st *invoiceStore) add(p PendingInvoice) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.pending[p.InvoiceID] = p
+	st.saveLocked()
+}
+
+// take removes and returns a pending invoice; ok is false if it wasn't
+// pending (unknown, or already handled).
+func (st *invoiceStore) take(id string) (PendingInvoice, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	p, ok := st.pending[id]
+	if ok {
+		delete(st.pending, id)
+		st.saveLocked()
+	}
+	return p, ok
+}
+
+func (st *invoiceStore) list() []PendingInvoice {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := make([]PendingInvoice, 0, len(st.pending))
+	for _, p := range st.pending {
+		out = append(out, p)
+	}
+	return out
+}
+
+func (st *invoiceStore) saveLocked() {
+	if st.path == "" {
+		return
+	}
+	list := make([]PendingInvoice, 0, len(st.pending))
+	for _, p := range st.pending {
+		list = append(list, p)
+	}
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		log.Println("invoice store marshal error:", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(st.path), 0o700); err != nil {
+		log.Println("invoice store mkdir error:", err)
+		return
+	}
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		log.Println("invoice store write error:", err)
+		return
+	}
+	if err := os.Rename(tmp, st.path); err != nil {
+		log.Println("invoice store rename error:", err)
+	}
+}
+
+// EnableInvoices lets clients buy subscriptions through POST /invoice.
+// Pending invoices are kept in storePath ("" keeps them in memory only)
+// and polled every pollInterval until they settle or expire.
+func (s *Server) EnableInvoices(backend InvoiceBackend, storePath string, pollInterval time.Duration) error {
+	st, err := newInvoiceStore(storePath)
+	if err != nil {
+		return err
+	}
+	s.Invoices = backend
+	s.invoices = st
+
+	go func() {
+		for {
+			time.Sleep(pollInterval)
+			s.pollInvoices()
+		}
+	}()
+	return nil
+}
+
+// InvoiceHandler serves POST /invoice: it creates an invoice for a plan
+// and remembers who to issue the subscription to once it is paid.
+func (s *Server) InvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Invoices == nil {
+		http.Error(w, "invoices not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	var req InvoiceRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	s.serveInvoice(w, r, req, giftMeta{})
+}
+
+// serveInvoice creates the invoice for req and responds with it.
+func (s *Server) serveInvoice(w http.ResponseWriter, r *http.Request, req InvoiceRequest, gift giftMeta) {
+	var renewal *Renewal
+	var userPub string
+	if req.RenewTokenID != "" {
+		q, err := s.QuoteRenewal(r.Context(), req.RenewTokenID, req.Plan, time.Now())
+		if errors.Is(err, ErrUnknownToken) {
+			http.Error(w, "unknown renew_token_id", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("quote renewal error:", err)
+			http.Error(w, "could not price renewal", http.StatusBadRequest)
+			return
+		}
+		head, _ := s.Ledger.Token(q.TokenID)
+		renewal, userPub, req.Plan = &q, head.Token.Payload.UserPubKey, q.Plan
+	} else {
+		var err error
+		if userPub, err = nostrutil.ParsePubKey(req.NostrPubKey); err != nil {
+			http.Error(w, "invalid nostr_pubkey", http.StatusBadRequest)
+			return
+		}
+	}
+	if _, ok := s.Catalog.Plan(req.Plan); !ok {
+		http.Error(w, "unknown plan", http.StatusBadRequest)
+		return
+	}
+	// A renewal quote carries its own price.
+	var amount int64
+	var err error
+	if renewal != nil {
+		amount = renewal.PriceSats
+	} else {
+		amount, err = s.Catalog.PriceSats(r.Context(), req.Plan)
+	}
+	if err != nil || amount <= 0 {
+		log.Println("price plan error:", err)
+		http.Error(w, "could not price plan", http.StatusBadGateway)
+		return
+	}
+
+	memo := fmt.Sprintf("MeerkatVPN %s subscription", req.Plan)
+	inv, err := s.Invoices.CreateInvoice(r.Context(), amount, memo, invoiceExpiry)
+	if err != nil {
+		log.Println("create invoice error:", err)
+		http.Error(w, "could not create invoice", http.StatusBadGateway)
+		return
+	}
+
+	resp := InvoiceResponse{
+		InvoiceID:  inv.ID,
+		Bolt11:     inv.Bolt11,
+		AmountSats: amount,
+		Plan:       req.Plan,
+		ExpiresAt:  inv.ExpiresAt.Unix(),
+		Renewal:    renewal,
+	}
+	meta := InvoiceMetadata{
+		Purpose:     "vpn-subscription",
+		Plan:        req.Plan,
+		NostrPubKey: userPub,
+		GiftFrom:    gift.From,
+		GiftMessage: gift.Message,
+	}
+	if renewal != nil {
+		meta.RenewTokenID, meta.Upgrade = renewal.TokenID, renewal.Upgrade
+	}
+	s.invoices.add(PendingInvoice{
+		InvoiceResponse: resp,
+		Metadata:        meta,
+		CreatedAt:       time.Now().Unix(),
+	})
+	log.Printf("created invoice %s: plan=%s amount=%d sats for %s\n", inv.ID, req.Plan, amount, userPub)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// pollInvoices checks every pending invoice and issues subscriptions
+// for the ones that settled. A settled invoice stays pending until its
+// token is issued, so a failed issuance is retried on the next poll.
+func (s *Server) pollInvoices() {
+	now := time.Now()
+	for _, p := range s.invoices.list() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		settled, err := s.Invoices.InvoiceSettled(ctx, p.InvoiceID)
+		cancel()
+		if err != nil {
+			log.Printf("check invoice %s: %v\n", p.InvoiceID, err)
+			continue
+		}
+		if !settled {
+			// Keep expired invoices around for a while in case the
+			// payment was in flight when they expired.
+			if now.Unix() > p.ExpiresAt+int64(time.Hour/time.Second) {
+				s.invoices.take(p.InvoiceID)
+			}
+			continue
+		}
+		// The ledger makes issuance idempotent, so racing the webhook
+		// is harmless.
+		src := Source{Kind: SourceInvoice, ID: p.InvoiceID}
+		if _, err := s.issueForInvoice(p.Metadata, src); err != nil && !errors.Is(err, ErrAlreadyIssued) {
+			log.Printf("invoice %s settled but issuing failed, will retry: %v\n", p.InvoiceID, err)
+			continue
+		}
+		s.invoices.take(p.InvoiceID)
+	}
+}
+
+// issueForInvoice issues the subscription or renewal a paid invoice's
+// metadata asks for.
+func (s *Server) issueForInvoice(meta InvoiceMetadata, src Source) (vpn.SubscriptionToken, error) {
+	if meta.RenewTokenID != "" {
+		return s.issueRenewal(Renewal{TokenID: meta.RenewTokenID, Plan: meta.Plan, Upgrade: meta.Upgrade}, src)
+	}
+	if meta.GiftFrom == "" {
+		return s.issueSubscription(meta.NostrPubKey, meta.Plan, src)
+	}
+
+	origin := TokenOrigin{GiftFrom: meta.GiftFrom, GiftMessage: meta.GiftMessage}
+	tok, err := s.issue(meta.NostrPubKey, meta.Plan, nil, src, origin, nil)
+	if err == nil {
+		s.sendGiftReceipt(meta.GiftFrom, src.ID, tok)
+	}
+	return tok, err
+}
+
+// LNbitsBackend creates invoices through an LNbits wallet's invoice key.
+type LNbitsBackend struct {
+	URL        string // e.g. "https://lnbits.example.com"
+	InvoiceKey string
+	HTTP       *http.Client
+}
+
+// NewLNbitsBackend returns an LNbitsBackend with a default HTTP client.
+func NewLNbitsBackend(url, invoiceKey string) *LNbitsBackend {
+	return &LNbitsBackend{
+		URL:        strings.TrimRight(url, "/"),
+		InvoiceKey: invoiceKey,
+		HTTP:       &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (b *LNbitsBackend) CreateInvoice(ctx context.Context, amountSats int64, memo string, expiry time.Duration) (*Invoice, error) {
+	body, err := json.Marshal(map[string]any{
+		"out":    false,
+		"amount": amountSats,
+		"memo":   memo,
+		"expiry": int64(expiry / time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		PaymentHash    string `json:"payment_hash"`
+		PaymentRequest string `json:"payment_request"`
+		Bolt11         string `json:"bolt11"`
+	}
+	if err := b.do(ctx, http.MethodPost, "/api/v1/payments", body, &resp); err != nil {
+		return nil, err
+	}
+
+	bolt11 := resp.Bolt11
+	if bolt11 == "" {
+		bolt11 = resp.PaymentRequest
+	}
+	if resp.PaymentHash == "" || bolt11 == "" {
+		return nil, errors.New("lnbits: response missing payment_hash or payment_request")
+	}
+	return &Invoice{ID: resp.PaymentHash, Bolt11: bolt11, ExpiresAt: time.Now().Add(expiry)}, nil
+}
+
+func (b *LNbitsBackend) InvoiceSettled(ctx context.Context, id string) (bool, error) {
+	var resp struct {
+		Paid bool `json:"paid"`
+	}
+	if err := b.do(ctx, http.MethodGet, "/api/v1/payments/"+id, nil, &resp); err != nil {
+		return false, err
+	}
+	return resp.Paid, nil
+}
+
+func (b *LNbitsBackend) do(ctx context.Context, method, path string, body []byte, out any) error {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.URL+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", b.InvoiceKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := b.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("lnbits: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("lnbits: read response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("lnbits: %s %s: %s (%s)", method, path, resp.Status, strings.TrimSpace(string(raw)))
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("lnbits: decode response: %w", err)
+	}
+	return nil
+}
+
+// InvoiceBackendFromEnv returns an LNbits backend if
+// MEERKAT_POOL_LNBITS_URL and MEERKAT_POOL_LNBITS_INVOICE_KEY are set,
+// or nil.
+func InvoiceBackendFromEnv() InvoiceBackend {
+	url := os.Getenv("MEERKAT_POOL_LNBITS_URL")
+	key := os.Getenv("MEERKAT_POOL_LNBITS_INVOICE_KEY")
+	if url == "" || key == "" {
+		return nil
+	}
+	return NewLNbitsBackend(url, key)
+}
@@ -0,0 +1,400 @@
+package pool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// Payment sources a subscription can be issued for.
+const (
+	SourceInvoice  = "invoice"  // Lightning invoice (webhook or POST /invoice)
+	SourceCashu    = "cashu"    // redeemed Cashu token
+	SourceZap      = "zap"      // NIP-57 zap receipt
+	SourceTransfer = "transfer" // signed transfer request, by event ID
+	SourceVoucher  = "voucher"  // voucher redemption, by code/pubkey
+	SourceTrial    = "trial"    // free trial, by pubkey/request event ID
+	SourceAdmin    = "admin"    // issued or extended by an operator, by auth event ID
+)
+
+// Source identifies the payment a subscription was issued for. Kind+ID
+// is unique: the ledger issues at most one token per source.
+type Source struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+}
+
+func (src Source) key() string { return src.Kind + ":" + src.ID }
+
+// LedgerEntry records an issued subscription token.
+type LedgerEntry struct {
+	Source    Source                `json:"source"`
+	Token     vpn.SubscriptionToken `json:"token"`
+	RevokedAt int64                 `json:"revoked_at,omitempty"`
+}
+
+// UnissuedPayment is a payment the pool has already collected but
+// hasn't issued a token for, e.g. Cashu proofs swapped at the mint
+// before signing failed. It is kept until issuance succeeds.
+type UnissuedPayment struct {
+	Source     Source     `json:"source"`
+	UserPubKey string     `json:"user_pubkey,omitempty"` // unset for renewals
+	Plan       string     `json:"plan"`
+	Renewal    *Renewal   `json:"renewal,omitempty"`
+	Tags       nostr.Tags `json:"tags,omitempty"` // for the token DM
+	PaidAt     int64      `json:"paid_at"`
+}
+
+// ErrAlreadyIssued is returned when a token was already issued for a source.
+var ErrAlreadyIssued = errors.New("subscription already issued for this payment")
+
+// Ledger is the pool's record of issued subscription tokens, optionally
+// persisted to a JSON file. It makes issuance idempotent per payment.
+// Payments still waiting for their token are kept next to it, in
+// <path>.unissued.
+type Ledger struct {
+	path string
+
+	mu       sync.Mutex
+	entries  []LedgerEntry
+	bySource map[string]int // source key -> index in entries
+	byToken  map[string]int // token ID -> index in entries
+	claimed  map[string]bool
+	unissued map[string]UnissuedPayment // by source key
+}
+
+// OpenLedger loads the ledger at path ("" keeps it in memory only).
+func OpenLedger(path string) (*Ledger, error) {
+	l := newLedger(path)
+	if path == "" {
+		return l, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &l.entries); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	for i, e := range l.entries {
+		if e.Source.ID != "" {
+			l.bySource[e.Source.key()] = i
+		}
+		l.byToken[e.Token.Payload.TokenID] = i
+	}
+
+	b, err = os.ReadFile(l.unissuedPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var unissued []UnissuedPayment
+	if err := json.Unmarshal(b, &unissued); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", l.unissuedPath(), err)
+	}
+	for _, u := range unissued {
+		if _, done := l.bySource[u.Source.key()]; !done {
+			l.unissued[u.Source.key()] = u
+		}
+	}
+	return l, nil
+}
+
+func newLedger(path string) *Ledger {
+	return &Ledger{
+		path:     path,
+		bySource: map[string]int{},
+		byToken:  map[string]int{},
+		claimed:  map[string]bool{},
+		unissued: map[string]UnissuedPayment{},
+	}
+}
+
+// claim reserves src for issuance. It returns the already issued entry
+// and false if src was handled before (or is being handled right now).
+func (l *Ledger) claim(src Source) (*LedgerEntry, bool) {
+	if src.ID == "" {
+		return nil, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i, ok := l.bySource[src.key()]; ok {
+		e := l.entries[i]
+		return &e, false
+	}
+	if l.claimed[src.key()] {
+		return nil, false
+	}
+	l.claimed[src.key()] = true
+	return nil, true
+}
+
+// release gives up a claim after issuance failed.
+func (l *Ledger) release(src Source) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.claimed, src.key())
+}
+
+// record stores an issued token and releases the claim on its source.
+func (l *Ledger) record(src Source, token vpn.SubscriptionToken) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, LedgerEntry{Source: src, Token: token})
+	l.byToken[token.Payload.TokenID] = len(l.entries) - 1
+	if src.ID != "" {
+		l.bySource[src.key()] = len(l.entries) - 1
+		delete(l.claimed, src.key())
+	}
+	l.saveLocked()
+	if _, ok := l.unissued[src.key()]; ok {
+		delete(l.unissued, src.key())
+		l.saveUnissuedLocked()
+	}
+}
+
+// MarkPaid records a collected payment whose token is yet to be issued.
+// It is dropped once a token is recorded for its source.
+func (l *Ledger) MarkPaid(p UnissuedPayment) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, done := l.bySource[p.Source.key()]; done {
+		return
+	}
+	l.unissued[p.Source.key()] = p
+	l.saveUnissuedLocked()
+}
+
+// UnissuedFor returns the unissued payment recorded for src.
+func (l *Ledger) UnissuedFor(src Source) (UnissuedPayment, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, ok := l.unissued[src.key()]
+	return p, ok
+}
+
+// Unissued returns the payments still waiting for a token, oldest first.
+func (l *Ledger) Unissued() []UnissuedPayment {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]UnissuedPayment, 0, len(l.unissued))
+	for _, p := range l.unissued {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PaidAt < out[j].PaidAt })
+	return out
+}
+
+// Issued reports whether a token was issued for src.
+func (l *Ledger) Issued(src Source) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.bySource[src.key()]
+	return ok
+}
+
+// BySource returns the entry of the token issued for src.
+func (l *Ledger) BySource(src Source) (LedgerEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i, ok := l.bySource[src.key()]
+	if !ok {
+		return LedgerEntry{}, false
+	}
+	return l.entries[i], true
+}
+
+// CountSource returns how many tokens were issued for sources of kind
+// whose ID starts with idPrefix.
+func (l *Ledger) CountSource(kind, idPrefix string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	for _, e := range l.entries {
+		if e.Source.Kind == kind && strings.HasPrefix(e.Source.ID, idPrefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// LastIssuedTo returns the newest token issued to userPub for a source
+// of kind.
+func (l *Ledger) LastIssuedTo(kind, userPub string) (LedgerEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var last LedgerEntry
+	found := false
+	for _, e := range l.entries {
+		if e.Source.Kind != kind || e.Token.Payload.UserPubKey != userPub {
+			continue
+		}
+		if !found || e.Token.Payload.IssuedAt > last.Token.Payload.IssuedAt {
+			last, found = e, true
+		}
+	}
+	return last, found
+}
+
+// Token returns the entry of an issued token.
+func (l *Ledger) Token(tokenID string) (LedgerEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i, ok := l.byToken[tokenID]
+	if !ok {
+		return LedgerEntry{}, false
+	}
+	return l.entries[i], true
+}
+
+// Head returns the newest token of tokenID's subscription: the
+// unrevoked one of its renewal chain that expires last (or tokenID's
+// own entry if they are all revoked).
+func (l *Ledger) Head(tokenID string) (LedgerEntry, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i, ok := l.byToken[tokenID]
+	if !ok {
+		return LedgerEntry{}, false
+	}
+	sub := l.entries[i].Token.Payload.Subscription()
+	head := l.entries[i]
+	for _, e := range l.entries {
+		if e.Token.Payload.Subscription() != sub || e.RevokedAt != 0 {
+			continue
+		}
+		if head.RevokedAt != 0 || e.Token.Payload.ExpiresAt > head.Token.Payload.ExpiresAt {
+			head = e
+		}
+	}
+	return head, true
+}
+
+// Chain returns the tokens of tokenID's subscription, oldest first.
+func (l *Ledger) Chain(tokenID string) []LedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i, ok := l.byToken[tokenID]
+	if !ok {
+		return nil
+	}
+	sub := l.entries[i].Token.Payload.Subscription()
+	var out []LedgerEntry
+	for _, e := range l.entries {
+		if e.Token.Payload.Subscription() == sub {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Revoke marks tokens as revoked.
+func (l *Ledger) Revoke(tokenIDs []string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range tokenIDs {
+		if i, ok := l.byToken[id]; ok && l.entries[i].RevokedAt == 0 {
+			l.entries[i].RevokedAt = at.Unix()
+		}
+	}
+	l.saveLocked()
+}
+
+// Revoked returns the IDs of revoked tokens that haven't expired yet.
+func (l *Ledger) Revoked(now time.Time) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []string
+	for _, e := range l.entries {
+		if e.RevokedAt != 0 && e.Token.Payload.ExpiresAt > now.Unix() {
+			out = append(out, e.Token.Payload.TokenID)
+		}
+	}
+	return out
+}
+
+// Entries returns the ledger's entries, oldest first.
+func (l *Ledger) Entries() []LedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]LedgerEntry, len(l.entries))
+	copy(out, l.entries)
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Token.Payload.IssuedAt < out[j].Token.Payload.IssuedAt
+	})
+	return out
+}
+
+func (l *Ledger) saveLocked() {
+	if l.path == "" {
+		return
+	}
+	b, err := json.MarshalIndent(l.entries, "", "  ")
+	if err != nil {
+		log.Println("ledger marshal error:", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+		log.Println("ledger mkdir error:", err)
+		return
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		log.Println("ledger write error:", err)
+		return
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		log.Println("ledger rename error:", err)
+	}
+}
+
+func (l *Ledger) unissuedPath() string { return l.path + ".unissued" }
+
+func (l *Ledger) saveUnissuedLocked() {
+	if l.path == "" {
+		return
+	}
+	if len(l.unissued) == 0 {
+		if err := os.Remove(l.unissuedPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println("ledger unissued remove error:", err)
+		}
+		return
+	}
+	out := make([]UnissuedPayment, 0, len(l.unissued))
+	for _, p := range l.unissued {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PaidAt < out[j].PaidAt })
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		log.Println("ledger unissued marshal error:", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+		log.Println("ledger mkdir error:", err)
+		return
+	}
+	tmp := l.unissuedPath() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		log.Println("ledger unissued write error:", err)
+		return
+	}
+	if err := os.Rename(tmp, l.unissuedPath()); err != nil {
+		log.Println("ledger unissued rename error:", err)
+	}
+}
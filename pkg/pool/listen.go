@@ -0,0 +1,25 @@
+package pool
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// listenLookback is how far back listeners look for events on start,
+// so payments sent while poold was down aren't lost. The ledger keeps
+// replayed events from being paid out twice.
+const listenLookback = 24 * time.Hour
+
+// listen subscribes to filter on every pool relay until ctx is
+// cancelled, resubscribing after reconnects, and calls handle once per
+// event.
+func (s *Server) listen(ctx context.Context, name string, filter nostr.Filter, handle func(context.Context, *nostr.Event)) {
+	since := nostr.Timestamp(time.Now().Add(-listenLookback).Unix())
+	filter.Since = &since
+
+	log.Printf("%s listener on %d relay(s)\n", name, len(s.Nostr.Relays.URLs()))
+	s.Nostr.Relays.Subscribe(ctx, filter, handle)
+}
@@ -0,0 +1,280 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+)
+
+const (
+	outboxMinBackoff = 30 * time.Second
+	outboxMaxBackoff = time.Hour
+	// outboxKeep is how long delivered messages stay in the outbox
+	// (and the admin API) before they are pruned.
+	outboxKeep = 7 * 24 * time.Hour
+)
+
+// OutboxMessage is a DM in the outbox. The event is signed once, so
+// every attempt republishes the same event and relays and clients see
+// duplicates, not new messages.
+type OutboxMessage struct {
+	Event       nostr.Event `json:"event"`
+	To          string      `json:"to"`
+	CreatedAt   int64       `json:"created_at"`
+	Attempts    int         `json:"attempts"`
+	NextAttempt int64       `json:"next_attempt,omitempty"`
+	Acks        []string    `json:"acks,omitempty"` // relays that confirmed with OK
+	LastError   string      `json:"last_error,omitempty"`
+	DeliveredAt int64       `json:"delivered_at,omitempty"`
+}
+
+// Delivered reports whether a quorum of relays confirmed the message.
+func (m OutboxMessage) Delivered() bool { return m.DeliveredAt != 0 }
+
+// Outbox delivers the pool's DMs: each is stored before it is sent and
+// republished with backoff until Quorum relays have confirmed it with
+// OK, across restarts if the outbox has a file.
+type Outbox struct {
+	path   string
+	nostr  *nostrutil.Client
+	quorum int
+
+	mu   sync.Mutex
+	msgs map[string]*OutboxMessage // by event ID
+	kick chan struct{}
+}
+
+// OpenOutbox loads the outbox at path ("" keeps it in memory only).
+// Messages count as delivered once quorum relays (at most all of them)
+// have confirmed them.
+func OpenOutbox(path string, nc *nostrutil.Client, quorum int) (*Outbox, error) {
+	o := &Outbox{path: path, nostr: nc, quorum: quorum, msgs: map[string]*OutboxMessage{}, kick: make(chan struct{}, 1)}
+	if path == "" {
+		return o, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []OutboxMessage
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range list {
+		o.msgs[list[i].Event.ID] = &list[i]
+	}
+	return o, nil
+}
+
+// OutboxFromEnv opens the outbox at MEERKAT_POOL_OUTBOX_FILE (default
+// ~/.meerkatvpn/pool-outbox.json) with a quorum of
+// MEERKAT_POOL_DM_QUORUM relays (default 2).
+func OutboxFromEnv(nc *nostrutil.Client) (*Outbox, error) {
+	path := os.Getenv("MEERKAT_POOL_OUTBOX_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("MEERKAT_POOL_OUTBOX_FILE not set and no home directory: %w", err)
+		}
+		path = filepath.Join(home, ".meerkatvpn", "pool-outbox.json")
+	}
+	quorum := 2
+	if v := os.Getenv("MEERKAT_POOL_DM_QUORUM"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("MEERKAT_POOL_DM_QUORUM: invalid number %q", v)
+		}
+		quorum = n
+	}
+	return OpenOutbox(path, nc, quorum)
+}
+
+// Send signs a DM to toPub and queues it. It only fails if the DM can't
+// be signed or stored; delivery happens in Run.
+func (o *Outbox) Send(toPub, content string, tags nostr.Tags) error {
+	ev, err := o.nostr.SignDM(toPub, content, tags)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	o.mu.Lock()
+	o.msgs[ev.ID] = &OutboxMessage{Event: ev, To: toPub, CreatedAt: now, NextAttempt: now}
+	o.saveLocked()
+	o.mu.Unlock()
+	o.wake()
+	return nil
+}
+
+// Undelivered returns the messages no quorum has confirmed yet, oldest
+// first.
+func (o *Outbox) Undelivered() []OutboxMessage {
+	return o.list(func(m *OutboxMessage) bool { return !m.Delivered() })
+}
+
+// All returns the messages in the outbox, oldest first.
+func (o *Outbox) All() []OutboxMessage {
+	return o.list(func(*OutboxMessage) bool { return true })
+}
+
+func (o *Outbox) list(keep func(*OutboxMessage) bool) []OutboxMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := []OutboxMessage{}
+	for _, m := range o.msgs {
+		if keep(m) {
+			out = append(out, *m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt < out[j].CreatedAt })
+	return out
+}
+
+// Retry makes an undelivered message due now.
+func (o *Outbox) Retry(id string) bool {
+	o.mu.Lock()
+	m, ok := o.msgs[id]
+	if ok && !m.Delivered() {
+		m.NextAttempt = time.Now().Unix()
+	}
+	o.mu.Unlock()
+	if ok {
+		o.wake()
+	}
+	return ok && !m.Delivered()
+}
+
+func (o *Outbox) wake() {
+	select {
+	case o.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due messages until ctx is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		o.deliverDue(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.kick:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *Outbox) deliverDue(ctx context.Context, now time.Time) {
+	o.mu.Lock()
+	var due []OutboxMessage
+	for id, m := range o.msgs {
+		if m.Delivered() {
+			if now.Sub(time.Unix(m.DeliveredAt, 0)) > outboxKeep {
+				delete(o.msgs, id)
+			}
+			continue
+		}
+		if m.NextAttempt <= now.Unix() {
+			due = append(due, *m)
+		}
+	}
+	o.mu.Unlock()
+
+	for _, m := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		o.attempt(ctx, m, now)
+	}
+}
+
+// attempt publishes m to the relays that haven't confirmed it yet.
+func (o *Outbox) attempt(ctx context.Context, m OutboxMessage, now time.Time) {
+	acked := map[string]bool{}
+	for _, url := range m.Acks {
+		acked[url] = true
+	}
+	pubCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	results := o.nostr.PublishEach(pubCtx, m.Event, acked)
+	cancel()
+
+	var errs []string
+	for url, err := range results {
+		if err == nil {
+			m.Acks = append(m.Acks, url)
+		} else {
+			errs = append(errs, url+": "+err.Error())
+		}
+	}
+	sort.Strings(errs)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	cur, ok := o.msgs[m.Event.ID]
+	if !ok {
+		return
+	}
+	cur.Attempts++
+	cur.Acks = m.Acks
+	cur.LastError = strings.Join(errs, "; ")
+	if len(cur.Acks) >= o.required() {
+		cur.DeliveredAt = now.Unix()
+		cur.NextAttempt = 0
+	} else {
+		backoff := outboxMinBackoff << min(cur.Attempts-1, 10)
+		cur.NextAttempt = now.Add(min(backoff, outboxMaxBackoff)).Unix()
+		log.Printf("outbox: DM %s to %s confirmed by %d/%d relays (attempt %d, next in %s): %s\n",
+			cur.Event.ID, cur.To, len(cur.Acks), o.required(), cur.Attempts, min(backoff, outboxMaxBackoff), cur.LastError)
+	}
+	o.saveLocked()
+}
+
+// required is the number of confirmations that delivers a message.
+func (o *Outbox) required() int {
+	return max(1, min(o.quorum, len(o.nostr.Relays.URLs())))
+}
+
+func (o *Outbox) saveLocked() {
+	if o.path == "" {
+		return
+	}
+	list := make([]OutboxMessage, 0, len(o.msgs))
+	for _, m := range o.msgs {
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt < list[j].CreatedAt })
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		log.Println("outbox marshal error:", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(o.path), 0o700); err != nil {
+		log.Println("outbox mkdir error:", err)
+		return
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		log.Println("outbox write error:", err)
+		return
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		log.Println("outbox rename error:", err)
+	}
+}
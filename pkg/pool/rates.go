@@ -0,0 +1,117 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FixedRates is a RateSource with fixed BTC prices per currency.
+type FixedRates map[string]float64
+
+func (r FixedRates) BTCPrice(ctx context.Context, currency string) (float64, error) {
+	if p, ok := r[strings.ToUpper(currency)]; ok {
+		return p, nil
+	}
+	return 0, fmt.Errorf("no fixed BTC/%s rate", currency)
+}
+
+// HTTPRates fetches BTC prices from an endpoint returning a JSON object
+// of currency -> price, like mempool.space's /api/v1/prices, and caches
+// them for TTL.
+type HTTPRates struct {
+	URL  string
+	TTL  time.Duration
+	HTTP *http.Client
+
+	mu      sync.Mutex
+	prices  map[string]float64
+	fetched time.Time
+}
+
+// NewHTTPRates returns an HTTPRates for url, cached for 10 minutes.
+func NewHTTPRates(url string) *HTTPRates {
+	return &HTTPRates{URL: url, TTL: 10 * time.Minute, HTTP: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (r *HTTPRates) BTCPrice(ctx context.Context, currency string) (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.prices == nil || time.Since(r.fetched) > r.TTL {
+		prices, err := r.fetch(ctx)
+		if err != nil {
+			// A stale rate beats no price at all for a while.
+			if r.prices == nil || time.Since(r.fetched) > 6*r.TTL {
+				return 0, err
+			}
+		} else {
+			r.prices, r.fetched = prices, time.Now()
+		}
+	}
+	p, ok := r.prices[strings.ToUpper(currency)]
+	if !ok || p <= 0 {
+		return 0, fmt.Errorf("rates: no BTC/%s price from %s", currency, r.URL)
+	}
+	return p, nil
+}
+
+func (r *HTTPRates) fetch(ctx context.Context) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rates: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rates: GET %s: %s", r.URL, resp.Status)
+	}
+
+	// Values other than numbers (e.g. "time") are ignored.
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("rates: decode response: %w", err)
+	}
+	prices := map[string]float64{}
+	for k, v := range raw {
+		var f float64
+		if json.Unmarshal(v, &f) == nil {
+			prices[strings.ToUpper(k)] = f
+		}
+	}
+	return prices, nil
+}
+
+// RateSourceFromEnv returns fixed rates from MEERKAT_POOL_BTC_RATES
+// ("USD=65000,EUR=60000") if set, else HTTPRates from
+// MEERKAT_POOL_RATES_URL (default mempool.space).
+func RateSourceFromEnv() RateSource {
+	if v := os.Getenv("MEERKAT_POOL_BTC_RATES"); v != "" {
+		rates := FixedRates{}
+		for _, kv := range strings.Split(v, ",") {
+			cur, price, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok {
+				continue
+			}
+			if f, err := strconv.ParseFloat(price, 64); err == nil {
+				rates[strings.ToUpper(cur)] = f
+			}
+		}
+		return rates
+	}
+	url := os.Getenv("MEERKAT_POOL_RATES_URL")
+	if url == "" {
+		url = "https://mempool.space/api/v1/prices"
+	}
+	return NewHTTPRates(url)
+}
@@ -0,0 +1,174 @@
+package pool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// KindRecoverRequest is the kind of the event a user signs to recover
+// their tokens, answering a challenge from GET /recover/challenge. It
+// is POSTed to /recover, not published. Tags: "p" (the pool) and
+// "challenge".
+const KindRecoverRequest = 21073
+
+const (
+	recoverChallengeTTL         = 5 * time.Minute
+	maxRecoverChallenges        = 10000
+	maxRecoverChallengesPerAddr = 5 // pending at once, so one client can't fill the table
+)
+
+// RecoverChallenge is returned by GET /recover/challenge.
+type RecoverChallenge struct {
+	Challenge string `json:"challenge"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// RecoverResponse is returned by POST /recover: the holder's tokens
+// that are neither expired nor revoked.
+type RecoverResponse struct {
+	Tokens []vpn.SubscriptionToken `json:"tokens"`
+}
+
+// Errors returned for recovery requests.
+var (
+	ErrBadRecover       = errors.New("invalid recovery request")
+	ErrUnknownChallenge = errors.New("unknown or expired challenge")
+	ErrTooManyRecovers  = errors.New("too many pending recoveries; try again later")
+)
+
+// challenges are single-use recovery challenges.
+type challenges struct {
+	mu      sync.Mutex
+	pending map[string]pendingChallenge // by challenge
+}
+
+type pendingChallenge struct {
+	addr    string // who asked for it; see requestAddr
+	expires time.Time
+}
+
+// issue returns a new challenge for a client at addr, unless addr (or
+// everyone together) already has too many pending.
+func (c *challenges) issue(addr string, now time.Time) (RecoverChallenge, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return RecoverChallenge{}, err
+	}
+	ch := hex.EncodeToString(b)
+	exp := now.Add(recoverChallengeTTL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = map[string]pendingChallenge{}
+	}
+	fromAddr := 0
+	for k, p := range c.pending {
+		switch {
+		case now.After(p.expires):
+			delete(c.pending, k)
+		case p.addr == addr:
+			fromAddr++
+		}
+	}
+	if fromAddr >= maxRecoverChallengesPerAddr || len(c.pending) >= maxRecoverChallenges {
+		return RecoverChallenge{}, ErrTooManyRecovers
+	}
+	c.pending[ch] = pendingChallenge{addr: addr, expires: exp}
+	return RecoverChallenge{Challenge: ch, ExpiresAt: exp.Unix()}, nil
+}
+
+// use consumes a challenge, reporting whether it was valid.
+func (c *challenges) use(ch string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.pending[ch]
+	delete(c.pending, ch)
+	return ok && now.Before(p.expires)
+}
+
+// RecoverChallengeHandler serves GET /recover/challenge.
+func (s *Server) RecoverChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ch, err := s.recoverChallenges.issue(requestAddr(r), time.Now())
+	if errors.Is(err, ErrTooManyRecovers) {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(ch)
+}
+
+// RecoverHandler serves POST /recover: the body is a signed
+// KindRecoverRequest event, and the response lists the signer's live
+// tokens from the ledger.
+func (s *Server) RecoverHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var ev nostr.Event
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<10)).Decode(&ev); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.recoverTokens(&ev, time.Now())
+	if err != nil {
+		log.Printf("recover %s: %v\n", ev.ID, err)
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrUnknownChallenge) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) recoverTokens(ev *nostr.Event, now time.Time) (RecoverResponse, error) {
+	if ev.Kind != KindRecoverRequest {
+		return RecoverResponse{}, fmt.Errorf("%w: kind %d", ErrBadRecover, ev.Kind)
+	}
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return RecoverResponse{}, fmt.Errorf("%w: bad signature", ErrBadRecover)
+	}
+	if tagValue(ev.Tags, "p") != s.PoolPubHex {
+		return RecoverResponse{}, fmt.Errorf("%w: not addressed to this pool", ErrBadRecover)
+	}
+	if !s.recoverChallenges.use(tagValue(ev.Tags, "challenge"), now) {
+		return RecoverResponse{}, ErrUnknownChallenge
+	}
+
+	resp := RecoverResponse{Tokens: []vpn.SubscriptionToken{}}
+	for _, e := range s.Ledger.Entries() {
+		p := e.Token.Payload
+		if p.UserPubKey == ev.PubKey && e.RevokedAt == 0 && p.ExpiresAt > now.Unix() {
+			resp.Tokens = append(resp.Tokens, e.Token)
+		}
+	}
+	log.Printf("recovered %d token(s) for %s\n", len(resp.Tokens), ev.PubKey)
+	return resp, nil
+}
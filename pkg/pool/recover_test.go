@@ -0,0 +1,43 @@
+package pool
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecoverChallengesPerAddr(t *testing.T) {
+	var c challenges
+	now := time.Now()
+
+	var first RecoverChallenge
+	for i := 0; i < maxRecoverChallengesPerAddr; i++ {
+		ch, err := c.issue("203.0.113.7", now)
+		if err != nil {
+			t.Fatalf("challenge %d: %v", i, err)
+		}
+		if i == 0 {
+			first = ch
+		}
+	}
+	if _, err := c.issue("203.0.113.7", now); !errors.Is(err, ErrTooManyRecovers) {
+		t.Fatalf("challenge over the limit: %v, want ErrTooManyRecovers", err)
+	}
+	if _, err := c.issue("198.51.100.1", now); err != nil {
+		t.Fatalf("challenge for another address: %v", err)
+	}
+
+	// Using a challenge frees its slot, as does expiry.
+	if !c.use(first.Challenge, now) {
+		t.Fatal("challenge not accepted")
+	}
+	if c.use(first.Challenge, now) {
+		t.Fatal("challenge accepted twice")
+	}
+	if _, err := c.issue("203.0.113.7", now); err != nil {
+		t.Fatalf("challenge after using one: %v", err)
+	}
+	if _, err := c.issue("203.0.113.7", now.Add(recoverChallengeTTL+time.Second)); err != nil {
+		t.Fatalf("challenge after expiry: %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// RedeemRequest is the JSON body of POST /redeem, and the content of an
+// encrypted redeem DM (where NostrPubKey defaults to the sender).
+type RedeemRequest struct {
+	Token       string `json:"token"`                  // cashuA... token
+	Plan        string `json:"plan,omitempty"`         // default: longest plan the token pays for
+	NostrPubKey string `json:"nostr_pubkey,omitempty"` // hex or npub; receives the token DM
+
+	// RenewTokenID renews that token's subscription, like in an
+	// InvoiceRequest; Plan defaults to the subscription's plan.
+	RenewTokenID string `json:"renew_token_id,omitempty"`
+}
+
+// RedeemDMTag marks the DM carrying a token issued for a Cashu
+// redemption; its value is the ID of the redeem DM, if there was one.
+const RedeemDMTag = "redeem"
+
+// Errors returned when redeeming Cashu tokens.
+var (
+	ErrCashuDisabled    = errors.New("cashu payments not enabled")
+	ErrUnknownMint      = errors.New("mint not accepted by this pool")
+	ErrInsufficientCash = errors.New("token amount too low for plan")
+)
+
+// EnableCashu lets clients pay for subscriptions with Cashu tokens from
+// the given mints, through POST /redeem and encrypted DMs. Tokens
+// redeemed at the mint whose subscription couldn't be issued are retried
+// every minute.
+func (s *Server) EnableCashu(mints ...Mint) {
+	s.Mints = map[string]Mint{}
+	for _, m := range mints {
+		s.Mints[m.URL()] = m
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			s.retryUnissued()
+		}
+	}()
+}
+
+// RedeemHandler serves POST /redeem: it redeems a Cashu token at its
+// mint and returns the issued subscription token (which is also DMed).
+func (s *Server) RedeemHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RedeemRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.NostrPubKey == "" && req.RenewTokenID == "" {
+		http.Error(w, "missing nostr_pubkey", http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.redeemCashu(r.Context(), req, "")
+	if err != nil {
+		log.Printf("redeem error: %v\n", err)
+		http.Error(w, err.Error(), redeemErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(token)
+}
+
+func redeemErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrCashuDisabled):
+		return http.StatusNotImplemented
+	case errors.Is(err, ErrRedeemRejected):
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// ErrRedeemRejected wraps errors caused by the request rather than the mint.
+var ErrRedeemRejected = errors.New("redeem rejected")
+
+// redeemCashu checks the token against the plan's price, redeems it at
+// the mint and issues the subscription through issueSubscription, like
+// a settled Lightning invoice. The pool pays the mint's input fees and
+// keeps any overpayment. A redeemed token is recorded in the ledger
+// before the subscription is signed, so a failed issuance is retried
+// rather than lost; redeeming the same token again returns its
+// subscription.
+func (s *Server) redeemCashu(ctx context.Context, req RedeemRequest, dmID string) (vpn.SubscriptionToken, error) {
+	var zero vpn.SubscriptionToken
+	if len(s.Mints) == 0 {
+		return zero, ErrCashuDisabled
+	}
+
+	tok, err := ParseCashuToken(req.Token)
+	if err != nil {
+		return zero, fmt.Errorf("%w: %v", ErrRedeemRejected, err)
+	}
+	if tok.Unit != "sat" {
+		return zero, fmt.Errorf("%w: unsupported unit %q", ErrRedeemRejected, tok.Unit)
+	}
+	mint, ok := s.Mints[tok.Mint]
+	if !ok {
+		return zero, fmt.Errorf("%w: %w: %s", ErrRedeemRejected, ErrUnknownMint, tok.Mint)
+	}
+
+	src := Source{Kind: SourceCashu, ID: tok.ID()}
+	if e, ok := s.Ledger.BySource(src); ok {
+		return e.Token, nil
+	}
+	if paid, ok := s.Ledger.UnissuedFor(src); ok {
+		log.Printf("cashu token %s was already redeemed; retrying its issuance\n", src.ID)
+		return s.issuePaid(paid)
+	}
+
+	var renewal *Renewal
+	var userPub string
+	if req.RenewTokenID != "" {
+		q, err := s.QuoteRenewal(ctx, req.RenewTokenID, req.Plan, time.Now())
+		if err != nil {
+			return zero, fmt.Errorf("%w: %w", ErrRedeemRejected, err)
+		}
+		head, _ := s.Ledger.Token(q.TokenID)
+		renewal, userPub, req.Plan = &q, head.Token.Payload.UserPubKey, q.Plan
+	} else {
+		var err error
+		if userPub, err = nostrutil.ParsePubKey(req.NostrPubKey); err != nil {
+			return zero, fmt.Errorf("%w: invalid nostr_pubkey", ErrRedeemRejected)
+		}
+	}
+
+	amount := tok.Amount()
+	plan := req.Plan
+	if plan == "" {
+		p, ok := s.Catalog.PlanForAmount(ctx, amount)
+		if !ok {
+			return zero, fmt.Errorf("%w: %w (%d sats)", ErrRedeemRejected, ErrInsufficientCash, amount)
+		}
+		plan = p.ID
+	} else if _, ok := s.Catalog.Plan(plan); !ok {
+		return zero, fmt.Errorf("%w: unknown plan %q", ErrRedeemRejected, plan)
+	}
+	var price int64
+	if renewal != nil {
+		price = renewal.PriceSats
+	} else if price, err = s.Catalog.PriceSats(ctx, plan); err != nil {
+		return zero, err
+	}
+	if amount < price {
+		return zero, fmt.Errorf("%w: %w (%d < %d sats)", ErrRedeemRejected, ErrInsufficientCash, amount, price)
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	received, err := mint.Redeem(rctx, tok.Proofs)
+	if err != nil {
+		return zero, fmt.Errorf("redeem at %s: %w", tok.Mint, err)
+	}
+	log.Printf("redeemed %d sats (received %d) at %s for plan=%s user=%s\n", amount, received, tok.Mint, plan, userPub)
+
+	paid := UnissuedPayment{Source: src, Plan: plan, Renewal: renewal, PaidAt: time.Now().Unix()}
+	if renewal == nil {
+		paid.UserPubKey = userPub
+	}
+	if dmID != "" {
+		paid.Tags = nostr.Tags{{RedeemDMTag, dmID}}
+	}
+	s.Ledger.MarkPaid(paid)
+	token, err := s.issuePaid(paid)
+	if err != nil {
+		return zero, fmt.Errorf("redeemed cashu token %s but issuing failed (will retry): %w", src.ID, err)
+	}
+	return token, nil
+}
+
+// issuePaid issues the subscription or renewal an unissued payment is
+// for. A token already issued for it is returned without an error.
+func (s *Server) issuePaid(p UnissuedPayment) (vpn.SubscriptionToken, error) {
+	var token vpn.SubscriptionToken
+	var err error
+	if p.Renewal != nil {
+		token, err = s.issueRenewal(*p.Renewal, p.Source, p.Tags...)
+	} else {
+		token, err = s.issueSubscription(p.UserPubKey, p.Plan, p.Source, p.Tags...)
+	}
+	if errors.Is(err, ErrAlreadyIssued) && token.Payload.TokenID != "" {
+		return token, nil
+	}
+	return token, err
+}
+
+// retryUnissued issues the subscriptions of payments the ledger holds as
+// paid but unissued.
+func (s *Server) retryUnissued() {
+	for _, p := range s.Ledger.Unissued() {
+		if _, err := s.issuePaid(p); err != nil {
+			log.Printf("%s %s paid but issuing failed, will retry: %v\n", p.Source.Kind, p.Source.ID, err)
+		}
+	}
+}
+
+// ListenForCashuDMs redeems Cashu tokens DMed to the pool (NIP-04
+// encrypted kind 4) until ctx is cancelled. The DM is either a bare
+// cashuA token or a RedeemRequest as JSON; the subscription goes to the
+// sender unless nostr_pubkey says otherwise.
+func (s *Server) ListenForCashuDMs(ctx context.Context) {
+	s.listen(ctx, "cashu DM", nostr.Filter{
+		Kinds: []int{nostr.KindEncryptedDirectMessage},
+		Tags:  nostr.TagMap{"p": []string{s.PoolPubHex}},
+	}, s.handleCashuDM)
+}
+
+func (s *Server) handleCashuDM(ctx context.Context, ev *nostr.Event) {
+	plain, ok := s.openDM(ctx, ev)
+	if !ok {
+		return
+	}
+
+	var req RedeemRequest
+	if strings.HasPrefix(plain, "{") {
+		if err := json.Unmarshal([]byte(plain), &req); err != nil {
+			log.Printf("cashu DM %s: bad request: %v\n", ev.ID, err)
+			return
+		}
+	} else {
+		req.Token = plain
+	}
+	if !strings.HasPrefix(strings.TrimPrefix(req.Token, "cashu:"), "cashu") {
+		return
+	}
+	if req.NostrPubKey == "" {
+		req.NostrPubKey = ev.PubKey
+	}
+
+	if _, err := s.redeemCashu(ctx, req, ev.ID); err != nil {
+		log.Printf("cashu DM %s from %s: %v\n", ev.ID, ev.PubKey, err)
+	}
+}
+
+// openDM checks and decrypts a DM to the pool, returning its trimmed
+// content.
+func (s *Server) openDM(ctx context.Context, ev *nostr.Event) (string, bool) {
+	if ev.PubKey == s.PoolPubHex {
+		return "", false
+	}
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return "", false
+	}
+	plain, err := s.Nostr.DecryptDM(ctx, ev)
+	if err != nil {
+		return "", false // not encrypted to us
+	}
+	return strings.TrimSpace(plain), true
+}
+
+// CashuMintsFromEnv returns the mints listed in MEERKAT_POOL_CASHU_MINTS
+// (comma-separated URLs), or nil. Redeemed proofs are kept in
+// MEERKAT_POOL_CASHU_WALLET (default ~/.meerkatvpn/pool-cashu-wallet.json).
+func CashuMintsFromEnv() []Mint {
+	v := os.Getenv("MEERKAT_POOL_CASHU_MINTS")
+	if v == "" {
+		return nil
+	}
+	wallet := os.Getenv("MEERKAT_POOL_CASHU_WALLET")
+	if wallet == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			wallet = filepath.Join(home, ".meerkatvpn", "pool-cashu-wallet.json")
+			_ = os.MkdirAll(filepath.Dir(wallet), 0o700)
+		}
+	}
+
+	var mints []Mint
+	for _, u := range strings.Split(v, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			mints = append(mints, NewCashuMint(u, wallet))
+		}
+	}
+	return mints
+}
@@ -0,0 +1,273 @@
+package pool
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// fakeMint is an in-memory Mint: it accepts any well-formed proofs once
+// and rejects secrets it has already seen as spent.
+type fakeMint struct {
+	MintURL string
+
+	mu      sync.Mutex
+	spent   map[string]bool
+	redeems int
+}
+
+func (f *fakeMint) URL() string { return normalizeMintURL(f.MintURL) }
+
+func (f *fakeMint) Redeem(ctx context.Context, proofs []CashuProof) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.redeems++
+	if f.spent == nil {
+		f.spent = map[string]bool{}
+	}
+	var total int64
+	for _, p := range proofs {
+		if f.spent[p.Secret] {
+			return 0, errors.New("cashu: mint error 11001: token already spent")
+		}
+		total += p.Amount
+	}
+	for _, p := range proofs {
+		f.spent[p.Secret] = true
+	}
+	return total, nil
+}
+
+func (f *fakeMint) Redeems() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.redeems
+}
+
+// cashuToken serializes a V3 token from mint with one proof per amount.
+func cashuToken(t *testing.T, mint string, amounts ...int64) string {
+	t.Helper()
+	var proofs []CashuProof
+	for _, a := range amounts {
+		secret, err := randomHex(16)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, CashuProof{Amount: a, ID: "009a1f293253e41e", Secret: secret, C: "02" + secret + secret})
+	}
+	b, err := json.Marshal(map[string]any{
+		"token": []map[string]any{{"mint": mint, "proofs": proofs}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "cashuA" + base64.RawURLEncoding.EncodeToString(b)
+}
+
+const testMintURL = "https://mint.example"
+
+// testCashuServer returns newTestServer's pool, selling for tokens
+// from testMintURL.
+func testCashuServer(t *testing.T, ledgerPath string) (*Server, *fakeMint, *failingSigner) {
+	t.Helper()
+	s, signer := newTestServer(t, ledgerPath)
+	mint := &fakeMint{MintURL: testMintURL + "/"}
+	s.EnableCashu(mint)
+	return s, mint, signer
+}
+
+func TestRedeemCashu(t *testing.T) {
+	s, mint, _ := testCashuServer(t, "")
+	user := testUser(t)
+
+	tok, err := s.redeemCashu(context.Background(), RedeemRequest{
+		Token:       cashuToken(t, testMintURL, 1024, 512),
+		NostrPubKey: user,
+	}, "")
+	if err != nil {
+		t.Fatalf("redeemCashu: %v", err)
+	}
+	if err := vpn.VerifySubscription(tok, time.Now()); err != nil {
+		t.Errorf("issued token doesn't verify: %v", err)
+	}
+	if tok.Payload.UserPubKey != user || tok.Payload.SubscriptionType != "weekly" {
+		t.Errorf("token for %s on %q, want %s on weekly", tok.Payload.UserPubKey, tok.Payload.SubscriptionType, user)
+	}
+	if mint.Redeems() != 1 {
+		t.Errorf("mint redeemed %d times, want 1", mint.Redeems())
+	}
+}
+
+func TestRedeemCashuPicksLongestPlanPaidFor(t *testing.T) {
+	s, _, _ := testCashuServer(t, "")
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		amount int64
+		plan   string
+	}{
+		{1499, ""},
+		{1500, "weekly"},
+		{4999, "weekly"},
+		{5000, "monthly"},
+		{90000, "monthly"},
+	} {
+		p, ok := s.Catalog.PlanForAmount(ctx, tc.amount)
+		if got := p.ID; !ok && tc.plan != "" || ok && got != tc.plan {
+			t.Errorf("PlanForAmount(%d) = %q, %v; want %q", tc.amount, got, ok, tc.plan)
+		}
+	}
+
+	tok, err := s.redeemCashu(ctx, RedeemRequest{
+		Token:       cashuToken(t, testMintURL, 4096, 1024),
+		NostrPubKey: testUser(t),
+	}, "")
+	if err != nil {
+		t.Fatalf("redeemCashu: %v", err)
+	}
+	if tok.Payload.SubscriptionType != "monthly" {
+		t.Errorf("5120 sats bought %q, want monthly", tok.Payload.SubscriptionType)
+	}
+}
+
+func TestRedeemCashuUnderpayment(t *testing.T) {
+	s, mint, _ := testCashuServer(t, "")
+	ctx := context.Background()
+	user := testUser(t)
+
+	for _, req := range []RedeemRequest{
+		{Token: cashuToken(t, testMintURL, 1024), NostrPubKey: user},
+		{Token: cashuToken(t, testMintURL, 2048, 1024), Plan: "monthly", NostrPubKey: user},
+	} {
+		_, err := s.redeemCashu(ctx, req, "")
+		if !errors.Is(err, ErrInsufficientCash) || !errors.Is(err, ErrRedeemRejected) {
+			t.Errorf("redeemCashu(plan=%q): %v, want ErrInsufficientCash", req.Plan, err)
+		}
+	}
+	if mint.Redeems() != 0 {
+		t.Errorf("underpaying tokens were redeemed at the mint %d times", mint.Redeems())
+	}
+}
+
+func TestRedeemCashuRejects(t *testing.T) {
+	s, _, _ := testCashuServer(t, "")
+	ctx := context.Background()
+	user := testUser(t)
+
+	for name, req := range map[string]RedeemRequest{
+		"unknown mint": {Token: cashuToken(t, "https://other.example", 2048), NostrPubKey: user},
+		"unknown plan": {Token: cashuToken(t, testMintURL, 2048), Plan: "daily", NostrPubKey: user},
+		"bad pubkey":   {Token: cashuToken(t, testMintURL, 2048), NostrPubKey: "npub1nope"},
+		"not a token":  {Token: "cashuAnot-base64!", NostrPubKey: user},
+	} {
+		if _, err := s.redeemCashu(ctx, req, ""); !errors.Is(err, ErrRedeemRejected) {
+			t.Errorf("%s: %v, want ErrRedeemRejected", name, err)
+		}
+	}
+
+	if _, err := (&Server{}).redeemCashu(ctx, RedeemRequest{}, ""); !errors.Is(err, ErrCashuDisabled) {
+		t.Errorf("without mints: %v, want ErrCashuDisabled", err)
+	}
+}
+
+func TestRedeemCashuIdempotent(t *testing.T) {
+	s, mint, _ := testCashuServer(t, "")
+	ctx := context.Background()
+	req := RedeemRequest{Token: cashuToken(t, testMintURL, 2048), NostrPubKey: testUser(t)}
+
+	first, err := s.redeemCashu(ctx, req, "")
+	if err != nil {
+		t.Fatalf("redeemCashu: %v", err)
+	}
+	again, err := s.redeemCashu(ctx, req, "")
+	if err != nil {
+		t.Fatalf("redeemCashu again: %v", err)
+	}
+	if again.Payload.TokenID != first.Payload.TokenID {
+		t.Errorf("second redemption issued %s, want %s", again.Payload.TokenID, first.Payload.TokenID)
+	}
+	if mint.Redeems() != 1 {
+		t.Errorf("mint redeemed %d times, want 1", mint.Redeems())
+	}
+	if n := len(s.Ledger.Entries()); n != 1 {
+		t.Errorf("ledger has %d entries, want 1", n)
+	}
+}
+
+func TestRedeemCashuRetriesIssuance(t *testing.T) {
+	ledgerPath := filepath.Join(t.TempDir(), "ledger.json")
+	s, mint, signer := testCashuServer(t, ledgerPath)
+	ctx := context.Background()
+	req := RedeemRequest{Token: cashuToken(t, testMintURL, 2048), NostrPubKey: testUser(t)}
+
+	signer.SetFail(true)
+	if _, err := s.redeemCashu(ctx, req, "dm-id"); err == nil {
+		t.Fatal("redeemCashu succeeded with a failing signer")
+	}
+	unissued := s.Ledger.Unissued()
+	if len(unissued) != 1 || unissued[0].Plan != "weekly" || unissued[0].UserPubKey != req.NostrPubKey {
+		t.Fatalf("Unissued = %+v, want the weekly redemption", unissued)
+	}
+
+	// The payment survives a restart.
+	reopened, err := OpenLedger(ledgerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Unissued(); len(got) != 1 || got[0].Source != unissued[0].Source ||
+		len(got[0].Tags) != 1 || got[0].Tags[0][1] != "dm-id" {
+		t.Fatalf("reopened Unissued = %+v", got)
+	}
+
+	// Redeeming the spent token again issues from the ledger instead of
+	// going back to the mint.
+	signer.SetFail(false)
+	tok, err := s.redeemCashu(ctx, req, "")
+	if err != nil {
+		t.Fatalf("redeemCashu retry: %v", err)
+	}
+	if mint.Redeems() != 1 {
+		t.Errorf("mint redeemed %d times, want 1", mint.Redeems())
+	}
+	if e, ok := s.Ledger.BySource(unissued[0].Source); !ok || e.Token.Payload.TokenID != tok.Payload.TokenID {
+		t.Errorf("ledger entry for the redemption: %+v, %v", e, ok)
+	}
+	if got := s.Ledger.Unissued(); len(got) != 0 {
+		t.Errorf("Unissued after issuance = %+v", got)
+	}
+	if reopened, err = OpenLedger(ledgerPath); err != nil || len(reopened.Unissued()) != 0 {
+		t.Errorf("reopened ledger still has unissued payments (%v)", err)
+	}
+}
+
+func TestRetryUnissued(t *testing.T) {
+	s, _, signer := testCashuServer(t, filepath.Join(t.TempDir(), "ledger.json"))
+	req := RedeemRequest{Token: cashuToken(t, testMintURL, 8192), NostrPubKey: testUser(t)}
+
+	signer.SetFail(true)
+	if _, err := s.redeemCashu(context.Background(), req, ""); err == nil {
+		t.Fatal("redeemCashu succeeded with a failing signer")
+	}
+	s.retryUnissued()
+	if n := len(s.Ledger.Unissued()); n != 1 {
+		t.Fatalf("%d unissued payments after a failed retry, want 1", n)
+	}
+
+	signer.SetFail(false)
+	s.retryUnissued()
+	if n := len(s.Ledger.Unissued()); n != 0 {
+		t.Errorf("%d unissued payments after a successful retry, want 0", n)
+	}
+	entries := s.Ledger.Entries()
+	if len(entries) != 1 || entries[0].Token.Payload.SubscriptionType != "monthly" {
+		t.Errorf("ledger entries = %s", fmt.Sprint(entries))
+	}
+}
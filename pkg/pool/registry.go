@@ -0,0 +1,195 @@
+package pool
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/discovery"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// attestationTTL is how long a node attestation served by /nodes is valid.
+// Cached attestations are re-signed once they are half-way to expiry.
+const attestationTTL = 24 * time.Hour
+
+// NodeRegistryResponse is the JSON body served at GET /nodes.
+type NodeRegistryResponse struct {
+	PoolPubKey string                `json:"pool_pubkey"`
+	Nodes      []vpn.NodeAttestation `json:"nodes"`
+}
+
+// Registry holds the pool's approved nodes and serves them, with signed
+// attestations, to clients that can't use Nostr discovery.
+type Registry struct {
+	nodes   *discovery.StaticFinder
+	pool    vpn.Signer
+	poolPub string
+
+	// File is the nodes file the list was loaded from; Approve and
+	// Remove write to it.
+	File   string
+	fileMu sync.Mutex
+
+	mu      sync.Mutex
+	body    []byte
+	etag    string
+	builtAt time.Time
+	builtOn []discovery.NodeInfo
+}
+
+// NewRegistry creates a Registry over an approved node list. The list
+// can be reloaded (e.g. via StaticFinder.WatchFile) and the registry
+// re-signs on the next request.
+func NewRegistry(nodes *discovery.StaticFinder, pool vpn.Signer) *Registry {
+	return &Registry{
+		nodes:   nodes,
+		pool:    pool,
+		poolPub: pool.PubKey(),
+	}
+}
+
+// Approve adds a node to the approved list, or replaces the node with
+// the same ID, in both the registry and its nodes file.
+func (r *Registry) Approve(entry discovery.StaticNodeEntry) error {
+	return r.updateFile(func(entries []discovery.StaticNodeEntry) ([]discovery.StaticNodeEntry, error) {
+		for i, e := range entries {
+			if e.ID == entry.ID {
+				entries[i] = entry
+				return entries, nil
+			}
+		}
+		return append(entries, entry), nil
+	})
+}
+
+// Remove takes a node off the approved list and its nodes file.
+func (r *Registry) Remove(id string) error {
+	return r.updateFile(func(entries []discovery.StaticNodeEntry) ([]discovery.StaticNodeEntry, error) {
+		for i, e := range entries {
+			if e.ID == id {
+				return append(entries[:i], entries[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("node %q is not approved", id)
+	})
+}
+
+func (r *Registry) updateFile(update func([]discovery.StaticNodeEntry) ([]discovery.StaticNodeEntry, error)) error {
+	if r.File == "" {
+		return errors.New("registry has no nodes file to edit")
+	}
+	r.fileMu.Lock()
+	defer r.fileMu.Unlock()
+	nodes, err := discovery.UpdateStaticNodesFile(r.File, update)
+	if err != nil {
+		return err
+	}
+	r.nodes.Reload(nodes)
+	return nil
+}
+
+// Nodes returns the approved node list.
+func (r *Registry) Nodes() []discovery.NodeInfo {
+	nodes, _ := r.nodes.ListNodes(context.Background())
+	return nodes
+}
+
+// NodesHandler serves GET /nodes with ETag / If-None-Match support.
+func (r *Registry) NodesHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, etag, err := r.current(req.Context())
+	if err != nil {
+		log.Println("registry: build /nodes response:", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+
+	if match := req.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		_, _ = w.Write(body)
+	}
+}
+
+// current returns the cached response, rebuilding it if the node list
+// changed or the attestations are getting old.
+func (r *Registry) current(ctx context.Context) ([]byte, string, error) {
+	nodes := r.Nodes()
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.body != nil && sameNodes(r.builtOn, nodes) && now.Sub(r.builtAt) < attestationTTL/2 {
+		return r.body, r.etag, nil
+	}
+
+	resp := NodeRegistryResponse{PoolPubKey: r.poolPub, Nodes: []vpn.NodeAttestation{}}
+	for _, n := range nodes {
+		if !n.Healthy {
+			// Disabled in the approved list: don't advertise it.
+			continue
+		}
+		att, err := vpn.SignNodeAttestation(ctx, r.pool, vpn.NodeAttestationPayload{
+			NodeID:     n.ID,
+			APIURL:     n.APIURL,
+			Region:     n.Region,
+			Country:    n.Country,
+			City:       n.City,
+			Backends:   n.Backends,
+			NodePubKey: n.PubKey,
+			WGPubKey:   n.WGPubKey,
+			Endpoints:  n.Endpoints,
+			PoolPubKey: r.poolPub,
+			IssuedAt:   now.Unix(),
+			ExpiresAt:  now.Add(attestationTTL).Unix(),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		resp.Nodes = append(resp.Nodes, att)
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+
+	r.body = body
+	r.etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	r.builtAt = now
+	r.builtOn = nodes
+
+	log.Printf("registry: signed %d node attestations (etag=%s)\n", len(resp.Nodes), r.etag)
+	return r.body, r.etag, nil
+}
+
+func sameNodes(a, b []discovery.NodeInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return string(ab) == string(bb)
+}
@@ -0,0 +1,26 @@
+package pool
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+)
+
+// PublishRelayList publishes the pool's NIP-65 relay list, so clients
+// find the relays to read its tokens and pricing from (and to reach it
+// on) without configuring them. The pool reads and writes on all of its
+// relays. The list goes to the pool's relays and to
+// nostrutil.DefaultRelays, where clients look it up.
+func (s *Server) PublishRelayList(ctx context.Context) error {
+	urls := s.Nostr.Relays.URLs()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	err := s.Nostr.PublishRelayList(ctx, nostrutil.RelayList{Read: urls, Write: urls}, nostrutil.DefaultRelays)
+	if err != nil {
+		return err
+	}
+	log.Printf("published relay list: %v\n", urls)
+	return nil
+}
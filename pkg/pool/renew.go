@@ -0,0 +1,117 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// ErrUnknownToken is returned when renewing a token this pool didn't issue.
+var ErrUnknownToken = errors.New("token not issued by this pool")
+
+// Renewal is the quote for renewing a subscription onto a plan. A
+// renewal on the same tier extends the subscription: the new period
+// starts when the current one ends. Switching tiers takes effect at once
+// and the unused part of the current period is credited against the
+// price; if that credit covers the whole new plan (a downgrade, say) the
+// switch waits until the current period ends instead, at full price.
+type Renewal struct {
+	TokenID    string    `json:"token_id"` // newest token of the subscription, which the renewal succeeds
+	Plan       string    `json:"plan"`
+	Upgrade    bool      `json:"upgrade"` // tier switch taking effect now
+	PriceSats  int64     `json:"price_sats"`
+	CreditSats int64     `json:"credit_sats,omitempty"`
+	StartsAt   time.Time `json:"starts_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// QuoteRenewal prices renewing tokenID's subscription onto planID ("":
+// the plan of the subscription's newest token).
+func (s *Server) QuoteRenewal(ctx context.Context, tokenID, planID string, now time.Time) (Renewal, error) {
+	head, ok := s.Ledger.Head(tokenID)
+	if !ok {
+		return Renewal{}, ErrUnknownToken
+	}
+	prev := head.Token.Payload
+	if planID == "" {
+		planID = prev.SubscriptionType
+	}
+	plan, ok := s.Catalog.Plan(planID)
+	if !ok {
+		return Renewal{}, fmt.Errorf("unknown plan %q", planID)
+	}
+	price, err := s.Catalog.PriceSats(ctx, plan.ID)
+	if err != nil {
+		return Renewal{}, err
+	}
+
+	r := Renewal{TokenID: prev.TokenID, Plan: plan.ID, PriceSats: price}
+	if tierOf(plan.Tier) != tierOf(prev.Tier) {
+		credit := s.unusedSats(ctx, prev, now)
+		if credit < price {
+			r.Upgrade = true
+			r.CreditSats = credit
+			r.PriceSats = price - credit
+		}
+	}
+	r.StartsAt, r.ExpiresAt = renewalPeriod(prev, plan, r.Upgrade, now)
+	return r, nil
+}
+
+// renewalPeriod returns when a renewal of prev onto plan starts and
+// expires.
+func renewalPeriod(prev vpn.SubscriptionPayload, plan PlanSpec, upgrade bool, now time.Time) (start, expires time.Time) {
+	start = now
+	if end := time.Unix(prev.ExpiresAt, 0); !upgrade && end.After(now) {
+		start = end
+	}
+	return start, start.Add(time.Duration(plan.Duration))
+}
+
+// unusedSats is what's left of prev's subscription, valued at the
+// current price of prev's plan. The time left may span several renewed
+// periods.
+func (s *Server) unusedSats(ctx context.Context, prev vpn.SubscriptionPayload, now time.Time) int64 {
+	left := time.Unix(prev.ExpiresAt, 0).Sub(now)
+	plan, ok := s.Catalog.Plan(prev.SubscriptionType)
+	if !ok || left <= 0 || plan.Duration <= 0 {
+		return 0
+	}
+	price, err := s.Catalog.PriceSats(ctx, plan.ID)
+	if err != nil {
+		return 0
+	}
+	return int64(float64(price) * float64(left) / float64(plan.Duration))
+}
+
+func tierOf(tier string) string {
+	if tier == "" {
+		return DefaultTier
+	}
+	return tier
+}
+
+// RenewQuoteHandler serves GET /renew/quote?token_id=...&plan=...: the
+// price and period of renewing a subscription, as a Renewal.
+func (s *Server) RenewQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := s.QuoteRenewal(r.Context(), r.URL.Query().Get("token_id"), r.URL.Query().Get("plan"), time.Now())
+	if errors.Is(err, ErrUnknownToken) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(q)
+}
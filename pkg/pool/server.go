@@ -3,14 +3,15 @@ package pool
 import (
     "context"
     "encoding/json"
+    "errors"
+    "fmt"
     "log"
     "net/http"
-    "os"
-    "strconv"
+    "sort"
     "strings"
+    "sync"
     "time"
 
-    "github.com/btcsuite/btcd/btcec/v2"
     "github.com/google/uuid"
     "github.com/nbd-wtf/go-nostr"
 
@@ -19,20 +20,59 @@ import (
 )
 
 type Server struct {
-    Nostr        *nostrutil.Client
-    PoolPrivKey  *btcec.PrivateKey
+    Nostr        *nostrutil.Client // signs as the pool via Nostr.Signer
     PoolPubHex   string
-    Pricing      Pricing
+    Catalog      *Catalog
     WebhookSecret string
+
+    // Registry is the optional approved-node registry served at GET /nodes.
+    Registry *Registry
+
+    // Invoices, if set, lets clients buy subscriptions via POST /invoice
+    // (see EnableInvoices).
+    Invoices InvoiceBackend
+    invoices *invoiceStore
+
+    // Mints are the Cashu mints whose tokens POST /redeem and redeem DMs
+    // accept, keyed by URL (see EnableCashu).
+    Mints map[string]Mint
+
+    // Ledger records every issued token; a payment is never issued twice.
+    Ledger *Ledger
+    renewMu sync.Mutex // serializes renewals, which extend the ledger's chains
+
+    // Vouchers, if set, are redeemable at POST /voucher and by DM (see
+    // EnableVouchers).
+    Vouchers  *Vouchers
+    voucherMu sync.Mutex
+
+    // Trials, if set, lets users get a free trial token at POST /trial
+    // and by DM (see EnableTrials).
+    Trials      *TrialPolicy
+    trialMu     sync.Mutex
+    trialLimits *trialLimits
+
+    recoverChallenges challenges
+
+    revocationsMu sync.Mutex
+    revocations   *vpn.RevocationList // last signed; see RevocationsHandler
+
+    // Outbox, if set, delivers the pool's DMs with retries until enough
+    // relays confirm them.
+    Outbox *Outbox
+
+    // PublicURL is the pool's externally reachable base URL, advertised
+    // in the pricing event so clients know where to request invoices.
+    PublicURL string
 }
 
-func NewServer(nostrClient *nostrutil.Client, poolPriv *btcec.PrivateKey, pricing Pricing, webhookSecret string) *Server {
+func NewServer(nostrClient *nostrutil.Client, catalog *Catalog, webhookSecret string) *Server {
     return &Server{
         Nostr:         nostrClient,
-        PoolPrivKey:   poolPriv,
         PoolPubHex:    nostrClient.PubKey,
-        Pricing:       pricing,
+        Catalog:       catalog,
         WebhookSecret: webhookSecret,
+        Ledger:        newLedger(""),
     }
 }
 
@@ -67,52 +107,161 @@ func (s *Server) LNWebhookHandler(w http.ResponseWriter, r *http.Request) {
 
     userPub := inv.Metadata.NostrPubKey
     plan := inv.Metadata.Plan
-    if userPub == "" || plan == "" {
+    if (userPub == "" && inv.Metadata.RenewTokenID == "") || plan == "" {
         log.Println("missing nostr_pubkey or plan in metadata")
         w.WriteHeader(http.StatusOK)
         return
     }
 
+    src := Source{Kind: SourceInvoice, ID: inv.InvoiceID}
+    if _, err := s.issueForInvoice(inv.Metadata, src); err != nil && !errors.Is(err, ErrAlreadyIssued) {
+        log.Printf("invoice %s settled but issuing failed: %v\n", inv.InvoiceID, err)
+        http.Error(w, "internal error", http.StatusInternalServerError)
+        return
+    }
+
+    // Invoices created through POST /invoice may also be reported here;
+    // once issued, the poller has nothing left to do for them (the
+    // ledger would refuse a second token anyway).
+    if s.invoices != nil && inv.InvoiceID != "" {
+        s.invoices.take(inv.InvoiceID)
+    }
+
+    w.WriteHeader(http.StatusOK)
+}
+
+// issueSubscription signs a subscription token for userPub on plan,
+// records it in the ledger and DMs it to them. If a token was already
+// issued for src it returns that token and ErrAlreadyIssued. Invoice IDs
+// are tagged on the DM so the buyer can match the token to the invoice
+// it paid; extraTags are added too.
+func (s *Server) issueSubscription(userPub, planID string, src Source, extraTags ...nostr.Tag) (vpn.SubscriptionToken, error) {
+    return s.issue(userPub, planID, nil, src, TokenOrigin{}, extraTags)
+}
+
+// issueRenewal is issueSubscription for a renewal quoted by QuoteRenewal:
+// the token succeeds the newest token of the subscription and goes to
+// its holder.
+func (s *Server) issueRenewal(r Renewal, src Source, extraTags ...nostr.Tag) (vpn.SubscriptionToken, error) {
+    return s.issue("", r.Plan, &r, src, TokenOrigin{}, extraTags)
+}
+
+func (s *Server) issue(userPub, planID string, renew *Renewal, src Source, origin TokenOrigin, extraTags []nostr.Tag) (vpn.SubscriptionToken, error) {
+    plan, ok := s.plan(planID)
+    if !ok {
+        log.Printf("not issuing %s %s: unknown plan %q\n", src.Kind, src.ID, planID)
+        return vpn.SubscriptionToken{}, fmt.Errorf("unknown plan %q", planID)
+    }
+    if prev, ok := s.Ledger.claim(src); !ok {
+        log.Printf("%s %s already handled; not issuing again\n", src.Kind, src.ID)
+        if prev != nil {
+            return prev.Token, ErrAlreadyIssued
+        }
+        return vpn.SubscriptionToken{}, ErrAlreadyIssued
+    }
+
     // Compute expiry
     now := time.Now().Unix()
-    duration := PlanDuration(plan)
-    expires := time.Now().Add(duration).Unix()
+    expires := time.Now().Add(time.Duration(plan.Duration)).Unix()
+
+    var prevID, subID string
+    var revoke []string
+    if renew != nil {
+        s.renewMu.Lock()
+        defer s.renewMu.Unlock()
+        // Renew whatever is newest now; the chain may have grown since
+        // the quote.
+        head, ok := s.Ledger.Head(renew.TokenID)
+        if !ok {
+            s.Ledger.release(src)
+            return vpn.SubscriptionToken{}, ErrUnknownToken
+        }
+        prev := head.Token.Payload
+        _, end := renewalPeriod(prev, plan, renew.Upgrade, time.Now())
+        userPub, expires = prev.UserPubKey, end.Unix()
+        prevID, subID = prev.TokenID, prev.Subscription()
+        // An upgrade credited the time left, so the tokens covering it
+        // stop being valid.
+        if renew.Upgrade {
+            for _, e := range s.Ledger.Chain(prevID) {
+                if e.RevokedAt == 0 && e.Token.Payload.ExpiresAt > now {
+                    revoke = append(revoke, e.Token.Payload.TokenID)
+                }
+            }
+        }
+    }
 
     payload := vpn.SubscriptionPayload{
         TokenID:          "sub_" + uuid.New().String(),
         UserPubKey:       userPub,
-        SubscriptionType: plan,
-        Tier:             "full",
+        SubscriptionType: plan.ID,
+        Tier:             plan.Tier,
         IssuedAt:         now,
         ExpiresAt:        expires,
         Nonce:            uuid.New().String(),
         IssuerPubKey:     s.PoolPubHex, // pool's nostr pubkey
+        PreviousTokenID:  prevID,
+        SubscriptionID:   subID,
+        PlanLimits:       plan.Limits(),
     }
+    token, err := s.deliver(src, payload, origin, extraTags)
+    if err != nil || len(revoke) == 0 {
+        return token, err
+    }
+    s.Ledger.Revoke(revoke, time.Now())
+    log.Printf("upgrade of subscription %s revoked %d token(s)\n", subID, len(revoke))
+    return token, nil
+}
 
-    token, err := vpn.SignSubscription(s.PoolPrivKey, payload)
+// deliver signs payload, records the token for src (which the caller
+// has claimed) and DMs it to its holder, telling them its origin.
+func (s *Server) deliver(src Source, payload vpn.SubscriptionPayload, origin TokenOrigin, extraTags []nostr.Tag) (vpn.SubscriptionToken, error) {
+    token, err := vpn.SignSubscription(context.Background(), s.Nostr.Signer, payload)
     if err != nil {
         log.Println("failed to sign subscription:", err)
-        http.Error(w, "internal error", http.StatusInternalServerError)
-        return
+        s.Ledger.release(src)
+        return vpn.SubscriptionToken{}, err
     }
+    s.Ledger.record(src, token)
 
-    log.Printf("LN webhook: issued subscription token %s for user %s plan=%s (expires=%d)\n",
+    log.Printf("issued subscription token %s for user %s plan=%s (expires=%d)\n",
     token.Payload.TokenID,
     token.Payload.UserPubKey,
     token.Payload.SubscriptionType,
     token.Payload.ExpiresAt,
     )
+    if payload.PreviousTokenID != "" {
+        log.Printf("token %s succeeds %s (subscription %s)\n", token.Payload.TokenID, payload.PreviousTokenID, payload.SubscriptionID)
+    }
 
-    if err := s.sendSubscriptionDM(userPub, token); err != nil {
+    tags := nostr.Tags(extraTags)
+    if src.Kind == SourceInvoice && src.ID != "" {
+        tags = append(tags, nostr.Tag{InvoiceDMTag, src.ID})
+    }
+    if err := s.sendSubscriptionDM(payload.UserPubKey, TokenDM{token, origin}, tags); err != nil {
         log.Println("failed to send sub DM:", err)
-        // Don't fail webhook: Lightning side already settled
+        // Don't fail issuance: the payment already settled
     }
+    return token, nil
+}
 
-    w.WriteHeader(http.StatusOK)
+// TokenDM is the content of the DM carrying a token: the token's JSON,
+// plus where a gifted or transferred token came from. The origin stays
+// in the content rather than in the DM's public tags.
+type TokenDM struct {
+    vpn.SubscriptionToken
+    TokenOrigin
+}
+
+// TokenOrigin is who a gifted or transferred token came from.
+type TokenOrigin struct {
+    GiftFrom     string `json:"gift_from,omitempty"`     // the buyer's pubkey
+    GiftMessage  string `json:"gift_message,omitempty"`  // the buyer's note
+    TransferFrom string `json:"transfer_from,omitempty"` // the previous holder's pubkey
 }
 
-func (s *Server) sendSubscriptionDM(userPubKey string, token vpn.SubscriptionToken) error {
-    data, err := json.Marshal(token)
+func (s *Server) sendSubscriptionDM(userPubKey string, dm TokenDM, extraTags nostr.Tags) error {
+    data, err := json.Marshal(dm)
     if err != nil {
         return err
     }
@@ -123,8 +272,18 @@ func (s *Server) sendSubscriptionDM(userPubKey string, token vpn.SubscriptionTok
     tags := nostr.Tags{
         {"t", "vpn-subscription"},
     }
-    
-    return s.Nostr.SendDM(context.Background(), userPubKey, string(data), tags)
+    tags = append(tags, extraTags...)
+
+    return s.sendDM(userPubKey, string(data), tags)
+}
+
+// sendDM queues a DM in the outbox, or sends it right away if the pool
+// has none.
+func (s *Server) sendDM(toPub, content string, tags nostr.Tags) error {
+    if s.Outbox != nil {
+        return s.Outbox.Send(toPub, content, tags)
+    }
+    return s.Nostr.SendDM(context.Background(), toPub, content, tags)
 }
 
 // Pricing publisher: emits kind=30070 periodically
@@ -138,13 +297,50 @@ func (s *Server) StartPricingPublisher(interval time.Duration) {
 }
 
 func (s *Server) publishPricing() {
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    offers := s.Catalog.Offers(ctx)
     contentMap := map[string]interface{}{
         "currency":            "sats",
-        "weekly_price_sats":   s.Pricing.WeeklyPriceSats,
-        "monthly_price_sats":  s.Pricing.MonthlyPriceSats,
-        "yearly_price_sats":   s.Pricing.YearlyPriceSats,
+        "plans":               offers,
         "price_last_updated":  time.Now().Unix(),
     }
+    // Clients that predate the catalog only know these three plans.
+    for _, o := range offers {
+        switch o.ID {
+        case "weekly", "monthly", "yearly":
+            contentMap[o.ID+"_price_sats"] = o.PriceSats
+        }
+    }
+    if s.Invoices != nil && s.PublicURL != "" {
+        contentMap["invoice_url"] = strings.TrimRight(s.PublicURL, "/") + "/invoice"
+        contentMap["gift_url"] = strings.TrimRight(s.PublicURL, "/") + "/gift"
+    }
+    if s.PublicURL != "" {
+        contentMap["transfer_url"] = strings.TrimRight(s.PublicURL, "/") + "/transfer"
+        contentMap["recover_url"] = strings.TrimRight(s.PublicURL, "/") + "/recover"
+        if s.Vouchers != nil {
+            contentMap["voucher_url"] = strings.TrimRight(s.PublicURL, "/") + "/voucher"
+        }
+        if s.Trials != nil {
+            contentMap["trial_url"] = strings.TrimRight(s.PublicURL, "/") + "/trial"
+        }
+    }
+    if s.Trials != nil {
+        contentMap["trial_seconds"] = int64(s.Trials.Duration / time.Second)
+    }
+    if len(s.Mints) > 0 {
+        mints := make([]string, 0, len(s.Mints))
+        for u := range s.Mints {
+            mints = append(mints, u)
+        }
+        sort.Strings(mints)
+        contentMap["cashu_mints"] = mints
+        if s.PublicURL != "" {
+            contentMap["redeem_url"] = strings.TrimRight(s.PublicURL, "/") + "/redeem"
+        }
+    }
     data, err := json.Marshal(contentMap)
     if err != nil {
         log.Println("pricing marshal error:", err)
@@ -160,48 +356,20 @@ func (s *Server) publishPricing() {
         },
         Content: string(data),
     }
+    if err := s.Nostr.Signer.SignEvent(ctx, &ev); err != nil {
+        log.Println("sign pricing error:", err)
+        return
+    }
 
-    if err := s.Nostr.Publish(context.Background(), ev); err != nil {
+    if err := s.Nostr.Publish(ctx, ev); err != nil {
         log.Println("publish pricing error:", err)
     } else {
         log.Println("published pricing event")
     }   
 }
 
-// Simple helper to load pricing from env
-func LoadPricingFromEnv() Pricing {
-    w, _ := strconv.ParseInt(os.Getenv("MEERKAT_POOL_WEEKLY_SATS"), 10, 64)
-    m, _ := strconv.ParseInt(os.Getenv("MEERKAT_POOL_MONTHLY_SATS"), 10, 64)
-    y, _ := strconv.ParseInt(os.Getenv("MEERKAT_POOL_YEARLY_SATS"), 10, 64)
-    if w == 0 {
-        w = 1500
-    }
-    if m == 0 {
-        m = 5000
-    }
-    if y == 0 {
-        y = 45000
-    }
-    return Pricing{
-        WeeklyPriceSats:  w,
-        MonthlyPriceSats: m,
-        YearlyPriceSats:  y,
-    }
-}
-
-// Useful helper to parse relays from env
+// RelayURLsFromEnv returns the pool's relays (MEERKAT_POOL_RELAYS,
+// comma-separated, default nostrutil.DefaultRelays).
 func RelayURLsFromEnv() []string {
-    v := os.Getenv("MEERKAT_POOL_RELAYS")
-    if v == "" {
-        return []string{"wss://relay.damus.io", "wss://relay.primal.net"}
-    }
-    parts := strings.Split(v, ",")
-    out := []string{}
-    for _, p := range parts {
-        p = strings.TrimSpace(p)
-        if p != "" {
-            out = append(out, p)
-        }
-    }
-    return out
+    return nostrutil.RelayURLsFromEnv("MEERKAT_POOL_RELAYS")
 }
@@ -0,0 +1,75 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+)
+
+// failingSigner is the pool's key, which can be made to fail signing.
+type failingSigner struct {
+	nostrutil.Signer
+
+	mu   sync.Mutex
+	fail bool
+}
+
+func (s *failingSigner) SetFail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+func (s *failingSigner) SignEvent(ctx context.Context, ev *nostr.Event) error {
+	s.mu.Lock()
+	fail := s.fail
+	s.mu.Unlock()
+	if fail {
+		return errors.New("signer unavailable")
+	}
+	return s.Signer.SignEvent(ctx, ev)
+}
+
+// newTestServer returns a pool selling weekly (1500 sats) and monthly
+// (5000 sats) plans, with its ledger in ledgerPath. Its DMs go nowhere.
+func newTestServer(t *testing.T, ledgerPath string) (*Server, *failingSigner) {
+	t.Helper()
+	local, err := nostrutil.NewLocalSigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &failingSigner{Signer: local}
+	client := &nostrutil.Client{
+		Signer: signer,
+		PubKey: signer.PubKey(),
+		Relays: nostrutil.NewRelayPool(context.Background(), nil, nil),
+	}
+	catalog, err := NewCatalog([]PlanSpec{
+		{ID: "weekly", Duration: Duration(7 * 24 * time.Hour), PriceSats: 1500},
+		{ID: "monthly", Duration: Duration(30 * 24 * time.Hour), PriceSats: 5000},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(client, catalog, "")
+	if s.Ledger, err = OpenLedger(ledgerPath); err != nil {
+		t.Fatal(err)
+	}
+	return s, signer
+}
+
+func testUser(t *testing.T) string {
+	t.Helper()
+	pub, err := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
@@ -0,0 +1,218 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// KindTransferRequest is the kind of the event a token holder signs to
+// hand their subscription over to another pubkey. It is POSTed to
+// /transfer, not published. Tags: "p" (the pool), "token" (a token of
+// the subscription) and "recipient" (hex pubkey).
+const KindTransferRequest = 21070
+
+// transferMaxAge bounds how far a transfer request's created_at may be
+// from the pool's clock.
+const transferMaxAge = 10 * time.Minute
+
+// TransferResponse is returned by POST /transfer.
+type TransferResponse struct {
+	TokenID   string   `json:"token_id"` // the recipient's new token
+	Recipient string   `json:"recipient"`
+	ExpiresAt int64    `json:"expires_at"`
+	Revoked   []string `json:"revoked"` // the previous holder's tokens
+}
+
+// Errors returned for transfer requests.
+var (
+	ErrBadTransfer        = errors.New("invalid transfer request")
+	ErrNotHolder          = errors.New("request not signed by the token's holder")
+	ErrAlreadyTransferred = errors.New("subscription already transferred")
+	ErrSubscriptionOver   = errors.New("subscription has expired")
+)
+
+// TransferHandler serves POST /transfer: the body is a signed
+// KindTransferRequest event. The recipient gets a new token for the
+// rest of the subscription, DMed to them, and the holder's tokens are
+// revoked.
+func (s *Server) TransferHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var ev nostr.Event
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<10)).Decode(&ev); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.transfer(&ev, time.Now())
+	if err != nil {
+		log.Printf("transfer %s: %v\n", ev.ID, err)
+		http.Error(w, err.Error(), transferErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func transferErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotHolder):
+		return http.StatusForbidden
+	case errors.Is(err, ErrUnknownToken):
+		return http.StatusNotFound
+	case errors.Is(err, ErrAlreadyTransferred), errors.Is(err, ErrAlreadyIssued):
+		return http.StatusConflict
+	case errors.Is(err, ErrBadTransfer), errors.Is(err, ErrSubscriptionOver):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (s *Server) transfer(ev *nostr.Event, now time.Time) (TransferResponse, error) {
+	var zero TransferResponse
+	if ev.Kind != KindTransferRequest {
+		return zero, fmt.Errorf("%w: kind %d", ErrBadTransfer, ev.Kind)
+	}
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return zero, fmt.Errorf("%w: bad signature", ErrBadTransfer)
+	}
+	if tagValue(ev.Tags, "p") != s.PoolPubHex {
+		return zero, fmt.Errorf("%w: not addressed to this pool", ErrBadTransfer)
+	}
+	if d := now.Sub(ev.CreatedAt.Time()); d > transferMaxAge || d < -transferMaxAge {
+		return zero, fmt.Errorf("%w: created_at too far from now", ErrBadTransfer)
+	}
+	recipient, err := nostrutil.ParsePubKey(tagValue(ev.Tags, "recipient"))
+	if err != nil || recipient == ev.PubKey {
+		return zero, fmt.Errorf("%w: bad recipient", ErrBadTransfer)
+	}
+	entry, ok := s.Ledger.Token(tagValue(ev.Tags, "token"))
+	if !ok {
+		return zero, ErrUnknownToken
+	}
+	if entry.Token.Payload.UserPubKey != ev.PubKey {
+		return zero, ErrNotHolder
+	}
+
+	// Transfers and renewals both extend the ledger's chains.
+	s.renewMu.Lock()
+	defer s.renewMu.Unlock()
+
+	src := Source{Kind: SourceTransfer, ID: ev.ID}
+	if prev, ok := s.Ledger.claim(src); !ok {
+		if prev == nil {
+			return zero, ErrAlreadyIssued
+		}
+		return TransferResponse{
+			TokenID:   prev.Token.Payload.TokenID,
+			Recipient: prev.Token.Payload.UserPubKey,
+			ExpiresAt: prev.Token.Payload.ExpiresAt,
+		}, nil
+	}
+
+	head, _ := s.Ledger.Head(entry.Token.Payload.TokenID)
+	prev := head.Token.Payload
+	if head.RevokedAt != 0 || prev.UserPubKey != ev.PubKey {
+		s.Ledger.release(src)
+		return zero, ErrAlreadyTransferred
+	}
+	if prev.ExpiresAt <= now.Unix() {
+		s.Ledger.release(src)
+		return zero, ErrSubscriptionOver
+	}
+
+	var revoke []string
+	for _, e := range s.Ledger.Chain(prev.TokenID) {
+		p := e.Token.Payload
+		if e.RevokedAt == 0 && p.UserPubKey == ev.PubKey && p.ExpiresAt > now.Unix() {
+			revoke = append(revoke, p.TokenID)
+		}
+	}
+
+	tok, err := s.deliver(src, vpn.SubscriptionPayload{
+		TokenID:          "sub_" + uuid.New().String(),
+		UserPubKey:       recipient,
+		SubscriptionType: prev.SubscriptionType,
+		Tier:             prev.Tier,
+		IssuedAt:         now.Unix(),
+		ExpiresAt:        prev.ExpiresAt,
+		Nonce:            uuid.New().String(),
+		IssuerPubKey:     s.PoolPubHex,
+		PreviousTokenID:  prev.TokenID,
+		SubscriptionID:   prev.Subscription(),
+		PlanLimits:       prev.PlanLimits,
+	}, TokenOrigin{TransferFrom: ev.PubKey}, nil)
+	if err != nil {
+		return zero, err
+	}
+	s.Ledger.Revoke(revoke, now)
+	log.Printf("transferred subscription %s from %s to %s; revoked %d token(s)\n",
+		prev.Subscription(), ev.PubKey, recipient, len(revoke))
+
+	return TransferResponse{
+		TokenID:   tok.Payload.TokenID,
+		Recipient: recipient,
+		ExpiresAt: tok.Payload.ExpiresAt,
+		Revoked:   revoke,
+	}, nil
+}
+
+// RevocationsHandler serves GET /revocations: the signed list of
+// revoked tokens that haven't expired, for nodes to refuse. The list is
+// signed again only when it changes.
+func (s *Server) RevocationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	list, err := s.revocationList(r.Context(), time.Now())
+	if err != nil {
+		log.Println("sign revocation list:", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// revocationList returns the signed list of the ledger's revocations,
+// reusing the last one signed if they haven't changed since.
+func (s *Server) revocationList(ctx context.Context, now time.Time) (vpn.RevocationList, error) {
+	hashes := []string{}
+	for _, id := range s.Ledger.Revoked(now) {
+		hashes = append(hashes, vpn.RevocationHash(id))
+	}
+
+	s.revocationsMu.Lock()
+	defer s.revocationsMu.Unlock()
+	if s.revocations != nil && slices.Equal(s.revocations.Payload.Revoked, hashes) {
+		return *s.revocations, nil
+	}
+	list, err := vpn.SignRevocationList(ctx, s.Nostr.Signer, vpn.RevocationListPayload{
+		PoolPubKey: s.PoolPubHex,
+		IssuedAt:   now.Unix(),
+		Revoked:    hashes,
+	})
+	if err != nil {
+		return vpn.RevocationList{}, err
+	}
+	s.revocations = &list
+	return list, nil
+}
@@ -0,0 +1,121 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+func TestTransfer(t *testing.T) {
+	s, _ := newTestServer(t, "")
+	holder := nostr.GeneratePrivateKey()
+	holderPub, _ := nostr.GetPublicKey(holder)
+	recipient := testUser(t)
+	now := time.Now()
+
+	first, err := s.issueSubscription(holderPub, "weekly", Source{Kind: SourceInvoice, ID: "inv1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := s.issueRenewal(Renewal{TokenID: first.Payload.TokenID, Plan: "weekly"}, Source{Kind: SourceInvoice, ID: "inv2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := nostr.Tag{"p", s.PoolPubHex}
+	token := nostr.Tag{"token", first.Payload.TokenID}
+	to := nostr.Tag{"recipient", recipient}
+
+	tampered := signedRequest(t, holder, KindTransferRequest, now, "", pool, token, to)
+	tampered.Tags = nostr.Tags{pool, token, {"recipient", testUser(t)}}
+
+	tests := []struct {
+		name string
+		ev   *nostr.Event
+		want error
+	}{
+		{"wrong kind", signedRequest(t, holder, KindGiftRequest, now, "", pool, token, to), ErrBadTransfer},
+		{"bad signature", tampered, ErrBadTransfer},
+		{"other pool", signedRequest(t, holder, KindTransferRequest, now, "", nostr.Tag{"p", testUser(t)}, token, to), ErrBadTransfer},
+		{"stale", signedRequest(t, holder, KindTransferRequest, now.Add(-time.Hour), "", pool, token, to), ErrBadTransfer},
+		{"from the future", signedRequest(t, holder, KindTransferRequest, now.Add(time.Hour), "", pool, token, to), ErrBadTransfer},
+		{"to themselves", signedRequest(t, holder, KindTransferRequest, now, "", pool, token, nostr.Tag{"recipient", holderPub}), ErrBadTransfer},
+		{"unknown token", signedRequest(t, holder, KindTransferRequest, now, "", pool, nostr.Tag{"token", "sub_x"}, to), ErrUnknownToken},
+		{"not the holder", signedRequest(t, nostr.GeneratePrivateKey(), KindTransferRequest, now, "", pool, token, to), ErrNotHolder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.transfer(tt.ev, now); !errors.Is(err, tt.want) {
+				t.Fatalf("transfer() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Any token of the subscription will do; the holder's are all revoked.
+	resp, err := s.transfer(signedRequest(t, holder, KindTransferRequest, now, "", pool, token, to), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Recipient != recipient || resp.ExpiresAt != renewed.Payload.ExpiresAt {
+		t.Fatalf("transfer() = %+v, want the rest of the renewed subscription for the recipient", resp)
+	}
+	revoked := s.Ledger.Revoked(now)
+	for _, id := range []string{first.Payload.TokenID, renewed.Payload.TokenID} {
+		if !slices.Contains(resp.Revoked, id) || !slices.Contains(revoked, id) {
+			t.Errorf("holder's token %s not revoked: response %v, ledger %v", id, resp.Revoked, revoked)
+		}
+	}
+	head, _ := s.Ledger.Head(first.Payload.TokenID)
+	if head.Token.Payload.TokenID != resp.TokenID || head.Token.Payload.PreviousTokenID != renewed.Payload.TokenID {
+		t.Fatalf("subscription head = %+v, want the recipient's token", head.Token.Payload)
+	}
+
+	// The holder's tokens are no longer the subscription's head.
+	again := signedRequest(t, holder, KindTransferRequest, now, "", pool, nostr.Tag{"token", renewed.Payload.TokenID}, nostr.Tag{"recipient", testUser(t)})
+	if _, err := s.transfer(again, now); !errors.Is(err, ErrAlreadyTransferred) {
+		t.Fatalf("transfer of a non-head token: %v, want ErrAlreadyTransferred", err)
+	}
+}
+
+func TestRevocationListSignedOnlyWhenChanged(t *testing.T) {
+	s, signer := newTestServer(t, "")
+	tok, err := s.issueSubscription(testUser(t), "weekly", Source{Kind: SourceInvoice, ID: "inv1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, now := context.Background(), time.Now()
+
+	first, err := s.revocationList(ctx, now)
+	if err != nil {
+		t.Fatalf("revocationList: %v", err)
+	}
+	if err := vpn.VerifyRevocationList(first, s.PoolPubHex); err != nil || len(first.Payload.Revoked) != 0 {
+		t.Fatalf("first list: %+v (%v)", first.Payload, err)
+	}
+
+	// Unchanged revocations reuse the signed list.
+	signer.SetFail(true)
+	again, err := s.revocationList(ctx, now.Add(time.Minute))
+	if err != nil || again.Signature != first.Signature {
+		t.Fatalf("unchanged list was signed again (%v)", err)
+	}
+
+	// A revocation needs a new list.
+	s.Ledger.Revoke([]string{tok.Payload.TokenID}, now)
+	if _, err := s.revocationList(ctx, now); err == nil {
+		t.Fatal("changed list wasn't signed again")
+	}
+	signer.SetFail(false)
+	list, err := s.revocationList(ctx, now)
+	if err != nil {
+		t.Fatalf("revocationList: %v", err)
+	}
+	if len(list.Payload.Revoked) != 1 || list.Payload.Revoked[0] != vpn.RevocationHash(tok.Payload.TokenID) {
+		t.Errorf("revoked = %v", list.Payload.Revoked)
+	}
+}
@@ -0,0 +1,335 @@
+package pool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// TrialTier is the tier of free trial tokens. Nodes can serve it with
+// tighter limits than paid tiers, or only when they have room to spare.
+const TrialTier = "trial"
+
+// TrialPlanID is the plan ID of free trial tokens.
+const TrialPlanID = "trial"
+
+// KindTrialRequest is the kind of the event a user signs to ask for a
+// free trial, proving they hold the key the token is for. It is POSTed
+// to /trial, not published. Tags: "p" (the pool).
+const KindTrialRequest = 21072
+
+// trialMaxAge bounds how far a trial request's created_at may be from
+// the pool's clock.
+const trialMaxAge = 10 * time.Minute
+
+// TrialDMTag marks the DM carrying a trial token; its value is the ID
+// of the request.
+const TrialDMTag = "trial"
+
+// TrialPolicy controls free trials.
+type TrialPolicy struct {
+	Duration   time.Duration // how long a trial token lasts
+	Cooldown   time.Duration // between two trials for the same pubkey
+	IPCooldown time.Duration // between two trials requested at POST /trial from the same address (IPv6: /64); 0: no limit
+	PerHour    int           // trials the pool issues per hour; 0: unlimited
+	DMPerHour  int           // of those, trials requested by DM, which carry no address for IPCooldown; 0: unlimited
+}
+
+// Errors returned for trial requests.
+var (
+	ErrTrialsDisabled = errors.New("free trials not enabled")
+	ErrBadTrial       = errors.New("invalid trial request")
+	ErrTrialTooSoon   = errors.New("trial already used")
+	ErrTrialsBusy     = errors.New("too many trials right now; try again later")
+)
+
+// TrialPolicyFromEnv returns the trial policy from
+// MEERKAT_POOL_TRIAL_DURATION (e.g. "24h" or "3d"; unset disables
+// trials), MEERKAT_POOL_TRIAL_COOLDOWN (default 90d),
+// MEERKAT_POOL_TRIAL_IP_COOLDOWN (default 1d; 0 disables the limit),
+// MEERKAT_POOL_TRIALS_PER_HOUR (default 20) and
+// MEERKAT_POOL_DM_TRIALS_PER_HOUR (default 5).
+func TrialPolicyFromEnv() (*TrialPolicy, error) {
+	v := os.Getenv("MEERKAT_POOL_TRIAL_DURATION")
+	if v == "" {
+		return nil, nil
+	}
+	p := &TrialPolicy{Cooldown: 90 * 24 * time.Hour, IPCooldown: 24 * time.Hour, PerHour: 20, DMPerHour: 5}
+	var err error
+	if p.Duration, err = ParseDuration(v); err != nil || p.Duration <= 0 {
+		return nil, fmt.Errorf("MEERKAT_POOL_TRIAL_DURATION: invalid duration %q", v)
+	}
+	if v := os.Getenv("MEERKAT_POOL_TRIAL_COOLDOWN"); v != "" {
+		if p.Cooldown, err = ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("MEERKAT_POOL_TRIAL_COOLDOWN: %w", err)
+		}
+	}
+	if v := os.Getenv("MEERKAT_POOL_TRIAL_IP_COOLDOWN"); v != "" {
+		if p.IPCooldown, err = ParseDuration(v); err != nil || p.IPCooldown < 0 {
+			return nil, fmt.Errorf("MEERKAT_POOL_TRIAL_IP_COOLDOWN: invalid duration %q", v)
+		}
+	}
+	if v := os.Getenv("MEERKAT_POOL_TRIALS_PER_HOUR"); v != "" {
+		if p.PerHour, err = strconv.Atoi(v); err != nil || p.PerHour < 0 {
+			return nil, fmt.Errorf("MEERKAT_POOL_TRIALS_PER_HOUR: invalid number %q", v)
+		}
+	}
+	if v := os.Getenv("MEERKAT_POOL_DM_TRIALS_PER_HOUR"); v != "" {
+		if p.DMPerHour, err = strconv.Atoi(v); err != nil || p.DMPerHour < 0 {
+			return nil, fmt.Errorf("MEERKAT_POOL_DM_TRIALS_PER_HOUR: invalid number %q", v)
+		}
+	}
+	return p, nil
+}
+
+// TrialsPathFromEnv returns MEERKAT_POOL_TRIALS_FILE, defaulting to
+// ~/.meerkatvpn/pool-trials.json.
+func TrialsPathFromEnv() string {
+	if p := os.Getenv("MEERKAT_POOL_TRIALS_FILE"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "pool-trials.json"
+	}
+	return filepath.Join(home, ".meerkatvpn", "pool-trials.json")
+}
+
+// EnableTrials lets users get one free trial token per cooldown through
+// POST /trial and "trial" DMs. The hourly and per-address limits are
+// kept in statePath ("" keeps them in memory only) so a restart doesn't
+// reset them.
+func (s *Server) EnableTrials(p TrialPolicy, statePath string) error {
+	limits, err := openTrialLimits(statePath)
+	if err != nil {
+		return err
+	}
+	s.Trials = &p
+	s.trialLimits = limits
+	return nil
+}
+
+// trialLimits is the state of the trial rate limits.
+type trialLimits struct {
+	path string
+
+	Recent   []int64          `json:"recent"`    // when trials were issued in the last hour (unix)
+	RecentDM []int64          `json:"recent_dm"` // the ones of those requested by DM
+	ByIP     map[string]int64 `json:"by_ip"`     // address -> when it last got a trial at POST /trial (unix)
+}
+
+func openTrialLimits(path string) (*trialLimits, error) {
+	l := &trialLimits{path: path, ByIP: map[string]int64{}}
+	if path == "" {
+		return l, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if l.ByIP == nil {
+		l.ByIP = map[string]int64{}
+	}
+	return l, nil
+}
+
+// prune drops what no longer limits anything at now.
+func (l *trialLimits) prune(p TrialPolicy, now time.Time) {
+	l.Recent = lastHour(l.Recent, now)
+	l.RecentDM = lastHour(l.RecentDM, now)
+	for ip, t := range l.ByIP {
+		if !now.Before(time.Unix(t, 0).Add(p.IPCooldown)) {
+			delete(l.ByIP, ip)
+		}
+	}
+}
+
+func lastHour(times []int64, now time.Time) []int64 {
+	recent := times[:0]
+	for _, t := range times {
+		if now.Sub(time.Unix(t, 0)) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}
+
+func (l *trialLimits) save() {
+	if l.path == "" {
+		return
+	}
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		log.Println("trial limits marshal error:", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+		log.Println("trial limits mkdir error:", err)
+		return
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		log.Println("trial limits write error:", err)
+		return
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		log.Println("trial limits rename error:", err)
+	}
+}
+
+// requestAddr is the address a request came from, as per-address
+// limits count it: the IP, or for IPv6 its /64, which one host can
+// easily hold in full.
+func requestAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip.To4() == nil {
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+	}
+	return ip.String()
+}
+
+// trialPlan is the plan trial tokens are issued for.
+func (p TrialPolicy) plan() PlanSpec {
+	return PlanSpec{ID: TrialPlanID, Name: "Free trial", Duration: Duration(p.Duration), Tier: TrialTier}
+}
+
+// plan looks up a plan by ID: the catalog's plans, plus the trial plan
+// when trials are enabled.
+func (s *Server) plan(id string) (PlanSpec, bool) {
+	if id == TrialPlanID && s.Trials != nil {
+		return s.Trials.plan(), true
+	}
+	return s.Catalog.Plan(id)
+}
+
+// TrialHandler serves POST /trial: the body is a signed
+// KindTrialRequest event. It returns the trial token (which is also
+// DMed).
+func (s *Server) TrialHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var ev nostr.Event
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<10)).Decode(&ev); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	token, err := s.trialRequest(&ev, requestAddr(r), now)
+	if err != nil && !errors.Is(err, ErrAlreadyIssued) {
+		log.Printf("trial %s: %v\n", ev.ID, err)
+		http.Error(w, err.Error(), trialErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(token)
+}
+
+func trialErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTrialsDisabled):
+		return http.StatusNotFound
+	case errors.Is(err, ErrBadTrial):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrTrialTooSoon), errors.Is(err, ErrTrialsBusy):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (s *Server) trialRequest(ev *nostr.Event, addr string, now time.Time) (vpn.SubscriptionToken, error) {
+	var zero vpn.SubscriptionToken
+	if ev.Kind != KindTrialRequest {
+		return zero, fmt.Errorf("%w: kind %d", ErrBadTrial, ev.Kind)
+	}
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return zero, fmt.Errorf("%w: bad signature", ErrBadTrial)
+	}
+	if tagValue(ev.Tags, "p") != s.PoolPubHex {
+		return zero, fmt.Errorf("%w: not addressed to this pool", ErrBadTrial)
+	}
+	if d := now.Sub(ev.CreatedAt.Time()); d > trialMaxAge || d < -trialMaxAge {
+		return zero, fmt.Errorf("%w: created_at too far from now", ErrBadTrial)
+	}
+	return s.issueTrial(ev.PubKey, ev.ID, addr, now)
+}
+
+// issueTrial issues a trial token to userPub for the request with ID
+// reqID (a trial request event or DM), unless userPub or the address the
+// request came from (addr; "" for DMs) had a trial within their cooldown
+// or the pool hit its hourly limit. DMs have no address to cool down, so
+// they also share a smaller hourly limit of their own.
+func (s *Server) issueTrial(userPub, reqID, addr string, now time.Time) (vpn.SubscriptionToken, error) {
+	var zero vpn.SubscriptionToken
+	if s.Trials == nil {
+		return zero, ErrTrialsDisabled
+	}
+	src := Source{Kind: SourceTrial, ID: userPub + "/" + reqID}
+
+	s.trialMu.Lock()
+	defer s.trialMu.Unlock()
+	if e, ok := s.Ledger.BySource(src); ok {
+		return e.Token, ErrAlreadyIssued
+	}
+	if last, ok := s.Ledger.LastIssuedTo(SourceTrial, userPub); ok {
+		next := time.Unix(last.Token.Payload.IssuedAt, 0).Add(s.Trials.Cooldown)
+		if now.Before(next) {
+			return zero, fmt.Errorf("%w: next trial available %s", ErrTrialTooSoon, next.UTC().Format(time.RFC3339))
+		}
+	}
+	limits := s.trialLimits
+	limits.prune(*s.Trials, now)
+	if last, ok := limits.ByIP[addr]; ok && addr != "" && s.Trials.IPCooldown > 0 {
+		next := time.Unix(last, 0).Add(s.Trials.IPCooldown)
+		return zero, fmt.Errorf("%w: a trial was requested from this address; next available %s", ErrTrialTooSoon, next.UTC().Format(time.RFC3339))
+	}
+	if s.Trials.PerHour > 0 && len(limits.Recent) >= s.Trials.PerHour {
+		return zero, ErrTrialsBusy
+	}
+	if addr == "" && s.Trials.DMPerHour > 0 && len(limits.RecentDM) >= s.Trials.DMPerHour {
+		return zero, ErrTrialsBusy
+	}
+
+	token, err := s.issueSubscription(userPub, TrialPlanID, src, nostr.Tag{TrialDMTag, reqID})
+	if err != nil {
+		return token, err
+	}
+	limits.Recent = append(limits.Recent, now.Unix())
+	if addr == "" {
+		limits.RecentDM = append(limits.RecentDM, now.Unix())
+	}
+	if addr != "" && s.Trials.IPCooldown > 0 {
+		limits.ByIP[addr] = now.Unix()
+	}
+	limits.save()
+	log.Printf("trial issued to %s (expires=%d)\n", userPub, token.Payload.ExpiresAt)
+	return token, nil
+}
@@ -0,0 +1,100 @@
+package pool
+
+import (
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRequestAddr(t *testing.T) {
+	for remote, want := range map[string]string{
+		"203.0.113.7:5555":           "203.0.113.7",
+		"[2001:db8:1:2:3:4:5:6]:443": "2001:db8:1:2::/64",
+		"[2001:db8:1:2:ffff::1]:80":  "2001:db8:1:2::/64",
+		"[::ffff:203.0.113.7]:5555":  "203.0.113.7",
+	} {
+		r := httptest.NewRequest("POST", "/trial", nil)
+		r.RemoteAddr = remote
+		if got := requestAddr(r); got != want {
+			t.Errorf("requestAddr(%s) = %s, want %s", remote, got, want)
+		}
+	}
+}
+
+func TestTrialPerAddressLimitPersists(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "trials.json")
+	policy := TrialPolicy{Duration: 24 * time.Hour, Cooldown: 90 * 24 * time.Hour, IPCooldown: 24 * time.Hour}
+	s, _ := newTestServer(t, "")
+	if err := s.EnableTrials(policy, state); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	if _, err := s.issueTrial(testUser(t), "req1", "203.0.113.7", now); err != nil {
+		t.Fatalf("first trial: %v", err)
+	}
+	if _, err := s.issueTrial(testUser(t), "req2", "203.0.113.7", now.Add(time.Hour)); !errors.Is(err, ErrTrialTooSoon) {
+		t.Fatalf("second trial from the address: %v, want ErrTrialTooSoon", err)
+	}
+	// DMs carry no address.
+	if _, err := s.issueTrial(testUser(t), "req3", "", now.Add(time.Hour)); err != nil {
+		t.Fatalf("trial by DM: %v", err)
+	}
+
+	// The limit survives a restart, and lapses after the cooldown.
+	s, _ = newTestServer(t, "")
+	if err := s.EnableTrials(policy, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.issueTrial(testUser(t), "req4", "203.0.113.7", now.Add(2*time.Hour)); !errors.Is(err, ErrTrialTooSoon) {
+		t.Fatalf("trial from the address after restart: %v, want ErrTrialTooSoon", err)
+	}
+	if _, err := s.issueTrial(testUser(t), "req5", "203.0.113.7", now.Add(25*time.Hour)); err != nil {
+		t.Fatalf("trial after the address cooldown: %v", err)
+	}
+}
+
+func TestTrialHourlyLimitPersists(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "trials.json")
+	policy := TrialPolicy{Duration: 24 * time.Hour, Cooldown: 90 * 24 * time.Hour, PerHour: 1}
+	s, _ := newTestServer(t, "")
+	if err := s.EnableTrials(policy, state); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := s.issueTrial(testUser(t), "req1", "", now); err != nil {
+		t.Fatalf("first trial: %v", err)
+	}
+
+	s, _ = newTestServer(t, "")
+	if err := s.EnableTrials(policy, state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.issueTrial(testUser(t), "req2", "", now.Add(time.Minute)); !errors.Is(err, ErrTrialsBusy) {
+		t.Fatalf("trial after restart: %v, want ErrTrialsBusy", err)
+	}
+}
+
+func TestTrialDMHourlyLimit(t *testing.T) {
+	policy := TrialPolicy{Duration: 24 * time.Hour, Cooldown: 90 * 24 * time.Hour, IPCooldown: 24 * time.Hour, PerHour: 10, DMPerHour: 1}
+	s, _ := newTestServer(t, "")
+	if err := s.EnableTrials(policy, ""); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := s.issueTrial(testUser(t), "req1", "", now); err != nil {
+		t.Fatalf("first trial by DM: %v", err)
+	}
+	if _, err := s.issueTrial(testUser(t), "req2", "", now.Add(time.Minute)); !errors.Is(err, ErrTrialsBusy) {
+		t.Fatalf("second trial by DM: %v, want ErrTrialsBusy", err)
+	}
+	// The DM limit leaves POST /trial alone, and lapses after an hour.
+	if _, err := s.issueTrial(testUser(t), "req3", "203.0.113.7", now.Add(time.Minute)); err != nil {
+		t.Fatalf("trial at POST /trial: %v", err)
+	}
+	if _, err := s.issueTrial(testUser(t), "req4", "", now.Add(time.Hour)); err != nil {
+		t.Fatalf("trial by DM an hour later: %v", err)
+	}
+}
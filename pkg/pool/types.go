@@ -1,17 +1,19 @@
 package pool
 
-import "time"
-
-type Pricing struct {
-    WeeklyPriceSats  int64
-    MonthlyPriceSats int64
-    YearlyPriceSats  int64
-}
-
 type InvoiceMetadata struct {
     Purpose    string `json:"purpose"`
-    Plan       string `json:"plan"`        // "weekly"|"monthly"|"yearly"
+    Plan       string `json:"plan"`        // plan ID from the catalog
     NostrPubKey string `json:"nostr_pubkey"`
+
+    // Renewals: the token whose subscription the invoice renews, and
+    // whether it was quoted as a tier switch taking effect now.
+    RenewTokenID string `json:"renew_token_id,omitempty"`
+    Upgrade      bool   `json:"upgrade,omitempty"`
+
+    // Gifts: the buyer, who gets a receipt DM, and their note to the
+    // recipient (NostrPubKey).
+    GiftFrom    string `json:"gift_from,omitempty"`
+    GiftMessage string `json:"gift_message,omitempty"`
 }
 
 type InvoiceWebhook struct {
@@ -20,15 +22,3 @@ type InvoiceWebhook struct {
     Settled    bool            `json:"settled"`
     Metadata   InvoiceMetadata `json:"metadata"`
 }
-
-// Subscription duration lookup
-func PlanDuration(plan string) time.Duration {
-    switch plan {
-    case "weekly":
-        return 7 * 24 * time.Hour
-    case "yearly":
-        return 365 * 24 * time.Hour
-    default:
-        return 30 * 24 * time.Hour
-    }
-}
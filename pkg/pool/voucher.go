@@ -0,0 +1,358 @@
+package pool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+	"github.com/MakerMaker19/meerkatvpn/pkg/vpn"
+)
+
+// Voucher is a code an operator hands out that redeems for a plan
+// without payment. Redemptions are counted in the ledger, so the
+// vouchers file only holds the definitions.
+type Voucher struct {
+	Code      string `json:"code"`
+	Plan      string `json:"plan"`
+	MaxUses   int    `json:"max_uses,omitempty"`   // 0: single use
+	ExpiresAt int64  `json:"expires_at,omitempty"` // unix; 0: never
+	CreatedAt int64  `json:"created_at"`
+	Note      string `json:"note,omitempty"`
+}
+
+// Uses returns how many times v may be redeemed.
+func (v Voucher) Uses() int {
+	if v.MaxUses <= 0 {
+		return 1
+	}
+	return v.MaxUses
+}
+
+// VoucherRequest is the JSON body of POST /voucher, and the content of
+// an encrypted voucher DM (where NostrPubKey defaults to the sender).
+type VoucherRequest struct {
+	Code        string `json:"voucher"`
+	NostrPubKey string `json:"nostr_pubkey,omitempty"` // hex or npub; receives the token DM
+}
+
+// VoucherDMTag marks the DM carrying a token issued for a voucher; its
+// value is the ID of the voucher DM, if there was one. The code itself
+// never appears in public tags.
+const VoucherDMTag = "voucher"
+
+// Errors returned when redeeming vouchers.
+var (
+	ErrVouchersDisabled = errors.New("vouchers not enabled")
+	ErrUnknownVoucher   = errors.New("unknown voucher code")
+	ErrVoucherExpired   = errors.New("voucher has expired")
+	ErrVoucherUsedUp    = errors.New("voucher has been used up")
+	ErrBadPubKey        = errors.New("invalid nostr_pubkey")
+)
+
+// NormalizeVoucherCode returns code as stored: upper case, without
+// spaces or dashes, so "meer-kat1" and "MEERKAT1" are the same code.
+func NormalizeVoucherCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', ' ', '\t':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// NewVoucherCode returns a random code, grouped in dashes for reading
+// out (the dashes are ignored on redemption).
+func NewVoucherCode() string {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	s := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	return s[:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:]
+}
+
+// Vouchers is the pool's vouchers file, a JSON array of Voucher. It is
+// reloaded when it changes, so `poold voucher create` takes effect
+// without a restart.
+type Vouchers struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	byCode  map[string]Voucher
+}
+
+// OpenVouchers loads the vouchers file at path; a missing file is empty.
+func OpenVouchers(path string) (*Vouchers, error) {
+	v := &Vouchers{path: path, byCode: map[string]Voucher{}}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *Vouchers) reloadLocked() error {
+	fi, err := os.Stat(v.path)
+	if errors.Is(err, os.ErrNotExist) {
+		v.byCode, v.modTime = map[string]Voucher{}, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(v.modTime) {
+		return nil
+	}
+	b, err := os.ReadFile(v.path)
+	if err != nil {
+		return err
+	}
+	var list []Voucher
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("parse %s: %w", v.path, err)
+	}
+	byCode := make(map[string]Voucher, len(list))
+	for _, vc := range list {
+		byCode[NormalizeVoucherCode(vc.Code)] = vc
+	}
+	v.byCode, v.modTime = byCode, fi.ModTime()
+	return nil
+}
+
+// Get looks up a voucher by code.
+func (v *Vouchers) Get(code string) (Voucher, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.reloadLocked(); err != nil {
+		log.Printf("vouchers: %v (keeping the previous list)\n", err)
+	}
+	vc, ok := v.byCode[NormalizeVoucherCode(code)]
+	return vc, ok
+}
+
+// List returns the vouchers, oldest first.
+func (v *Vouchers) List() []Voucher {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.reloadLocked(); err != nil {
+		log.Printf("vouchers: %v (keeping the previous list)\n", err)
+	}
+	return v.listLocked()
+}
+
+func (v *Vouchers) listLocked() []Voucher {
+	out := make([]Voucher, 0, len(v.byCode))
+	for _, vc := range v.byCode {
+		out = append(out, vc)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt != out[j].CreatedAt {
+			return out[i].CreatedAt < out[j].CreatedAt
+		}
+		return out[i].Code < out[j].Code
+	})
+	return out
+}
+
+// Add stores new vouchers in the file.
+func (v *Vouchers) Add(vouchers ...Voucher) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err := v.reloadLocked(); err != nil {
+		return err
+	}
+	for _, vc := range vouchers {
+		key := NormalizeVoucherCode(vc.Code)
+		if key == "" {
+			return errors.New("voucher without a code")
+		}
+		if _, ok := v.byCode[key]; ok {
+			return fmt.Errorf("voucher %s already exists", vc.Code)
+		}
+	}
+	for _, vc := range vouchers {
+		v.byCode[NormalizeVoucherCode(vc.Code)] = vc
+	}
+
+	b, err := json.MarshalIndent(v.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(v.path), 0o700); err != nil {
+		return err
+	}
+	tmp := v.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, v.path); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(v.path); err == nil {
+		v.modTime = fi.ModTime()
+	}
+	return nil
+}
+
+// VouchersPathFromEnv returns MEERKAT_POOL_VOUCHERS_FILE, defaulting to
+// ~/.meerkatvpn/pool-vouchers.json.
+func VouchersPathFromEnv() string {
+	if p := os.Getenv("MEERKAT_POOL_VOUCHERS_FILE"); p != "" {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "pool-vouchers.json"
+	}
+	return filepath.Join(home, ".meerkatvpn", "pool-vouchers.json")
+}
+
+// EnableVouchers lets users redeem vouchers through POST /voucher and
+// encrypted DMs.
+func (s *Server) EnableVouchers(v *Vouchers) {
+	s.Vouchers = v
+}
+
+// VoucherHandler serves POST /voucher: it redeems a voucher and returns
+// the issued subscription token (which is also DMed).
+func (s *Server) VoucherHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req VoucherRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<10)).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if req.NostrPubKey == "" || req.Code == "" {
+		http.Error(w, "missing voucher or nostr_pubkey", http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.redeemVoucher(req, "", time.Now())
+	if err != nil && !errors.Is(err, ErrAlreadyIssued) {
+		log.Printf("voucher error: %v\n", err)
+		http.Error(w, err.Error(), voucherErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(token)
+}
+
+func voucherErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrVouchersDisabled), errors.Is(err, ErrUnknownVoucher):
+		return http.StatusNotFound
+	case errors.Is(err, ErrVoucherExpired), errors.Is(err, ErrVoucherUsedUp):
+		return http.StatusGone
+	case errors.Is(err, ErrBadPubKey):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// redeemVoucher issues the voucher's plan to req.NostrPubKey. Each
+// pubkey redeems a code at most once; redeeming again returns the same
+// token with ErrAlreadyIssued. dmID is the voucher DM's event ID, if
+// the voucher came by DM.
+func (s *Server) redeemVoucher(req VoucherRequest, dmID string, now time.Time) (vpn.SubscriptionToken, error) {
+	var zero vpn.SubscriptionToken
+	if s.Vouchers == nil {
+		return zero, ErrVouchersDisabled
+	}
+	userPub, err := nostrutil.ParsePubKey(req.NostrPubKey)
+	if err != nil {
+		return zero, fmt.Errorf("%w: %v", ErrBadPubKey, err)
+	}
+	v, ok := s.Vouchers.Get(req.Code)
+	if !ok {
+		return zero, ErrUnknownVoucher
+	}
+	code := NormalizeVoucherCode(v.Code)
+	src := Source{Kind: SourceVoucher, ID: code + "/" + userPub}
+
+	s.voucherMu.Lock()
+	defer s.voucherMu.Unlock()
+	if e, ok := s.Ledger.BySource(src); ok {
+		return e.Token, ErrAlreadyIssued
+	}
+	if v.ExpiresAt != 0 && now.Unix() >= v.ExpiresAt {
+		return zero, ErrVoucherExpired
+	}
+	if used := s.Ledger.CountSource(SourceVoucher, code+"/"); used >= v.Uses() {
+		return zero, fmt.Errorf("%w (%d of %d)", ErrVoucherUsedUp, used, v.Uses())
+	}
+
+	var tags nostr.Tags
+	if dmID != "" {
+		tags = nostr.Tags{{VoucherDMTag, dmID}}
+	}
+	token, err := s.issueSubscription(userPub, v.Plan, src, tags...)
+	if err != nil {
+		return token, err
+	}
+	log.Printf("voucher %s redeemed by %s for plan=%s\n", code, userPub, v.Plan)
+	return token, nil
+}
+
+// ListenForRequestDMs handles voucher and trial requests DMed to the
+// pool (NIP-04 encrypted kind 4) until ctx is cancelled. The DM is a
+// VoucherRequest as JSON, "voucher CODE", or "trial".
+func (s *Server) ListenForRequestDMs(ctx context.Context) {
+	s.listen(ctx, "voucher DM", nostr.Filter{
+		Kinds: []int{nostr.KindEncryptedDirectMessage},
+		Tags:  nostr.TagMap{"p": []string{s.PoolPubHex}},
+	}, s.handleRequestDM)
+}
+
+func (s *Server) handleRequestDM(ctx context.Context, ev *nostr.Event) {
+	plain, ok := s.openDM(ctx, ev)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch fields := strings.Fields(plain); {
+	case strings.HasPrefix(plain, "{"):
+		var req VoucherRequest
+		if json.Unmarshal([]byte(plain), &req) != nil || req.Code == "" {
+			return // likely a redeem request
+		}
+		if req.NostrPubKey == "" {
+			req.NostrPubKey = ev.PubKey
+		}
+		_, err = s.redeemVoucher(req, ev.ID, time.Now())
+	case len(fields) == 2 && strings.EqualFold(fields[0], "voucher"):
+		_, err = s.redeemVoucher(VoucherRequest{Code: fields[1], NostrPubKey: ev.PubKey}, ev.ID, time.Now())
+	case len(fields) == 1 && strings.EqualFold(fields[0], "trial"):
+		// No address to cool down; DMPerHour limits these instead.
+		_, err = s.issueTrial(ev.PubKey, ev.ID, "", time.Now())
+	default:
+		return
+	}
+	if err != nil && !errors.Is(err, ErrAlreadyIssued) {
+		log.Printf("request DM %s from %s: %v\n", ev.ID, ev.PubKey, err)
+	}
+}
@@ -0,0 +1,164 @@
+package pool
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/MakerMaker19/meerkatvpn/pkg/bolt11"
+	"github.com/MakerMaker19/meerkatvpn/pkg/nostrutil"
+)
+
+// NIP-57 event kinds.
+const (
+	KindZapRequest = 9734
+	KindZapReceipt = 9735
+)
+
+// ZapDMTag marks the DM carrying a token issued for a zap; its value is
+// the zap receipt's event ID.
+const ZapDMTag = "zap"
+
+// EnableZaps issues subscriptions for zaps to the pool. providerPub is
+// the nostrPubkey of the pool's LNURL server: only receipts it signed
+// are trusted, since anyone can publish a kind-9735 event.
+func (s *Server) EnableZaps(ctx context.Context, providerPub string) {
+	s.listen(ctx, "zap receipt", nostr.Filter{
+		Kinds:   []int{KindZapReceipt},
+		Authors: []string{providerPub},
+		Tags:    nostr.TagMap{"p": []string{s.PoolPubHex}},
+	}, func(ctx context.Context, ev *nostr.Event) {
+		if err := s.handleZapReceipt(ctx, ev, providerPub); err != nil && !errors.Is(err, ErrAlreadyIssued) {
+			log.Printf("zap receipt %s: %v\n", ev.ID, err)
+		}
+	})
+}
+
+// handleZapReceipt validates a zap receipt (NIP-57 appendix F) and issues
+// the plan its amount pays for to the zapper.
+func (s *Server) handleZapReceipt(ctx context.Context, receipt *nostr.Event, providerPub string) error {
+	if receipt.Kind != KindZapReceipt || receipt.PubKey != providerPub {
+		return errors.New("not a receipt from the pool's zap provider")
+	}
+	if ok, err := receipt.CheckSignature(); err != nil || !ok {
+		return errors.New("invalid receipt signature")
+	}
+	if tag := receipt.Tags.GetFirst([]string{"p", s.PoolPubHex}); tag == nil {
+		return errors.New("receipt not addressed to the pool")
+	}
+
+	invoice := tagValue(receipt.Tags, "bolt11")
+	if invoice == "" {
+		return errors.New("receipt has no bolt11")
+	}
+	msats, err := bolt11.AmountMsat(invoice)
+	if err != nil {
+		return err
+	}
+
+	var zapReq nostr.Event
+	if err := json.Unmarshal([]byte(tagValue(receipt.Tags, "description")), &zapReq); err != nil {
+		return fmt.Errorf("decode zap request: %w", err)
+	}
+	if zapReq.Kind != KindZapRequest {
+		return fmt.Errorf("embedded event has kind %d, not a zap request", zapReq.Kind)
+	}
+	if ok, err := zapReq.CheckSignature(); err != nil || !ok {
+		return errors.New("invalid zap request signature")
+	}
+	if tag := zapReq.Tags.GetFirst([]string{"p", s.PoolPubHex}); tag == nil {
+		return errors.New("zap request not addressed to the pool")
+	}
+	if amt := tagValue(zapReq.Tags, "amount"); amt != "" {
+		want, err := strconv.ParseInt(amt, 10, 64)
+		if err != nil || want != msats {
+			return fmt.Errorf("zap request amount %s msat doesn't match invoice amount %d msat", amt, msats)
+		}
+	}
+	if sender := tagValue(receipt.Tags, "P"); sender != "" && sender != zapReq.PubKey {
+		return errors.New("receipt sender doesn't match zap request author")
+	}
+
+	sats := msats / 1000
+	plan, ok := s.Catalog.PlanForAmount(ctx, sats)
+	if !ok {
+		return fmt.Errorf("zap of %d sats from %s doesn't pay for any plan", sats, zapReq.PubKey)
+	}
+
+	// One token per paid invoice, however many receipts announce it.
+	h := sha256.Sum256([]byte(strings.ToLower(invoice)))
+	src := Source{Kind: SourceZap, ID: hex.EncodeToString(h[:16])}
+	log.Printf("zap of %d sats from %s (receipt %s) pays for plan=%s\n", sats, zapReq.PubKey, receipt.ID, plan.ID)
+	_, err = s.issueSubscription(zapReq.PubKey, plan.ID, src, nostr.Tag{ZapDMTag, receipt.ID})
+	return err
+}
+
+func tagValue(tags nostr.Tags, name string) string {
+	if tag := tags.GetFirst([]string{name}); tag != nil && len(*tag) > 1 {
+		return (*tag)[1]
+	}
+	return ""
+}
+
+// ZapProviderFromEnv returns the pubkey whose zap receipts the pool
+// trusts: MEERKAT_POOL_ZAP_PUBKEY, or the nostrPubkey advertised by the
+// LNURL server of the lightning address in MEERKAT_POOL_LUD16. It
+// returns "" if zaps aren't configured.
+func ZapProviderFromEnv(ctx context.Context) (string, error) {
+	if v := os.Getenv("MEERKAT_POOL_ZAP_PUBKEY"); v != "" {
+		return nostrutil.ParsePubKey(v)
+	}
+	if addr := os.Getenv("MEERKAT_POOL_LUD16"); addr != "" {
+		return ResolveZapProvider(ctx, addr)
+	}
+	return "", nil
+}
+
+// ResolveZapProvider looks up the zap receipt signer of a lightning
+// address (user@domain) through its LNURL-pay endpoint.
+func ResolveZapProvider(ctx context.Context, lud16 string) (string, error) {
+	name, domain, ok := strings.Cut(strings.TrimSpace(lud16), "@")
+	if !ok || name == "" || domain == "" {
+		return "", fmt.Errorf("invalid lightning address %q", lud16)
+	}
+	url := fmt.Sprintf("https://%s/.well-known/lnurlp/%s", domain, name)
+
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("GET %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	var lnurl struct {
+		AllowsNostr bool   `json:"allowsNostr"`
+		NostrPubkey string `json:"nostrPubkey"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&lnurl); err != nil {
+		return "", fmt.Errorf("decode %s: %w", url, err)
+	}
+	if !lnurl.AllowsNostr || lnurl.NostrPubkey == "" {
+		return "", fmt.Errorf("%s doesn't support zaps", lud16)
+	}
+	return nostrutil.ParsePubKey(lnurl.NostrPubkey)
+}
@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Runner starts external programs (openvpn, wg-quick, ...). It exists so
+// the tunnel code can be exercised with fake binaries.
+type Runner interface {
+	// Start launches a long-running process. Its combined stdout/stderr
+	// is available from Process.Output.
+	Start(ctx context.Context, name string, args ...string) (Process, error)
+
+	// Run executes a command to completion and returns its combined output.
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// Process is a running program started by a Runner.
+type Process interface {
+	Pid() int
+	Output() io.Reader
+	Signal(sig os.Signal) error
+	Wait() error
+}
+
+// ExecRunner is the Runner backed by os/exec.
+type ExecRunner struct{}
+
+func (ExecRunner) Start(ctx context.Context, name string, args ...string) (Process, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	if err := cmd.Start(); err != nil {
+		pw.Close()
+		return nil, err
+	}
+
+	p := &execProcess{cmd: cmd, out: pr, done: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		pw.Close()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+func (ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+type execProcess struct {
+	cmd  *exec.Cmd
+	out  io.Reader
+	done chan struct{}
+	err  error
+}
+
+func (p *execProcess) Pid() int          { return p.cmd.Process.Pid }
+func (p *execProcess) Output() io.Reader { return p.out }
+
+func (p *execProcess) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+func (p *execProcess) Wait() error {
+	<-p.done
+	return p.err
+}
@@ -0,0 +1,82 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNoActiveTunnel is returned when there is no recorded tunnel session.
+var ErrNoActiveTunnel = errors.New("no active tunnel")
+
+// State records the tunnel this client brought up, so a later
+// `disconnect` (or the reconnect daemon) can find and tear it down.
+type State struct {
+	Backend    string    `json:"backend"` // "openvpn" or "wireguard"
+	NodeID     string    `json:"node_id,omitempty"`
+	NodeURL    string    `json:"node_url"`
+	TokenID    string    `json:"token_id"`
+	ConfigPath string    `json:"config_path"`
+	Interface  string    `json:"interface,omitempty"` // WireGuard interface name
+	PID        int       `json:"pid,omitempty"`       // supervised openvpn process
+	StartedAt  time.Time `json:"started_at"`
+}
+
+func statePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".meerkatvpn")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tunnel-state.json"), nil
+}
+
+// LoadState returns the recorded tunnel, or ErrNoActiveTunnel.
+func LoadState() (*State, error) {
+	path, err := statePath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoActiveTunnel
+	}
+	if err != nil {
+		return nil, err
+	}
+	var st State
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// SaveState records st as the active tunnel.
+func SaveState(st State) error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// ClearState forgets the active tunnel.
+func ClearState() error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
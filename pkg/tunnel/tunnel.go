@@ -0,0 +1,392 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// openvpnReadyMarker is the log line openvpn prints once routes and DNS
+// are in place.
+const openvpnReadyMarker = "Initialization Sequence Completed"
+
+// openvpnRestartMarkers are log lines openvpn prints when it loses the
+// connection and starts reconnecting on its own.
+var openvpnRestartMarkers = []string{
+	"Inactivity timeout",
+	"Connection reset, restarting",
+	"SIGUSR1[soft",
+}
+
+// Manager brings tunnels up and down using the openvpn and wg-quick
+// binaries.
+type Manager struct {
+	Runner     Runner
+	OpenVPNBin string
+	WGQuickBin string
+	WGBin      string
+
+	// UpTimeout bounds how long Up waits for the tunnel to come up.
+	UpTimeout time.Duration
+}
+
+// NewManagerFromEnv returns a Manager using ExecRunner.
+//
+//	MEERKAT_OPENVPN_BIN   (default "openvpn")
+//	MEERKAT_WG_QUICK_BIN  (default "wg-quick")
+//	MEERKAT_WG_BIN        (default "wg")
+func NewManagerFromEnv() *Manager {
+	m := &Manager{
+		Runner:     ExecRunner{},
+		OpenVPNBin: os.Getenv("MEERKAT_OPENVPN_BIN"),
+		WGQuickBin: os.Getenv("MEERKAT_WG_QUICK_BIN"),
+		WGBin:      os.Getenv("MEERKAT_WG_BIN"),
+		UpTimeout:  60 * time.Second,
+	}
+	if m.OpenVPNBin == "" {
+		m.OpenVPNBin = "openvpn"
+	}
+	if m.WGQuickBin == "" {
+		m.WGQuickBin = "wg-quick"
+	}
+	if m.WGBin == "" {
+		m.WGBin = "wg"
+	}
+	return m
+}
+
+// Session is a tunnel brought up by Manager.Up.
+type Session struct {
+	State State
+
+	m        *Manager
+	proc     Process // openvpn only
+	done     chan struct{}
+	doneOnce sync.Once
+	err      error
+
+	// OpenVPN connection state, tracked from its log output.
+	mu          sync.Mutex
+	connected   bool
+	stateChange time.Time
+}
+
+// Done is closed when the tunnel goes away (the openvpn process exits,
+// or Close is called).
+func (s *Session) Done() <-chan struct{} { return s.done }
+
+// Err returns why the tunnel went away, once Done is closed.
+func (s *Session) Err() error { return s.err }
+
+// finish records why the tunnel went away and closes Done; only the
+// first call has an effect.
+func (s *Session) finish(err error) {
+	s.doneOnce.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// Up brings up the tunnel described by st and records it as the active
+// tunnel, with the current process as its supervisor.
+func (m *Manager) Up(ctx context.Context, st State) (*Session, error) {
+	if prev, err := LoadState(); err == nil && processAlive(prev.PID) {
+		return nil, fmt.Errorf("a tunnel is already active (backend=%s node=%s pid=%d); run disconnect first",
+			prev.Backend, prev.NodeURL, prev.PID)
+	}
+
+	st.PID = os.Getpid()
+	st.StartedAt = time.Now()
+
+	var (
+		s   *Session
+		err error
+	)
+	switch st.Backend {
+	case "openvpn":
+		s, err = m.upOpenVPN(ctx, st)
+	case "wireguard":
+		s, err = m.upWireGuard(ctx, st)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", st.Backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := SaveState(s.State); err != nil {
+		_ = s.Close(context.Background())
+		return nil, fmt.Errorf("record tunnel state: %w", err)
+	}
+	return s, nil
+}
+
+func (m *Manager) upOpenVPN(ctx context.Context, st State) (*Session, error) {
+	proc, err := m.Runner.Start(context.Background(), m.OpenVPNBin, "--config", st.ConfigPath, "--verb", "3")
+	if err != nil {
+		return nil, fmt.Errorf("start %s: %w", m.OpenVPNBin, err)
+	}
+
+	s := &Session{State: st, m: m, proc: proc, done: make(chan struct{})}
+
+	ready := make(chan struct{})
+	failed := make(chan string, 1)
+	go func() {
+		sc := bufio.NewScanner(proc.Output())
+		signalled := false
+		for sc.Scan() {
+			line := sc.Text()
+			log.Printf("[openvpn] %s\n", line)
+			switch {
+			case strings.Contains(line, openvpnReadyMarker):
+				s.setConnected(true)
+				if !signalled {
+					signalled = true
+					close(ready)
+				}
+			case containsAny(line, openvpnRestartMarkers):
+				s.setConnected(false)
+			case strings.Contains(line, "AUTH_FAILED"), strings.Contains(line, "Exiting due to fatal error"):
+				select {
+				case failed <- line:
+				default:
+				}
+			}
+		}
+	}()
+
+	go func() {
+		err := proc.Wait()
+		if err == nil {
+			err = errors.New("openvpn exited")
+		}
+		clearStateIfOwned()
+		s.finish(err)
+	}()
+
+	timeout := time.NewTimer(m.UpTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-ready:
+		log.Printf("[tunnel] openvpn up (pid=%d)\n", proc.Pid())
+		return s, nil
+	case line := <-failed:
+		_ = s.Close(context.Background())
+		return nil, fmt.Errorf("openvpn failed: %s", line)
+	case <-s.done:
+		return nil, fmt.Errorf("openvpn exited before the tunnel came up: %w", s.err)
+	case <-timeout.C:
+		_ = s.Close(context.Background())
+		return nil, fmt.Errorf("openvpn did not come up within %s", m.UpTimeout)
+	case <-ctx.Done():
+		_ = s.Close(context.Background())
+		return nil, ctx.Err()
+	}
+}
+
+func (m *Manager) upWireGuard(ctx context.Context, st State) (*Session, error) {
+	st.Interface = strings.TrimSuffix(filepath.Base(st.ConfigPath), filepath.Ext(st.ConfigPath))
+
+	ctx, cancel := context.WithTimeout(ctx, m.UpTimeout)
+	defer cancel()
+
+	out, err := m.Runner.Run(ctx, m.WGQuickBin, "up", st.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s up: %w (%s)", m.WGQuickBin, err, strings.TrimSpace(string(out)))
+	}
+	log.Printf("[tunnel] wireguard interface %s up\n", st.Interface)
+
+	return &Session{State: st, m: m, done: make(chan struct{})}, nil
+}
+
+// Close tears the tunnel down and clears the recorded state. For
+// OpenVPN it stops the process (openvpn removes its routes and DNS on a
+// clean shutdown); for WireGuard it runs `wg-quick down`, which does the
+// same for the interface.
+func (s *Session) Close(ctx context.Context) error {
+	var err error
+
+	switch s.State.Backend {
+	case "openvpn":
+		if s.proc != nil {
+			err = stopProcess(s.proc, s.done)
+		}
+	case "wireguard":
+		err = s.m.downWireGuard(ctx, s.State.ConfigPath)
+		s.finish(errors.New("tunnel closed"))
+	}
+
+	clearStateIfOwned()
+	return err
+}
+
+// stopProcess asks p to exit and waits up to 10s before killing it.
+func stopProcess(p Process, done <-chan struct{}) error {
+	sig := os.Signal(syscall.SIGTERM)
+	if runtime.GOOS == "windows" {
+		sig = os.Kill
+	}
+	if err := p.Signal(sig); err != nil {
+		select {
+		case <-done:
+			return nil
+		default:
+			return fmt.Errorf("signal openvpn: %w", err)
+		}
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(10 * time.Second):
+		_ = p.Signal(os.Kill)
+		<-done
+		return nil
+	}
+}
+
+func (m *Manager) downWireGuard(ctx context.Context, configPath string) error {
+	out, err := m.Runner.Run(ctx, m.WGQuickBin, "down", configPath)
+	if err != nil {
+		return fmt.Errorf("%s down: %w (%s)", m.WGQuickBin, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Down tears down the recorded tunnel from any process. If the
+// supervising client is still running it is asked to shut the tunnel
+// down itself; otherwise the leftovers are cleaned up directly.
+func (m *Manager) Down(ctx context.Context) (*State, error) {
+	st, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+
+	if st.PID != os.Getpid() && processAlive(st.PID) {
+		if err := signalPID(st.PID); err != nil {
+			return st, fmt.Errorf("signal supervising client (pid=%d): %w", st.PID, err)
+		}
+		// Wait for the supervisor to clean up and clear the state file.
+		deadline := time.Now().Add(15 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := LoadState(); errors.Is(err, ErrNoActiveTunnel) {
+				return st, nil
+			}
+			time.Sleep(250 * time.Millisecond)
+		}
+		return st, fmt.Errorf("supervising client (pid=%d) did not shut down the tunnel", st.PID)
+	}
+
+	// Supervisor is gone: clean up what it left behind.
+	if st.Backend == "wireguard" {
+		if err := m.downWireGuard(ctx, st.ConfigPath); err != nil {
+			log.Printf("[tunnel] %v\n", err)
+		}
+	}
+	return st, ClearState()
+}
+
+// clearStateIfOwned clears the state file only if this process is the
+// recorded supervisor, so a stale session can't wipe a newer one.
+func clearStateIfOwned() {
+	st, err := LoadState()
+	if err != nil || st.PID != os.Getpid() {
+		return
+	}
+	if err := ClearState(); err != nil {
+		log.Printf("[tunnel] clear state: %v\n", err)
+	}
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess fails on Windows if the process doesn't exist.
+		return true
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+func signalPID(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return p.Kill()
+	}
+	return p.Signal(syscall.SIGTERM)
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Session) setConnected(up bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connected != up || s.stateChange.IsZero() {
+		s.connected = up
+		s.stateChange = time.Now()
+	}
+}
+
+// OpenVPNState reports whether openvpn currently considers itself
+// connected, and since when. While openvpn is reconnecting on its own
+// (after an inactivity timeout or connection reset) connected is false.
+func (s *Session) OpenVPNState() (connected bool, since time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected, s.stateChange
+}
+
+// LatestHandshake returns the most recent handshake time across the
+// peers of a WireGuard interface (zero if there has been none yet),
+// using `wg show <iface> latest-handshakes`.
+func (m *Manager) LatestHandshake(ctx context.Context, iface string) (time.Time, error) {
+	out, err := m.Runner.Run(ctx, m.WGBin, "show", iface, "latest-handshakes")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s show %s: %w (%s)", m.WGBin, iface, err, strings.TrimSpace(string(out)))
+	}
+
+	var latest int64
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		ts, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if ts > latest {
+			latest = ts
+		}
+	}
+	if latest == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(latest, 0), nil
+}
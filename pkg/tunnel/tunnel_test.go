@@ -0,0 +1,306 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBin writes an executable shell script to dir and returns its path.
+func fakeBin(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testManager returns a Manager running fake binaries, with the state
+// file in a temporary home directory.
+func testManager(t *testing.T) (*Manager, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake binaries are shell scripts")
+	}
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	return &Manager{
+		Runner:     ExecRunner{},
+		OpenVPNBin: filepath.Join(dir, "openvpn"),
+		WGQuickBin: filepath.Join(dir, "wg-quick"),
+		WGBin:      filepath.Join(dir, "wg"),
+		UpTimeout:  5 * time.Second,
+	}, dir
+}
+
+// fakeOpenVPN prints lines, then runs until SIGTERM.
+func fakeOpenVPN(t *testing.T, m *Manager, lines ...string) {
+	t.Helper()
+	var body strings.Builder
+	body.WriteString("trap 'echo \"SIGTERM received, exiting\"; exit 0' TERM\n")
+	for _, l := range lines {
+		body.WriteString("echo '" + l + "'\n")
+	}
+	body.WriteString("while :; do sleep 1 >/dev/null 2>&1 & wait $!; done\n")
+	fakeBin(t, filepath.Dir(m.OpenVPNBin), "openvpn", body.String())
+}
+
+func waitDone(t *testing.T, s *Session) {
+	t.Helper()
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not done")
+	}
+}
+
+func TestOpenVPNUpAndClose(t *testing.T) {
+	m, _ := testManager(t)
+	fakeOpenVPN(t, m, "OpenVPN 2.6 starting", openvpnReadyMarker)
+
+	s, err := m.Up(context.Background(), State{Backend: "openvpn", NodeURL: "http://node", ConfigPath: "/tmp/x.ovpn"})
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if connected, _ := s.OpenVPNState(); !connected {
+		t.Error("OpenVPNState: not connected after ready marker")
+	}
+
+	st, err := LoadState()
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if st.Backend != "openvpn" || st.PID != os.Getpid() || st.NodeURL != "http://node" {
+		t.Errorf("state = %+v", st)
+	}
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	waitDone(t, s)
+	if _, err := LoadState(); !errors.Is(err, ErrNoActiveTunnel) {
+		t.Errorf("LoadState after Close: %v, want ErrNoActiveTunnel", err)
+	}
+}
+
+func TestOpenVPNProcessExitEndsSession(t *testing.T) {
+	m, dir := testManager(t)
+	fakeBin(t, dir, "openvpn", "echo '"+openvpnReadyMarker+"'\nsleep 0.2\nexit 1\n")
+
+	s, err := m.Up(context.Background(), State{Backend: "openvpn", ConfigPath: "/tmp/x.ovpn"})
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	waitDone(t, s)
+	if s.Err() == nil {
+		t.Error("Err() = nil after openvpn exited")
+	}
+	if _, err := LoadState(); !errors.Is(err, ErrNoActiveTunnel) {
+		t.Errorf("LoadState after exit: %v, want ErrNoActiveTunnel", err)
+	}
+}
+
+func TestOpenVPNTracksReconnects(t *testing.T) {
+	m, _ := testManager(t)
+	fakeOpenVPN(t, m, openvpnReadyMarker, "Inactivity timeout (--ping-restart), restarting")
+
+	s, err := m.Up(context.Background(), State{Backend: "openvpn", ConfigPath: "/tmp/x.ovpn"})
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	defer s.Close(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if connected, _ := s.OpenVPNState(); !connected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("OpenVPNState still connected after restart marker")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpenVPNAuthFailed(t *testing.T) {
+	m, _ := testManager(t)
+	fakeOpenVPN(t, m, "AUTH_FAILED")
+
+	if _, err := m.Up(context.Background(), State{Backend: "openvpn", ConfigPath: "/tmp/x.ovpn"}); err == nil ||
+		!strings.Contains(err.Error(), "AUTH_FAILED") {
+		t.Fatalf("Up: %v, want AUTH_FAILED error", err)
+	}
+	if _, err := LoadState(); !errors.Is(err, ErrNoActiveTunnel) {
+		t.Errorf("LoadState after failed Up: %v, want ErrNoActiveTunnel", err)
+	}
+}
+
+func TestOpenVPNUpTimeout(t *testing.T) {
+	m, _ := testManager(t)
+	m.UpTimeout = 200 * time.Millisecond
+	fakeOpenVPN(t, m, "still connecting")
+
+	if _, err := m.Up(context.Background(), State{Backend: "openvpn", ConfigPath: "/tmp/x.ovpn"}); err == nil ||
+		!strings.Contains(err.Error(), "did not come up") {
+		t.Fatalf("Up: %v, want timeout", err)
+	}
+}
+
+func TestWireGuardUpAndConcurrentClose(t *testing.T) {
+	m, dir := testManager(t)
+	calls := filepath.Join(dir, "calls")
+	fakeBin(t, dir, "wg-quick", `echo "$@" >> "`+calls+`"`+"\n")
+
+	cfg := filepath.Join(dir, "meerkat0.conf")
+	s, err := m.Up(context.Background(), State{Backend: "wireguard", ConfigPath: cfg})
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if s.State.Interface != "meerkat0" {
+		t.Errorf("Interface = %q, want meerkat0", s.State.Interface)
+	}
+
+	// The daemon's teardown and a failover may close the session at the
+	// same time.
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = s.Close(context.Background())
+		}()
+	}
+	wg.Wait()
+	waitDone(t, s)
+
+	b, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(got) < 2 || got[0] != "up "+cfg || got[1] != "down "+cfg {
+		t.Errorf("wg-quick calls = %q", got)
+	}
+	if _, err := LoadState(); !errors.Is(err, ErrNoActiveTunnel) {
+		t.Errorf("LoadState after Close: %v, want ErrNoActiveTunnel", err)
+	}
+}
+
+func TestWireGuardUpFails(t *testing.T) {
+	m, dir := testManager(t)
+	fakeBin(t, dir, "wg-quick", "echo 'RTNETLINK answers: Operation not permitted'\nexit 1\n")
+
+	_, err := m.Up(context.Background(), State{Backend: "wireguard", ConfigPath: filepath.Join(dir, "wg0.conf")})
+	if err == nil || !strings.Contains(err.Error(), "Operation not permitted") {
+		t.Fatalf("Up: %v, want wg-quick output in error", err)
+	}
+}
+
+func TestUpRefusesWhileActive(t *testing.T) {
+	m, _ := testManager(t)
+	if err := SaveState(State{Backend: "wireguard", NodeURL: "http://node", PID: os.Getpid()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background(), State{Backend: "wireguard"}); err == nil {
+		t.Fatal("Up succeeded with a tunnel already active")
+	}
+}
+
+func TestDownCleansUpAfterDeadSupervisor(t *testing.T) {
+	m, dir := testManager(t)
+	calls := filepath.Join(dir, "calls")
+	fakeBin(t, dir, "wg-quick", `echo "$@" >> "`+calls+`"`+"\n")
+
+	cfg := filepath.Join(dir, "wg0.conf")
+	// A PID that can't be a live process.
+	if err := SaveState(State{Backend: "wireguard", ConfigPath: cfg, PID: -1}); err != nil {
+		t.Fatal(err)
+	}
+	st, err := m.Down(context.Background())
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if st.ConfigPath != cfg {
+		t.Errorf("Down returned %+v", st)
+	}
+	if b, _ := os.ReadFile(calls); strings.TrimSpace(string(b)) != "down "+cfg {
+		t.Errorf("wg-quick calls = %q, want down", b)
+	}
+	if _, err := LoadState(); !errors.Is(err, ErrNoActiveTunnel) {
+		t.Errorf("LoadState after Down: %v, want ErrNoActiveTunnel", err)
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+
+	if _, err := LoadState(); !errors.Is(err, ErrNoActiveTunnel) {
+		t.Fatalf("LoadState with no file: %v, want ErrNoActiveTunnel", err)
+	}
+
+	want := State{
+		Backend:    "wireguard",
+		NodeID:     "node-1",
+		NodeURL:    "http://node",
+		TokenID:    "tok",
+		ConfigPath: "/etc/wg0.conf",
+		Interface:  "wg0",
+		PID:        42,
+		StartedAt:  time.Unix(1700000000, 0).UTC(),
+	}
+	if err := SaveState(want); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	path := filepath.Join(dir, ".meerkatvpn", "tunnel-state.json")
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("state file %s: %v, mode %v", path, err, fi.Mode())
+	}
+
+	got, err := LoadState()
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if !got.StartedAt.Equal(want.StartedAt) {
+		t.Errorf("StartedAt = %v, want %v", got.StartedAt, want.StartedAt)
+	}
+	got.StartedAt = want.StartedAt
+	if *got != want {
+		t.Errorf("LoadState = %+v, want %+v", *got, want)
+	}
+
+	if err := ClearState(); err != nil {
+		t.Fatalf("ClearState: %v", err)
+	}
+	if err := ClearState(); err != nil {
+		t.Fatalf("ClearState twice: %v", err)
+	}
+	if _, err := LoadState(); !errors.Is(err, ErrNoActiveTunnel) {
+		t.Errorf("LoadState after ClearState: %v, want ErrNoActiveTunnel", err)
+	}
+}
+
+func TestLatestHandshake(t *testing.T) {
+	m, dir := testManager(t)
+	fakeBin(t, dir, "wg", "printf 'peerA=\\t1700000000\\npeerB=\\t1700000100\\npeerC=\\t0\\n'\n")
+
+	got, err := m.LatestHandshake(context.Background(), "wg0")
+	if err != nil {
+		t.Fatalf("LatestHandshake: %v", err)
+	}
+	if want := time.Unix(1700000100, 0); !got.Equal(want) {
+		t.Errorf("LatestHandshake = %v, want %v", got, want)
+	}
+
+	fakeBin(t, dir, "wg", "printf 'peerA=\\t0\\n'\n")
+	if got, err := m.LatestHandshake(context.Background(), "wg0"); err != nil || !got.IsZero() {
+		t.Errorf("LatestHandshake with no handshake = %v, %v; want zero", got, err)
+	}
+}
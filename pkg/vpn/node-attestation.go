@@ -0,0 +1,62 @@
+package vpn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NodeAttestationPayload is the pool's signed statement that a node is
+// approved, along with the metadata clients should trust for it.
+type NodeAttestationPayload struct {
+	NodeID     string            `json:"node_id"`
+	APIURL     string            `json:"api_url"`
+	Region     string            `json:"region"`
+	Country    string            `json:"country,omitempty"`
+	City       string            `json:"city,omitempty"`
+	Backends   []string          `json:"backends"`
+	NodePubKey string            `json:"node_pubkey,omitempty"`
+	WGPubKey   string            `json:"wg_pubkey,omitempty"`
+	Endpoints  map[string]string `json:"endpoints,omitempty"`
+	PoolPubKey string            `json:"pool_pubkey"`
+	IssuedAt   int64             `json:"issued_at"`
+	ExpiresAt  int64             `json:"expires_at"`
+}
+
+// NodeAttestation = payload + pool signature.
+type NodeAttestation struct {
+	Payload   NodeAttestationPayload `json:"payload"`
+	Signature string                 `json:"signature"` // hex-encoded Schnorr signature
+
+	// SignedAt is set if Signature signs a KindSignedPayload event
+	// rather than the payload's hash.
+	SignedAt int64 `json:"signed_at,omitempty"`
+}
+
+// SignNodeAttestation signs the payload as the pool.
+func SignNodeAttestation(ctx context.Context, pool Signer, payload NodeAttestationPayload) (NodeAttestation, error) {
+	sig, signedAt, err := signJSON(ctx, pool, payload)
+	if err != nil {
+		return NodeAttestation{}, err
+	}
+	return NodeAttestation{Payload: payload, Signature: sig, SignedAt: signedAt}, nil
+}
+
+// VerifyNodeAttestation checks that att was signed by poolPubHex and
+// has not expired.
+func VerifyNodeAttestation(att NodeAttestation, poolPubHex string, now time.Time) error {
+	if !strings.EqualFold(att.Payload.PoolPubKey, poolPubHex) {
+		return fmt.Errorf("attestation from pool %s, expected %s", att.Payload.PoolPubKey, poolPubHex)
+	}
+	if err := verifyJSON(att.Payload.PoolPubKey, att.Payload, att.Signature, att.SignedAt); err != nil {
+		if err == errBadSignature {
+			return fmt.Errorf("invalid node attestation signature")
+		}
+		return err
+	}
+	if att.Payload.ExpiresAt <= now.Unix() {
+		return fmt.Errorf("node attestation expired at %d", att.Payload.ExpiresAt)
+	}
+	return nil
+}
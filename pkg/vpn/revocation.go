@@ -0,0 +1,57 @@
+package vpn
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// RevocationListPayload lists the pool's revoked, unexpired tokens. The
+// token IDs are hashed with RevocationHash so the list doesn't reveal
+// them.
+type RevocationListPayload struct {
+	PoolPubKey string   `json:"pool_pubkey"`
+	IssuedAt   int64    `json:"issued_at"`
+	Revoked    []string `json:"revoked"`
+}
+
+// RevocationList = payload + pool signature.
+type RevocationList struct {
+	Payload   RevocationListPayload `json:"payload"`
+	Signature string                `json:"signature"` // hex-encoded Schnorr signature
+
+	// SignedAt is set if Signature signs a KindSignedPayload event
+	// rather than the payload's hash.
+	SignedAt int64 `json:"signed_at,omitempty"`
+}
+
+// RevocationHash is how a revoked token ID appears in a RevocationList.
+func RevocationHash(tokenID string) string {
+	h := sha256.Sum256([]byte("meerkat-revoked:" + tokenID))
+	return hex.EncodeToString(h[:])
+}
+
+// SignRevocationList signs the payload as the pool.
+func SignRevocationList(ctx context.Context, pool Signer, payload RevocationListPayload) (RevocationList, error) {
+	sig, signedAt, err := signJSON(ctx, pool, payload)
+	if err != nil {
+		return RevocationList{}, err
+	}
+	return RevocationList{Payload: payload, Signature: sig, SignedAt: signedAt}, nil
+}
+
+// VerifyRevocationList checks that l was signed by poolPubHex.
+func VerifyRevocationList(l RevocationList, poolPubHex string) error {
+	if !strings.EqualFold(l.Payload.PoolPubKey, poolPubHex) {
+		return fmt.Errorf("revocation list from pool %s, expected %s", l.Payload.PoolPubKey, poolPubHex)
+	}
+	if err := verifyJSON(l.Payload.PoolPubKey, l.Payload, l.Signature, l.SignedAt); err != nil {
+		if err == errBadSignature {
+			return fmt.Errorf("invalid revocation list signature")
+		}
+		return err
+	}
+	return nil
+}
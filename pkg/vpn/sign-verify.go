@@ -1,16 +1,38 @@
 package vpn
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
 )
 
+// Signer signs as the pool (see nostrutil.Signer, which covers local
+// keys and remote signers).
+type Signer interface {
+	PubKey() string
+	SignEvent(ctx context.Context, ev *nostr.Event) error
+}
+
+// hashSigner is a Signer holding its key, which signs hashes directly.
+type hashSigner interface {
+	SignHash(hash []byte) ([]byte, error)
+}
+
+// KindSignedPayload is the kind of the event a payload is wrapped in
+// when the signer only signs events (e.g. a NIP-46 bunker): its content
+// is the payload JSON, with no tags, created at the SignedAt of the
+// signed object. Nodes older than this format only accept payloads
+// signed directly, so upgrade them before moving the pool key to a
+// bunker.
+const KindSignedPayload = 21075
+
 // SubscriptionPayload is the data that gets signed by the pool.
 type SubscriptionPayload struct {
 	TokenID          string `json:"token_id"`
@@ -21,30 +43,56 @@ type SubscriptionPayload struct {
 	ExpiresAt        int64  `json:"expires_at"`
 	Nonce            string `json:"nonce"`
 	IssuerPubKey     string `json:"issuer_pubkey"`
+
+	// Set on tokens issued by renewing another token: the token renewed,
+	// and the TokenID of the first token of the chain.
+	PreviousTokenID string `json:"previous_token_id,omitempty"`
+	SubscriptionID  string `json:"subscription_id,omitempty"`
+
+	// Limits of the plan the token was bought on.
+	PlanLimits
+}
+
+// PlanLimits are limits of a pool's plan that every node enforces on top
+// of its own tier policy. Zero limits mean unlimited; empty lists allow
+// all.
+type PlanLimits struct {
+	DeviceLimit    int      `json:"device_limit,omitempty"`     // across all of the pool's nodes
+	BandwidthCapGB int64    `json:"bandwidth_cap_gb,omitempty"` // per node
+	Regions        []string `json:"regions,omitempty"`          // node regions the token may use
+	Backends       []string `json:"backends,omitempty"`
+}
+
+// Subscription returns the ID of the subscription the token belongs to:
+// the first token of its renewal chain.
+func (p SubscriptionPayload) Subscription() string {
+	if p.SubscriptionID != "" {
+		return p.SubscriptionID
+	}
+	return p.TokenID
 }
 
 // SubscriptionToken = payload + signature.
 type SubscriptionToken struct {
 	Payload   SubscriptionPayload `json:"payload"`
 	Signature string              `json:"signature"` // hex-encoded Schnorr signature
-}
 
-// SignSubscription signs the payload with the pool's private key.
-func SignSubscription(poolPriv *btcec.PrivateKey, payload SubscriptionPayload) (SubscriptionToken, error) {
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		return SubscriptionToken{}, err
-	}
-	hash := sha256.Sum256(payloadBytes)
+	// SignedAt is set if Signature signs a KindSignedPayload event
+	// rather than the payload's hash.
+	SignedAt int64 `json:"signed_at,omitempty"`
+}
 
-	sig, err := schnorr.Sign(poolPriv, hash[:])
+// SignSubscription signs the payload as the pool.
+func SignSubscription(ctx context.Context, pool Signer, payload SubscriptionPayload) (SubscriptionToken, error) {
+	sig, signedAt, err := signJSON(ctx, pool, payload)
 	if err != nil {
 		return SubscriptionToken{}, err
 	}
 
 	return SubscriptionToken{
 		Payload:   payload,
-		Signature: hex.EncodeToString(sig.Serialize()),
+		Signature: sig,
+		SignedAt:  signedAt,
 	}, nil
 }
 
@@ -52,15 +100,81 @@ func SignSubscription(poolPriv *btcec.PrivateKey, payload SubscriptionPayload) (
 //
 // It uses Payload.IssuerPubKey as the public key.
 func VerifySubscription(tok SubscriptionToken, now time.Time) error {
-	// 1) Recreate the hash of the payload.
-	payloadBytes, err := json.Marshal(tok.Payload)
+	if err := verifyJSON(tok.Payload.IssuerPubKey, tok.Payload, tok.Signature, tok.SignedAt); err != nil {
+		if err == errBadSignature {
+			return fmt.Errorf("invalid subscription signature")
+		}
+		return err
+	}
+
+	// Check expiry.
+	if tok.Payload.ExpiresAt <= now.Unix() {
+		return fmt.Errorf("subscription expired at %d", tok.Payload.ExpiresAt)
+	}
+
+	return nil
+}
+
+var errBadSignature = errors.New("invalid signature")
+
+// signJSON returns the hex Schnorr signature of sha256(json(v)) if the
+// signer can sign hashes, and otherwise that of a KindSignedPayload
+// event wrapping json(v), along with the event's created_at.
+func signJSON(ctx context.Context, signer Signer, v any) (sig string, signedAt int64, err error) {
+	payloadBytes, err := json.Marshal(v)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if hs, ok := signer.(hashSigner); ok {
+		hash := sha256.Sum256(payloadBytes)
+		sig, err := hs.SignHash(hash[:])
+		if err != nil {
+			return "", 0, err
+		}
+		return hex.EncodeToString(sig), 0, nil
+	}
+
+	ev := payloadEvent(signer.PubKey(), int64(nostr.Now()), payloadBytes)
+	want := ev
+	if err := signer.SignEvent(ctx, &ev); err != nil {
+		return "", 0, err
+	}
+	if ev.PubKey != want.PubKey || ev.CreatedAt != want.CreatedAt || ev.Kind != want.Kind ||
+		ev.Content != want.Content || len(ev.Tags) != 0 {
+		return "", 0, errors.New("signer altered the payload event")
+	}
+	return ev.Sig, int64(ev.CreatedAt), nil
+}
+
+// payloadEvent wraps a payload in a KindSignedPayload event.
+func payloadEvent(pubHex string, signedAt int64, payload []byte) nostr.Event {
+	return nostr.Event{
+		PubKey:    pubHex,
+		CreatedAt: nostr.Timestamp(signedAt),
+		Kind:      KindSignedPayload,
+		Tags:      nostr.Tags{},
+		Content:   string(payload),
+	}
+}
+
+// verifyJSON checks a signature produced by signJSON against a Nostr
+// style x-only pubkey in hex. A well-formed but wrong signature returns
+// errBadSignature.
+func verifyJSON(pubHex string, v any, sigHex string, signedAt int64) error {
+	// 1) Recreate the hash of the payload, or of the event wrapping it.
+	payloadBytes, err := json.Marshal(v)
 	if err != nil {
 		return fmt.Errorf("marshal payload: %w", err)
 	}
 	hash := sha256.Sum256(payloadBytes)
+	if signedAt != 0 {
+		ev := payloadEvent(pubHex, signedAt, payloadBytes)
+		hash = sha256.Sum256(ev.Serialize())
+	}
 
 	// 2) Parse issuer pubkey from hex (Nostr style x-only pubkey).
-	pubBytes, err := hex.DecodeString(tok.Payload.IssuerPubKey)
+	pubBytes, err := hex.DecodeString(pubHex)
 	if err != nil {
 		return fmt.Errorf("invalid issuer pubkey hex: %w", err)
 	}
@@ -69,9 +183,8 @@ func VerifySubscription(tok SubscriptionToken, now time.Time) error {
 		return fmt.Errorf("parse issuer pubkey: %w", err)
 	}
 
-
 	// 3) Parse signature.
-	sigBytes, err := hex.DecodeString(tok.Signature)
+	sigBytes, err := hex.DecodeString(sigHex)
 	if err != nil {
 		return fmt.Errorf("invalid signature hex: %w", err)
 	}
@@ -82,13 +195,7 @@ func VerifySubscription(tok SubscriptionToken, now time.Time) error {
 
 	// 4) Verify Schnorr signature.
 	if ok := sig.Verify(hash[:], pub); !ok {
-		return fmt.Errorf("invalid subscription signature")
+		return errBadSignature
 	}
-
-	// 5) Check expiry.
-	if tok.Payload.ExpiresAt <= now.Unix() {
-		return fmt.Errorf("subscription expired at %d", tok.Payload.ExpiresAt)
-	}
-
 	return nil
 }
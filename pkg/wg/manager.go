@@ -7,7 +7,10 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Manager manages a simple in-memory IP allocator for WireGuard peers
@@ -153,3 +156,66 @@ func incrementIP(ip net.IP) {
 		}
 	}
 }
+
+// Interface returns the WireGuard interface name.
+func (m *Manager) Interface() string {
+	return m.iface
+}
+
+// Applying reports whether the manager runs `wg` (rather than only logging).
+func (m *Manager) Applying() bool {
+	return m.apply
+}
+
+// RemovePeer, if enabled, runs `wg set <iface> peer <clientPub> remove`.
+func (m *Manager) RemovePeer(clientPub string) error {
+	if !m.apply {
+		log.Printf("[wg] apply disabled; not removing peer %s\n", clientPub)
+		return nil
+	}
+	out, err := exec.Command("wg", "set", m.iface, "peer", clientPub, "remove").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running wg set remove: %w (%s)", err, strings.TrimSpace(string(out)))
+	}
+	log.Printf("[wg] removed WireGuard peer %s\n", clientPub)
+	return nil
+}
+
+// PeerStat is a peer's latest handshake and transfer counters.
+type PeerStat struct {
+	LastHandshake time.Time
+	RxBytes       int64
+	TxBytes       int64
+}
+
+// PeerStats returns per-peer stats from `wg show <iface> dump`, keyed
+// by public key. It returns nil when apply mode is off.
+func (m *Manager) PeerStats() (map[string]PeerStat, error) {
+	if !m.apply {
+		return nil, nil
+	}
+	out, err := exec.Command("wg", "show", m.iface, "dump").Output()
+	if err != nil {
+		return nil, fmt.Errorf("running wg show dump: %w", err)
+	}
+
+	stats := map[string]PeerStat{}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	// The first line describes the interface itself; peer lines are:
+	// pubkey psk endpoint allowed-ips latest-handshake rx tx keepalive
+	for _, line := range lines[min(1, len(lines)):] {
+		f := strings.Split(line, "\t")
+		if len(f) < 7 {
+			continue
+		}
+		hs, _ := strconv.ParseInt(f[4], 10, 64)
+		rx, _ := strconv.ParseInt(f[5], 10, 64)
+		tx, _ := strconv.ParseInt(f[6], 10, 64)
+		st := PeerStat{RxBytes: rx, TxBytes: tx}
+		if hs > 0 {
+			st.LastHandshake = time.Unix(hs, 0)
+		}
+		stats[f[0]] = st
+	}
+	return stats, nil
+}